@@ -2,38 +2,65 @@ package http
 
 import (
 	"bufio"
-	"chat/client/internal/dto"
+	"chat/client/internal/model"
+	"chat/client/internal/utils"
+	"chat/protocol"
 	"fmt"
 	"os"
-	"strings"
-	"time"
+	"sync"
 
 	"github.com/gorilla/websocket"
 )
 
 type Client struct {
-	ws       *websocket.Conn
-	username string
+	ws        *websocket.Conn
+	dial      func() (*websocket.Conn, error) // Переподключение после обрыва, nil - без него
+	username  string
+	creds     model.Credentials
+	room      string     // Текущая комната, пустая строка - общий чат
+	codecName string     // Кодек, который клиент просит в hello
+	refs      utils.Refs // Метки ref отправленных кадров
+
+	mu    sync.Mutex     // Писать в ws можно только из одной горутины; ws и codec меняются при переподключении
+	codec protocol.Codec // Кодек, о котором договорились в hello
+
+	stateMu  sync.Mutex
+	token    string // Токен возобновления сессии, выданный сервером
+	resuming bool   // Отправлен resume, ответа ещё нет
+	exiting  bool   // Пользователь вышел, обрыв соединения ожидаем
 }
 
-func NewClient(ws *websocket.Conn) *Client {
+func NewClient(ws *websocket.Conn, creds model.Credentials) *Client {
 	return &Client{
-		ws: ws,
+		creds:     creds,
+		ws:        ws,
+		codecName: protocol.CodecJSON,
+		codec:     protocol.JSON,
 	}
 }
 
+// SetCodec задаёт кодек, который клиент просит в hello. Вызывается до
+// ConnectToChat.
+func (c *Client) SetCodec(name string) {
+	c.codecName = name
+}
+
+// SetDialer включает переподключение: после обрыва клиент открывает новое
+// соединение и возобновляет сессию по токену. Вызывается до ConnectToChat.
+func (c *Client) SetDialer(dial func() (*websocket.Conn, error)) {
+	c.dial = dial
+}
+
 func (c *Client) ConnectToChat() {
 	c.registration()
 
 	go func() {
 		for {
-			var msg dto.HTTPMessageDTO
-			err := c.ws.ReadJSON(&msg)
-			if err != nil {
+			err := c.read(c.connection())
+			if !c.reconnect() {
 				fmt.Println("Disconnected from server:", err)
 				os.Exit(0)
 			}
-			c.print(msg)
 		}
 	}()
 
@@ -42,101 +69,189 @@ func (c *Client) ConnectToChat() {
 	select {}
 }
 
-func (c *Client) print(msg dto.HTTPMessageDTO) {
-	const (
-		ColorReset   = "\033[0m"
-		ColorGreen   = "\033[32m"
-		ColorBlue    = "\033[34m"
-		ColorMagenta = "\033[35m"
-		ColorRed     = "\033[31m"
-	)
-
-	timeStr := ""
-	if msg.Time != "" {
-		timeStr = fmt.Sprintf("%s[%s]%s ", ColorBlue, msg.Time, ColorReset)
-	}
-	nameStr := ""
-	if msg.Name != "" {
-		nameStr = fmt.Sprintf("%s%s%s", ColorGreen, msg.Name, ColorReset)
-	}
-
-	switch msg.Type {
-	case "whisper":
-		fmt.Printf("%s%s[whisper]%s %s: %s\n",
-			timeStr,
-			ColorMagenta, ColorReset,
-			nameStr,
-			msg.Text,
-		)
-	case "broadcast":
-		fmt.Printf("%s%s: %s\n",
-			timeStr,
-			nameStr,
-			msg.Text,
-		)
-	case "error":
-		fmt.Printf("%s[error]%s %s\n", ColorRed, ColorReset, msg.Text)
-	default:
-		fmt.Println(msg.Text)
-	}
-	fmt.Print("Enter text to send:\n")
-}
-
 func (c *Client) SendMessage() {
 	consoleScanner := bufio.NewScanner(os.Stdin)
 	fmt.Println("Enter text to send:")
 	for consoleScanner.Scan() {
-		text := consoleScanner.Text()
+		cmd := utils.ParseCommand(consoleScanner.Text())
 
-		if text == "/exit" {
-			exitMsg := dto.HTTPMessageDTO{
-				Type: "exit",
-				Name: c.username,
-			}
-			c.ws.WriteJSON(exitMsg)
-			c.ws.Close()
+		msg, err := utils.Frame(cmd, c.username, &c.room)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if cmd.Name == utils.CommandExit {
+			c.stateMu.Lock()
+			c.exiting = true
+			c.stateMu.Unlock()
+			c.send(msg)
+			ws, _ := c.connection()
+			ws.Close()
 			return
 		}
+		c.send(msg)
+	}
+}
 
-		if len(text) > 3 && text[:3] == "/w " {
-
-			parts := bufio.NewScanner(bufio.NewReader(strings.NewReader(text[3:])))
-			parts.Split(bufio.ScanWords)
-			if parts.Scan() {
-				dst := parts.Text()
-				whisperText := text[3+len(dst)+1:]
-
-				whisperMsg := dto.HTTPMessageDTO{
-					Type: "whisper",
-					Name: c.username,
-					Text: whisperText,
-					Dst:  dst,
-					Time: time.Now().Format("2006/01/02 15:04:05"),
-				}
-				c.ws.WriteJSON(whisperMsg)
+// read разбирает кадры сервера, пока соединение не оборвётся
+func (c *Client) read(ws *websocket.Conn, codec protocol.Codec) error {
+	for {
+		messageType, data, err := ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+		msg, err := codec.Unmarshal(data)
+		if err != nil {
+			if messageType == websocket.TextMessage {
+				fmt.Println(string(data))
 			}
-		} else {
+			continue
+		}
+		switch msg.Type {
+		case protocol.TypeHello:
+			// Ответ на hello разбирает handshake, повтор не нужен
+			continue
+		case protocol.TypePing:
+			// На управляющие ping сервера gorilla/websocket отвечает сама,
+			// а кадр ping протокола подтверждаем явно
+			c.send(protocol.New(protocol.TypePong, protocol.Payload{Name: c.username}))
+			continue
+		case protocol.TypeSession:
+			c.stateMu.Lock()
+			c.token = msg.Payload.Token
+			c.stateMu.Unlock()
+			continue
+		case protocol.TypeResumed:
+			c.stateMu.Lock()
+			c.resuming = false
+			c.stateMu.Unlock()
+		}
+		utils.Print(msg)
+		switch msg.Type {
+		case protocol.TypeAuthFailed:
+			os.Exit(1)
+		case protocol.TypeServerShutdown:
+			os.Exit(0)
+		}
+		switch c.refs.React(msg) {
+		case utils.Reregister:
+			c.resumeFailed()
+		case utils.Quit:
+			os.Exit(1)
+		}
+	}
+}
 
-			broadcastMsg := dto.HTTPMessageDTO{
-				Type: "broadcast",
-				Name: c.username,
-				Text: text,
-				Time: time.Now().Format("2006/01/02 15:04:05"),
-			}
-			c.ws.WriteJSON(broadcastMsg)
+// reconnect открывает новое соединение и просит сервер вернуть сессию.
+// false - переподключаться некуда или не с чем.
+func (c *Client) reconnect() bool {
+	c.stateMu.Lock()
+	token, exiting := c.token, c.exiting
+	c.stateMu.Unlock()
+	if c.dial == nil || token == "" || exiting {
+		return false
+	}
+
+	fmt.Println("Connection lost, reconnecting...")
+	var ws *websocket.Conn
+	err := utils.Retry(utils.ReconnectDelays, func() error {
+		var err error
+		ws, err = c.dial()
+		return err
+	})
+	if err != nil {
+		fmt.Println("Reconnect failed:", err)
+		return false
+	}
+
+	codec := c.handshake(ws)
+	c.mu.Lock()
+	c.ws, c.codec = ws, codec
+	c.mu.Unlock()
+	c.stateMu.Lock()
+	c.resuming = true
+	c.stateMu.Unlock()
+	c.send(utils.ResumeRequest(c.username, token))
+	return true
+}
+
+// resumeFailed регистрирует клиента заново, если сервер не вернул сессию
+func (c *Client) resumeFailed() {
+	c.stateMu.Lock()
+	resuming := c.resuming
+	if resuming {
+		c.resuming = false
+		c.token = ""
+	}
+	c.stateMu.Unlock()
+	if resuming {
+		c.register()
+	}
+}
+
+func (c *Client) connection() (*websocket.Conn, protocol.Codec) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws, c.codec
+}
+
+// handshake отправляет hello и ждёт ответа: после него сервер читает и
+// пишет кадры в выбранном кодеке. Hello и ответ на него идут в JSON.
+func (c *Client) handshake(ws *websocket.Conn) protocol.Codec {
+	data, _ := protocol.Encode(utils.Hello(c.codecName, protocol.CapResume, protocol.CapPresence))
+	if err := ws.WriteMessage(websocket.TextMessage, data); err != nil {
+		return protocol.JSON
+	}
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			// Обрыв заметит read и переподключится
+			return protocol.JSON
+		}
+		msg, err := protocol.Decode(data)
+		if err != nil {
+			continue
+		}
+		switch msg.Type {
+		case protocol.TypeError:
+			utils.Print(msg)
+			return protocol.JSON
+		case protocol.TypeHello:
+			return utils.NegotiatedCodec(msg)
 		}
 	}
 }
 
+func (c *Client) send(msg protocol.Envelope) {
+	c.refs.Tag(&msg)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := c.codec.Marshal(msg)
+	if err != nil {
+		fmt.Println("Error encoding message:", err)
+		return
+	}
+	messageType := websocket.TextMessage
+	if c.codec.Binary() {
+		messageType = websocket.BinaryMessage
+	}
+	c.ws.WriteMessage(messageType, data)
+}
+
 func (c *Client) registration() {
-	fmt.Print("Enter your name: ")
-	scanner := bufio.NewScanner(os.Stdin)
-	scanner.Scan()
-	c.username = scanner.Text()
+	utils.PromptCredentials(&c.creds)
+	c.username = c.creds.User
+	c.codec = c.handshake(c.ws)
+	c.register()
+}
 
-	regMsg := dto.HTTPMessageDTO{
-		Type: "register",
+func (c *Client) register() {
+	c.send(protocol.New(protocol.TypeRegister, protocol.Payload{
+		Name:     c.username,
+		Password: c.creds.Password,
+		Token:    c.creds.Token,
+	}))
+	c.send(protocol.New(protocol.TypeHistory, protocol.Payload{
 		Name: c.username,
-	}
-	c.ws.WriteJSON(regMsg)
+	}))
 }
@@ -0,0 +1,297 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"chat/client/internal/model"
+	"chat/client/internal/utils"
+	"chat/protocol"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Stream - открытый поток /events и id соединения для POST /send
+type Stream struct {
+	id     string
+	body   io.ReadCloser
+	reader *bufio.Reader
+}
+
+// Dial открывает поток Server-Sent Events сервера base (например
+// http://127.0.0.1:4545) и ждёт событие open с id соединения
+func Dial(client *http.Client, base string) (*Stream, error) {
+	resp, err := client.Get(base + "/events")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("open event stream: %s", resp.Status)
+	}
+	s := &Stream{body: resp.Body, reader: bufio.NewReader(resp.Body)}
+	event, data, err := s.next()
+	if err == nil && event != "open" {
+		err = fmt.Errorf("want open event, got %q", event)
+	}
+	var open struct{ Conn string }
+	if err == nil {
+		err = json.Unmarshal(data, &open)
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	s.id = open.Conn
+	return s, nil
+}
+
+// next читает одно событие, пропуская комментарии keepalive
+func (s *Stream) next() (event string, data []byte, err error) {
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if data != nil || event != "" {
+				return event, data, nil
+			}
+		case strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data != nil {
+				data = append(data, '\n')
+			}
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")...)
+		}
+	}
+}
+
+func (s *Stream) Close() error {
+	return s.body.Close()
+}
+
+// Client работает через SSE: кадры сервера приходят в поток /events, свои
+// кадры клиент отправляет запросами POST /send. Кадры всегда в JSON.
+type Client struct {
+	http     *http.Client
+	base     string
+	username string
+	creds    model.Credentials
+	room     string     // Текущая комната, пустая строка - общий чат
+	refs     utils.Refs // Метки ref отправленных кадров
+
+	mu     sync.Mutex // Кадры отправляются по одному и по порядку; stream меняется при переподключении
+	stream *Stream
+
+	stateMu  sync.Mutex
+	token    string // Токен возобновления сессии, выданный сервером
+	resuming bool   // Отправлен resume, ответа ещё нет
+	exiting  bool   // Пользователь вышел, обрыв соединения ожидаем
+}
+
+func NewClient(client *http.Client, base string, stream *Stream, creds model.Credentials) *Client {
+	return &Client{
+		http:   client,
+		base:   base,
+		stream: stream,
+		creds:  creds,
+	}
+}
+
+func (c *Client) ConnectToChat() {
+	c.registration()
+
+	go func() {
+		for {
+			err := c.read(c.connection())
+			if !c.reconnect() {
+				fmt.Println("Disconnected from server:", err)
+				os.Exit(0)
+			}
+		}
+	}()
+
+	go c.SendMessage()
+
+	select {}
+}
+
+func (c *Client) SendMessage() {
+	consoleScanner := bufio.NewScanner(os.Stdin)
+	fmt.Println("Enter text to send:")
+	for consoleScanner.Scan() {
+		cmd := utils.ParseCommand(consoleScanner.Text())
+
+		msg, err := utils.Frame(cmd, c.username, &c.room)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if cmd.Name == utils.CommandExit {
+			c.stateMu.Lock()
+			c.exiting = true
+			c.stateMu.Unlock()
+			c.send(msg)
+			c.connection().Close()
+			return
+		}
+		c.send(msg)
+	}
+}
+
+// read разбирает события потока, пока он не оборвётся
+func (c *Client) read(stream *Stream) error {
+	for {
+		_, data, err := stream.next()
+		if err != nil {
+			return err
+		}
+		msg, err := protocol.Decode(data)
+		if err != nil {
+			fmt.Println(string(data))
+			continue
+		}
+		switch msg.Type {
+		case protocol.TypeHello:
+			// Кодек у SSE всегда JSON, из ответа на hello ничего не нужно
+			continue
+		case protocol.TypePing:
+			c.send(protocol.New(protocol.TypePong, protocol.Payload{Name: c.username}))
+			continue
+		case protocol.TypeSession:
+			c.stateMu.Lock()
+			c.token = msg.Payload.Token
+			c.stateMu.Unlock()
+			continue
+		case protocol.TypeResumed:
+			c.stateMu.Lock()
+			c.resuming = false
+			c.stateMu.Unlock()
+		}
+		utils.Print(msg)
+		switch msg.Type {
+		case protocol.TypeAuthFailed:
+			os.Exit(1)
+		case protocol.TypeServerShutdown:
+			os.Exit(0)
+		}
+		switch c.refs.React(msg) {
+		case utils.Reregister:
+			c.resumeFailed()
+		case utils.Quit:
+			os.Exit(1)
+		}
+	}
+}
+
+// reconnect открывает новый поток и просит сервер вернуть сессию.
+// false - переподключаться не с чем.
+func (c *Client) reconnect() bool {
+	c.stateMu.Lock()
+	token, exiting := c.token, c.exiting
+	c.stateMu.Unlock()
+	if token == "" || exiting {
+		return false
+	}
+
+	fmt.Println("Connection lost, reconnecting...")
+	var stream *Stream
+	err := utils.Retry(utils.ReconnectDelays, func() error {
+		var err error
+		stream, err = Dial(c.http, c.base)
+		return err
+	})
+	if err != nil {
+		fmt.Println("Reconnect failed:", err)
+		return false
+	}
+
+	c.mu.Lock()
+	c.stream = stream
+	c.mu.Unlock()
+	c.stateMu.Lock()
+	c.resuming = true
+	c.stateMu.Unlock()
+	c.send(hello())
+	c.send(utils.ResumeRequest(c.username, token))
+	return true
+}
+
+// resumeFailed регистрирует клиента заново, если сервер не вернул сессию
+func (c *Client) resumeFailed() {
+	c.stateMu.Lock()
+	resuming := c.resuming
+	if resuming {
+		c.resuming = false
+		c.token = ""
+	}
+	c.stateMu.Unlock()
+	if resuming {
+		c.register()
+	}
+}
+
+func (c *Client) connection() *Stream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stream
+}
+
+// send отправляет кадр запросом POST /send. Ответ сервера, в том числе
+// ошибка разбора, придёт в поток.
+func (c *Client) send(msg protocol.Envelope) {
+	c.refs.Tag(&msg)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := protocol.Encode(msg)
+	if err != nil {
+		fmt.Println("Error encoding message:", err)
+		return
+	}
+	resp, err := c.http.Post(c.base+"/send?conn="+c.stream.id, "application/json", bytes.NewReader(data))
+	if err != nil {
+		// Обрыв заметит read и переподключится
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		var body struct{ Error string }
+		if json.NewDecoder(resp.Body).Decode(&body) != nil || body.Error == "" {
+			body.Error = resp.Status
+		}
+		fmt.Println("Error sending message:", errors.New(body.Error))
+	}
+}
+
+// hello - кадр hello SSE: кодек всегда JSON
+func hello() protocol.Envelope {
+	return utils.Hello(protocol.CodecJSON, protocol.CapResume, protocol.CapPresence)
+}
+
+func (c *Client) registration() {
+	utils.PromptCredentials(&c.creds)
+	c.username = c.creds.User
+	c.send(hello())
+	c.register()
+}
+
+func (c *Client) register() {
+	c.send(protocol.New(protocol.TypeRegister, protocol.Payload{
+		Name:     c.username,
+		Password: c.creds.Password,
+		Token:    c.creds.Token,
+	}))
+	c.send(protocol.New(protocol.TypeHistory, protocol.Payload{
+		Name: c.username,
+	}))
+}
@@ -2,56 +2,65 @@ package tcp
 
 import (
 	"bufio"
-	"chat/client/internal/dto"
-	"encoding/json"
+	"chat/client/internal/model"
+	"chat/client/internal/utils"
+	"chat/protocol"
+	"errors"
 	"fmt"
 	"net"
 	"os"
-	"strings"
-	"time"
+	"sync"
 )
 
 type Client struct {
 	conn     net.Conn
+	dial     func() (net.Conn, error) // Переподключение после обрыва, nil - без него
 	username string
+	creds    model.Credentials
+	room     string // Текущая комната, пустая строка - общий чат
+
+	codecName string     // Кодек, который клиент просит в hello
+	refs      utils.Refs // Метки ref отправленных кадров
+
+	mu       sync.Mutex     // conn, reader, codec и token меняются при переподключении
+	reader   *bufio.Reader  // Чтение кадров из conn
+	codec    protocol.Codec // Кодек, о котором договорились в hello
+	token    string         // Токен возобновления сессии, выданный сервером
+	resuming bool           // Отправлен resume, ответа ещё нет
+	exiting  bool           // Пользователь вышел, обрыв соединения ожидаем
 }
 
-func NewClient(connect net.Conn) *Client {
+func NewClient(connect net.Conn, creds model.Credentials) *Client {
 	return &Client{
-		conn: connect,
+		creds:     creds,
+		conn:      connect,
+		codecName: protocol.CodecJSON,
+		codec:     protocol.JSON,
 	}
 }
 
+// SetCodec задаёт кодек, который клиент просит в hello. Вызывается до
+// ConnectToChat.
+func (cl *Client) SetCodec(name string) {
+	cl.codecName = name
+}
+
+// SetDialer включает переподключение: после обрыва клиент открывает новое
+// соединение и возобновляет сессию по токену. Вызывается до ConnectToChat.
+func (cl *Client) SetDialer(dial func() (net.Conn, error)) {
+	cl.dial = dial
+}
+
 func (cl *Client) ConnectToChat() {
 	cl.registration()
 
 	go func() {
-		serverReader := bufio.NewReader(cl.conn)
 		for {
-			msg, err := serverReader.ReadString('\n')
-			if err != nil {
+			cl.read(cl.stream())
+			if !cl.reconnect() {
 				fmt.Println("Disconnected from server.")
 				os.Exit(0)
 			}
-
-			msg = strings.TrimSpace(msg)
-			if msg == "" {
-				continue
-			}
-
-			var dtoMsg dto.TCPMessageDTO
-			if err := json.Unmarshal([]byte(msg), &dtoMsg); err == nil && dtoMsg.Type != "" && dtoMsg.Type != "error" {
-				cl.Print(dtoMsg)
-				continue
-			}
-
-			var errMsg dto.ErrorDTO
-			if err := json.Unmarshal([]byte(msg), &errMsg); err == nil && errMsg.Type == "error" {
-				cl.Print(dto.TCPMessageDTO{Type: "error", Text: errMsg.Message})
-				continue
-			}
-
-			fmt.Println(msg)
 		}
 	}()
 
@@ -60,102 +69,195 @@ func (cl *Client) ConnectToChat() {
 	select {}
 }
 
-func (cl *Client) Print(msg dto.TCPMessageDTO) {
-	const (
-		ColorReset   = "\033[0m"
-		ColorGreen   = "\033[32m"
-		ColorBlue    = "\033[34m"
-		ColorMagenta = "\033[35m"
-		ColorRed     = "\033[31m"
-	)
-
-	timeStr := ""
-	if msg.Time != "" {
-		timeStr = fmt.Sprintf("%s[%s]%s ", ColorBlue, msg.Time, ColorReset)
-	}
-	nameStr := ""
-	if msg.Name != "" {
-		nameStr = fmt.Sprintf("%s%s%s", ColorGreen, msg.Name, ColorReset)
+// read разбирает кадры сервера, пока соединение не оборвётся
+func (cl *Client) read(reader *bufio.Reader, codec protocol.Codec) {
+	for {
+		frame, err := codec.ReadFrame(reader, protocol.DefaultMaxFrameSize)
+		if errors.Is(err, protocol.ErrFrameTooLarge) {
+			fmt.Println("Frame from server dropped:", err)
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		msg, err := codec.Unmarshal(frame)
+		if err != nil {
+			if !codec.Binary() {
+				fmt.Println(string(frame))
+			}
+			continue
+		}
+		if cl.handleService(msg) {
+			continue
+		}
+		utils.Print(msg)
+		switch msg.Type {
+		case protocol.TypeAuthFailed:
+			os.Exit(1)
+		case protocol.TypeServerShutdown:
+			os.Exit(0)
+		}
+		switch cl.refs.React(msg) {
+		case utils.Reregister:
+			cl.resumeFailed()
+		case utils.Quit:
+			os.Exit(1)
+		}
 	}
+}
 
+// handleService обрабатывает служебные кадры, которые не показываются
+// пользователю. true - кадр обработан.
+func (cl *Client) handleService(msg protocol.Envelope) bool {
 	switch msg.Type {
-	case "whisper":
-		fmt.Printf("%s%s[whisper]%s %s: %s\n",
-			timeStr,
-			ColorMagenta, ColorReset,
-			nameStr,
-			msg.Text,
-		)
-	case "broadcast":
-		fmt.Printf("%s%s: %s\n",
-			timeStr,
-			nameStr,
-			msg.Text,
-		)
-	case "error":
-		fmt.Printf("%s[error]%s %s\n", ColorRed, ColorReset, msg.Text)
+	case protocol.TypeHello:
+		// Ответ на hello разбирает handshake, повтор не нужен
+	case protocol.TypePing:
+		cl.send(protocol.New(protocol.TypePong, protocol.Payload{Name: cl.username}))
+	case protocol.TypeSession:
+		cl.mu.Lock()
+		cl.token = msg.Payload.Token
+		cl.mu.Unlock()
+	case protocol.TypeResumed:
+		cl.mu.Lock()
+		cl.resuming = false
+		cl.mu.Unlock()
+		utils.Print(msg)
 	default:
-		fmt.Println(msg.Text)
+		return false
+	}
+	return true
+}
+
+// reconnect открывает новое соединение и просит сервер вернуть сессию.
+// false - переподключаться некуда или не с чем.
+func (cl *Client) reconnect() bool {
+	cl.mu.Lock()
+	token, exiting := cl.token, cl.exiting
+	cl.mu.Unlock()
+	if cl.dial == nil || token == "" || exiting {
+		return false
+	}
+
+	fmt.Println("Connection lost, reconnecting...")
+	var conn net.Conn
+	err := utils.Retry(utils.ReconnectDelays, func() error {
+		var err error
+		conn, err = cl.dial()
+		return err
+	})
+	if err != nil {
+		fmt.Println("Reconnect failed:", err)
+		return false
+	}
+
+	reader, codec := cl.handshake(conn)
+	cl.mu.Lock()
+	cl.conn, cl.reader, cl.codec = conn, reader, codec
+	cl.resuming = true
+	cl.mu.Unlock()
+	cl.send(utils.ResumeRequest(cl.username, token))
+	return true
+}
+
+// resumeFailed регистрирует клиента заново, если сервер не вернул сессию
+func (cl *Client) resumeFailed() {
+	cl.mu.Lock()
+	resuming := cl.resuming
+	if resuming {
+		cl.resuming = false
+		cl.token = ""
+	}
+	cl.mu.Unlock()
+	if resuming {
+		cl.register()
+	}
+}
+
+// stream возвращает, откуда и в каком кодеке читать текущее соединение
+func (cl *Client) stream() (*bufio.Reader, protocol.Codec) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.reader, cl.codec
+}
+
+// handshake отправляет hello и ждёт ответа: после него сервер читает и
+// пишет кадры в выбранном кодеке. Hello и ответ на него идут в JSON.
+func (cl *Client) handshake(conn net.Conn) (*bufio.Reader, protocol.Codec) {
+	reader := bufio.NewReader(conn)
+	data, _ := protocol.Encode(utils.Hello(cl.codecName, protocol.CapResume, protocol.CapPresence))
+	if err := protocol.JSON.WriteFrame(conn, data); err != nil {
+		return reader, protocol.JSON
+	}
+	for {
+		frame, err := protocol.JSON.ReadFrame(reader, protocol.DefaultMaxFrameSize)
+		if err != nil {
+			// Обрыв заметит read и переподключится
+			return reader, protocol.JSON
+		}
+		msg, err := protocol.Decode(frame)
+		if err != nil {
+			continue
+		}
+		switch msg.Type {
+		case protocol.TypeError:
+			utils.Print(msg)
+			return reader, protocol.JSON
+		case protocol.TypeHello:
+			return reader, utils.NegotiatedCodec(msg)
+		}
 	}
-	fmt.Print("Enter text to send:\n")
 }
 
 func (cl *Client) SendMessage() {
 	consoleScanner := bufio.NewScanner(os.Stdin)
 	fmt.Println("Enter text to send:")
 	for consoleScanner.Scan() {
-		text := consoleScanner.Text()
+		cmd := utils.ParseCommand(consoleScanner.Text())
 
-		if text == "/exit" {
-			exitMsg := dto.TCPMessageDTO{
-				Type: "exit",
-				Name: cl.username,
-			}
-			data, _ := json.Marshal(exitMsg)
-			cl.conn.Write(append(data, '\n'))
-			return
+		msg, err := utils.Frame(cmd, cl.username, &cl.room)
+		if err != nil {
+			fmt.Println(err)
+			continue
 		}
-
-		if len(text) > 3 && text[:3] == "/w " {
-			// Формат: /w username message
-			parts := strings.SplitN(text[3:], " ", 2)
-			if len(parts) == 2 {
-				dst := parts[0]
-				whisperText := parts[1]
-				whisperMsg := dto.TCPMessageDTO{
-					Type: "whisper",
-					Name: cl.username,
-					Text: whisperText,
-					Dst:  dst,
-					Time: time.Now().Format("2006/01/02 15:04:05"),
-				}
-				data, _ := json.Marshal(whisperMsg)
-				cl.conn.Write(append(data, '\n'))
-				continue
-			}
+		if cmd.Name == utils.CommandExit {
+			cl.mu.Lock()
+			cl.exiting = true
+			cl.mu.Unlock()
+			cl.send(msg)
+			return
 		}
+		cl.send(msg)
+	}
+}
 
-		broadcastMsg := dto.TCPMessageDTO{
-			Type: "broadcast",
-			Name: cl.username,
-			Text: text,
-			Time: time.Now().Format("2006/01/02 15:04:05"),
-		}
-		data, _ := json.Marshal(broadcastMsg)
-		cl.conn.Write(append(data, '\n'))
+func (cl *Client) send(msg protocol.Envelope) {
+	cl.refs.Tag(&msg)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	data, err := cl.codec.Marshal(msg)
+	if err != nil {
+		fmt.Println("Error encoding message:", err)
+		return
 	}
+	cl.codec.WriteFrame(cl.conn, data)
 }
 
 func (cl *Client) registration() {
-	fmt.Print("Enter your name: ")
-	scanner := bufio.NewScanner(os.Stdin)
-	scanner.Scan()
-	cl.username = scanner.Text()
+	utils.PromptCredentials(&cl.creds)
+	cl.username = cl.creds.User
+	cl.reader, cl.codec = cl.handshake(cl.conn)
+	cl.register()
+}
 
-	regMsg := dto.TCPMessageDTO{
-		Type: "register",
+func (cl *Client) register() {
+	cl.send(protocol.New(protocol.TypeRegister, protocol.Payload{
+		Name:     cl.username,
+		Password: cl.creds.Password,
+		Token:    cl.creds.Token,
+	}))
+	cl.send(protocol.New(protocol.TypeHistory, protocol.Payload{
 		Name: cl.username,
-	}
-	data, _ := json.Marshal(regMsg)
-	cl.conn.Write(append(data, '\n'))
+	}))
 }
@@ -2,170 +2,198 @@ package udp
 
 import (
 	"bufio"
-	"chat/client/internal/dto"
-	"encoding/json"
+	"chat/client/internal/model"
+	"chat/client/internal/utils"
+	"chat/protocol"
+	"chat/rudp"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Client struct {
-	addr     *net.UDPAddr
-	conn     *net.UDPConn
-	username string
+	addr       *net.UDPAddr
+	conn       *rudp.Conn // Подтверждения, повторы, порядок и фрагментация датаграмм
+	maxMessage int        // Наибольшее сообщение в байтах, 0 - rudp.DefaultMaxMessageSize
+	username   string
+	creds      model.Credentials
+	room       string // Текущая комната, пустая строка - общий чат
+	codecName  string // Кодек, который клиент просит в hello
+
+	mu     sync.Mutex
+	codec  protocol.Codec // Кодек, о котором договорились в hello
+	helloc chan struct{}  // Сигнал, что пришёл ответ на hello
+
+	refs utils.Refs // Метки ref отправленных кадров
 }
 
-func NewClient(addr *net.UDPAddr) *Client {
+// helloTimeout - сколько ждать ответа на hello, прежде чем продолжить в JSON
+const helloTimeout = 5 * time.Second
+
+func NewClient(addr *net.UDPAddr, creds model.Credentials) *Client {
 	return &Client{
-		addr: addr,
+		creds:     creds,
+		addr:      addr,
+		codecName: protocol.CodecJSON,
+		codec:     protocol.JSON,
+		helloc:    make(chan struct{}, 1),
 	}
 }
 
-func (c *Client) ConnectToChat() {
-	c.registration()
+// SetCodec задаёт кодек, который клиент просит в hello. Вызывается до
+// ConnectToChat.
+func (c *Client) SetCodec(name string) {
+	c.codecName = name
+}
 
-	go func() {
-		buf := make([]byte, 4096)
-		for {
-			n, _, err := c.conn.ReadFromUDP(buf)
-			if err != nil {
-				fmt.Println("Error reading from server:", err)
-				return
-			}
-			msg := strings.TrimSpace(string(buf[:n]))
-			if msg == "" {
-				continue
-			}
-
-			var dtoMsg dto.UDPMessageDTO
-			if err := json.Unmarshal([]byte(msg), &dtoMsg); err == nil && dtoMsg.Type != "" && dtoMsg.Type != "error" {
-				c.Print(dtoMsg)
-				continue
-			}
-
-			var errMsg struct {
-				Type    string `json:"type"`
-				Message string `json:"message"`
-			}
-			if err := json.Unmarshal([]byte(msg), &errMsg); err == nil && errMsg.Type == "error" {
-				c.Print(dto.UDPMessageDTO{Type: "error", Text: errMsg.Message})
-				continue
-			}
-
-			fmt.Println(msg)
-		}
-	}()
+// SetMaxMessageSize задаёт наибольший размер сообщения в байтах.
+// Вызывается до ConnectToChat.
+func (c *Client) SetMaxMessageSize(n int) {
+	c.maxMessage = n
+}
 
+func (c *Client) ConnectToChat() {
+	c.registration()
 	c.SendMessage()
 }
 
-func (c *Client) Print(msg dto.UDPMessageDTO) {
-	const (
-		ColorReset   = "\033[0m"
-		ColorGreen   = "\033[32m"
-		ColorBlue    = "\033[34m"
-		ColorMagenta = "\033[35m"
-		ColorRed     = "\033[31m"
-	)
-
-	timeStr := ""
-	if msg.Time != "" {
-		timeStr = fmt.Sprintf("%s[%s]%s ", ColorBlue, msg.Time, ColorReset)
+func (c *Client) handle(from net.Addr, data []byte) {
+	if from.String() != c.addr.String() {
+		return
 	}
-	nameStr := ""
-	if msg.Name != "" {
-		nameStr = fmt.Sprintf("%s%s%s", ColorGreen, msg.Name, ColorReset)
+	codec := c.currentCodec()
+	msg, err := codec.Unmarshal(data)
+	if err != nil {
+		if line := strings.TrimSpace(string(data)); line != "" && !codec.Binary() {
+			fmt.Println(line)
+		}
+		return
 	}
-
 	switch msg.Type {
-	case "whisper":
-		fmt.Println(msg.Type)
-		fmt.Printf("%s%s[whisper]%s %s: %s\n",
-			timeStr,
-			ColorMagenta, ColorReset,
-			nameStr,
-			msg.Text,
-		)
-	case "broadcast":
-
-		fmt.Printf("%s%s: %s\n",
-			timeStr,
-			nameStr,
-			msg.Text,
-		)
-	case "error":
-		fmt.Printf("%s[error]%s %s\n", ColorRed, ColorReset, msg.Text)
-	default:
-		fmt.Println(msg.Text)
+	case protocol.TypeHello:
+		// Следующие кадры сервер пишет уже в выбранном кодеке. rudp отдаёт
+		// сообщения по порядку, поэтому кодек меняется до их разбора.
+		c.mu.Lock()
+		c.codec = utils.NegotiatedCodec(msg)
+		c.mu.Unlock()
+		select {
+		case c.helloc <- struct{}{}:
+		default:
+		}
+		return
+	case protocol.TypePing:
+		c.send(protocol.New(protocol.TypePong, protocol.Payload{Name: c.username}))
+		return
+	case protocol.TypeSession:
+		// Обрывы UDP переживает rudp, токен возобновления не нужен
+		return
+	}
+	utils.Print(msg)
+	switch msg.Type {
+	case protocol.TypeAuthFailed:
+		os.Exit(1)
+	case protocol.TypeServerShutdown:
+		os.Exit(0)
+	}
+	if c.refs.React(msg) == utils.Quit {
+		os.Exit(1)
 	}
-	fmt.Print("Enter text to send:\n")
 }
 
 func (c *Client) SendMessage() {
 	consoleScanner := bufio.NewScanner(os.Stdin)
 	for consoleScanner.Scan() {
-		text := consoleScanner.Text()
-
-		if text == "/exit" {
-			exitMsg := dto.UDPMessageDTO{
-				Type: "exit",
-				Name: c.username,
-			}
-			data, _ := json.Marshal(exitMsg)
-			c.conn.Write(data)
+		cmd := utils.ParseCommand(consoleScanner.Text())
+
+		msg, err := utils.Frame(cmd, c.username, &c.room)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if cmd.Name == utils.CommandExit {
+			c.send(msg)
+			c.waitDelivered()
 			return
 		}
+		c.send(msg)
+	}
+}
 
-		if len(text) > 3 && text[:3] == "/w " {
-
-			parts := strings.SplitN(text[3:], " ", 2)
-			if len(parts) == 2 {
-				dst := parts[0]
-				whisperText := parts[1]
-				whisperMsg := dto.UDPMessageDTO{
-					Type: "whisper",
-					Name: c.username,
-					Text: whisperText,
-					Dst:  dst,
-					Time: time.Now().Format("2006/01/02 15:04:05"),
-				}
-				data, _ := json.Marshal(whisperMsg)
-				c.conn.Write(data)
-				continue
-			}
-		}
+func (c *Client) send(msg protocol.Envelope) {
+	c.refs.Tag(&msg)
+	data, err := c.currentCodec().Marshal(msg)
+	if err != nil {
+		fmt.Println("Error encoding message:", err)
+		return
+	}
+	err = c.conn.Send(c.addr, data)
+	if errors.Is(err, rudp.ErrMessageTooLarge) {
+		utils.Print(protocol.New(protocol.TypeMessageTooLarge, protocol.Payload{Text: err.Error()}))
+	} else if err != nil {
+		fmt.Println("Error sending message:", err)
+	}
+}
 
-		broadcastMsg := dto.UDPMessageDTO{
-			Type: "broadcast",
-			Name: c.username,
-			Text: text,
-			Time: time.Now().Format("2006/01/02 15:04:05"),
-		}
-		data, _ := json.Marshal(broadcastMsg)
-		c.conn.Write(data)
+func (c *Client) currentCodec() protocol.Codec {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.codec
+}
+
+// waitDelivered ждёт подтверждения отправленных сообщений перед выходом,
+// чтобы exit не потерялся вместе с процессом
+func (c *Client) waitDelivered() {
+	deadline := time.Now().Add(2 * time.Second)
+	for c.conn.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
 	}
 }
 
 func (c *Client) registration() {
-	fmt.Print("Enter your name: ")
-	scanner := bufio.NewScanner(os.Stdin)
-	scanner.Scan()
-	c.username = scanner.Text()
+	utils.PromptCredentials(&c.creds)
+	c.username = c.creds.User
 
-	conn, err := net.DialUDP("udp", nil, c.addr)
+	conn, err := net.ListenUDP("udp", nil)
 	if err != nil {
 		fmt.Println("Error connecting:", err)
 		os.Exit(1)
 	}
-	c.conn = conn
+	c.conn = rudp.NewConn(conn, rudp.Config{
+		MaxMessageSize: c.maxMessage,
+		OnLost: func(net.Addr) {
+			fmt.Println("Server stopped acknowledging messages, disconnecting.")
+			os.Exit(1)
+		},
+		OnTooLarge: func(net.Addr) {
+			utils.Print(protocol.New(protocol.TypeMessageTooLarge, protocol.Payload{Text: "message from server dropped"}))
+		},
+	})
 
-	regMsg := dto.UDPMessageDTO{
-		Type: "register",
-		Name: c.username,
+	go func() {
+		err := c.conn.Serve(c.handle)
+		if err != nil && !errors.Is(err, rudp.ErrClosed) {
+			fmt.Println("Error reading from server:", err)
+		}
+	}()
+
+	// Токен возобновления UDP-клиенту не нужен: обрывы переживает rudp.
+	// Register отправляется после ответа на hello, уже в выбранном кодеке.
+	c.send(utils.Hello(c.codecName, protocol.CapPresence))
+	select {
+	case <-c.helloc:
+	case <-time.After(helloTimeout):
+		fmt.Println("No hello reply from server, continuing with json")
 	}
-	regData, _ := json.Marshal(regMsg)
-	c.conn.Write(regData)
-	fmt.Printf("Registered as %s\n", c.username)
+	c.send(protocol.New(protocol.TypeRegister, protocol.Payload{
+		Name:     c.username,
+		Password: c.creds.Password,
+		Token:    c.creds.Token,
+	}))
+	c.send(protocol.New(protocol.TypeHistory, protocol.Payload{
+		Name: c.username,
+	}))
 }
@@ -1,22 +1,91 @@
 package cfg
 
 import (
+	"chat/config"
+	"chat/protocol"
+	"chat/rudp"
 	"flag"
+	"os"
 )
 
 type Flag struct {
-	ProtoType string
-	IP        string
-	Port      string
+	ProtoType   string
+	IP          string
+	Port        string
+	WSPath      string // Путь WebSocket сервера для -p http
+	User        string
+	Password    string
+	Token       string
+	AskPassword bool
+
+	UDPMaxMessage int // Наибольшее сообщение UDP в байтах
+
+	Codec string // Кодек, который клиент просит в hello
+
+	TLS     bool   // Подключаться по TLS (tcp) или WSS (http)
+	CA      string // CA сервера в PEM вместо системных корневых сертификатов
+	TLSCert string // Клиентский сертификат и ключ для mTLS
+	TLSKey  string
+}
+
+// Keys - ключи файла настроек клиента. Переменные окружения - CHAT_ и имя
+// флага: CHAT_USER для -user.
+var Keys = []config.Key{
+	{Path: "server.protocol", Flag: "p", Env: "CHAT_PROTOCOL"},
+	{Path: "server.ip", Flag: "ip"},
+	{Path: "server.port", Flag: "port"},
+	{Path: "server.ws-path", Flag: "ws-path"},
+	{Path: "user.name", Flag: "user"},
+	{Path: "user.password", Flag: "password", Secret: true},
+	{Path: "user.token", Flag: "token", Secret: true},
+	{Path: "user.ask-password", Flag: "ask-password"},
+	{Path: "limits.udp-max-message", Flag: "udp-max-message"},
+	{Path: "protocol.codec", Flag: "codec"},
+	{Path: "tls.enabled", Flag: "tls"},
+	{Path: "tls.ca", Flag: "ca"},
+	{Path: "tls.cert", Flag: "tls-cert"},
+	{Path: "tls.key", Flag: "tls-key"},
+}
+
+// NewFlagsFromArgs читает настройки из командной строки, окружения и файла
+// -config. С -print-config выводит их и завершает программу.
+func NewFlagsFromArgs() (*Flag, error) {
+	f, printConfig, err := LoadFlags(flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	if printConfig {
+		if err := config.Print(os.Stdout, flag.CommandLine, Keys); err != nil {
+			return nil, err
+		}
+		os.Exit(0)
+	}
+	return f, nil
 }
 
-func NewFlagsFromArgs() *Flag {
+// LoadFlags разбирает args во флаги fs поверх файла настроек и переменных
+// окружения env. Второе значение - запрошен ли -print-config.
+func LoadFlags(fs *flag.FlagSet, args []string, env func(string) (string, bool)) (*Flag, bool, error) {
 	f := &Flag{}
 
-	flag.StringVar(&f.IP, "ip", "127.0.0.1", "ip address")
-	flag.StringVar(&f.Port, "port", "4545", "port")
-	flag.StringVar(&f.ProtoType, "p", "", "protocol type")
-	flag.Parse()
+	fs.StringVar(&f.IP, "ip", "127.0.0.1", "ip address")
+	fs.StringVar(&f.Port, "port", "4545", "port")
+	fs.StringVar(&f.ProtoType, "p", "", "protocol type (tcp, udp, http, sse)")
+	fs.StringVar(&f.WSPath, "ws-path", "/ws", "websocket endpoint path for -p http")
+	fs.StringVar(&f.User, "user", "", "username (asked on start if empty)")
+	fs.StringVar(&f.Password, "password", "", "password for servers with -auth password")
+	fs.StringVar(&f.Token, "token", "", "token for servers with -auth token")
+	fs.BoolVar(&f.AskPassword, "ask-password", false, "ask for the password on start")
+	fs.IntVar(&f.UDPMaxMessage, "udp-max-message", rudp.DefaultMaxMessageSize, "maximum udp message size in bytes")
+	fs.StringVar(&f.Codec, "codec", protocol.CodecJSON, "wire codec requested from the server (json, json-lp, msgpack)")
+	fs.BoolVar(&f.TLS, "tls", false, "connect over TLS (tcp) or WSS (http)")
+	fs.StringVar(&f.CA, "ca", "", "server CA certificate (PEM), system roots if empty")
+	fs.StringVar(&f.TLSCert, "tls-cert", "", "client certificate (PEM) for mutual TLS")
+	fs.StringVar(&f.TLSKey, "tls-key", "", "client private key (PEM) for mutual TLS")
 
-	return f
+	printConfig, err := config.Load(fs, args, Keys, env)
+	if err != nil {
+		return nil, false, err
+	}
+	return f, printConfig, nil
 }
@@ -3,61 +3,152 @@ package cfg
 import (
 	"chat/client/internal/app"
 	"chat/client/internal/app/http"
+	"chat/client/internal/app/sse"
 	"chat/client/internal/app/tcp"
 	"chat/client/internal/app/udp"
+	"chat/client/internal/model"
+	"chat/protocol"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	nethttp "net/http"
+	"strings"
 
 	"github.com/gorilla/websocket"
 )
 
 func Setup() (*app.App, error) {
-	flags := NewFlagsFromArgs()
+	flags, err := NewFlagsFromArgs()
+	if err != nil {
+		return nil, err
+	}
 	address := net.JoinHostPort(flags.IP, flags.Port)
+	creds := model.Credentials{
+		User:        flags.User,
+		Password:    flags.Password,
+		Token:       flags.Token,
+		AskPassword: flags.AskPassword,
+	}
+
+	if _, err := protocol.CodecByName(flags.Codec); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newTLSConfig(flags)
+	if err != nil {
+		return nil, err
+	}
+	// С клиентским сертификатом имя по умолчанию берётся из него
+	if creds.User == "" && tlsConfig != nil && len(tlsConfig.Certificates) > 0 && tlsConfig.Certificates[0].Leaf != nil {
+		creds.User = tlsConfig.Certificates[0].Leaf.Subject.CommonName
+	}
 
 	switch flags.ProtoType {
 	case "tcp":
-		return setupTCP(address)
+		return setupTCP(address, creds, flags.Codec, tlsConfig)
 
 	case "udp":
-		return setupUDP(address)
+		if tlsConfig != nil {
+			return nil, fmt.Errorf("tls is not supported for udp")
+		}
+		return setupUDP(address, creds, flags.Codec, flags.UDPMaxMessage)
 
 	case "http":
-		return setupHTTP(address)
+		if !strings.HasPrefix(flags.WSPath, "/") {
+			return nil, fmt.Errorf("invalid -ws-path %q: expected an absolute path like /ws", flags.WSPath)
+		}
+		return setupHTTP(address, flags.WSPath, creds, flags.Codec, tlsConfig)
+
+	case "sse":
+		if flags.Codec != protocol.CodecJSON {
+			return nil, fmt.Errorf("sse supports only the json codec")
+		}
+		return setupSSE(address, creds, tlsConfig)
 
 	default:
-		return nil, fmt.Errorf("unsupported protocol type: %s (expected: tcp, udp, http)", flags.ProtoType)
+		return nil, fmt.Errorf("unsupported protocol type: %s (expected: tcp, udp, http, sse)", flags.ProtoType)
 	}
 }
 
-func setupTCP(address string) (*app.App, error) {
-	conn, err := net.Dial("tcp", address)
+func setupTCP(address string, creds model.Credentials, codec string, tlsConfig *tls.Config) (*app.App, error) {
+	dial := func() (net.Conn, error) {
+		if tlsConfig != nil {
+			return tls.Dial("tcp", address, tlsConfig)
+		}
+		return net.Dial("tcp", address)
+	}
+	conn, err := dial()
 	if err != nil {
 		fmt.Println("Error connecting (TCP):", err.Error())
 		return nil, err
 	}
-	client := tcp.NewClient(conn)
+	client := tcp.NewClient(conn, creds)
+	client.SetDialer(dial)
+	client.SetCodec(codec)
 	return app.NewApp(client), nil
 }
 
-func setupUDP(address string) (*app.App, error) {
+func setupUDP(address string, creds model.Credentials, codec string, maxMessage int) (*app.App, error) {
 	addr, err := net.ResolveUDPAddr("udp", address)
 	if err != nil {
 		fmt.Println("Error resolving UDP address:", err.Error())
 		return nil, err
 	}
-	client := udp.NewClient(addr)
+	client := udp.NewClient(addr, creds)
+	client.SetMaxMessageSize(maxMessage)
+	client.SetCodec(codec)
 	return app.NewApp(client), nil
 }
 
-func setupHTTP(address string) (*app.App, error) {
-	
-	wsURL := fmt.Sprintf("ws://%s/ws", address)
-	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+func setupHTTP(address, wsPath string, creds model.Credentials, codec string, tlsConfig *tls.Config) (*app.App, error) {
+	scheme := "ws"
+	dialer := *websocket.DefaultDialer
+	if tlsConfig != nil {
+		scheme = "wss"
+		dialer.TLSClientConfig = tlsConfig
+	}
+
+	wsURL := fmt.Sprintf("%s://%s%s", scheme, address, wsPath)
+	dial := func() (*websocket.Conn, error) {
+		ws, _, err := dialer.Dial(wsURL, nil)
+		return ws, err
+	}
+	ws, err := dial()
+	if errors.Is(err, websocket.ErrBadHandshake) {
+		// Сервер или прокси по пути не пропустил upgrade: тот же чат доступен
+		// через SSE, но только в JSON, а другой кодек молча не подменяем
+		if codec != protocol.CodecJSON {
+			err = fmt.Errorf("websocket upgrade failed and sse supports only the json codec, not %s", codec)
+			fmt.Println("Error connecting (HTTP/WebSocket):", err.Error())
+			return nil, err
+		}
+		fmt.Println("WebSocket upgrade failed, falling back to SSE (use -p sse to skip WebSocket)")
+		return setupSSE(address, creds, tlsConfig)
+	}
 	if err != nil {
 		fmt.Println("Error connecting (HTTP/WebSocket):", err.Error())
 		return nil, err
 	}
-	client := http.NewClient(ws)
+	client := http.NewClient(ws, creds)
+	client.SetDialer(dial)
+	client.SetCodec(codec)
 	return app.NewApp(client), nil
 }
+
+func setupSSE(address string, creds model.Credentials, tlsConfig *tls.Config) (*app.App, error) {
+	scheme := "http"
+	client := &nethttp.Client{}
+	if tlsConfig != nil {
+		scheme = "https"
+		client.Transport = &nethttp.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	base := fmt.Sprintf("%s://%s", scheme, address)
+	stream, err := sse.Dial(client, base)
+	if err != nil {
+		fmt.Println("Error connecting (HTTP/SSE):", err.Error())
+		return nil, err
+	}
+	return app.NewApp(sse.NewClient(client, base, stream, creds)), nil
+}
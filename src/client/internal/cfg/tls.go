@@ -0,0 +1,43 @@
+package cfg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// newTLSConfig собирает настройки TLS из флагов; nil, если -tls не задан
+func newTLSConfig(flags *Flag) (*tls.Config, error) {
+	if !flags.TLS {
+		if flags.CA != "" || flags.TLSCert != "" || flags.TLSKey != "" {
+			return nil, fmt.Errorf("-ca, -tls-cert and -tls-key require -tls")
+		}
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if flags.CA != "" {
+		data, err := os.ReadFile(flags.CA)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("ca file %s contains no certificates", flags.CA)
+		}
+		config.RootCAs = pool
+	}
+
+	if flags.TLSCert != "" || flags.TLSKey != "" {
+		if flags.TLSCert == "" || flags.TLSKey == "" {
+			return nil, fmt.Errorf("-tls-cert and -tls-key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(flags.TLSCert, flags.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -1,9 +0,0 @@
-package dto
-
-type HTTPMessageDTO struct {
-	Type string `json:"type"`
-	Name string `json:"name"`
-	Text string `json:"text,omitempty"`
-	Time string `json:"time,omitempty"`
-	Dst  string `json:"dst,omitempty"`
-}
@@ -0,0 +1,9 @@
+package model
+
+// Credentials - имя пользователя и данные для входа
+type Credentials struct {
+	User        string
+	Password    string
+	Token       string
+	AskPassword bool // Спросить пароль в консоли, если он не передан флагом
+}
@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"chat/protocol"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Команды консоли клиента
+const (
+	CommandText    = ""
+	CommandExit    = "exit"
+	CommandWhisper = "whisper"
+	CommandJoin    = "join"
+	CommandLeave   = "leave"
+	CommandRooms   = "rooms"
+	CommandHistory = "history"
+	CommandWho     = "who"
+)
+
+// Command - разобранная строка, введённая пользователем
+type Command struct {
+	Name  string // Одна из констант Command*, CommandText для обычного сообщения
+	Arg   string // Получатель для whisper, комната для join, комната или собеседник для history
+	Text  string
+	Limit int // Количество сообщений для history
+}
+
+// ParseCommand разбирает строку консоли. Неизвестные команды отправляются
+// как обычный текст.
+func ParseCommand(line string) Command {
+	if !strings.HasPrefix(line, "/") {
+		return Command{Name: CommandText, Text: line}
+	}
+
+	name, rest, _ := strings.Cut(line[1:], " ")
+	rest = strings.TrimSpace(rest)
+
+	switch name {
+	case "exit":
+		return Command{Name: CommandExit}
+	case "w", "whisper":
+		dst, text, _ := strings.Cut(rest, " ")
+		return Command{Name: CommandWhisper, Arg: dst, Text: text}
+	case "join":
+		return Command{Name: CommandJoin, Arg: roomName(rest)}
+	case "leave":
+		return Command{Name: CommandLeave, Arg: roomName(rest)}
+	case "rooms":
+		return Command{Name: CommandRooms}
+	case "history":
+		return parseHistory(rest)
+	case "who":
+		return Command{Name: CommandWho}
+	default:
+		return Command{Name: CommandText, Text: line}
+	}
+}
+
+// Frame строит кадр команды cmd пользователя username. room - текущая
+// комната клиента: join и leave меняют её, обычный текст уходит в неё.
+// Ошибка - подсказка для консоли, кадр тогда не отправляется.
+func Frame(cmd Command, username string, room *string) (protocol.Envelope, error) {
+	switch cmd.Name {
+	case CommandExit:
+		return protocol.New(protocol.TypeExit, protocol.Payload{Name: username}), nil
+
+	case CommandWhisper:
+		// Формат: /w username message
+		if cmd.Arg == "" || cmd.Text == "" {
+			return protocol.Envelope{}, errors.New("usage: /w <username> <message>")
+		}
+		return protocol.New(protocol.TypeWhisper, protocol.Payload{
+			Name: username,
+			Text: cmd.Text,
+			Dst:  cmd.Arg,
+		}), nil
+
+	case CommandJoin:
+		if cmd.Arg == "" {
+			return protocol.Envelope{}, errors.New("usage: /join #room")
+		}
+		*room = cmd.Arg
+		return protocol.New(protocol.TypeJoin, protocol.Payload{
+			Name: username,
+			Room: cmd.Arg,
+		}), nil
+
+	case CommandLeave:
+		target := cmd.Arg
+		if target == "" {
+			target = *room
+		}
+		if target == "" {
+			return protocol.Envelope{}, errors.New("you are not in a room")
+		}
+		if target == *room {
+			*room = ""
+		}
+		return protocol.New(protocol.TypeLeave, protocol.Payload{
+			Name: username,
+			Room: target,
+		}), nil
+
+	case CommandRooms:
+		return protocol.New(protocol.TypeRooms, protocol.Payload{Name: username}), nil
+
+	case CommandWho:
+		return protocol.New(protocol.TypeWho, protocol.Payload{Name: username}), nil
+
+	case CommandHistory:
+		msg := protocol.New(protocol.TypeHistory, protocol.Payload{
+			Name:  username,
+			Limit: cmd.Limit,
+		})
+		if strings.HasPrefix(cmd.Arg, "#") {
+			msg.Payload.Room = cmd.Arg
+		} else {
+			msg.Payload.Dst = cmd.Arg
+		}
+		return msg, nil
+
+	default:
+		return protocol.New(protocol.TypeBroadcast, protocol.Payload{
+			Name: username,
+			Text: cmd.Text,
+			Room: *room,
+		}), nil
+	}
+}
+
+// roomName приводит имя комнаты к виду #name, как это делает сервер
+func roomName(room string) string {
+	if room == "" || strings.HasPrefix(room, "#") {
+		return room
+	}
+	return "#" + room
+}
+
+// parseHistory разбирает аргументы /history [#room|username] [n]
+func parseHistory(args string) Command {
+	cmd := Command{Name: CommandHistory}
+	for _, field := range strings.Fields(args) {
+		if n, err := strconv.Atoi(field); err == nil {
+			cmd.Limit = n
+			continue
+		}
+		cmd.Arg = field
+	}
+	return cmd
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"chat/protocol"
+	"fmt"
+	"strings"
+)
+
+const (
+	ColorReset   = "\033[0m"
+	ColorGreen   = "\033[32m"
+	ColorBlue    = "\033[34m"
+	ColorMagenta = "\033[35m"
+	ColorRed     = "\033[31m"
+	ColorGray    = "\033[90m"
+)
+
+// Print выводит кадр сервера в консоль и приглашает ввести следующее
+// сообщение. После auth_failed и server_shutdown приглашения нет: клиент
+// завершается.
+func Print(msg protocol.Envelope) {
+	p := msg.Payload
+	timeStr := ""
+	if msg.Time != "" {
+		timeStr = fmt.Sprintf("%s[%s]%s ", ColorBlue, msg.Time, ColorReset)
+	}
+	if p.History {
+		timeStr = fmt.Sprintf("%s[history]%s ", ColorGray, ColorReset) + timeStr
+	}
+	nameStr := ""
+	if p.Name != "" {
+		nameStr = fmt.Sprintf("%s%s%s", ColorGreen, p.Name, ColorReset)
+	}
+
+	switch msg.Type {
+	case protocol.TypeWhisper:
+		fmt.Printf("%s%s[whisper]%s %s: %s\n",
+			timeStr,
+			ColorMagenta, ColorReset,
+			nameStr,
+			p.Text,
+		)
+	case protocol.TypeBroadcast:
+		roomStr := ""
+		if p.Room != "" {
+			roomStr = fmt.Sprintf("%s[%s]%s ", ColorMagenta, p.Room, ColorReset)
+		}
+		fmt.Printf("%s%s%s: %s\n",
+			timeStr,
+			roomStr,
+			nameStr,
+			p.Text,
+		)
+	case protocol.TypeJoin:
+		fmt.Printf("%s joined %s\n", nameStr, p.Room)
+	case protocol.TypeLeave:
+		fmt.Printf("%s left %s\n", nameStr, p.Room)
+	case protocol.TypeRooms:
+		if len(p.Rooms) == 0 {
+			fmt.Println("No rooms")
+		} else {
+			fmt.Printf("Rooms: %s\n", strings.Join(p.Rooms, ", "))
+		}
+	case protocol.TypeUserJoined:
+		fmt.Printf("%s joined the chat\n", nameStr)
+	case protocol.TypeUserLeft:
+		if p.Reason != "" {
+			fmt.Printf("%s left the chat (%s)\n", nameStr, p.Reason)
+		} else {
+			fmt.Printf("%s left the chat\n", nameStr)
+		}
+	case protocol.TypeWho:
+		fmt.Printf("Online: %s\n", strings.Join(p.Users, ", "))
+	case protocol.TypeError:
+		fmt.Printf("%s[error]%s %s\n", ColorRed, ColorReset, errorText(p))
+	case protocol.TypeMessageTooLarge:
+		fmt.Printf("%s[message too large]%s %s\n", ColorRed, ColorReset, errorText(p))
+	case protocol.TypeRateLimited:
+		fmt.Printf("%s[rate limited]%s %s\n", ColorRed, ColorReset, errorText(p))
+	case protocol.TypeInvalidInput:
+		fmt.Printf("%s[invalid input]%s %s\n", ColorRed, ColorReset, errorText(p))
+	case protocol.TypeAuthFailed:
+		fmt.Printf("%s[auth failed]%s %s\n", ColorRed, ColorReset, errorText(p))
+		return
+	case protocol.TypeRegistered:
+		fmt.Printf("Registered as %s\n", nameStr)
+	case protocol.TypeMOTD:
+		fmt.Printf("%s[motd]%s %s\n", ColorGray, ColorReset, p.Text)
+	case protocol.TypeResumed:
+		fmt.Printf("%s[reconnected]%s %s\n", ColorGray, ColorReset, p.Text)
+	case protocol.TypeServerShutdown:
+		fmt.Printf("%s[server shutdown]%s %s\n", ColorRed, ColorReset, p.Text)
+		return
+	default:
+		fmt.Println(p.Text)
+	}
+	fmt.Print("Enter text to send:\n")
+}
+
+// Hello - первый кадр клиента: версии протокола, которые он понимает,
+// желаемый кодек и нужные ему возможности. Hello всегда отправляется в JSON.
+func Hello(codec string, capabilities ...string) protocol.Envelope {
+	return protocol.New(protocol.TypeHello, protocol.Payload{
+		Versions:     protocol.SupportedVersions,
+		Capabilities: capabilities,
+		Codecs:       []string{codec},
+	})
+}
+
+// NegotiatedCodec возвращает кодек из ответа сервера на hello. Если сервер
+// кодек не выбрал или ответил ошибкой, соединение остаётся в JSON.
+func NegotiatedCodec(reply protocol.Envelope) protocol.Codec {
+	if reply.Type != protocol.TypeHello || len(reply.Payload.Codecs) == 0 {
+		return protocol.JSON
+	}
+	c, err := protocol.CodecByName(reply.Payload.Codecs[0])
+	if err != nil {
+		return protocol.JSON
+	}
+	return c
+}
+
+// errorText - текст ошибки с кодом протокола, если сервер его прислал
+func errorText(p protocol.Payload) string {
+	if p.Code == "" {
+		return p.Text
+	}
+	return fmt.Sprintf("%s (%s)", p.Text, p.Code)
+}
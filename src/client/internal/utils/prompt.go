@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"bufio"
+	"chat/client/internal/model"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// PromptCredentials спрашивает в консоли то, что не передано флагами:
+// имя пользователя и, если нужно, пароль (без эха, когда stdin - терминал)
+func PromptCredentials(creds *model.Credentials) {
+	if creds.User == "" {
+		fmt.Print("Enter your name: ")
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Scan()
+		creds.User = scanner.Text()
+	}
+
+	if creds.AskPassword && creds.Password == "" {
+		creds.Password = readPassword("Password: ")
+	}
+}
+
+func readPassword(prompt string) string {
+	fmt.Print(prompt)
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		password, _ := term.ReadPassword(fd)
+		fmt.Println()
+		return string(password)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	password, _ := reader.ReadString('\n')
+	return strings.TrimRight(password, "\r\n")
+}
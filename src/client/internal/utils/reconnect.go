@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"chat/protocol"
+	"time"
+)
+
+// ReconnectDelays - паузы между попытками переподключения, в сумме около
+// 30 секунд: столько сервер по умолчанию держит сессию
+var ReconnectDelays = []time.Duration{
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	4 * time.Second,
+	5 * time.Second,
+	5 * time.Second,
+	5 * time.Second,
+	5 * time.Second,
+}
+
+// ResumeRef - метка кадра resume. Ошибка с этой меткой в ref значит, что
+// сервер не вернул сессию и нужно зарегистрироваться заново.
+const ResumeRef = "resume"
+
+// ResumeRequest возвращает кадр resume с меткой ResumeRef
+func ResumeRequest(name, token string) protocol.Envelope {
+	msg := protocol.New(protocol.TypeResume, protocol.Payload{Name: name, Token: token})
+	msg.Ref = ResumeRef
+	return msg
+}
+
+// ResumeRejected сообщает, что msg - ошибка в ответ на ResumeRequest
+func ResumeRejected(msg protocol.Envelope) bool {
+	return msg.IsError() && msg.Ref == ResumeRef
+}
+
+// Retry вызывает fn, пока она не выполнится без ошибки, выдерживая перед
+// каждой попыткой очередную паузу из delays. Возвращает последнюю ошибку.
+func Retry(delays []time.Duration, fn func() error) error {
+	var err error
+	for _, d := range delays {
+		time.Sleep(d)
+		if err = fn(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"chat/protocol"
+	"strconv"
+	"sync"
+)
+
+// refsKept - сколько последних кадров помнит Refs. Ошибка приходит сразу
+// после кадра, более старые метки не нужны.
+const refsKept = 64
+
+// Refs ставит кадрам клиента метки ref и помнит, какой кадр ушёл под
+// меткой: сервер повторяет ref в ошибке, и по нему видно, какая команда
+// не выполнена. Нулевое значение готово к работе.
+type Refs struct {
+	mu    sync.Mutex
+	next  uint64
+	sent  map[string]protocol.Envelope
+	order []string // Метки в порядке отправки, для забывания старых
+}
+
+// Tag ставит кадру очередную метку и запоминает его. Метку, заданную
+// заранее, например ResumeRef, не меняет.
+func (r *Refs) Tag(msg *protocol.Envelope) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if msg.Ref == "" {
+		r.next++
+		msg.Ref = strconv.FormatUint(r.next, 10)
+	}
+	if r.sent == nil {
+		r.sent = make(map[string]protocol.Envelope)
+	}
+	if len(r.order) == refsKept {
+		delete(r.sent, r.order[0])
+		r.order = r.order[1:]
+	}
+	r.sent[msg.Ref] = *msg
+	r.order = append(r.order, msg.Ref)
+}
+
+// Reaction - что клиенту делать после кадра ошибки
+type Reaction int
+
+const (
+	Continue   Reaction = iota // Ошибка только показывается
+	Reregister                 // Сервер не вернул сессию: зарегистрироваться заново
+	Quit                       // Сервер не принял регистрацию: работать дальше нельзя
+)
+
+// React разбирает кадр ошибки msg по ref и коду
+func (r *Refs) React(msg protocol.Envelope) Reaction {
+	if !msg.IsError() || msg.Ref == "" {
+		return Continue
+	}
+	if ResumeRejected(msg) {
+		return Reregister
+	}
+	r.mu.Lock()
+	frame, ok := r.sent[msg.Ref]
+	r.mu.Unlock()
+	// При лимите регистраций с наказанием warn сервер всё же принимает register
+	if ok && frame.Type == protocol.TypeRegister && msg.Payload.Code != protocol.CodeRateLimited {
+		return Quit
+	}
+	return Continue
+}
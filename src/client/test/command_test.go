@@ -0,0 +1,70 @@
+package test
+
+import (
+	"chat/client/internal/utils"
+	"chat/protocol"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected utils.Command
+	}{
+		{"hello", utils.Command{Name: utils.CommandText, Text: "hello"}},
+		{"/exit", utils.Command{Name: utils.CommandExit}},
+		{"/w bob hi there", utils.Command{Name: utils.CommandWhisper, Arg: "bob", Text: "hi there"}},
+		{"/whisper bob hi", utils.Command{Name: utils.CommandWhisper, Arg: "bob", Text: "hi"}},
+		{"/w bob", utils.Command{Name: utils.CommandWhisper, Arg: "bob"}},
+		{"/join #dev", utils.Command{Name: utils.CommandJoin, Arg: "#dev"}},
+		{"/join dev", utils.Command{Name: utils.CommandJoin, Arg: "#dev"}},
+		{"/leave", utils.Command{Name: utils.CommandLeave}},
+		{"/leave #dev", utils.Command{Name: utils.CommandLeave, Arg: "#dev"}},
+		{"/rooms", utils.Command{Name: utils.CommandRooms}},
+		{"/history", utils.Command{Name: utils.CommandHistory}},
+		{"/history #dev 50", utils.Command{Name: utils.CommandHistory, Arg: "#dev", Limit: 50}},
+		{"/who", utils.Command{Name: utils.CommandWho}},
+		{"/history bob", utils.Command{Name: utils.CommandHistory, Arg: "bob"}},
+		{"/shrug ok", utils.Command{Name: utils.CommandText, Text: "/shrug ok"}},
+	}
+
+	for _, c := range cases {
+		got := utils.ParseCommand(c.input)
+		if got != c.expected {
+			t.Errorf("ParseCommand(%q) = %+v, want %+v", c.input, got, c.expected)
+		}
+	}
+}
+
+func TestFrame(t *testing.T) {
+	room := ""
+	cases := []struct {
+		input    string
+		expected protocol.Envelope
+		room     string // Текущая комната после команды
+	}{
+		{"hello", protocol.New(protocol.TypeBroadcast, protocol.Payload{Name: "alice", Text: "hello"}), ""},
+		{"/w bob hi", protocol.New(protocol.TypeWhisper, protocol.Payload{Name: "alice", Dst: "bob", Text: "hi"}), ""},
+		{"/join dev", protocol.New(protocol.TypeJoin, protocol.Payload{Name: "alice", Room: "#dev"}), "#dev"},
+		{"in room", protocol.New(protocol.TypeBroadcast, protocol.Payload{Name: "alice", Text: "in room", Room: "#dev"}), "#dev"},
+		{"/history #dev 5", protocol.New(protocol.TypeHistory, protocol.Payload{Name: "alice", Room: "#dev", Limit: 5}), "#dev"},
+		{"/history bob", protocol.New(protocol.TypeHistory, protocol.Payload{Name: "alice", Dst: "bob"}), "#dev"},
+		{"/leave", protocol.New(protocol.TypeLeave, protocol.Payload{Name: "alice", Room: "#dev"}), ""},
+		{"/who", protocol.New(protocol.TypeWho, protocol.Payload{Name: "alice"}), ""},
+		{"/exit", protocol.New(protocol.TypeExit, protocol.Payload{Name: "alice"}), ""},
+	}
+	for _, c := range cases {
+		got, err := utils.Frame(utils.ParseCommand(c.input), "alice", &room)
+		if err != nil || !reflect.DeepEqual(got, c.expected) || room != c.room {
+			t.Errorf("Frame(%q) = %+v, %v, room %q; want %+v, room %q", c.input, got, err, room, c.expected, c.room)
+		}
+	}
+
+	// Неполные команды не отправляются
+	for _, input := range []string{"/w bob", "/join", "/leave"} {
+		if _, err := utils.Frame(utils.ParseCommand(input), "alice", &room); err == nil {
+			t.Errorf("Frame(%q): want a usage error", input)
+		}
+	}
+}
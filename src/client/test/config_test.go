@@ -0,0 +1,66 @@
+package test
+
+import (
+	"bytes"
+	"chat/client/internal/cfg"
+	"chat/config"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadFlags_ClientConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.toml")
+	data := `
+[server]
+protocol = "http"
+ip = "chat.example.com"
+ws-path = "/chat/ws"
+
+[user]
+name = "alice"
+password = "s3cret"
+
+[protocol]
+codec = "msgpack"
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"CHAT_USER": "bob", "CHAT_PORT": "8080", "CHAT_CODEC": "json-lp"}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	f, printConfig, err := cfg.LoadFlags(fs, []string{"-config", path, "-codec", "json", "-print-config"}, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := cfg.Flag{
+		ProtoType:     "http",
+		IP:            "chat.example.com",
+		Port:          "8080",
+		WSPath:        "/chat/ws",
+		User:          "bob",
+		Password:      "s3cret",
+		UDPMaxMessage: f.UDPMaxMessage,
+		Codec:         "json",
+	}
+	if *f != want || !printConfig {
+		t.Errorf("got %+v (print %v), want %+v", *f, printConfig, want)
+	}
+
+	var out bytes.Buffer
+	if err := config.Print(&out, fs, cfg.Keys); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "s3cret") || !strings.Contains(out.String(), "  name: bob\n") {
+		t.Errorf("unexpected -print-config output:\n%s", out.String())
+	}
+}
@@ -0,0 +1,56 @@
+package test
+
+import (
+	"chat/client/internal/utils"
+	"chat/protocol"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	delays := []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}
+	errDown := errors.New("server down")
+
+	calls := 0
+	err := utils.Retry(delays, func() error {
+		calls++
+		if calls < 2 {
+			return errDown
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("want success on the second attempt, got err %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = utils.Retry(delays, func() error {
+		calls++
+		return errDown
+	})
+	if !errors.Is(err, errDown) || calls != len(delays) {
+		t.Errorf("want %v after %d calls, got %v after %d", errDown, len(delays), err, calls)
+	}
+}
+
+func TestResumeRejected(t *testing.T) {
+	req := utils.ResumeRequest("alice", "t0k3n")
+	if req.Type != protocol.TypeResume || req.Ref != utils.ResumeRef || req.Payload.Token != "t0k3n" {
+		t.Fatalf("unexpected resume frame %+v", req)
+	}
+
+	rejected := protocol.New(protocol.TypeError, protocol.Payload{Code: protocol.CodeResumeFailed})
+	rejected.Ref = req.Ref
+	other := protocol.New(protocol.TypeError, protocol.Payload{Code: protocol.CodeNotRegistered})
+	other.Ref = "q1"
+	reply := protocol.New(protocol.TypeResumed, protocol.Payload{})
+	reply.Ref = req.Ref
+
+	if !utils.ResumeRejected(rejected) {
+		t.Error("error with the resume ref should reject the resume")
+	}
+	if utils.ResumeRejected(other) || utils.ResumeRejected(reply) {
+		t.Error("only errors with the resume ref reject the resume")
+	}
+}
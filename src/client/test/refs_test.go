@@ -0,0 +1,57 @@
+package test
+
+import (
+	"chat/client/internal/utils"
+	"chat/protocol"
+	"testing"
+)
+
+func TestRefs_React(t *testing.T) {
+	var refs utils.Refs
+	register := protocol.New(protocol.TypeRegister, protocol.Payload{Name: "alice"})
+	refs.Tag(&register)
+	whisper := protocol.New(protocol.TypeWhisper, protocol.Payload{Dst: "bob", Text: "hi"})
+	refs.Tag(&whisper)
+	resume := utils.ResumeRequest("alice", "t0k3n")
+	refs.Tag(&resume)
+
+	if register.Ref == "" || whisper.Ref == "" || register.Ref == whisper.Ref {
+		t.Fatalf("frames need distinct refs: %q, %q", register.Ref, whisper.Ref)
+	}
+	if resume.Ref != utils.ResumeRef {
+		t.Fatalf("resume ref changed to %q", resume.Ref)
+	}
+
+	reply := func(ref, typ, code string) protocol.Envelope {
+		msg := protocol.New(typ, protocol.Payload{Code: code})
+		msg.Ref = ref
+		return msg
+	}
+	cases := []struct {
+		name string
+		msg  protocol.Envelope
+		want utils.Reaction
+	}{
+		{"name taken", reply(register.Ref, protocol.TypeError, protocol.CodeNameTaken), utils.Quit},
+		{"invalid name", reply(register.Ref, protocol.TypeInvalidInput, protocol.CodeNameInvalid), utils.Quit},
+		{"register rate limited", reply(register.Ref, protocol.TypeRateLimited, protocol.CodeRateLimited), utils.Continue},
+		{"whisper failed", reply(whisper.Ref, protocol.TypeError, protocol.CodeUserNotFound), utils.Continue},
+		{"resume failed", reply(resume.Ref, protocol.TypeError, protocol.CodeResumeFailed), utils.Reregister},
+		{"unknown ref", reply("999", protocol.TypeError, protocol.CodeNameTaken), utils.Continue},
+		{"not an error", reply(register.Ref, protocol.TypeWho, ""), utils.Continue},
+	}
+	for _, c := range cases {
+		if got := refs.React(c.msg); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	// Старые метки забываются
+	for i := 0; i < 100; i++ {
+		msg := protocol.New(protocol.TypeWho, protocol.Payload{})
+		refs.Tag(&msg)
+	}
+	if got := refs.React(reply(register.Ref, protocol.TypeError, protocol.CodeNameTaken)); got != utils.Continue {
+		t.Errorf("forgotten register ref: got %v, want %v", got, utils.Continue)
+	}
+}
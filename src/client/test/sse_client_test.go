@@ -0,0 +1,223 @@
+package test
+
+import (
+	"bufio"
+	"chat/client/internal/app/sse"
+	"chat/client/internal/model"
+	"chat/client/internal/utils"
+	"chat/protocol"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sseBaseEnv - адрес сервера для клиента, запущенного в дочернем процессе
+const sseBaseEnv = "CHAT_SSE_TEST_BASE"
+
+// sentFrame - кадр, который клиент отправил запросом POST /send
+type sentFrame struct {
+	conn string
+	msg  protocol.Envelope
+}
+
+// sseServer изображает SSE-транспорт сервера: каждый GET /events получает
+// свой id соединения и поток кадров, POST /send складывает кадры в sent
+type sseServer struct {
+	mu      sync.Mutex
+	next    int
+	streams map[string]chan protocol.Envelope
+	sent    chan sentFrame
+}
+
+func newSSEServer(t *testing.T) (*sseServer, string) {
+	t.Helper()
+	s := &sseServer{
+		streams: make(map[string]chan protocol.Envelope),
+		sent:    make(chan sentFrame, 64),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", s.events)
+	mux.HandleFunc("POST /send", s.send)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return s, srv.URL
+}
+
+func (s *sseServer) events(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.next++
+	id := fmt.Sprintf("c%d", s.next)
+	out := make(chan protocol.Envelope, 16)
+	s.streams[id] = out
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	fmt.Fprintf(w, "event: open\ndata: {\"conn\":%q}\n\n", id)
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case msg, ok := <-out:
+			if !ok {
+				return
+			}
+			data, _ := protocol.Encode(msg)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *sseServer) send(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg, err := protocol.Decode(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.sent <- sentFrame{conn: r.URL.Query().Get("conn"), msg: msg}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// push отправляет кадр в поток соединения conn
+func (s *sseServer) push(conn string, msg protocol.Envelope) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams[conn] <- msg
+}
+
+// drop обрывает поток соединения conn
+func (s *sseServer) drop(conn string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.streams[conn])
+	delete(s.streams, conn)
+}
+
+// expect ждёт от клиента кадр типа typ по соединению conn
+func (s *sseServer) expect(t *testing.T, conn, typ string) protocol.Envelope {
+	t.Helper()
+	select {
+	case f := <-s.sent:
+		if f.conn != conn || f.msg.Type != typ {
+			t.Fatalf("want %s on %s, got %s on %s: %+v", typ, conn, f.msg.Type, f.conn, f.msg)
+		}
+		return f.msg
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timeout waiting for %s on %s", typ, conn)
+	}
+	return protocol.Envelope{}
+}
+
+// waitOutput читает вывод клиента, пока не встретит want
+func waitOutput(t *testing.T, lines <-chan string, want string) {
+	t.Helper()
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatalf("client exited before printing %q", want)
+			}
+			if strings.Contains(line, want) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for %q in client output", want)
+		}
+	}
+}
+
+// SSE-клиент выходит из процесса через os.Exit и читает консоль, поэтому
+// работает в дочернем процессе теста
+func TestSSEClient_RegisterBroadcastResume(t *testing.T) {
+	if base := os.Getenv(sseBaseEnv); base != "" {
+		stream, err := sse.Dial(http.DefaultClient, base)
+		if err != nil {
+			fmt.Println("dial:", err)
+			os.Exit(2)
+		}
+		sse.NewClient(http.DefaultClient, base, stream, model.Credentials{User: "alice"}).ConnectToChat()
+		return
+	}
+
+	server, base := newSSEServer(t)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSSEClient_RegisterBroadcastResume$")
+	cmd.Env = append(os.Environ(), sseBaseEnv+"="+base)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill() })
+	lines := make(chan string, 64)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	// Открытый поток: клиент представляется и регистрируется
+	server.expect(t, "c1", protocol.TypeHello)
+	register := server.expect(t, "c1", protocol.TypeRegister)
+	if register.Payload.Name != "alice" || register.Ref == "" {
+		t.Fatalf("unexpected register %+v", register)
+	}
+	server.expect(t, "c1", protocol.TypeHistory)
+
+	server.push("c1", protocol.New(protocol.TypeSession, protocol.Payload{Token: "t0k3n"}))
+	registered := protocol.New(protocol.TypeRegistered, protocol.Payload{Name: "alice"})
+	registered.Ref = register.Ref
+	server.push("c1", registered)
+	waitOutput(t, lines, "Registered as")
+
+	// Рассылка приходит в поток, свои сообщения уходят в POST /send
+	server.push("c1", protocol.New(protocol.TypeBroadcast, protocol.Payload{Name: "bob", Text: "hi all"}))
+	waitOutput(t, lines, "hi all")
+	fmt.Fprintln(stdin, "hello bob")
+	if msg := server.expect(t, "c1", protocol.TypeBroadcast); msg.Payload.Text != "hello bob" {
+		t.Fatalf("unexpected broadcast %+v", msg)
+	}
+
+	// Обрыв потока: клиент открывает новый и просит вернуть сессию по токену
+	server.drop("c1")
+	waitOutput(t, lines, "reconnecting")
+	server.expect(t, "c2", protocol.TypeHello)
+	resume := server.expect(t, "c2", protocol.TypeResume)
+	if resume.Payload.Name != "alice" || resume.Payload.Token != "t0k3n" || resume.Ref != utils.ResumeRef {
+		t.Fatalf("unexpected resume %+v", resume)
+	}
+	resumed := protocol.New(protocol.TypeResumed, protocol.Payload{})
+	resumed.Ref = resume.Ref
+	server.push("c2", resumed)
+	waitOutput(t, lines, "[reconnected]")
+
+	// После /exit клиент не переподключается и завершается
+	fmt.Fprintln(stdin, "/exit")
+	server.expect(t, "c2", protocol.TypeExit)
+	for range lines {
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("client exited with %v", err)
+	}
+}
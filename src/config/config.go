@@ -0,0 +1,216 @@
+// Package config - настройки сервера и клиента из трёх источников поверх
+// значений флагов по умолчанию: файла YAML или TOML (-config), переменных
+// окружения CHAT_* и флагов командной строки. Флаг важнее переменной
+// окружения, переменная - файла. Каждая настройка - это флаг программы,
+// поэтому значения из файла и окружения разбираются и проверяются так же,
+// как из командной строки.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvConfig - переменная окружения с путём к файлу настроек
+const EnvConfig = "CHAT_CONFIG"
+
+// Key связывает ключ файла настроек с флагом программы
+type Key struct {
+	Path   string // Секция и имя в файле через точку: timeouts.shutdown
+	Flag   string // Имя флага без дефиса
+	Env    string // Переменная окружения, по умолчанию CHAT_ и имя флага
+	Secret bool   // Не показывать значение в -print-config
+	Reload bool   // Можно менять на работающем сервере без перезапуска
+}
+
+// EnvName возвращает переменную окружения ключа
+func (k Key) EnvName() string {
+	if k.Env != "" {
+		return k.Env
+	}
+	return "CHAT_" + strings.ToUpper(strings.ReplaceAll(k.Flag, "-", "_"))
+}
+
+// Load добавляет в fs флаги -config и -print-config, разбирает args и
+// дополняет флаги, не заданные в командной строке, значениями из окружения
+// env и файла настроек. Возвращает, запрошен ли -print-config.
+func Load(fs *flag.FlagSet, args []string, keys []Key, env func(string) (string, bool)) (bool, error) {
+	defaultPath, _ := env(EnvConfig)
+	path := fs.String("config", defaultPath, "config file (.yaml, .yml or .toml), also $"+EnvConfig)
+	printConfig := fs.Bool("print-config", false, "print the effective configuration as yaml and exit")
+	if err := fs.Parse(args); err != nil {
+		return false, err
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	if *path != "" {
+		values, err := readFile(*path)
+		if err != nil {
+			return false, err
+		}
+		byPath := make(map[string]Key, len(keys))
+		for _, k := range keys {
+			byPath[k.Path] = k
+		}
+		for _, v := range values {
+			k, ok := byPath[v.path]
+			if !ok {
+				return false, fmt.Errorf("config file %s: unknown key %q", *path, v.path)
+			}
+			if explicit[k.Flag] {
+				continue
+			}
+			if err := set(fs, k, v.value); err != nil {
+				return false, fmt.Errorf("config file %s: %s: %w", *path, k.Path, err)
+			}
+		}
+	}
+
+	for _, k := range keys {
+		value, ok := env(k.EnvName())
+		if !ok || explicit[k.Flag] {
+			continue
+		}
+		if err := set(fs, k, value); err != nil {
+			return false, fmt.Errorf("environment %s: %w", k.EnvName(), err)
+		}
+	}
+	return *printConfig, nil
+}
+
+// Values возвращает текущие значения флагов keys в виде строк: по ним
+// видно, какие настройки изменились между двумя чтениями
+func Values(fs *flag.FlagSet, keys []Key) map[string]string {
+	values := make(map[string]string, len(keys))
+	for _, k := range keys {
+		if f := fs.Lookup(k.Flag); f != nil {
+			values[k.Flag] = f.Value.String()
+		}
+	}
+	return values
+}
+
+func set(fs *flag.FlagSet, k Key, value string) error {
+	f := fs.Lookup(k.Flag)
+	if f == nil {
+		return fmt.Errorf("no flag -%s", k.Flag)
+	}
+	if err := fs.Set(k.Flag, value); err != nil {
+		return fmt.Errorf("invalid value %q: %s", value, expected(f, err))
+	}
+	return nil
+}
+
+// expected описывает, какое значение ждёт флаг
+func expected(f *flag.Flag, err error) string {
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return err.Error()
+	}
+	switch getter.Get().(type) {
+	case bool:
+		return "expected true or false"
+	case int, int64, uint, uint64:
+		return "expected an integer"
+	case float64:
+		return "expected a number"
+	case time.Duration:
+		return "expected a duration like 30s or 1m30s"
+	default:
+		return err.Error()
+	}
+}
+
+type fileValue struct {
+	path  string
+	value string
+}
+
+// readFile читает файл настроек и раскладывает вложенные секции в пары
+// секция.имя - значение. Формат выбирается по расширению.
+func readFile(path string) ([]fileValue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	tree := make(map[string]any)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format %q (expected .yaml, .yml or .toml)", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	var values []fileValue
+	if err := flatten("", tree, &values); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+func flatten(prefix string, tree map[string]any, values *[]fileValue) error {
+	for _, name := range slices.Sorted(maps.Keys(tree)) {
+		v := tree[name]
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		switch v := v.(type) {
+		case map[string]any:
+			if err := flatten(path, v, values); err != nil {
+				return err
+			}
+		default:
+			value, err := scalar(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			*values = append(*values, fileValue{path: path, value: value})
+		}
+	}
+	return nil
+}
+
+// scalar приводит значение из файла к строке флага. Список становится
+// строкой через запятую, как в флагах -p и -codecs.
+func scalar(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := scalar(item)
+			if err != nil {
+				return "", err
+			}
+			if _, nested := item.([]any); nested {
+				return "", fmt.Errorf("nested lists are not supported")
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", v)
+	}
+}
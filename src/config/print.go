@@ -0,0 +1,67 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Print выводит действующие настройки в YAML по секциям в порядке keys.
+// Вывод можно сохранить и передать в -config; значения секретов скрыты.
+func Print(w io.Writer, fs *flag.FlagSet, keys []Key) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := make(map[string]*yaml.Node)
+	for _, k := range keys {
+		f := fs.Lookup(k.Flag)
+		if f == nil {
+			return fmt.Errorf("no flag -%s", k.Flag)
+		}
+		section, name, ok := strings.Cut(k.Path, ".")
+		parent := root
+		if ok {
+			if sections[section] == nil {
+				sections[section] = &yaml.Node{Kind: yaml.MappingNode}
+				root.Content = append(root.Content, stringNode(section), sections[section])
+			}
+			parent = sections[section]
+		} else {
+			name = section
+		}
+
+		value := &yaml.Node{}
+		if err := value.Encode(printable(f, k.Secret)); err != nil {
+			return err
+		}
+		parent.Content = append(parent.Content, stringNode(name), value)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// printable возвращает значение флага того типа, который примет Load
+func printable(f *flag.Flag, secret bool) any {
+	if secret && f.Value.String() != "" {
+		return "<hidden>"
+	}
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return f.Value.String()
+	}
+	if d, ok := getter.Get().(time.Duration); ok {
+		return d.String()
+	}
+	return getter.Get()
+}
+
+func stringNode(s string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+}
@@ -0,0 +1,218 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Имена кодеков. Соединение начинается в CodecJSON, другой кодек клиент
+// запрашивает в hello и переходит на него после ответа сервера.
+const (
+	CodecJSON    = "json"    // JSON, в потоке кадры разделены переводом строки
+	CodecJSONLP  = "json-lp" // JSON, в потоке перед кадром его длина
+	CodecMsgpack = "msgpack" // MessagePack, в потоке перед кадром его длина
+)
+
+// DefaultMaxFrameSize - наибольший кадр, который читается из потока
+const DefaultMaxFrameSize = 1 << 20
+
+// lengthSize - размер префикса длины кадра: uint32, big-endian
+const lengthSize = 4
+
+var (
+	ErrUnknownCodec  = errors.New("unknown codec")
+	ErrFrameTooLarge = errors.New("frame too large")
+)
+
+// Codec кодирует кадры и разделяет их в потоке байт. В датаграммах и
+// сообщениях WebSocket границы кадра задаёт транспорт, там нужны только
+// Marshal и Unmarshal.
+type Codec interface {
+	Name() string
+	Marshal(e Envelope) ([]byte, error)
+	// Unmarshal разбирает кадр и проверяет версию, как Decode
+	Unmarshal(data []byte) (Envelope, error)
+	// Binary сообщает, что кадры не текстовые: WebSocket отправляет их
+	// бинарными сообщениями
+	Binary() bool
+
+	// WriteFrame записывает закодированный кадр в поток
+	WriteFrame(w io.Writer, data []byte) error
+	// ReadFrame читает из потока следующий кадр не больше max байт. Слишком
+	// большой кадр пропускается и возвращается ErrFrameTooLarge, после
+	// чего чтение можно продолжать.
+	ReadFrame(r *bufio.Reader, max int) ([]byte, error)
+}
+
+var codecs = map[string]Codec{
+	CodecJSON:    jsonLines{},
+	CodecJSONLP:  jsonLP{},
+	CodecMsgpack: msgpackCodec{},
+}
+
+// JSON - кодек, с которого начинается любое соединение
+var JSON Codec = jsonLines{}
+
+// Codecs возвращает имена всех кодеков
+func Codecs() []string {
+	return []string{CodecJSON, CodecJSONLP, CodecMsgpack}
+}
+
+// CodecByName возвращает кодек по имени
+func CodecByName(name string) (Codec, error) {
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q (expected: %s, %s, %s)", ErrUnknownCodec, name, CodecJSON, CodecJSONLP, CodecMsgpack)
+	}
+	return c, nil
+}
+
+// checkVersion отклоняет кадр версии, которую эта сборка не понимает
+func checkVersion(e Envelope) (Envelope, error) {
+	if !slices.Contains(SupportedVersions, e.Version) {
+		return Envelope{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, e.Version)
+	}
+	return e, nil
+}
+
+// jsonLines - JSON-кадры, по одному на строку. encoding/json экранирует
+// перевод строки внутри значений, поэтому он всегда означает конец кадра.
+type jsonLines struct{}
+
+func (jsonLines) Name() string { return CodecJSON }
+func (jsonLines) Binary() bool { return false }
+
+func (jsonLines) Marshal(e Envelope) ([]byte, error) { return json.Marshal(e) }
+
+func (jsonLines) Unmarshal(data []byte) (Envelope, error) { return unmarshalJSON(data) }
+
+func (jsonLines) WriteFrame(w io.Writer, data []byte) error {
+	_, err := w.Write(append(data, '\n'))
+	return err
+}
+
+func (jsonLines) ReadFrame(r *bufio.Reader, max int) ([]byte, error) {
+	var frame []byte
+	for {
+		line, err := r.ReadSlice('\n')
+		// Перевод строки в конце не входит в размер кадра
+		n := len(line)
+		if err == nil {
+			n--
+		}
+		if len(frame)+n > max {
+			// Остаток строки дочитывается и отбрасывается
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = r.ReadSlice('\n')
+			}
+			if err != nil {
+				return nil, err
+			}
+			return nil, ErrFrameTooLarge
+		}
+		frame = append(frame, line...)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if errors.Is(err, io.EOF) && len(bytes.TrimSpace(frame)) > 0 {
+			// Последний кадр без перевода строки, EOF вернёт следующий вызов
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		frame = bytes.TrimSpace(frame)
+		if len(frame) == 0 {
+			continue // Пустые строки между кадрами допустимы
+		}
+		return frame, nil
+	}
+}
+
+func unmarshalJSON(data []byte) (Envelope, error) {
+	var e Envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return Envelope{}, err
+	}
+	return checkVersion(e)
+}
+
+// jsonLP - JSON-кадры с префиксом длины: в кадре могут быть любые байты,
+// а получатель заранее знает, сколько читать
+type jsonLP struct{ lengthPrefixed }
+
+func (jsonLP) Name() string { return CodecJSONLP }
+func (jsonLP) Binary() bool { return false }
+
+func (jsonLP) Marshal(e Envelope) ([]byte, error) { return json.Marshal(e) }
+
+func (jsonLP) Unmarshal(data []byte) (Envelope, error) { return unmarshalJSON(data) }
+
+// msgpackCodec - компактное двоичное представление тех же полей. Имена
+// полей берутся из тегов json, пустые поля не передаются.
+type msgpackCodec struct{ lengthPrefixed }
+
+func (msgpackCodec) Name() string { return CodecMsgpack }
+func (msgpackCodec) Binary() bool { return true }
+
+func (msgpackCodec) Marshal(e Envelope) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(e); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte) (Envelope, error) {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	var e Envelope
+	if err := dec.Decode(&e); err != nil {
+		return Envelope{}, err
+	}
+	return checkVersion(e)
+}
+
+// lengthPrefixed - кадрирование префиксом длины, общее для json-lp и msgpack
+type lengthPrefixed struct{}
+
+func (lengthPrefixed) WriteFrame(w io.Writer, data []byte) error {
+	frame := make([]byte, lengthSize+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[lengthSize:], data)
+	_, err := w.Write(frame)
+	return err
+}
+
+func (lengthPrefixed) ReadFrame(r *bufio.Reader, max int) ([]byte, error) {
+	var prefix [lengthSize]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	n := int64(binary.BigEndian.Uint32(prefix[:]))
+	if n > int64(max) {
+		if _, err := io.CopyN(io.Discard, r, n); err != nil {
+			return nil, err
+		}
+		return nil, ErrFrameTooLarge
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return frame, nil
+}
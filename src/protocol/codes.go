@@ -0,0 +1,34 @@
+package protocol
+
+// Коды ошибок в поле code кадров ошибок и ответов REST API. Клиент
+// разбирает код, а текст ошибки предназначен человеку и может меняться.
+// В поле ref кадра ошибки сервер повторяет ref кадра, который её вызвал.
+const (
+	CodeBadFrame           = "BAD_FRAME"           // Кадр не разобран
+	CodeUnsupportedVersion = "UNSUPPORTED_VERSION" // Версия кадра или hello не поддерживается
+	CodeUnknownType        = "UNKNOWN_TYPE"        // Неизвестный тип кадра
+	CodeMessageTooLarge    = "MESSAGE_TOO_LARGE"   // Кадр или текст больше лимита
+	CodeRateLimited        = "RATE_LIMITED"        // Превышен лимит частоты
+	CodeTextEmpty          = "TEXT_EMPTY"          // Пустой текст сообщения
+	CodeTextInvalid        = "TEXT_INVALID"        // Текст не в UTF-8 или с управляющими символами
+	CodeNameEmpty          = "NAME_EMPTY"          // Не указано имя
+	CodeNameTooLong        = "NAME_TOO_LONG"
+	CodeNameInvalid        = "NAME_INVALID" // Недопустимые символы в имени
+	CodeNameReserved       = "NAME_RESERVED"
+	CodeNameTaken          = "NAME_TAKEN"
+	CodeNameMismatch       = "NAME_MISMATCH" // Имя в кадре не совпадает с именем сессии
+	CodeAuthFailed         = "AUTH_FAILED"
+	CodeBanned             = "BANNED"
+	CodeAlreadyRegistered  = "ALREADY_REGISTERED"
+	CodeNotRegistered      = "NOT_REGISTERED"
+	CodeNoDestination      = "NO_DESTINATION" // В whisper не указан получатель
+	CodeUserNotFound       = "USER_NOT_FOUND"
+	CodeRoomNameEmpty      = "ROOM_NAME_EMPTY"
+	CodeRoomNameInvalid    = "ROOM_NAME_INVALID" // Имя комнаты длиннее лимита или с недопустимыми символами
+	CodeNotInRoom          = "NOT_IN_ROOM"
+	CodeHistoryUnavailable = "HISTORY_UNAVAILABLE"
+	CodeResumeFailed       = "RESUME_FAILED"
+	CodeSessionClosed      = "SESSION_CLOSED"
+	CodeServerShutdown     = "SERVER_SHUTDOWN"
+	CodeInternal           = "INTERNAL" // Ошибка без своего кода
+)
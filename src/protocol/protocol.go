@@ -0,0 +1,134 @@
+// Package protocol - формат кадров чата, общий для сервера и клиентов всех
+// транспортов. Каждый кадр - конверт с версией протокола, типом, номером,
+// временем и полезной нагрузкой. Версию и возможности стороны согласуют
+// кадром hello.
+package protocol
+
+import (
+	"errors"
+	"slices"
+)
+
+// Version - текущая версия протокола
+const Version = 1
+
+// SupportedVersions - версии, которые понимает эта сборка, по возрастанию
+var SupportedVersions = []int{1}
+
+// TimeLayout - формат времени в поле ts
+const TimeLayout = "2006/01/02 15:04:05"
+
+// Типы кадров
+const (
+	TypeHello      = "hello" // Согласование версии и возможностей
+	TypeRegister   = "register"
+	TypeRegistered = "registered" // Сервер принял register
+	TypeBroadcast  = "broadcast"
+	TypeWhisper    = "whisper"
+	TypeExit       = "exit"
+	TypeError      = "error"
+	TypeJoin       = "join"
+	TypeLeave      = "leave"
+	TypeRooms      = "rooms"
+	TypeHistory    = "history"
+	TypeAuthFailed = "auth_failed"
+	TypeUserJoined = "user_joined"
+	TypeUserLeft   = "user_left"
+	TypeWho        = "who"
+	TypePing       = "ping"
+	TypePong       = "pong"
+	TypeSession    = "session" // Токен для возобновления сессии после регистрации
+	TypeResume     = "resume"  // Запрос на возобновление сессии по токену
+	TypeResumed    = "resumed" // Сессия возобновлена, дальше идут пропущенные кадры
+	TypeMOTD       = "motd"    // Сообщение дня после регистрации
+
+	TypeMessageTooLarge = "message_too_large"
+	TypeServerShutdown  = "server_shutdown" // Последний кадр перед остановкой сервера
+	TypeRateLimited     = "rate_limited"    // Клиент превысил лимит частоты
+	TypeInvalidInput    = "invalid_input"   // Недопустимое имя или текст сообщения
+)
+
+// Возможности, о которых договариваются в hello. Клиент, не приславший
+// hello, получает все возможности сервера.
+const (
+	CapResume   = "resume"   // Клиент возобновляет сессию по токену из кадра session
+	CapPresence = "presence" // Клиент получает события user_joined и user_left
+)
+
+// ErrUnsupportedVersion - кадр или hello версии, которую эта сборка не понимает
+var ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+// Envelope - кадр протокола
+type Envelope struct {
+	Version int     `json:"v"`
+	Type    string  `json:"type"`
+	ID      uint64  `json:"id,omitempty"`  // Номер сообщения в истории, 0 для служебных кадров
+	Time    string  `json:"ts,omitempty"`  // Время сообщения в формате TimeLayout
+	Ref     string  `json:"ref,omitempty"` // Метка запроса клиента, повторяется в ответной ошибке и в registered
+	Payload Payload `json:"payload"`
+}
+
+// Payload - полезная нагрузка кадра. Какие поля заполнены, зависит от типа.
+type Payload struct {
+	Name     string   `json:"name,omitempty"` // Отправитель, или пользователь в событиях
+	Text     string   `json:"text,omitempty"` // Текст сообщения или ошибки
+	Code     string   `json:"code,omitempty"` // Код ошибки из Code*
+	Dst      string   `json:"dst,omitempty"`  // Получатель whisper
+	Room     string   `json:"room,omitempty"`
+	Rooms    []string `json:"rooms,omitempty"`  // Ответ на rooms
+	Users    []string `json:"users,omitempty"`  // Ответ на who
+	Reason   string   `json:"reason,omitempty"` // Причина ухода в user_left
+	Limit    int      `json:"limit,omitempty"`  // Сколько сообщений вернуть на history
+	History  bool     `json:"history,omitempty"`
+	Password string   `json:"password,omitempty"`
+	Token    string   `json:"token,omitempty"` // Токен в register, session и resume
+
+	Versions     []int    `json:"versions,omitempty"`     // hello: поддерживаемые версии, в ответе - выбранная
+	Capabilities []string `json:"capabilities,omitempty"` // hello: возможности стороны, в ответе - общие
+	Codecs       []string `json:"codecs,omitempty"`       // hello: кодеки по предпочтению, в ответе - выбранный
+}
+
+// New создаёт кадр текущей версии
+func New(typ string, p Payload) Envelope {
+	return Envelope{Version: Version, Type: typ, Payload: p}
+}
+
+// IsError сообщает, что кадр описывает ошибку
+func (e Envelope) IsError() bool {
+	return e.Type == TypeError || e.Type == TypeAuthFailed || e.Type == TypeMessageTooLarge ||
+		e.Type == TypeRateLimited || e.Type == TypeInvalidInput
+}
+
+// Encode кодирует кадр в JSON
+func Encode(e Envelope) ([]byte, error) {
+	return JSON.Marshal(e)
+}
+
+// Decode разбирает JSON-кадр и проверяет версию. Ошибка версии оборачивает
+// ErrUnsupportedVersion, испорченный JSON возвращается как есть.
+func Decode(data []byte) (Envelope, error) {
+	return JSON.Unmarshal(data)
+}
+
+// Negotiate выбирает наибольшую версию, которую поддерживают обе стороны.
+// false - общих версий нет.
+func Negotiate(offered []int) (int, bool) {
+	best := 0
+	for _, v := range offered {
+		if slices.Contains(SupportedVersions, v) && v > best {
+			best = v
+		}
+	}
+	return best, best != 0
+}
+
+// Common возвращает возможности из offered, которые есть и в supported
+func Common(offered, supported []string) []string {
+	var common []string
+	for _, c := range offered {
+		if slices.Contains(supported, c) && !slices.Contains(common, c) {
+			common = append(common, c)
+		}
+	}
+	return common
+}
@@ -0,0 +1,505 @@
+// Package rudp - надёжная доставка поверх UDP: номера пакетов, подтверждения,
+// повторная отправка с экспоненциальной задержкой, отбрасывание дубликатов и
+// выдача сообщений в порядке отправки. Сообщение больше одной датаграммы
+// делится на фрагменты и собирается получателем. Используется и сервером,
+// и клиентом.
+package rudp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Типы пакетов
+const (
+	kindData     byte = 1 // Последний (или единственный) пакет сообщения
+	kindAck      byte = 2
+	kindFragment byte = 3 // Сообщение продолжается в следующем пакете
+)
+
+// headerSize - тип пакета, эпоха нумерации, номер пакета и наименьший
+// неподтверждённый номер отправителя. Всё, что ниже него, получатель уже
+// подтвердил. Эпоха меняется, когда отправитель начинает нумерацию заново.
+const headerSize = 1 + 8 + 8 + 8
+
+// maxDatagram - размер буфера чтения, больше UDP не доставит
+const maxDatagram = 65535
+
+// Размеры по умолчанию. Фрагмент с заголовком помещается в минимальный
+// MTU IPv6 (1280 байт) вместе с заголовками IP и UDP.
+const (
+	DefaultFragmentSize   = 1200
+	DefaultMaxMessageSize = 64 * 1024
+)
+
+var (
+	ErrClosed          = errors.New("connection closed")
+	ErrBadPacket       = errors.New("malformed packet")
+	ErrMessageTooLarge = errors.New("message too large")
+)
+
+// Config - параметры повторной отправки. Нулевые поля заменяются значениями
+// по умолчанию.
+type Config struct {
+	RTO        time.Duration // Первая задержка перед повтором
+	MaxRTO     time.Duration // Предел, до которого задержка удваивается
+	MaxRetries int           // После стольких повторов адрес считается недоступным
+	Window     int           // Сколько пакетов вперёд получатель держит до выдачи
+
+	FragmentSize   int // Наибольшая полезная нагрузка одной датаграммы
+	MaxMessageSize int // Наибольшее сообщение в обе стороны
+
+	// OnLost вызывается, когда пакет так и не подтвердили. Состояние адреса
+	// к этому моменту уже сброшено.
+	OnLost func(addr net.Addr)
+
+	// OnTooLarge вызывается, когда адрес прислал сообщение больше
+	// MaxMessageSize. Фрагменты такого сообщения отбрасываются.
+	OnTooLarge func(addr net.Addr)
+}
+
+func (c Config) withDefaults() Config {
+	if c.RTO <= 0 {
+		c.RTO = 200 * time.Millisecond
+	}
+	if c.MaxRTO <= 0 {
+		c.MaxRTO = 5 * time.Second
+	}
+	if c.MaxRetries <= 0 {
+		c.MaxRetries = 12
+	}
+	if c.Window <= 0 {
+		c.Window = 1024
+	}
+	if c.FragmentSize <= 0 {
+		c.FragmentSize = DefaultFragmentSize
+	}
+	if c.FragmentSize > maxDatagram-headerSize {
+		c.FragmentSize = maxDatagram - headerSize
+	}
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = DefaultMaxMessageSize
+	}
+	return c
+}
+
+// Conn - надёжный канал поверх net.PacketConn. У каждого адреса своя
+// нумерация пакетов в обе стороны.
+type Conn struct {
+	pc     net.PacketConn
+	cfg    Config
+	peers  map[string]*peer
+	epoch  uint64 // Эпоха последнего созданного состояния адреса
+	closed bool
+	done   chan struct{}
+	mu     sync.Mutex
+}
+
+// peer - состояние обмена с одним адресом
+type peer struct {
+	addr     net.Addr
+	epoch    uint64              // Эпоха нашей нумерации для адреса
+	nextSeq  uint64              // Номер следующего отправляемого пакета
+	base     uint64              // Наименьший неподтверждённый номер
+	pending  map[uint64]*pending // Отправленные, но не подтверждённые
+	expected uint64              // Номер следующего пакета для выдачи
+	buffered map[uint64]packet   // Пришедшие раньше expected
+	remote   uint64              // Эпоха нумерации адреса, 0 - пакетов ещё не было
+
+	partial   []byte // Собираемое из фрагментов сообщение
+	oversized bool   // Собираемое сообщение превысило предел и отбрасывается
+}
+
+// packet - пакет данных: фрагмент или последний пакет сообщения
+type packet struct {
+	kind    byte
+	payload []byte
+}
+
+type pending struct {
+	packet
+	rto      time.Duration
+	deadline time.Time
+	retries  int
+}
+
+func NewConn(pc net.PacketConn, cfg Config) *Conn {
+	c := &Conn{
+		pc:    pc,
+		cfg:   cfg.withDefaults(),
+		peers: make(map[string]*peer),
+		// Эпохи растут и после перезапуска процесса на том же адресе
+		epoch: uint64(time.Now().UnixNano()),
+		done:  make(chan struct{}),
+	}
+	go c.retransmitLoop()
+	return c
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.pc.LocalAddr()
+}
+
+// Send отправляет сообщение и повторяет его, пока адрес не подтвердит приём.
+// Длинное сообщение уходит несколькими фрагментами с соседними номерами.
+func (c *Conn) Send(addr net.Addr, payload []byte) error {
+	if len(payload) > c.cfg.MaxMessageSize {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrMessageTooLarge, len(payload), c.cfg.MaxMessageSize)
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	p := c.peer(addr)
+	now := time.Now()
+	var datagrams [][]byte
+	for {
+		chunk, kind := payload, kindData
+		if len(chunk) > c.cfg.FragmentSize {
+			chunk, kind = chunk[:c.cfg.FragmentSize], kindFragment
+		}
+		payload = payload[len(chunk):]
+
+		seq := p.nextSeq
+		p.nextSeq++
+		pk := packet{kind: kind, payload: append([]byte(nil), chunk...)}
+		p.pending[seq] = &pending{
+			packet:   pk,
+			rto:      c.cfg.RTO,
+			deadline: now.Add(c.cfg.RTO),
+		}
+		datagrams = append(datagrams, encode(kind, p.epoch, seq, p.base, pk.payload))
+		if kind == kindData {
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	// Потерю первой отправки исправит повтор
+	for _, datagram := range datagrams {
+		if _, err := c.pc.WriteTo(datagram, addr); err != nil && c.isClosed() {
+			return ErrClosed
+		}
+	}
+	return nil
+}
+
+// Serve читает пакеты и передаёт handler сообщения каждого адреса по порядку
+// и без повторов. Возвращает ошибку чтения; после Close - ErrClosed.
+func (c *Conn) Serve(handler func(addr net.Addr, payload []byte)) error {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := c.pc.ReadFrom(buf)
+		if err != nil {
+			if c.isClosed() {
+				return ErrClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			// Например, ICMP port unreachable от ушедшего клиента
+			continue
+		}
+
+		kind, epoch, seq, base, payload, err := decode(buf[:n])
+		if err != nil {
+			continue
+		}
+		switch kind {
+		case kindAck:
+			c.ack(addr, epoch, seq)
+		case kindData, kindFragment:
+			msgs, tooLarge := c.receive(addr, epoch, seq, base, packet{kind: kind, payload: payload})
+			for _, msg := range msgs {
+				handler(addr, msg)
+			}
+			if c.cfg.OnTooLarge != nil {
+				for i := 0; i < tooLarge; i++ {
+					c.cfg.OnTooLarge(addr)
+				}
+			}
+		}
+	}
+}
+
+// Pending возвращает число отправленных, но ещё не подтверждённых сообщений
+func (c *Conn) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, p := range c.peers {
+		n += len(p.pending)
+	}
+	return n
+}
+
+// PendingTo возвращает число неподтверждённых пакетов для адреса
+func (c *Conn) PendingTo(addr net.Addr) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.peers[addr.String()]; ok {
+		return len(p.pending)
+	}
+	return 0
+}
+
+// Forget сбрасывает состояние адреса: неподтверждённые пакеты больше не
+// повторяются, а следующий пакет начнёт новую нумерацию в новой эпохе
+func (c *Conn) Forget(addr net.Addr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.peers, addr.String())
+}
+
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	c.mu.Unlock()
+	return c.pc.Close()
+}
+
+func (c *Conn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// peer возвращает состояние адреса, создавая его. Вызывается под c.mu.
+func (c *Conn) peer(addr net.Addr) *peer {
+	key := addr.String()
+	if p, ok := c.peers[key]; ok {
+		return p
+	}
+	c.epoch++
+	p := &peer{
+		addr:     addr,
+		epoch:    c.epoch,
+		nextSeq:  1,
+		base:     1,
+		pending:  make(map[uint64]*pending),
+		expected: 1,
+		buffered: make(map[uint64]packet),
+	}
+	c.peers[key] = p
+	return p
+}
+
+func (c *Conn) ack(addr net.Addr, epoch, seq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Подтверждение пакета прежней эпохи к новой нумерации не относится
+	if p, ok := c.peers[addr.String()]; ok && p.epoch == epoch {
+		delete(p.pending, seq)
+		for p.base < p.nextSeq {
+			if _, ok := p.pending[p.base]; ok {
+				break
+			}
+			p.base++
+		}
+	}
+}
+
+// receive подтверждает пакет и возвращает сообщения, которые теперь можно
+// выдать по порядку, и число отброшенных слишком больших сообщений
+func (c *Conn) receive(addr net.Addr, epoch, seq, base uint64, pk packet) ([][]byte, int) {
+	c.mu.Lock()
+	p := c.peer(addr)
+	switch {
+	case epoch < p.remote:
+		// Задержавшийся пакет прежней эпохи: отправитель его уже забыл
+		c.mu.Unlock()
+		return nil, 0
+	case epoch > p.remote:
+		// Отправитель начал нумерацию заново, например после Forget
+		p.resetReceive(epoch)
+	}
+	var a assembly
+	// Всё ниже base мы уже подтвердили. Если этих пакетов нет в буфере, их
+	// принимало прежнее состояние адреса, сброшенное Forget.
+	p.skipTo(base, c.cfg.MaxMessageSize, &a)
+	if seq >= p.expected+uint64(c.cfg.Window) {
+		// Не подтверждаем: отправитель повторит, когда окно сдвинется
+		c.mu.Unlock()
+		return a.msgs, a.tooLarge
+	}
+
+	if _, dup := p.buffered[seq]; seq >= p.expected && !dup {
+		p.buffered[seq] = packet{kind: pk.kind, payload: append([]byte(nil), pk.payload...)}
+		for {
+			next, ok := p.buffered[p.expected]
+			if !ok {
+				break
+			}
+			delete(p.buffered, p.expected)
+			p.expected++
+			p.push(next, c.cfg.MaxMessageSize, &a)
+		}
+	}
+	c.mu.Unlock()
+
+	// Дубликат тоже подтверждается: прошлое подтверждение могло потеряться
+	c.pc.WriteTo(encode(kindAck, epoch, seq, 0, nil), addr)
+	return a.msgs, a.tooLarge
+}
+
+// assembly - результат разбора пакетов, пришедших по порядку
+type assembly struct {
+	msgs     [][]byte
+	tooLarge int
+}
+
+// push добавляет очередной по порядку пакет к собираемому сообщению
+func (p *peer) push(pk packet, limit int, a *assembly) {
+	if !p.oversized {
+		if len(p.partial)+len(pk.payload) > limit {
+			p.oversized = true
+			p.partial = nil
+		} else {
+			p.partial = append(p.partial, pk.payload...)
+		}
+	}
+	if pk.kind == kindFragment {
+		return
+	}
+
+	if p.oversized {
+		a.tooLarge++
+	} else {
+		a.msgs = append(a.msgs, p.partial)
+	}
+	p.partial, p.oversized = nil, false
+}
+
+// resetReceive начинает приём нумерации эпохи epoch с первого пакета.
+// Недособранное сообщение прежней эпохи теряется.
+func (p *peer) resetReceive(epoch uint64) {
+	p.remote = epoch
+	p.expected = 1
+	p.buffered = make(map[uint64]packet)
+	p.partial, p.oversized = nil, false
+}
+
+// skipTo выдаёт буферизованные пакеты ниже base и переносит ожидание на base.
+// Сообщение, часть которого пропала, собрать уже нельзя.
+func (p *peer) skipTo(base uint64, limit int, a *assembly) {
+	if base <= p.expected {
+		return
+	}
+	var seqs []uint64
+	for seq := range p.buffered {
+		if seq < base {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	next := p.expected
+	for _, seq := range seqs {
+		if seq != next {
+			p.partial, p.oversized = nil, false
+		}
+		p.push(p.buffered[seq], limit, a)
+		delete(p.buffered, seq)
+		next = seq + 1
+	}
+	if next != base {
+		p.partial, p.oversized = nil, false
+	}
+	p.expected = base
+}
+
+func (c *Conn) retransmitLoop() {
+	interval := c.cfg.RTO / 4
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			resend, lost := c.due(now)
+			for _, r := range resend {
+				c.pc.WriteTo(r.packet, r.addr)
+			}
+			if c.cfg.OnLost != nil {
+				for _, addr := range lost {
+					c.cfg.OnLost(addr)
+				}
+			}
+		}
+	}
+}
+
+type resend struct {
+	addr   net.Addr
+	packet []byte
+}
+
+// due выбирает пакеты, которые пора повторить, и адреса, исчерпавшие повторы
+func (c *Conn) due(now time.Time) ([]resend, []net.Addr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var out []resend
+	var lost []net.Addr
+	for key, p := range c.peers {
+		for seq, pk := range p.pending {
+			if now.Before(pk.deadline) {
+				continue
+			}
+			if pk.retries >= c.cfg.MaxRetries {
+				lost = append(lost, p.addr)
+				delete(c.peers, key)
+				break
+			}
+			pk.retries++
+			pk.rto *= 2
+			if pk.rto > c.cfg.MaxRTO {
+				pk.rto = c.cfg.MaxRTO
+			}
+			pk.deadline = now.Add(pk.rto)
+			out = append(out, resend{addr: p.addr, packet: encode(pk.packet.kind, p.epoch, seq, p.base, pk.packet.payload)})
+		}
+	}
+	return out, lost
+}
+
+func encode(kind byte, epoch, seq, base uint64, payload []byte) []byte {
+	packet := make([]byte, headerSize+len(payload))
+	packet[0] = kind
+	binary.BigEndian.PutUint64(packet[1:9], epoch)
+	binary.BigEndian.PutUint64(packet[9:17], seq)
+	binary.BigEndian.PutUint64(packet[17:headerSize], base)
+	copy(packet[headerSize:], payload)
+	return packet
+}
+
+func decode(packet []byte) (kind byte, epoch, seq, base uint64, payload []byte, err error) {
+	if len(packet) < headerSize {
+		return 0, 0, 0, 0, nil, ErrBadPacket
+	}
+	kind = packet[0]
+	if kind != kindData && kind != kindAck && kind != kindFragment {
+		return 0, 0, 0, 0, nil, ErrBadPacket
+	}
+	epoch = binary.BigEndian.Uint64(packet[1:9])
+	seq = binary.BigEndian.Uint64(packet[9:17])
+	base = binary.BigEndian.Uint64(packet[17:headerSize])
+	return kind, epoch, seq, base, packet[headerSize:], nil
+}
@@ -2,15 +2,32 @@ package main
 
 import (
 	"chat/server/internal/cfg"
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
 
-	serverInstance, err := cfg.Setup()
+	serverInstance, reloader, err := cfg.Setup()
 
 	if err != nil {
 		log.Fatal(err)
 	}
-	serverInstance.Start()
+
+	// SIGHUP перечитывает настройки без перезапуска и разрыва сессий
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go reloader.Watch(hup)
+
+	// SIGINT и SIGTERM останавливают сервер мягко, с уведомлением клиентов
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := serverInstance.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Server stopped")
 }
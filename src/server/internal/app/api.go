@@ -0,0 +1,90 @@
+package app
+
+import (
+	"chat/server/internal/logging"
+	"chat/server/internal/model"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// APISenderSuffix добавляется к имени отправителя Post. Символа @ нет в
+// допустимых именах, поэтому сообщение API не выдать за сообщение
+// пользователя, даже защищённого паролем или сертификатом, и наоборот.
+const APISenderSuffix = "@api"
+
+// Post доставляет сообщение отправителя без соединения, например из REST
+// API: broadcast всем или участникам комнаты, whisper - получателю.
+// Имя отправителя проверяется как при регистрации и доставляется с
+// суффиксом APISenderSuffix, поэтому может совпадать с именем пользователя
+// в сети.
+// Лимиты сообщений считаются по адресу remote.
+// Возвращает сообщение с назначенными ID и временем.
+func (h *Hub) Post(remote string, msg model.IncomingMessage) (model.OutgoingMessage, error) {
+	h.mu.RLock()
+	if h.closing {
+		h.mu.RUnlock()
+		return model.OutgoingMessage{}, ErrServerShutdown
+	}
+	h.inflight.Add(1)
+	h.mu.RUnlock()
+	defer h.inflight.Done()
+
+	if err := h.limiter.allowPost(remote, time.Now()); err != nil {
+		logging.Warnf("API message from %s rejected: %s\n", remote, err)
+		return model.OutgoingMessage{}, err
+	}
+	if err := ValidateName(msg.From); err != nil {
+		return model.OutgoingMessage{}, err
+	}
+	if h.banned(msg.From) {
+		return model.OutgoingMessage{}, fmt.Errorf("%w: %s", ErrBanned, msg.From)
+	}
+	if msg.Type != model.TypeBroadcast && msg.Type != model.TypeWhisper {
+		return model.OutgoingMessage{}, fmt.Errorf("%w: %q", ErrUnknownType, msg.Type)
+	}
+
+	out, _, err := h.deliver(msg.From+APISenderSuffix, msg)
+	return out, err
+}
+
+// Users возвращает имена пользователей в сети по алфавиту
+func (h *Hub) Users() []string {
+	h.mu.RLock()
+	users := make([]string, 0, len(h.byName))
+	for name := range h.byName {
+		users = append(users, name)
+	}
+	h.mu.RUnlock()
+	sort.Strings(users)
+	return users
+}
+
+// Messages возвращает последние сообщения выборки от старых к новым.
+// Limit ограничивается так же, как в запросе history.
+func (h *Hub) Messages(q model.HistoryQuery) ([]model.OutgoingMessage, error) {
+	q.Room = normalizeRoom(q.Room)
+	if q.Room != "" {
+		if err := ValidateRoom(q.Room); err != nil {
+			return nil, err
+		}
+	}
+	stored, err := h.last(q)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]model.OutgoingMessage, 0, len(stored))
+	for _, m := range stored {
+		out := outgoingFromStored(m)
+		out.History = true
+		messages = append(messages, out)
+	}
+	return messages, nil
+}
+
+// Closing сообщает, что сервер останавливается и новые сообщения не принимает
+func (h *Hub) Closing() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.closing
+}
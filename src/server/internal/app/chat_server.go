@@ -1,48 +1,165 @@
 package app
 
 import (
-	"chat/server/internal/model"
+	"chat/server/internal/logging"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 )
 
+// DefaultDrainTimeout - сколько Run ждёт доставки сообщений при остановке
+const DefaultDrainTimeout = 10 * time.Second
+
+// Transport - адаптер соединений конкретного протокола. Транспорт только
+// принимает кадры и доставляет их; регистрация и маршрутизация - в Hub.
 type Transport interface {
 	Start(address string) error
+	// Shutdown перестаёт принимать новые соединения, не трогая открытые
+	Shutdown(ctx context.Context) error
+	// Stop закрывает транспорт сразу
 	Stop() error
-	BroadcastMessage(msg model.IncomingMessage) error
-	SendPrivateMessage(msg model.IncomingMessage) error
+}
+
+type listener struct {
+	transport Transport
+	address   string
 }
 
 type ChatServer struct {
-	transport  Transport
-	quit       chan struct{}
-	serverAddr string
+	hub          *Hub
+	listeners    []listener
+	drainTimeout time.Duration
+
+	heartbeatInterval time.Duration // 0 отключает проверку связи
+	heartbeatTimeout  time.Duration
 }
 
-func NewChatServer(tr Transport, addr string) *ChatServer {
+func NewChatServer() *ChatServer {
 	return &ChatServer{
-		transport:  tr,
-		quit:       make(chan struct{}),
-		serverAddr: addr,
+		hub:               NewHub(),
+		drainTimeout:      DefaultDrainTimeout,
+		heartbeatInterval: DefaultHeartbeatInterval,
+		heartbeatTimeout:  DefaultHeartbeatTimeout,
 	}
 }
 
+// SetDrainTimeout задаёт, сколько Run ждёт доставки сообщений при остановке
+func (s *ChatServer) SetDrainTimeout(d time.Duration) {
+	s.drainTimeout = d
+}
+
+// SetHeartbeat задаёт, как часто проверять связь с молчащими клиентами и
+// через сколько отключать неответивших. Вызывается до Start; interval 0
+// отключает проверку.
+func (s *ChatServer) SetHeartbeat(interval, timeout time.Duration) {
+	s.heartbeatInterval = interval
+	s.heartbeatTimeout = timeout
+}
+
+// Hub возвращает хаб, к которому подключаются транспорты сервера
+func (s *ChatServer) Hub() *Hub {
+	return s.hub
+}
+
+// AddTransport добавляет транспорт, который будет запущен на указанном адресе
+func (s *ChatServer) AddTransport(tr Transport, addr string) {
+	s.listeners = append(s.listeners, listener{transport: tr, address: addr})
+}
+
+// Start запускает все транспорты и блокируется, пока они работают.
+// Возвращает первую ошибку запуска.
 func (s *ChatServer) Start() error {
-	err := s.transport.Start(s.serverAddr)
-	if err != nil {
-		return err
+	if len(s.listeners) == 0 {
+		return fmt.Errorf("no transports configured")
 	}
+	s.hub.StartHeartbeat(s.heartbeatInterval, s.heartbeatTimeout)
 
-	return nil
+	errCh := make(chan error, len(s.listeners))
+	var wg sync.WaitGroup
+	for _, l := range s.listeners {
+		wg.Add(1)
+		go func(l listener) {
+			defer wg.Done()
+			if err := l.transport.Start(l.address); err != nil {
+				errCh <- fmt.Errorf("start transport on %s: %w", l.address, err)
+			}
+		}(l)
+	}
+
+	go func() {
+		wg.Wait()
+		close(errCh)
+	}()
+
+	// nil, если все транспорты завершились без ошибок
+	return <-errCh
 }
 
-func (s *ChatServer) Stop() error {
-	close(s.quit)
-	return s.transport.Stop()
+// Run запускает сервер и работает, пока не отменён ctx, после чего
+// останавливает сервер через Shutdown с таймаутом SetDrainTimeout. Если
+// доставка не уложилась в таймаут, сервер всё равно остановлен: это
+// предупреждение, а не ошибка.
+func (s *ChatServer) Run(ctx context.Context) error {
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.Start() }()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			s.Stop()
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	logging.Infof("Shutting down the server\n")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.drainTimeout)
+	defer cancel()
+	err := s.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		logging.Warnf("Shutdown took longer than %s, undelivered frames dropped\n", s.drainTimeout)
+		err = nil
+	}
+	// Shutdown уже остановил транспорты, Start вот-вот вернётся
+	if startErr := <-errCh; startErr != nil {
+		return startErr
+	}
+	if err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	return nil
 }
 
-func (s *ChatServer) BroadcastMessage(msg model.IncomingMessage) error {
-	return s.transport.BroadcastMessage(msg)
+// Shutdown останавливает сервер мягко: транспорты перестают принимать
+// соединения, клиенты получают server_shutdown, уже принятые сообщения
+// доставляются до истечения ctx, затем транспорты закрываются.
+func (s *ChatServer) Shutdown(ctx context.Context) error {
+	var firstErr error
+	for _, l := range s.listeners {
+		if err := l.transport.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := s.hub.Shutdown(ctx, "server is shutting down"); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	for _, l := range s.listeners {
+		if err := l.transport.Stop(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
-func (s *ChatServer) SendPrivateMessage(msg model.IncomingMessage) error {
-	return s.transport.SendPrivateMessage(msg)
+func (s *ChatServer) Stop() error {
+	var firstErr error
+	for _, l := range s.listeners {
+		if err := l.transport.Stop(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.hub.Close()
+	return firstErr
 }
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"chat/protocol"
+	"chat/server/internal/model"
+	"errors"
+)
+
+// Ошибки, которые хаб отправляет клиенту. Транспорты используют их же,
+// чтобы одинаковые ситуации описывались одинаково на всех протоколах.
+var (
+	ErrInvalidFrame       = errors.New("malformed frame")
+	ErrMessageTooLarge    = errors.New("message too large")
+	ErrTextEmpty          = errors.New("message text cannot be empty")
+	ErrTextInvalid        = errors.New("message text is invalid")
+	ErrRateLimited        = errors.New("rate limit exceeded")
+	ErrUnknownType        = errors.New("unknown message type")
+	ErrNameEmpty          = errors.New("username cannot be empty")
+	ErrNameTooLong        = errors.New("username too long")
+	ErrNameInvalid        = errors.New("username contains invalid characters")
+	ErrNameReserved       = errors.New("username is reserved")
+	ErrNameTaken          = errors.New("username already taken")
+	ErrAuthFailed         = errors.New("authentication failed")
+	ErrBanned             = errors.New("username is banned")
+	ErrAlreadyRegistered  = errors.New("already registered")
+	ErrNotRegistered      = errors.New("user not registered")
+	ErrNameMismatch       = errors.New("name does not match registered user")
+	ErrNoDestination      = errors.New("destination user not specified")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrSessionClosed      = errors.New("session closed")
+	ErrServerShutdown     = errors.New("server is shutting down")
+	ErrRoomNameEmpty      = errors.New("room name cannot be empty")
+	ErrRoomNameInvalid    = errors.New("room name is invalid")
+	ErrNotInRoom          = errors.New("not a member of room")
+	ErrHistoryUnavailable = errors.New("history unavailable")
+	ErrResumeFailed       = errors.New("session cannot be resumed")
+	ErrUnsupportedVersion = protocol.ErrUnsupportedVersion
+)
+
+// errorCodes - каталог кодов ошибок: код протокола и тип кадра для каждой
+// ошибки хаба. Обёрнутая ошибка получает код исходной.
+var errorCodes = []struct {
+	err  error
+	code string
+	typ  string
+}{
+	{ErrInvalidFrame, protocol.CodeBadFrame, model.TypeError},
+	{ErrUnsupportedVersion, protocol.CodeUnsupportedVersion, model.TypeError},
+	{ErrUnknownType, protocol.CodeUnknownType, model.TypeError},
+	{ErrMessageTooLarge, protocol.CodeMessageTooLarge, model.TypeMessageTooLarge},
+	{ErrRateLimited, protocol.CodeRateLimited, model.TypeRateLimited},
+	{ErrTextEmpty, protocol.CodeTextEmpty, model.TypeInvalidInput},
+	{ErrTextInvalid, protocol.CodeTextInvalid, model.TypeInvalidInput},
+	{ErrNameEmpty, protocol.CodeNameEmpty, model.TypeInvalidInput},
+	{ErrNameTooLong, protocol.CodeNameTooLong, model.TypeInvalidInput},
+	{ErrNameInvalid, protocol.CodeNameInvalid, model.TypeInvalidInput},
+	{ErrNameReserved, protocol.CodeNameReserved, model.TypeInvalidInput},
+	{ErrNameTaken, protocol.CodeNameTaken, model.TypeError},
+	{ErrNameMismatch, protocol.CodeNameMismatch, model.TypeError},
+	{ErrAuthFailed, protocol.CodeAuthFailed, model.TypeAuthFailed},
+	{ErrBanned, protocol.CodeBanned, model.TypeError},
+	{ErrAlreadyRegistered, protocol.CodeAlreadyRegistered, model.TypeError},
+	{ErrNotRegistered, protocol.CodeNotRegistered, model.TypeError},
+	{ErrNoDestination, protocol.CodeNoDestination, model.TypeError},
+	{ErrUserNotFound, protocol.CodeUserNotFound, model.TypeError},
+	{ErrRoomNameEmpty, protocol.CodeRoomNameEmpty, model.TypeError},
+	{ErrRoomNameInvalid, protocol.CodeRoomNameInvalid, model.TypeInvalidInput},
+	{ErrNotInRoom, protocol.CodeNotInRoom, model.TypeError},
+	{ErrHistoryUnavailable, protocol.CodeHistoryUnavailable, model.TypeError},
+	{ErrResumeFailed, protocol.CodeResumeFailed, model.TypeError},
+	{ErrSessionClosed, protocol.CodeSessionClosed, model.TypeError},
+	{ErrServerShutdown, protocol.CodeServerShutdown, model.TypeError},
+}
+
+// ErrorCode возвращает код протокола для ошибки хаба, для остальных
+// ошибок - protocol.CodeInternal
+func ErrorCode(err error) string {
+	code, _ := classify(err)
+	return code
+}
+
+func classify(err error) (code, typ string) {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code, c.typ
+		}
+	}
+	return protocol.CodeInternal, model.TypeError
+}
+
+// ErrorMessage возвращает кадр с ошибкой err. Транспорт отправляет его сам,
+// когда отказывает клиенту ещё до создания сессии.
+func ErrorMessage(err error) model.OutgoingMessage {
+	code, typ := classify(err)
+	return model.OutgoingMessage{
+		Type: typ,
+		Code: code,
+		Text: err.Error(),
+	}
+}
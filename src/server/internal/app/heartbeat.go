@@ -0,0 +1,63 @@
+package app
+
+import (
+	"chat/server/internal/logging"
+	"chat/server/internal/model"
+	"time"
+)
+
+// Значения по умолчанию для проверки связи с клиентами
+const (
+	DefaultHeartbeatInterval = 30 * time.Second
+	DefaultHeartbeatTimeout  = 90 * time.Second
+)
+
+// StartHeartbeat раз в interval отправляет ping клиентам, которые молчат
+// дольше interval, и отключает с причиной timeout тех, кто молчит дольше
+// timeout. Останавливается вместе с хабом; interval <= 0 ничего не делает.
+func (h *Hub) StartHeartbeat(interval, timeout time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	if timeout < interval {
+		timeout = interval
+	}
+	go h.heartbeat(interval, timeout)
+}
+
+func (h *Hub) heartbeat(interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.checkAlive(interval, timeout)
+		case <-h.quit:
+			return
+		}
+	}
+}
+
+// checkAlive проходит по всем сессиям, включая незарегистрированные:
+// полуоткрытое соединение без имени тоже нужно закрыть
+func (h *Hub) checkAlive(interval, timeout time.Duration) {
+	h.mu.RLock()
+	closing := h.closing
+	h.mu.RUnlock()
+	if closing {
+		return
+	}
+
+	for _, s := range h.all() {
+		idle := s.idle()
+		switch {
+		case idle >= timeout:
+			logging.Infof("Session %s (%s) timed out after %s\n", s.Name(), s.RemoteAddr(), idle.Round(time.Second))
+			h.Evict(s, model.LeftTimeout)
+		case idle >= interval:
+			if err := s.ping(); err != nil {
+				logging.Debugf("ping %s error: %s\n", s.RemoteAddr(), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"chat/protocol"
+	"chat/server/internal/model"
+	"fmt"
+	"slices"
+)
+
+// Hello согласует с клиентом версию протокола, возможности и кодек. Клиент,
+// который hello не присылал, работает с текущей версией, всеми
+// возможностями сервера и кодеком JSON.
+func (h *Hub) Hello(s *Session, msg model.IncomingMessage) error {
+	version, ok := protocol.Negotiate(msg.Versions)
+	if !ok {
+		return fmt.Errorf("%w: offered %v, supported %v", ErrUnsupportedVersion, msg.Versions, protocol.SupportedVersions)
+	}
+	caps := protocol.Common(msg.Capabilities, h.capabilities())
+	// Первый из запрошенных кодеков, который разрешён на сервере; если
+	// таких нет, соединение остаётся на текущем
+	h.mu.RLock()
+	allowed := h.codecs
+	h.mu.RUnlock()
+	var codec string
+	if common := protocol.Common(msg.Codecs, allowed); len(common) > 0 {
+		codec = common[0]
+	}
+
+	s.mu.Lock()
+	s.hello = true
+	s.caps = caps
+	if codec != "" {
+		s.codec = codec
+	}
+	s.mu.Unlock()
+
+	return s.Send(model.OutgoingMessage{
+		Type:         model.TypeHello,
+		Version:      version,
+		Capabilities: caps,
+		Codec:        codec,
+	})
+}
+
+// SetCodecs ограничивает кодеки, которые клиенты могут выбрать в hello.
+// Можно вызывать на работающем сервере: уже выбранный кодек соединения
+// не меняется. По умолчанию разрешены все.
+func (h *Hub) SetCodecs(names []string) {
+	h.mu.Lock()
+	h.codecs = names
+	h.mu.Unlock()
+}
+
+// Codec возвращает имя кодека, о котором сессия договорилась в hello.
+// Транспорт переходит на него после ответа на hello.
+func (s *Session) Codec() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.codec == "" {
+		return protocol.CodecJSON
+	}
+	return s.codec
+}
+
+// capabilities возвращает возможности, которые сервер предлагает клиентам
+func (h *Hub) capabilities() []string {
+	caps := []string{protocol.CapPresence}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.resumeGrace > 0 {
+		caps = append(caps, protocol.CapResume)
+	}
+	return caps
+}
+
+// supports сообщает, договорилась ли сессия о возможности
+func (s *Session) supports(capability string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.hello || slices.Contains(s.caps, capability)
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"chat/server/internal/logging"
+	"chat/server/internal/model"
+	"fmt"
+)
+
+const (
+	DefaultHistoryLimit = 20  // Сколько сообщений отдаётся, если клиент не указал limit
+	MaxHistoryLimit     = 100 // Больше за один запрос не отдаётся
+)
+
+// MessageStore - хранилище истории сообщений
+type MessageStore interface {
+	// Save назначает сообщению ID и время и сохраняет его
+	Save(msg model.StoredMessage) (model.StoredMessage, error)
+	// Last возвращает последние q.Limit сообщений выборки от старых к новым
+	Last(q model.HistoryQuery) ([]model.StoredMessage, error)
+	Close() error
+}
+
+// History отправляет сессии последние сообщения комнаты, переписки
+// с пользователем msg.To или общего чата
+func (h *Hub) History(s *Session, msg model.IncomingMessage) error {
+	name := s.Name()
+	if name == "" {
+		return ErrNotRegistered
+	}
+
+	q := model.HistoryQuery{
+		Room:  normalizeRoom(msg.Room),
+		User:  name,
+		Peer:  msg.To,
+		Limit: msg.Limit,
+	}
+	if q.Room != "" {
+		if err := ValidateRoom(q.Room); err != nil {
+			return err
+		}
+	}
+	if q.Room != "" && !s.InRoom(q.Room) {
+		return fmt.Errorf("%w %s", ErrNotInRoom, q.Room)
+	}
+	return h.replay(s, q)
+}
+
+func (h *Hub) replay(s *Session, q model.HistoryQuery) error {
+	messages, err := h.last(q)
+	if err != nil {
+		return err
+	}
+	for _, stored := range messages {
+		out := outgoingFromStored(stored)
+		out.History = true
+		if err := s.Send(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// last читает выборку из хранилища, ограничивая limit
+func (h *Hub) last(q model.HistoryQuery) ([]model.StoredMessage, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultHistoryLimit
+	}
+	if q.Limit > MaxHistoryLimit {
+		q.Limit = MaxHistoryLimit
+	}
+
+	messages, err := h.store.Last(q)
+	if err != nil {
+		logging.Errorf("read history error: %s\n", err)
+		return nil, ErrHistoryUnavailable
+	}
+	return messages, nil
+}
+
+// save сохраняет сообщение в историю. Если хранилище недоступно,
+// сообщение всё равно доставляется, но без ID.
+func (h *Hub) save(msg model.StoredMessage) model.OutgoingMessage {
+	saved, err := h.store.Save(msg)
+	if err != nil {
+		logging.Errorf("save history error: %s\n", err)
+	}
+	return outgoingFromStored(saved)
+}
+
+func outgoingFromStored(m model.StoredMessage) model.OutgoingMessage {
+	return model.OutgoingMessage{
+		ID:      m.ID,
+		Type:    m.Type,
+		Name:    m.From,
+		Text:    m.Text,
+		Time:    m.Time.Format(model.TimeLayout),
+		Dst:     m.To,
+		Room:    m.Room,
+		Private: m.Type == model.TypeWhisper,
+	}
+}
@@ -0,0 +1,496 @@
+package app
+
+import (
+	"chat/protocol"
+	"chat/server/internal/logging"
+	"chat/server/internal/model"
+	"chat/server/internal/store"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultHistorySize - размер истории в памяти, если хранилище не задано
+const DefaultHistorySize = 1000
+
+// Hub - центральная часть сервера: хранит сессии всех транспортов,
+// проверяет регистрацию и маршрутизирует сообщения
+type Hub struct {
+	sessions map[*Session]struct{}
+	byName   map[string]*Session
+	rooms    map[string]map[*Session]struct{} // Комната -> участники
+	store    MessageStore
+	auth     Authenticator
+	mu       sync.RWMutex
+
+	closing   bool           // Идёт остановка: новые кадры не принимаются
+	inflight  sync.WaitGroup // Кадры, обработка которых уже началась
+	closeOnce sync.Once
+	quit      chan struct{} // Закрывается в Close и останавливает heartbeat
+
+	held        map[*Session]*heldSession // Отключившиеся сессии, которые ждут возобновления
+	resumeGrace time.Duration             // Сколько ждать переподключения, 0 - не ждать
+	resumeQueue int                       // Сколько кадров копить для отключившейся сессии
+
+	sendQueue       int // Размер очереди отправки сессии, 0 - без очереди
+	queuePolicy     QueuePolicy
+	droppedFrames   atomic.Uint64
+	slowDisconnects atomic.Uint64
+	dropWarned      atomic.Int64 // Время последнего предупреждения об отброшенных кадрах, UnixNano
+
+	codecs []string // Кодеки, которые клиент может выбрать в hello
+
+	bans    map[string]struct{} // Имена, которым запрещено входить в чат
+	motd    string              // Сообщение дня для каждого нового пользователя
+	defined map[string]struct{} // Комнаты из настроек: существуют и без участников
+	maxText int                 // Наибольший размер текста сообщения в байтах
+
+	limiter rateLimiter // Лимиты частоты сообщений, регистраций и соединений
+}
+
+// Authenticator проверяет, что клиент вправе занять имя
+type Authenticator interface {
+	Authenticate(name string, creds model.Credentials) error
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		sessions: make(map[*Session]struct{}),
+		byName:   make(map[string]*Session),
+		rooms:    make(map[string]map[*Session]struct{}),
+		store:    store.NewMemoryStore(DefaultHistorySize),
+		quit:     make(chan struct{}),
+		held:     make(map[*Session]*heldSession),
+		codecs:   protocol.Codecs(),
+		bans:     make(map[string]struct{}),
+		defined:  make(map[string]struct{}),
+		maxText:  DefaultMaxTextSize,
+	}
+}
+
+// SetStore задаёт хранилище истории. Вызывается до запуска транспортов.
+func (h *Hub) SetStore(st MessageStore) {
+	h.store = st
+}
+
+// SetAuthenticator включает проверку учётных данных при регистрации.
+// Вызывается до запуска транспортов; nil отключает проверку.
+func (h *Hub) SetAuthenticator(a Authenticator) {
+	h.auth = a
+}
+
+// Connect создаёт сессию для нового соединения
+func (h *Hub) Connect(c Conn) *Session {
+	s := newSession(c)
+	h.attachQueue(s)
+	h.mu.Lock()
+	h.sessions[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+// Disconnect освобождает имя сессии и закрывает соединение.
+// Повторный вызов ничего не делает.
+func (h *Hub) Disconnect(s *Session) {
+	h.disconnect(s, model.LeftDisconnect)
+}
+
+// Evict отключает сессию по инициативе сервера, например по таймауту.
+// Остальные пользователи получат user_left с указанной причиной.
+func (h *Hub) Evict(s *Session, reason string) {
+	h.disconnect(s, reason)
+}
+
+func (h *Hub) disconnect(s *Session, reason string) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	if resumable(reason) && h.hold(s, reason) {
+		s.mu.Unlock()
+		s.closeConn()
+		return
+	}
+	s.mu.Unlock()
+
+	name, rooms := h.unlink(s)
+	if name != "" {
+		logging.Infof("User %s (%s) disconnected\n", name, s.RemoteAddr())
+	}
+	s.closeConn()
+	h.announceGone(name, rooms, reason)
+}
+
+// resumable сообщает, можно ли вернуться в сессию, отключённую по reason.
+// После выхода, бана и отключения за лимит частоты или переполненную
+// очередь сессия закрывается сразу, иначе клиент вернулся бы в неё по токену.
+func resumable(reason string) bool {
+	switch reason {
+	case model.LeftExit, model.LeftBanned, model.LeftRateLimit, model.LeftSlow:
+		return false
+	}
+	return true
+}
+
+// unlink освобождает имя сессии и убирает её из комнат
+func (h *Hub) unlink(s *Session) (name string, rooms []string) {
+	s.mu.Lock()
+	name = s.name
+	rooms = make([]string, 0, len(s.rooms))
+	for room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	s.rooms = make(map[string]struct{})
+	s.missed = nil
+	s.mu.Unlock()
+
+	h.mu.Lock()
+	delete(h.sessions, s)
+	if name != "" && h.byName[name] == s {
+		delete(h.byName, name)
+	}
+	for _, room := range rooms {
+		h.removeMember(room, s)
+	}
+	h.mu.Unlock()
+	return name, rooms
+}
+
+// announceGone сообщает комнатам и остальным пользователям об уходе
+func (h *Hub) announceGone(name string, rooms []string, reason string) {
+	for _, room := range rooms {
+		h.sendToRoom(room, model.OutgoingMessage{Type: model.TypeLeave, Name: name, Room: room})
+	}
+	if name != "" {
+		h.announceLeft(name, reason)
+	}
+}
+
+// Close отключает все сессии и закрывает хранилище истории.
+// Повторный вызов ничего не делает.
+func (h *Hub) Close() {
+	h.closeOnce.Do(func() {
+		h.mu.Lock()
+		h.closing = true
+		held := h.held
+		h.held = make(map[*Session]*heldSession)
+		h.mu.Unlock()
+		close(h.quit)
+		for _, hs := range held {
+			hs.timer.Stop()
+		}
+		for _, s := range h.all() {
+			h.Disconnect(s)
+		}
+		if err := h.store.Close(); err != nil {
+			logging.Errorf("close history error: %s\n", err)
+		}
+	})
+}
+
+// Shutdown останавливает хаб: перестаёт принимать кадры, дожидается уже
+// начатой обработки, отправляет всем клиентам server_shutdown, ждёт доставки
+// и отключает сессии. Если ctx истёк раньше, сессии отключаются сразу и
+// возвращается ошибка ctx.
+func (h *Hub) Shutdown(ctx context.Context, reason string) error {
+	h.mu.Lock()
+	h.closing = true
+	h.mu.Unlock()
+
+	err := wait(ctx, h.inflight.Wait)
+	if err == nil {
+		sessions := h.all()
+		frame := model.OutgoingMessage{Type: model.TypeServerShutdown, Text: reason}
+		for _, s := range sessions {
+			if err := s.Send(frame); err != nil {
+				logging.Warnf("send shutdown to %s error: %s\n", s.RemoteAddr(), err)
+			}
+		}
+		err = wait(ctx, func() {
+			for _, s := range sessions {
+				s.flush(ctx)
+			}
+		})
+	}
+
+	h.Close()
+	return err
+}
+
+// wait выполняет fn, но возвращает ошибку ctx, если тот истёк раньше
+func wait(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// all возвращает снимок всех сессий, включая незарегистрированные
+func (h *Hub) all() []*Session {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	sessions := make([]*Session, 0, len(h.sessions))
+	for s := range h.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// Handle обрабатывает входящее сообщение сессии. Ошибка, если она есть,
+// уже отправлена клиенту с ref кадра и возвращается для логирования и тестов;
+// кадр отключённой сессии отклоняется с ErrSessionClosed без ответа.
+func (h *Hub) Handle(s *Session, msg model.IncomingMessage) error {
+	s.Touch()
+	logging.Debugf("Frame %s from %s (%s)\n", msg.Type, s.Name(), s.RemoteAddr())
+	if msg.Type == model.TypePong {
+		// Ответ на ping нужен только для отметки активности
+		return nil
+	}
+
+	h.mu.RLock()
+	if h.closing {
+		h.mu.RUnlock()
+		s.ReplyError(msg.Ref, ErrServerShutdown)
+		return ErrServerShutdown
+	}
+	// Add под блокировкой: Shutdown ждёт inflight только после closing
+	h.inflight.Add(1)
+	h.mu.RUnlock()
+	defer h.inflight.Done()
+
+	if s.isClosed() {
+		// Кадры, дошедшие после отключения, не обрабатываются: имя сессии
+		// уже не её, а ответить некому
+		return ErrSessionClosed
+	}
+
+	if err := h.checkRate(s, msg); err != nil {
+		return err // checkRate уже сообщил клиенту
+	}
+	if err := h.checkIdentity(s, msg); err != nil {
+		s.ReplyError(msg.Ref, err)
+		return err
+	}
+
+	var err error
+	switch msg.Type {
+	case model.TypeHello:
+		err = h.Hello(s, msg)
+	case model.TypeRegister:
+		if err = h.Register(s, msg.From, msg.Credentials); err == nil && msg.Ref != "" {
+			// Подтверждение нужно клиенту, который ждёт ответа по ref
+			s.Send(model.OutgoingMessage{Type: model.TypeRegistered, Name: msg.From, Ref: msg.Ref})
+		}
+	case model.TypeBroadcast:
+		err = h.Broadcast(s, msg)
+	case model.TypeWhisper:
+		err = h.Whisper(s, msg)
+	case model.TypeJoin:
+		err = h.Join(s, msg.Room)
+	case model.TypeLeave:
+		err = h.Leave(s, msg.Room)
+	case model.TypeRooms:
+		err = h.Rooms(s)
+	case model.TypeHistory:
+		err = h.History(s, msg)
+	case model.TypeWho:
+		err = h.Who(s)
+	case model.TypeResume:
+		err = h.Resume(s, msg.From, msg.Credentials.Token)
+	case model.TypePing:
+		err = s.Send(model.OutgoingMessage{Type: model.TypePong})
+	case model.TypeExit:
+		h.disconnect(s, model.LeftExit)
+	default:
+		err = fmt.Errorf("%w: %q", ErrUnknownType, msg.Type)
+	}
+
+	if err != nil {
+		s.ReplyError(msg.Ref, err)
+	}
+	return err
+}
+
+// checkIdentity отклоняет кадр, в котором указано чужое имя. Отправитель
+// всегда определяется по сессии, поле name клиента лишь сверяется с ним.
+func (h *Hub) checkIdentity(s *Session, msg model.IncomingMessage) error {
+	if msg.Type == model.TypeRegister || msg.From == "" {
+		return nil
+	}
+	name := s.Name()
+	if name == "" || msg.From == name {
+		return nil
+	}
+	logging.Warnf("Session %s (%s) sent a frame as %s\n", name, s.RemoteAddr(), msg.From)
+	return fmt.Errorf("%w: registered as %s, frame name %s", ErrNameMismatch, name, msg.From)
+}
+
+func (h *Hub) Register(s *Session, name string, creds model.Credentials) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	if h.banned(name) {
+		logging.Warnf("Banned user %s tried to register from %s\n", name, s.RemoteAddr())
+		return fmt.Errorf("%w: %s", ErrBanned, name)
+	}
+	if id := s.identity(); id != "" {
+		// Сертификат клиента заменяет пароль, но закрепляет имя
+		if name != id {
+			logging.Warnf("Certificate of %s from %s used to register as %s\n", id, s.RemoteAddr(), name)
+			return fmt.Errorf("%w: certificate issued to %s", ErrAuthFailed, id)
+		}
+	} else if s.Name() == "" && h.auth != nil {
+		if err := h.auth.Authenticate(name, creds); err != nil {
+			logging.Warnf("Authentication of %s from %s failed: %s\n", name, s.RemoteAddr(), err)
+			return fmt.Errorf("%w: %v", ErrAuthFailed, err)
+		}
+	}
+
+	joined, err := h.claim(s, name)
+	if err != nil {
+		return err
+	}
+	if joined {
+		logging.Infof("User %s registered from %s\n", name, s.conn.RemoteAddr())
+		h.issueToken(s, name)
+		h.sendMOTD(s)
+		h.announceJoined(s, name)
+	}
+	return nil
+}
+
+// claim закрепляет имя за сессией. joined ложно, если сессия уже
+// зарегистрирована под этим именем.
+func (h *Hub) claim(s *Session, name string) (joined bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false, ErrSessionClosed
+	}
+	if s.name == name {
+		return false, nil
+	}
+	if s.name != "" {
+		return false, ErrAlreadyRegistered
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, exists := h.byName[name]; exists {
+		return false, ErrNameTaken
+	}
+	h.byName[name] = s
+	s.name = name
+	return true, nil
+}
+
+// Broadcast рассылает сообщение всем зарегистрированным пользователям,
+// а если указана комната - только её участникам
+func (h *Hub) Broadcast(s *Session, msg model.IncomingMessage) error {
+	from := s.Name()
+	if from == "" {
+		return ErrNotRegistered
+	}
+	if room := normalizeRoom(msg.Room); room != "" && !s.InRoom(room) {
+		return fmt.Errorf("%w %s", ErrNotInRoom, room)
+	}
+	_, _, err := h.deliver(from, msg)
+	return err
+}
+
+// Whisper доставляет приватное сообщение получателю и эхо отправителю
+func (h *Hub) Whisper(s *Session, msg model.IncomingMessage) error {
+	from := s.Name()
+	if from == "" {
+		return ErrNotRegistered
+	}
+	out, dst, err := h.deliver(from, msg)
+	if err != nil {
+		return err
+	}
+	if dst != s {
+		s.Send(out)
+	}
+	return nil
+}
+
+// deliver проверяет текст и получателя, сохраняет сообщение from в истории
+// и доставляет его: whisper - получателю msg.To, которого и возвращает,
+// broadcast - всем зарегистрированным или участникам комнаты. Права
+// отправителя проверяет вызывающий.
+func (h *Hub) deliver(from string, msg model.IncomingMessage) (model.OutgoingMessage, *Session, error) {
+	if msg.Type == model.TypeWhisper && msg.To == "" {
+		return model.OutgoingMessage{}, nil, ErrNoDestination
+	}
+	if err := h.validateText(msg.Text); err != nil {
+		return model.OutgoingMessage{}, nil, err
+	}
+
+	if msg.Type == model.TypeWhisper {
+		h.mu.RLock()
+		dst, ok := h.byName[msg.To]
+		h.mu.RUnlock()
+		if !ok {
+			return model.OutgoingMessage{}, nil, fmt.Errorf("%w: %s", ErrUserNotFound, msg.To)
+		}
+		out := h.save(model.StoredMessage{
+			Time: time.Now(),
+			Type: model.TypeWhisper,
+			From: from,
+			To:   msg.To,
+			Text: msg.Text,
+		})
+		if err := dst.Send(out); err != nil {
+			logging.Warnf("send private message for client %s error: %s\n", msg.To, err)
+		}
+		return out, dst, nil
+	}
+
+	room := normalizeRoom(msg.Room)
+	if room != "" {
+		if err := ValidateRoom(room); err != nil {
+			return model.OutgoingMessage{}, nil, err
+		}
+	}
+	out := h.save(model.StoredMessage{
+		Time: time.Now(),
+		Type: model.TypeBroadcast,
+		From: from,
+		Room: room,
+		Text: msg.Text,
+	})
+	if room != "" {
+		h.sendToRoom(room, out)
+		return out, nil, nil
+	}
+	for _, recipient := range h.registered() {
+		if err := recipient.Send(out); err != nil {
+			logging.Warnf("send message for client %s error: %s\n", recipient.Name(), err)
+		}
+	}
+	return out, nil, nil
+}
+
+// registered возвращает снимок зарегистрированных сессий, чтобы отправка
+// не выполнялась под блокировкой хаба
+func (h *Hub) registered() []*Session {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	sessions := make([]*Session, 0, len(h.byName))
+	for _, s := range h.byName {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"chat/server/internal/logging"
+	"chat/server/internal/model"
+	"context"
+	"fmt"
+	"time"
+)
+
+// banFlushTimeout - сколько ждать доставки ошибки пользователю, которого
+// отключает бан
+const banFlushTimeout = time.Second
+
+// SetBans задаёт имена, которым запрещено входить в чат. Можно вызывать на
+// работающем сервере: пользователи из нового списка, которые уже в сети,
+// получают ошибку и отключаются с причиной banned, без возобновления.
+func (h *Hub) SetBans(names []string) {
+	bans := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		bans[name] = struct{}{}
+	}
+
+	h.mu.Lock()
+	h.bans = bans
+	var kicked []*Session
+	for name := range bans {
+		if s, ok := h.byName[name]; ok {
+			if _, held := h.held[s]; !held {
+				kicked = append(kicked, s)
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	for _, s := range kicked {
+		logging.Infof("User %s (%s) is banned, disconnecting\n", s.Name(), s.RemoteAddr())
+		s.SendError(fmt.Errorf("%w: %s", ErrBanned, s.Name()))
+		ctx, cancel := context.WithTimeout(context.Background(), banFlushTimeout)
+		s.flush(ctx)
+		cancel()
+		h.Evict(s, model.LeftBanned)
+	}
+}
+
+// banned сообщает, запрещено ли имя
+func (h *Hub) banned(name string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.bans[name]
+	return ok
+}
+
+// SetMOTD задаёт сообщение дня, которое получает каждый новый пользователь
+// сразу после регистрации. Можно вызывать на работающем сервере; пустая
+// строка отключает сообщение.
+func (h *Hub) SetMOTD(text string) {
+	h.mu.Lock()
+	h.motd = text
+	h.mu.Unlock()
+}
+
+func (h *Hub) sendMOTD(s *Session) {
+	h.mu.RLock()
+	motd := h.motd
+	h.mu.RUnlock()
+	if motd != "" {
+		s.Send(model.OutgoingMessage{Type: model.TypeMOTD, Text: motd})
+	}
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"chat/protocol"
+	"chat/server/internal/logging"
+	"chat/server/internal/model"
+)
+
+// Who отправляет сессии список пользователей в сети
+func (h *Hub) Who(s *Session) error {
+	if s.Name() == "" {
+		return ErrNotRegistered
+	}
+
+	return s.Send(model.OutgoingMessage{Type: model.TypeWho, Users: h.Users()})
+}
+
+// announceJoined сообщает остальным пользователям о регистрации новичка
+func (h *Hub) announceJoined(s *Session, name string) {
+	h.notifyOthers(s, model.OutgoingMessage{Type: model.TypeUserJoined, Name: name})
+}
+
+// announceLeft сообщает оставшимся пользователям об уходе. При остановке
+// сервера событие не рассылается: все и так получают server_shutdown.
+func (h *Hub) announceLeft(name, reason string) {
+	h.mu.RLock()
+	closing := h.closing
+	h.mu.RUnlock()
+	if closing {
+		return
+	}
+	h.notifyOthers(nil, model.OutgoingMessage{Type: model.TypeUserLeft, Name: name, Reason: reason})
+}
+
+// notifyOthers рассылает событие присутствия всем зарегистрированным, кроме s
+// и тех, кто отказался от таких событий в hello
+func (h *Hub) notifyOthers(s *Session, msg model.OutgoingMessage) {
+	for _, recipient := range h.registered() {
+		if recipient == s || !recipient.supports(protocol.CapPresence) {
+			continue
+		}
+		if err := recipient.Send(msg); err != nil {
+			logging.Warnf("send %s event for client %s error: %s\n", msg.Type, recipient.Name(), err)
+		}
+	}
+}
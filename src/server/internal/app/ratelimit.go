@@ -0,0 +1,390 @@
+package app
+
+import (
+	"chat/server/internal/logging"
+	"chat/server/internal/model"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMuteDuration - на сколько наказание mute лишает клиента слова
+const DefaultMuteDuration = 30 * time.Second
+
+// Rate - ограничение частоты по алгоритму token bucket: Burst событий
+// подряд и PerSecond событий в секунду в среднем. Нулевое значение -
+// без ограничения.
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// ParseRate разбирает значение флага вида N/s, N/m или N/h с
+// необязательным запасом через двоеточие: 10/s:20. Без запаса он равен N.
+// Пустая строка, 0 и off отключают ограничение.
+func ParseRate(s string) (Rate, error) {
+	if s == "" || s == "0" || s == "off" {
+		return Rate{}, nil
+	}
+	spec, burstStr, hasBurst := strings.Cut(s, ":")
+	countStr, unit, ok := strings.Cut(spec, "/")
+	count, err := strconv.Atoi(countStr)
+	if !ok || err != nil || count <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate %q (expected N/s, N/m or N/h, optionally with :burst)", s)
+	}
+	var per time.Duration
+	switch unit {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return Rate{}, fmt.Errorf("invalid rate %q: unknown unit %q (expected s, m or h)", s, unit)
+	}
+	burst := count
+	if hasBurst {
+		burst, err = strconv.Atoi(burstStr)
+		if err != nil || burst <= 0 {
+			return Rate{}, fmt.Errorf("invalid rate %q: burst must be a positive integer", s)
+		}
+	}
+	return Rate{PerSecond: float64(count) / per.Seconds(), Burst: burst}, nil
+}
+
+func (r Rate) String() string {
+	switch {
+	case !r.Enabled():
+		return "off"
+	case r.PerSecond >= 1:
+		return fmt.Sprintf("%g/s:%d", roundRate(r.PerSecond), r.Burst)
+	case r.PerSecond*60 >= 1:
+		return fmt.Sprintf("%g/m:%d", roundRate(r.PerSecond*60), r.Burst)
+	default:
+		return fmt.Sprintf("%g/h:%d", roundRate(r.PerSecond*3600), r.Burst)
+	}
+}
+
+func roundRate(x float64) float64 {
+	return math.Round(x*1000) / 1000
+}
+
+// Enabled сообщает, задано ли ограничение
+func (r Rate) Enabled() bool {
+	return r.PerSecond > 0 && r.Burst > 0
+}
+
+// Penalty - что делать с клиентом, превысившим лимит сообщений или регистраций
+type Penalty int
+
+const (
+	PenaltyDrop       Penalty = iota // Отбросить кадр
+	PenaltyWarn                      // Обработать кадр, но предупредить клиента
+	PenaltyMute                      // Отбрасывать сообщения клиента в течение MuteFor
+	PenaltyDisconnect                // Отключить клиента с причиной rate_limited
+)
+
+// ParsePenalty разбирает значение флага: drop, warn, mute или disconnect
+func ParsePenalty(s string) (Penalty, error) {
+	switch s {
+	case "drop":
+		return PenaltyDrop, nil
+	case "warn":
+		return PenaltyWarn, nil
+	case "mute":
+		return PenaltyMute, nil
+	case "disconnect":
+		return PenaltyDisconnect, nil
+	default:
+		return 0, fmt.Errorf("unknown rate limit penalty %q (expected: drop, warn, mute, disconnect)", s)
+	}
+}
+
+func (p Penalty) String() string {
+	switch p {
+	case PenaltyWarn:
+		return "warn"
+	case PenaltyMute:
+		return "mute"
+	case PenaltyDisconnect:
+		return "disconnect"
+	default:
+		return "drop"
+	}
+}
+
+// RateLimits - лимиты частоты хаба. Сообщения - broadcast и whisper,
+// регистрации - register и resume. Лимит адреса общий для всех сессий с
+// одного IP на всех транспортах.
+type RateLimits struct {
+	Messages           Rate
+	MessagesPerIP      Rate
+	Registrations      Rate
+	RegistrationsPerIP Rate
+	ConnectionsPerIP   Rate // Новые соединения, для UDP - новые адреса
+
+	Penalty Penalty
+	MuteFor time.Duration // Для PenaltyMute, 0 - DefaultMuteDuration
+}
+
+// SetRateLimits задаёт лимиты частоты. Можно вызывать на работающем
+// сервере: счётчики с изменившимся лимитом начинаются заново.
+func (h *Hub) SetRateLimits(limits RateLimits) {
+	if limits.MuteFor <= 0 {
+		limits.MuteFor = DefaultMuteDuration
+	}
+	h.limiter.mu.Lock()
+	h.limiter.limits = limits
+	h.limiter.mu.Unlock()
+}
+
+// Admit проверяет лимит новых соединений с адреса remote. Транспорт
+// вызывает его до Connect и при ошибке отказывает клиенту.
+func (h *Hub) Admit(remote string) error {
+	l := &h.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := l.limits.ConnectionsPerIP
+	if !r.Enabled() {
+		return nil
+	}
+	ip := hostOf(remote)
+	if wait, ok := l.addr(ip).connections.take(r, time.Now()); !ok {
+		logging.Warnf("Connection from %s rejected: rate limit exceeded\n", remote)
+		return rateError("connections per address", r, wait)
+	}
+	return nil
+}
+
+// limitKind - какие кадры считает лимит
+type limitKind int
+
+const (
+	limitNone limitKind = iota
+	limitMessage
+	limitRegistration
+)
+
+func kindOf(msgType string) limitKind {
+	switch msgType {
+	case model.TypeBroadcast, model.TypeWhisper:
+		return limitMessage
+	case model.TypeRegister, model.TypeResume:
+		return limitRegistration
+	default:
+		return limitNone
+	}
+}
+
+// checkRate применяет лимиты частоты к кадру и сам сообщает клиенту о
+// превышении. nil - кадр нужно обработать.
+func (h *Hub) checkRate(s *Session, msg model.IncomingMessage) error {
+	kind := kindOf(msg.Type)
+	if kind == limitNone {
+		return nil
+	}
+	penalty, err := h.limiter.allow(s, kind, time.Now())
+	if err == nil {
+		return nil
+	}
+
+	logging.Warnf("Session %s (%s) exceeded the rate limit, penalty %s: %s\n", s.Name(), s.RemoteAddr(), penalty, err)
+	s.ReplyError(msg.Ref, err)
+	switch penalty {
+	case PenaltyWarn:
+		return nil
+	case PenaltyDisconnect:
+		ctx, cancel := context.WithTimeout(context.Background(), banFlushTimeout)
+		s.flush(ctx)
+		cancel()
+		h.Evict(s, model.LeftRateLimit)
+	}
+	return err
+}
+
+// sessionRate - счётчики одной сессии
+type sessionRate struct {
+	messages      bucket
+	registrations bucket
+	mutedUntil    time.Time
+}
+
+// addrRate - счётчики одного IP
+type addrRate struct {
+	messages      bucket
+	registrations bucket
+	connections   bucket
+	posts         bucket // Сообщения REST API по лимиту сессии
+}
+
+// rateLimiter хранит лимиты и счётчики адресов. Счётчики сессий лежат в
+// самих сессиях, но тоже меняются под mu.
+type rateLimiter struct {
+	mu      sync.Mutex
+	limits  RateLimits
+	byAddr  map[string]*addrRate
+	sweepAt int // При таком числе адресов убрать забытые
+}
+
+// minSweep - сколько адресов хранить, прежде чем убирать забытые
+const minSweep = 1024
+
+// allow списывает событие со счётчиков сессии и её адреса. Вместе с ошибкой
+// возвращается наказание, которое к ней применить.
+func (l *rateLimiter) allow(s *Session, kind limitKind, now time.Time) (Penalty, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limits := l.limits
+	penalty := limits.Penalty
+	if kind == limitRegistration && penalty == PenaltyMute {
+		penalty = PenaltyDrop // Молчание к регистрации не относится
+	}
+
+	var own, shared *bucket
+	var ownRate, sharedRate Rate
+	var what string
+	switch kind {
+	case limitMessage:
+		if now.Before(s.rate.mutedUntil) {
+			return PenaltyDrop, fmt.Errorf("%w: muted for %s", ErrRateLimited, roundWait(s.rate.mutedUntil.Sub(now)))
+		}
+		what = "messages"
+		own, ownRate = &s.rate.messages, limits.Messages
+		sharedRate = limits.MessagesPerIP
+		if sharedRate.Enabled() {
+			shared = &l.addr(hostOf(s.RemoteAddr())).messages
+		}
+	case limitRegistration:
+		what = "registrations"
+		own, ownRate = &s.rate.registrations, limits.Registrations
+		sharedRate = limits.RegistrationsPerIP
+		if sharedRate.Enabled() {
+			shared = &l.addr(hostOf(s.RemoteAddr())).registrations
+		}
+	}
+
+	if wait, ok := own.take(ownRate, now); !ok {
+		return l.penalize(s, rateError(what+" per session", ownRate, wait), penalty, now)
+	}
+	if shared != nil {
+		if wait, ok := shared.take(sharedRate, now); !ok {
+			return l.penalize(s, rateError(what+" per address", sharedRate, wait), penalty, now)
+		}
+	}
+	return penalty, nil
+}
+
+// allowPost списывает сообщение без сессии, пришедшее с адреса remote.
+// Лимит сессии считается по адресу, лимит адреса общий с соединениями.
+// Наказание одно - отказ: отключать и лишать слова некого.
+func (l *rateLimiter) allowPost(remote string, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limits := l.limits
+	if !limits.Messages.Enabled() && !limits.MessagesPerIP.Enabled() {
+		return nil
+	}
+	a := l.addr(hostOf(remote))
+	if wait, ok := a.posts.take(limits.Messages, now); !ok {
+		return rateError("api messages per address", limits.Messages, wait)
+	}
+	if wait, ok := a.messages.take(limits.MessagesPerIP, now); !ok {
+		return rateError("messages per address", limits.MessagesPerIP, wait)
+	}
+	return nil
+}
+
+// penalize вызывается под l.mu
+func (l *rateLimiter) penalize(s *Session, err error, penalty Penalty, now time.Time) (Penalty, error) {
+	if penalty == PenaltyMute {
+		s.rate.mutedUntil = now.Add(l.limits.MuteFor)
+		return penalty, fmt.Errorf("%w, muted for %s", err, l.limits.MuteFor)
+	}
+	return penalty, err
+}
+
+// addr возвращает счётчики адреса, заводя их при первом обращении.
+// Вызывается под l.mu.
+func (l *rateLimiter) addr(ip string) *addrRate {
+	if a, ok := l.byAddr[ip]; ok {
+		return a
+	}
+	if l.byAddr == nil {
+		l.byAddr = make(map[string]*addrRate)
+	}
+	if len(l.byAddr) >= max(l.sweepAt, minSweep) {
+		l.sweep(time.Now())
+		l.sweepAt = 2 * len(l.byAddr)
+	}
+	a := &addrRate{}
+	l.byAddr[ip] = a
+	return a
+}
+
+// sweep забывает адреса, счётчики которых уже полностью восстановились
+func (l *rateLimiter) sweep(now time.Time) {
+	for ip, a := range l.byAddr {
+		if a.messages.full(now) && a.registrations.full(now) && a.connections.full(now) && a.posts.full(now) {
+			delete(l.byAddr, ip)
+		}
+	}
+}
+
+// bucket - счётчик token bucket
+type bucket struct {
+	tokens float64
+	last   time.Time
+	rate   Rate // Лимит, с которым счётчик заполнялся
+}
+
+// take списывает одно событие. false - лимит исчерпан, wait - через
+// сколько появится следующий токен.
+func (b *bucket) take(r Rate, now time.Time) (wait time.Duration, ok bool) {
+	if !r.Enabled() {
+		return 0, true
+	}
+	if b.rate != r || b.last.IsZero() {
+		*b = bucket{tokens: float64(r.Burst), last: now, rate: r}
+	}
+	b.tokens = math.Min(float64(r.Burst), b.tokens+now.Sub(b.last).Seconds()*r.PerSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / r.PerSecond * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// full сообщает, что счётчик не использовался или уже восстановился
+func (b *bucket) full(now time.Time) bool {
+	if !b.rate.Enabled() {
+		return true
+	}
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate.PerSecond >= float64(b.rate.Burst)
+}
+
+func rateError(what string, r Rate, wait time.Duration) error {
+	return fmt.Errorf("%w: %s %s, retry in %s", ErrRateLimited, what, r, roundWait(wait))
+}
+
+// roundWait округляет ожидание для сообщения клиенту
+func roundWait(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(10 * time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
+
+// hostOf возвращает IP из адреса host:port
+func hostOf(remote string) string {
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		return host
+	}
+	return remote
+}
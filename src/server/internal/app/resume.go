@@ -0,0 +1,234 @@
+package app
+
+import (
+	"chat/protocol"
+	"chat/server/internal/logging"
+	"chat/server/internal/model"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Значения по умолчанию для возобновления сессий
+const (
+	DefaultResumeGrace = 30 * time.Second // Сколько ждать переподключения
+	DefaultResumeQueue = 100              // Сколько кадров копить для отключившейся сессии
+)
+
+// heldSession - отключившаяся сессия, которую можно возобновить по токену
+type heldSession struct {
+	token  string
+	reason string // Причина для user_left, если клиент не вернётся
+	timer  *time.Timer
+}
+
+// SetResume включает возобновление сессий: после обрыва соединения имя,
+// комнаты и до queueSize последних кадров хранятся grace, и клиент может
+// вернуться с токеном, полученным при регистрации. Вызывается до запуска
+// транспортов или на работающем сервере: новые значения действуют для
+// следующих обрывов. grace 0 отключает возобновление.
+func (h *Hub) SetResume(grace time.Duration, queueSize int) {
+	if queueSize <= 0 {
+		queueSize = DefaultResumeQueue
+	}
+	h.mu.Lock()
+	h.resumeGrace = grace
+	h.resumeQueue = queueSize
+	h.mu.Unlock()
+}
+
+// issueToken выдаёт новой сессии токен возобновления
+func (h *Hub) issueToken(s *Session, name string) {
+	h.mu.RLock()
+	grace := h.resumeGrace
+	h.mu.RUnlock()
+	if grace <= 0 || !s.supports(protocol.CapResume) {
+		return
+	}
+	token, err := newToken()
+	if err != nil {
+		logging.Errorf("generate resume token error: %s\n", err)
+		return
+	}
+	s.mu.Lock()
+	s.token = token
+	s.mu.Unlock()
+	s.Send(model.OutgoingMessage{Type: model.TypeSession, Name: name, Token: token})
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hold оставляет за отключившейся сессией имя и комнаты на время grace.
+// Вызывается под s.mu; false - сессию нужно отключить сразу.
+func (h *Hub) hold(s *Session, reason string) bool {
+	if s.name == "" || s.token == "" {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closing || h.resumeGrace <= 0 {
+		return false
+	}
+	delete(h.sessions, s)
+	s.missed = newOutbox(h.resumeQueue)
+	h.held[s] = &heldSession{
+		token:  s.token,
+		reason: reason,
+		timer:  time.AfterFunc(h.resumeGrace, func() { h.expire(s) }),
+	}
+	logging.Infof("User %s (%s) dropped, waiting %s to resume\n", s.name, s.RemoteAddr(), h.resumeGrace)
+	return true
+}
+
+// expire окончательно отключает сессию, которую так и не возобновили
+func (h *Hub) expire(s *Session) {
+	h.mu.Lock()
+	hs, ok := h.held[s]
+	delete(h.held, s)
+	h.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	name, rooms := h.unlink(s)
+	logging.Infof("User %s (%s) did not resume, disconnected\n", name, s.RemoteAddr())
+	h.announceGone(name, rooms, hs.reason)
+}
+
+// Resume переносит в новую сессию имя, комнаты и накопленные кадры
+// отключившейся сессии. Первым клиент получает resumed, затем пропущенное.
+func (h *Hub) Resume(s *Session, name, token string) error {
+	if s.Name() != "" {
+		return ErrAlreadyRegistered
+	}
+
+	if h.banned(name) {
+		logging.Warnf("Resume of banned user %s from %s rejected\n", name, s.RemoteAddr())
+		return ErrResumeFailed
+	}
+
+	h.mu.Lock()
+	old := h.byName[name]
+	hs, ok := h.held[old]
+	if !ok || subtle.ConstantTimeCompare([]byte(hs.token), []byte(token)) != 1 {
+		h.mu.Unlock()
+		logging.Warnf("Resume of %s from %s rejected\n", name, s.RemoteAddr())
+		return ErrResumeFailed
+	}
+	delete(h.held, old)
+	hs.timer.Stop()
+	h.mu.Unlock()
+
+	old.mu.Lock()
+	rooms := old.rooms
+	q := old.missed
+	old.rooms = make(map[string]struct{})
+	old.mu.Unlock()
+
+	s.mu.Lock()
+	if s.closed || s.name != "" {
+		s.mu.Unlock()
+		// Новое соединение уже закрылось: сессию не вернуть
+		old.mu.Lock()
+		old.rooms = rooms
+		old.mu.Unlock()
+		name, rooms := h.unlink(old)
+		h.announceGone(name, rooms, hs.reason)
+		return ErrSessionClosed
+	}
+	s.name = name
+	s.token = hs.token
+	s.rooms = rooms
+	s.missed = q // Пока очередь не разобрана, новые кадры встают за ней
+	h.mu.Lock()
+	h.byName[name] = s
+	for room := range rooms {
+		if members, ok := h.rooms[room]; ok {
+			delete(members, old)
+			members[s] = struct{}{}
+		}
+	}
+	h.mu.Unlock()
+	s.mu.Unlock()
+
+	missed, dropped := q.stats()
+	logging.Infof("User %s resumed from %s, %d missed frames\n", name, s.RemoteAddr(), missed)
+	s.write(model.OutgoingMessage{
+		Type: model.TypeResumed,
+		Name: name,
+		Text: fmt.Sprintf("%d missed, %d dropped", missed, dropped),
+	})
+	q.drain(s)
+	return nil
+}
+
+// outbox - ограниченная очередь кадров отключившейся сессии. При
+// переполнении отбрасываются самые старые кадры.
+type outbox struct {
+	mu      sync.Mutex
+	msgs    []model.OutgoingMessage
+	limit   int
+	dropped int
+	next    *Session // После разбора очереди кадры уходят этой сессии
+}
+
+func newOutbox(limit int) *outbox {
+	return &outbox{limit: limit}
+}
+
+// push ставит кадр в очередь. Если очередь уже разобрана, возвращает
+// сессию, которой кадр нужно отправить напрямую.
+func (q *outbox) push(msg model.OutgoingMessage) *Session {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.next != nil {
+		return q.next
+	}
+	if len(q.msgs) >= q.limit {
+		q.msgs = q.msgs[1:]
+		q.dropped++
+	}
+	q.msgs = append(q.msgs, msg)
+	return nil
+}
+
+func (q *outbox) stats() (queued, dropped int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.msgs), q.dropped
+}
+
+// drain передаёт накопленные кадры соединению s. Кадры, пришедшие во
+// время отправки, встают в очередь и уходят следующей порцией, поэтому
+// порядок не нарушается.
+func (q *outbox) drain(s *Session) {
+	for {
+		q.mu.Lock()
+		msgs := q.msgs
+		q.msgs = nil
+		if len(msgs) == 0 {
+			q.next = s
+			s.mu.Lock()
+			s.missed = nil
+			s.mu.Unlock()
+			q.mu.Unlock()
+			return
+		}
+		q.mu.Unlock()
+
+		for _, msg := range msgs {
+			if err := s.write(msg); err != nil {
+				logging.Warnf("send missed frame to %s error: %s\n", s.RemoteAddr(), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"chat/server/internal/logging"
+	"chat/server/internal/model"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// normalizeRoom приводит имя комнаты к виду #name
+func normalizeRoom(room string) string {
+	room = strings.TrimSpace(room)
+	if room == "" || strings.HasPrefix(room, "#") {
+		return room
+	}
+	return "#" + room
+}
+
+// Join добавляет сессию в комнату, создавая её при необходимости,
+// сообщает об этом всем участникам комнаты и присылает новичку историю комнаты
+func (h *Hub) Join(s *Session, room string) error {
+	room = normalizeRoom(room)
+	if err := ValidateRoom(room); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrSessionClosed
+	}
+	if s.name == "" {
+		s.mu.Unlock()
+		return ErrNotRegistered
+	}
+	if _, ok := s.rooms[room]; ok {
+		s.mu.Unlock()
+		return nil
+	}
+	s.rooms[room] = struct{}{}
+	name := s.name
+	h.mu.Lock()
+	members, ok := h.rooms[room]
+	if !ok {
+		members = make(map[*Session]struct{})
+		h.rooms[room] = members
+	}
+	members[s] = struct{}{}
+	h.mu.Unlock()
+	s.mu.Unlock()
+
+	logging.Infof("User %s joined %s\n", name, room)
+	h.sendToRoom(room, model.OutgoingMessage{Type: model.TypeJoin, Name: name, Room: room})
+	return h.replay(s, model.HistoryQuery{Room: room, User: name})
+}
+
+// Leave убирает сессию из комнаты; пустая комната удаляется
+func (h *Hub) Leave(s *Session, room string) error {
+	room = normalizeRoom(room)
+	if err := ValidateRoom(room); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if _, ok := s.rooms[room]; !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("%w %s", ErrNotInRoom, room)
+	}
+	delete(s.rooms, room)
+	name := s.name
+	h.mu.Lock()
+	h.removeMember(room, s)
+	h.mu.Unlock()
+	s.mu.Unlock()
+
+	out := model.OutgoingMessage{Type: model.TypeLeave, Name: name, Room: room}
+	s.Send(out)
+	h.sendToRoom(room, out)
+	return nil
+}
+
+// SetRooms задаёт комнаты из настроек сервера: они есть в списке комнат,
+// даже когда в них никого нет. Недопустимые имена пропускаются. Можно вызывать на работающем сервере;
+// участники убранной из настроек комнаты остаются в ней.
+func (h *Hub) SetRooms(names []string) {
+	defined := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		if room := normalizeRoom(name); ValidateRoom(room) == nil {
+			defined[room] = struct{}{}
+		}
+	}
+	h.mu.Lock()
+	h.defined = defined
+	h.mu.Unlock()
+}
+
+// Rooms отправляет сессии список существующих комнат
+func (h *Hub) Rooms(s *Session) error {
+	if s.Name() == "" {
+		return ErrNotRegistered
+	}
+
+	h.mu.RLock()
+	rooms := make([]string, 0, len(h.rooms)+len(h.defined))
+	for room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	for room := range h.defined {
+		if _, ok := h.rooms[room]; !ok {
+			rooms = append(rooms, room)
+		}
+	}
+	h.mu.RUnlock()
+	sort.Strings(rooms)
+
+	return s.Send(model.OutgoingMessage{Type: model.TypeRooms, Rooms: rooms})
+}
+
+// removeMember вызывается под h.mu
+func (h *Hub) removeMember(room string, s *Session) {
+	members, ok := h.rooms[room]
+	if !ok {
+		return
+	}
+	delete(members, s)
+	if len(members) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+func (h *Hub) sendToRoom(room string, msg model.OutgoingMessage) {
+	h.mu.RLock()
+	members := make([]*Session, 0, len(h.rooms[room]))
+	for s := range h.rooms[room] {
+		members = append(members, s)
+	}
+	h.mu.RUnlock()
+
+	for _, member := range members {
+		if err := member.Send(msg); err != nil {
+			logging.Warnf("send room %s message for client %s error: %s\n", room, member.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package app
+
+import (
+	"chat/server/internal/logging"
+	"chat/server/internal/model"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultSendQueue - сколько кадров может ждать отправки одному клиенту
+const DefaultSendQueue = 256
+
+// dropWarnInterval - не чаще чем раз в столько лог сообщает об отброшенных
+// кадрах, чтобы медленный клиент не засыпал его предупреждениями
+const dropWarnInterval = 10 * time.Second
+
+// QueuePolicy - что делать, когда клиент не успевает забирать кадры
+type QueuePolicy int
+
+const (
+	DropOldest     QueuePolicy = iota // Отбросить самый старый кадр очереди
+	DisconnectSlow                    // Отключить клиента с причиной slow_consumer
+)
+
+// ParseQueuePolicy разбирает значение флага: drop-oldest или disconnect
+func ParseQueuePolicy(s string) (QueuePolicy, error) {
+	switch s {
+	case "drop-oldest":
+		return DropOldest, nil
+	case "disconnect":
+		return DisconnectSlow, nil
+	default:
+		return 0, fmt.Errorf("unknown send queue policy %q (expected: drop-oldest, disconnect)", s)
+	}
+}
+
+// Stats - счётчики хаба для мониторинга
+type Stats struct {
+	DroppedFrames   uint64 // Кадры, отброшенные из переполненных очередей
+	SlowDisconnects uint64 // Клиенты, отключённые из-за переполнения очереди
+}
+
+// SetSendQueue даёт каждой сессии свою очередь отправки на size кадров и
+// горутину, которая пишет в соединение. Так зависший клиент не задерживает
+// рассылку остальным. Можно вызывать на работающем сервере: новые значения
+// получат следующие соединения. size 0 - кадры пишутся в соединение в
+// горутине отправителя.
+func (h *Hub) SetSendQueue(size int, policy QueuePolicy) {
+	h.mu.Lock()
+	h.sendQueue = size
+	h.queuePolicy = policy
+	h.mu.Unlock()
+}
+
+// Stats возвращает текущие значения счётчиков
+func (h *Hub) Stats() Stats {
+	return Stats{
+		DroppedFrames:   h.droppedFrames.Load(),
+		SlowDisconnects: h.slowDisconnects.Load(),
+	}
+}
+
+// attachQueue запускает очередь отправки новой сессии
+func (h *Hub) attachQueue(s *Session) {
+	h.mu.RLock()
+	size, policy := h.sendQueue, h.queuePolicy
+	h.mu.RUnlock()
+	if size <= 0 {
+		return
+	}
+	q := &sendQueue{
+		conn:   s.conn,
+		limit:  size,
+		policy: policy,
+		onDrop: func() { h.dropped(s) },
+		onFull: func() {
+			h.slowDisconnects.Add(1)
+			logging.Infof("Session %s (%s) is too slow, disconnecting\n", s.Name(), s.RemoteAddr())
+			// Не в горутине отправителя: отключение само рассылает события
+			go h.Evict(s, model.LeftSlow)
+		},
+	}
+	q.cond = sync.NewCond(&q.mu)
+	s.out = q
+	go q.run()
+}
+
+// dropped считает кадр, отброшенный из переполненной очереди s, и время от
+// времени предупреждает об этом в логе
+func (h *Hub) dropped(s *Session) {
+	total := h.droppedFrames.Add(1)
+	now := time.Now().UnixNano()
+	last := h.dropWarned.Load()
+	if now-last < int64(dropWarnInterval) || !h.dropWarned.CompareAndSwap(last, now) {
+		return
+	}
+	logging.Warnf("Send queue of %s is full, frames dropped (%d in total)\n", s.RemoteAddr(), total)
+}
+
+// sendQueue - ограниченная очередь кадров одного клиента. Кадры пишет в
+// соединение единственная горутина run, поэтому Conn.Send не вызывается
+// параллельно.
+type sendQueue struct {
+	conn   Conn
+	limit  int
+	policy QueuePolicy
+	onDrop func()
+	onFull func()
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	msgs    []model.OutgoingMessage
+	writing bool // Кадр уже взят из очереди, но ещё не записан
+	full    bool // Очередь переполнилась при политике DisconnectSlow
+	closed  bool
+}
+
+func (q *sendQueue) push(msg model.OutgoingMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrSessionClosed
+	}
+	if len(q.msgs) >= q.limit {
+		q.onDrop()
+		if q.policy == DisconnectSlow {
+			if !q.full {
+				q.full = true
+				q.onFull()
+			}
+			return nil
+		}
+		q.msgs = q.msgs[1:]
+	}
+	q.msgs = append(q.msgs, msg)
+	q.cond.Signal()
+	return nil
+}
+
+func (q *sendQueue) run() {
+	for {
+		q.mu.Lock()
+		for len(q.msgs) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		msg := q.msgs[0]
+		q.msgs = q.msgs[1:]
+		q.writing = true
+		q.mu.Unlock()
+
+		if err := q.conn.Send(msg); err != nil {
+			logging.Warnf("send to %s error: %s\n", q.conn.RemoteAddr(), err)
+		}
+
+		q.mu.Lock()
+		q.writing = false
+		q.mu.Unlock()
+	}
+}
+
+// wait ждёт, пока очередь опустеет и последний кадр будет записан
+func (q *sendQueue) wait(ctx context.Context) error {
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		q.mu.Lock()
+		idle := q.closed || (len(q.msgs) == 0 && !q.writing)
+		q.mu.Unlock()
+		if idle {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// close останавливает горутину записи; неотправленные кадры отбрасываются
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.msgs = nil
+	q.cond.Broadcast()
+}
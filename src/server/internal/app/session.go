@@ -0,0 +1,173 @@
+package app
+
+import (
+	"chat/server/internal/model"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Conn - соединение клиента, которое предоставляет транспорт.
+// Транспорт отвечает только за кодирование кадров и их доставку.
+type Conn interface {
+	Send(msg model.OutgoingMessage) error
+	Close() error
+	RemoteAddr() string
+}
+
+// Flusher - соединение с буфером исходящих кадров. Flush ждёт, пока
+// отправленное дойдёт до клиента, но не дольше ctx.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Identified - соединение, владелец которого подтверждён транспортом,
+// например клиентским TLS-сертификатом
+type Identified interface {
+	Identity() string
+}
+
+// Pinger - соединение со своим механизмом проверки связи, например
+// управляющими кадрами ping WebSocket. Ответ транспорт передаёт в Session.Touch.
+type Pinger interface {
+	Ping() error
+}
+
+// Session - подключение клиента к хабу, независимо от транспорта
+type Session struct {
+	conn   Conn
+	name   string
+	rooms  map[string]struct{} // Комнаты, в которых состоит сессия
+	closed bool
+	mu     sync.RWMutex
+
+	lastSeen atomic.Int64 // Время последнего кадра от клиента, UnixNano
+
+	token  string     // Токен возобновления, выданный при регистрации
+	missed *outbox    // Пока клиент переподключается, кадры копятся здесь
+	out    *sendQueue // Очередь отправки; nil - кадры пишутся в соединение сразу
+
+	hello bool     // Клиент прислал hello
+	caps  []string // Возможности, согласованные в hello
+	codec string   // Кодек, выбранный в hello; пустая строка - JSON
+
+	rate sessionRate // Лимиты частоты сессии, под rateLimiter.mu
+}
+
+func newSession(c Conn) *Session {
+	s := &Session{
+		conn:  c,
+		rooms: make(map[string]struct{}),
+	}
+	s.Touch()
+	return s
+}
+
+// Touch отмечает, что клиент на связи. Хаб вызывает его на каждый кадр,
+// транспорт - на ответы, которые до хаба не доходят.
+func (s *Session) Touch() {
+	s.lastSeen.Store(time.Now().UnixNano())
+}
+
+// idle возвращает, сколько клиент молчит
+func (s *Session) idle() time.Duration {
+	return time.Since(time.Unix(0, s.lastSeen.Load()))
+}
+
+// ping проверяет связь средствами транспорта, а если их нет - кадром ping
+func (s *Session) ping() error {
+	if p, ok := s.conn.(Pinger); ok {
+		return p.Ping()
+	}
+	return s.write(model.OutgoingMessage{Type: model.TypePing})
+}
+
+// Name возвращает имя, под которым зарегистрирована сессия, или пустую строку
+func (s *Session) Name() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.name
+}
+
+// isClosed сообщает, что сессия отключена: её имя уже свободно или ждёт
+// возобновления в другой сессии
+func (s *Session) isClosed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.closed
+}
+
+// InRoom сообщает, состоит ли сессия в комнате
+func (s *Session) InRoom(room string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.rooms[room]
+	return ok
+}
+
+// identity возвращает имя, подтверждённое транспортом, или пустую строку
+func (s *Session) identity() string {
+	if c, ok := s.conn.(Identified); ok {
+		return c.Identity()
+	}
+	return ""
+}
+
+// flush дожидается доставки отправленных кадров: сначала разбора очереди
+// отправки, затем буфера транспорта, если он есть
+func (s *Session) flush(ctx context.Context) error {
+	if s.out != nil {
+		if err := s.out.wait(ctx); err != nil {
+			return err
+		}
+	}
+	if f, ok := s.conn.(Flusher); ok {
+		return f.Flush(ctx)
+	}
+	return nil
+}
+
+func (s *Session) RemoteAddr() string {
+	return s.conn.RemoteAddr()
+}
+
+func (s *Session) Send(msg model.OutgoingMessage) error {
+	s.mu.RLock()
+	q := s.missed
+	s.mu.RUnlock()
+	if q != nil {
+		if next := q.push(msg); next != nil {
+			return next.Send(msg)
+		}
+		return nil
+	}
+	return s.write(msg)
+}
+
+// write передаёт кадр соединению, минуя очередь пропущенных кадров
+func (s *Session) write(msg model.OutgoingMessage) error {
+	if s.out != nil {
+		return s.out.push(msg)
+	}
+	return s.conn.Send(msg)
+}
+
+// closeConn останавливает отправку и закрывает соединение
+func (s *Session) closeConn() error {
+	if s.out != nil {
+		s.out.close()
+	}
+	return s.conn.Close()
+}
+
+func (s *Session) SendError(err error) error {
+	return s.Send(ErrorMessage(err))
+}
+
+// ReplyError отправляет ошибку в ответ на кадр клиента с меткой ref
+func (s *Session) ReplyError(ref string, err error) error {
+	msg := ErrorMessage(err)
+	msg.Ref = ref
+	return s.Send(msg)
+}
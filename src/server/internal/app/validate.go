@@ -0,0 +1,108 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxNameLength - наибольшая длина имени пользователя в символах
+const MaxNameLength = 32
+
+// DefaultMaxTextSize - наибольший размер текста сообщения в байтах
+const DefaultMaxTextSize = 4096
+
+// reservedNames - имена, которые клиент не может занять, в нижнем регистре
+var reservedNames = map[string]struct{}{
+	"server":    {},
+	"system":    {},
+	"admin":     {},
+	"root":      {},
+	"anonymous": {},
+}
+
+// ValidateName проверяет имя пользователя: от 1 до MaxNameLength букв,
+// цифр и символов _ - . и не из зарезервированных. Начинается имя с буквы
+// или цифры.
+func ValidateName(name string) error {
+	if name == "" {
+		return ErrNameEmpty
+	}
+	if err := validateIdent(name, ErrNameInvalid, ErrNameTooLong); err != nil {
+		return err
+	}
+	if _, ok := reservedNames[strings.ToLower(name)]; ok {
+		return fmt.Errorf("%w: %s", ErrNameReserved, name)
+	}
+	return nil
+}
+
+// ValidateRoom проверяет имя комнаты вида #name: после # те же символы и
+// та же длина, что у имени пользователя
+func ValidateRoom(room string) error {
+	name := strings.TrimPrefix(normalizeRoom(room), "#")
+	if name == "" {
+		return ErrRoomNameEmpty
+	}
+	return validateIdent(name, ErrRoomNameInvalid, ErrRoomNameInvalid)
+}
+
+// validateIdent проверяет непустое имя: UTF-8, не больше MaxNameLength
+// символов, буквы, цифры и _ - . не в начале
+func validateIdent(name string, invalid, tooLong error) error {
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("%w: not valid UTF-8", invalid)
+	}
+	if n := utf8.RuneCountInString(name); n > MaxNameLength {
+		return fmt.Errorf("%w: %d characters, limit %d", tooLong, n, MaxNameLength)
+	}
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+		case i > 0 && (r == '_' || r == '-' || r == '.'):
+		default:
+			return fmt.Errorf("%w: %q (allowed: letters, digits, _ - . not at the start)", invalid, r)
+		}
+	}
+	return nil
+}
+
+// ValidateText проверяет текст сообщения: не пустой, не больше max байт,
+// в UTF-8 и без управляющих символов, кроме перевода строки и табуляции
+func ValidateText(text string, max int) error {
+	if strings.TrimSpace(text) == "" {
+		return ErrTextEmpty
+	}
+	if len(text) > max {
+		return fmt.Errorf("%w: text is %d bytes, limit %d", ErrMessageTooLarge, len(text), max)
+	}
+	if !utf8.ValidString(text) {
+		return fmt.Errorf("%w: not valid UTF-8", ErrTextInvalid)
+	}
+	for _, r := range text {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return fmt.Errorf("%w: control character %U", ErrTextInvalid, r)
+		}
+	}
+	return nil
+}
+
+// SetMaxTextSize задаёт наибольший размер текста сообщения в байтах.
+// Можно вызывать на работающем сервере; 0 - DefaultMaxTextSize.
+func (h *Hub) SetMaxTextSize(size int) {
+	if size <= 0 {
+		size = DefaultMaxTextSize
+	}
+	h.mu.Lock()
+	h.maxText = size
+	h.mu.Unlock()
+}
+
+// validateText проверяет текст по действующему лимиту хаба
+func (h *Hub) validateText(text string) error {
+	h.mu.RLock()
+	max := h.maxText
+	h.mu.RUnlock()
+	return ValidateText(text, max)
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"bufio"
+	"chat/server/internal/model"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// dummyHash сравнивается с паролем неизвестного пользователя, чтобы по времени
+// ответа нельзя было понять, существует ли учётная запись
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// PasswordStore - учётные записи в файле строками "имя:bcrypt-хеш"
+// (формат htpasswd -B)
+type PasswordStore struct {
+	path   string
+	hashes map[string][]byte
+	enroll bool // Неизвестное имя регистрируется с указанным паролем
+	mu     sync.RWMutex
+}
+
+func OpenPasswordStore(path string, enroll bool) (*PasswordStore, error) {
+	p := &PasswordStore{
+		path:   path,
+		hashes: make(map[string][]byte),
+		enroll: enroll,
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) && enroll {
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open password file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, hash, ok := strings.Cut(text, ":")
+		if !ok || name == "" || hash == "" {
+			return nil, fmt.Errorf("password file %s line %d: expected name:hash", path, line)
+		}
+		p.hashes[name] = []byte(hash)
+	}
+	return p, scanner.Err()
+}
+
+func (p *PasswordStore) Authenticate(name string, creds model.Credentials) error {
+	p.mu.RLock()
+	hash, ok := p.hashes[name]
+	p.mu.RUnlock()
+
+	if !ok {
+		if p.enroll && creds.Password != "" {
+			return p.Add(name, creds.Password)
+		}
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(creds.Password))
+		return ErrInvalidCredentials
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(creds.Password)) != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// Add создаёт учётную запись и дописывает её в файл
+func (p *PasswordStore) Add(name, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, exists := p.hashes[name]; exists {
+		return fmt.Errorf("user %s already exists", name)
+	}
+
+	file, err := os.OpenFile(p.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open password file: %w", err)
+	}
+	defer file.Close()
+	if _, err := fmt.Fprintf(file, "%s:%s\n", name, hash); err != nil {
+		return fmt.Errorf("write password file: %w", err)
+	}
+
+	p.hashes[name] = hash
+	return nil
+}
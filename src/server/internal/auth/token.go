@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"bufio"
+	"chat/server/internal/model"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TokenStore - заранее выданные токены из файла строками "имя токен"
+type TokenStore struct {
+	tokens map[string]string
+}
+
+func LoadTokenFile(path string) (*TokenStore, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open token file: %w", err)
+	}
+	defer file.Close()
+
+	t := &TokenStore{tokens: make(map[string]string)}
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("token file %s line %d: expected name and token", path, line)
+		}
+		t.tokens[fields[0]] = fields[1]
+	}
+	return t, scanner.Err()
+}
+
+func (t *TokenStore) Authenticate(name string, creds model.Credentials) error {
+	token, ok := t.tokens[name]
+	if !ok || creds.Token == "" {
+		return ErrInvalidCredentials
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(creds.Token)) != 1 {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
@@ -1,22 +1,188 @@
 package cfg
 
 import (
+	"chat/config"
+	"chat/protocol"
+	"chat/rudp"
+	"chat/server/internal/app"
+	"chat/server/internal/transport/http"
 	"flag"
+	"os"
+	"strings"
+	"time"
 )
 
 type Flag struct {
-	ProtoType string
+	ProtoType string // Один или несколько протоколов через запятую: tcp,udp,http
 	IP        string
 	Port      string
+	TCPAddr   string // Адрес TCP-транспорта, по умолчанию ip:port
+	UDPAddr   string // Адрес UDP-транспорта, по умолчанию ip:port
+	HTTPAddr  string // Адрес HTTP-транспорта, по умолчанию ip:port
+
+	WSPath       string // Путь WebSocket HTTP-транспорта
+	WSBufferSize int    // Буферы чтения и записи WebSocket в байтах
+	WSOrigins    string // Origin, с которых браузер открывает WebSocket и SSE, через запятую
+
+	APIToken string // Bearer-токен REST API HTTP-транспорта, пустой - API выключен
+
+	UDPMaxMessage int // Наибольшее сообщение UDP в байтах, длинные делятся на фрагменты
+
+	ShutdownTimeout time.Duration // Сколько ждать доставки сообщений при остановке
+
+	HeartbeatInterval time.Duration // Как часто проверять связь с молчащими клиентами, 0 - не проверять
+	HeartbeatTimeout  time.Duration // Через сколько молчания клиент отключается
+
+	ResumeGrace time.Duration // Сколько держать сессию после обрыва, 0 - не держать
+	ResumeQueue int           // Сколько кадров копить для отключившейся сессии
+
+	SendQueue       int    // Очередь отправки каждого клиента в кадрах, 0 - писать сразу
+	SendQueuePolicy string // Что делать при переполнении: drop-oldest или disconnect
+
+	Codecs string // Кодеки, которые клиент может выбрать в hello, через запятую
+
+	MaxText int // Наибольший размер текста сообщения в байтах
+
+	RateMessages           string        // Сообщения одной сессии: 20/s:40, пусто - без лимита
+	RateMessagesPerIP      string        // Сообщения всех сессий одного IP
+	RateRegistrations      string        // Регистрации и возобновления одной сессии
+	RateRegistrationsPerIP string        // Регистрации и возобновления с одного IP
+	RateConnectionsPerIP   string        // Новые соединения с одного IP
+	RatePenalty            string        // Что делать при превышении: drop, warn, mute или disconnect
+	RateMute               time.Duration // Сколько длится наказание mute
+
+	LogLevel string // Уровень журнала: debug, info, warn или error
+	MOTD     string // Сообщение дня после регистрации, пустое - не отправлять
+	Rooms    string // Комнаты, которые есть всегда, через запятую
+	Bans     string // Имена, которым запрещено входить, через запятую
+
+	History     string // Хранилище истории: memory или file
+	HistoryFile string
+	HistorySize int // Сколько последних сообщений держать в памяти
+
+	Auth       string // Проверка при регистрации: none, password или token
+	AuthFile   string // Файл паролей (имя:bcrypt-хеш) или токенов (имя токен)
+	AuthEnroll bool   // Для -auth password: новое имя регистрируется с первым паролем
+
+	TLSCert       string // Сертификат и ключ сервера в PEM: включают TLS для tcp и WSS для http
+	TLSKey        string
+	TLSClientCA   string // CA клиентских сертификатов; CommonName сертификата - имя пользователя
+	TLSClientAuth bool   // Требовать клиентский сертификат, а не только проверять предъявленный
+}
+
+// Keys - ключи файла настроек сервера. Переменные окружения - CHAT_ и имя
+// флага: CHAT_HEARTBEAT_INTERVAL для -heartbeat-interval. Ключи с Reload
+// применяются по SIGHUP без перезапуска, см. Reloader.
+var Keys = []config.Key{
+	{Path: "listeners.protocols", Flag: "p", Env: "CHAT_PROTOCOLS"},
+	{Path: "listeners.ip", Flag: "ip"},
+	{Path: "listeners.port", Flag: "port"},
+	{Path: "listeners.tcp", Flag: "tcp-addr"},
+	{Path: "listeners.udp", Flag: "udp-addr"},
+	{Path: "listeners.http", Flag: "http-addr"},
+	{Path: "listeners.ws-path", Flag: "ws-path"},
+	{Path: "listeners.ws-origins", Flag: "ws-origins"},
+	{Path: "api.token", Flag: "api-token", Secret: true},
+	{Path: "limits.udp-max-message", Flag: "udp-max-message"},
+	{Path: "limits.ws-buffer-size", Flag: "ws-buffer-size"},
+	{Path: "limits.send-queue", Flag: "send-queue", Reload: true},
+	{Path: "limits.send-queue-policy", Flag: "send-queue-policy", Reload: true},
+	{Path: "limits.resume-queue", Flag: "resume-queue", Reload: true},
+	{Path: "limits.max-text", Flag: "max-text", Reload: true},
+	{Path: "limits.rate-messages", Flag: "rate-messages", Reload: true},
+	{Path: "limits.rate-messages-ip", Flag: "rate-messages-ip", Reload: true},
+	{Path: "limits.rate-registrations", Flag: "rate-registrations", Reload: true},
+	{Path: "limits.rate-registrations-ip", Flag: "rate-registrations-ip", Reload: true},
+	{Path: "limits.rate-connections-ip", Flag: "rate-connections-ip", Reload: true},
+	{Path: "limits.rate-penalty", Flag: "rate-penalty", Reload: true},
+	{Path: "limits.rate-mute", Flag: "rate-mute", Reload: true},
+	{Path: "protocol.codecs", Flag: "codecs", Reload: true},
+	{Path: "timeouts.shutdown", Flag: "shutdown-timeout"},
+	{Path: "timeouts.heartbeat-interval", Flag: "heartbeat-interval"},
+	{Path: "timeouts.heartbeat-timeout", Flag: "heartbeat-timeout"},
+	{Path: "timeouts.resume-grace", Flag: "resume-grace", Reload: true},
+	{Path: "chat.motd", Flag: "motd", Reload: true},
+	{Path: "chat.rooms", Flag: "rooms", Reload: true},
+	{Path: "moderation.bans", Flag: "bans", Reload: true},
+	{Path: "logging.level", Flag: "log-level", Reload: true},
+	{Path: "storage.history", Flag: "history"},
+	{Path: "storage.history-file", Flag: "history-file"},
+	{Path: "storage.history-size", Flag: "history-size"},
+	{Path: "auth.mode", Flag: "auth"},
+	{Path: "auth.file", Flag: "auth-file"},
+	{Path: "auth.enroll", Flag: "auth-enroll"},
+	{Path: "tls.cert", Flag: "tls-cert"},
+	{Path: "tls.key", Flag: "tls-key"},
+	{Path: "tls.client-ca", Flag: "tls-client-ca"},
+	{Path: "tls.client-auth", Flag: "tls-client-auth"},
+}
+
+// NewFlagsFromArgs читает настройки из командной строки, окружения и файла
+// -config. С -print-config выводит их и завершает программу.
+func NewFlagsFromArgs() (*Flag, error) {
+	f, printConfig, err := LoadFlags(flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	if printConfig {
+		if err := config.Print(os.Stdout, flag.CommandLine, Keys); err != nil {
+			return nil, err
+		}
+		os.Exit(0)
+	}
+	return f, nil
 }
 
-func NewFlagsFromArgs() *Flag {
+// LoadFlags разбирает args во флаги fs поверх файла настроек и переменных
+// окружения env. Второе значение - запрошен ли -print-config.
+func LoadFlags(fs *flag.FlagSet, args []string, env func(string) (string, bool)) (*Flag, bool, error) {
 	f := &Flag{}
 
-	flag.StringVar(&f.IP, "ip", "127.0.0.1", "ip address")
-	flag.StringVar(&f.Port, "port", "4545", "port")
-	flag.StringVar(&f.ProtoType, "p", "", "protocol type")
-	flag.Parse()
+	fs.StringVar(&f.IP, "ip", "127.0.0.1", "ip address")
+	fs.StringVar(&f.Port, "port", "4545", "port")
+	fs.StringVar(&f.ProtoType, "p", "", "protocol types, comma separated (tcp,udp,http)")
+	fs.StringVar(&f.TCPAddr, "tcp-addr", "", "tcp listen address (default ip:port)")
+	fs.StringVar(&f.UDPAddr, "udp-addr", "", "udp listen address (default ip:port)")
+	fs.StringVar(&f.HTTPAddr, "http-addr", "", "http listen address (default ip:port)")
+	fs.StringVar(&f.WSPath, "ws-path", http.DefaultWSPath, "websocket endpoint path of the http transport")
+	fs.StringVar(&f.WSOrigins, "ws-origins", "", "origins allowed to open websocket and sse connections besides the server host, comma separated")
+	fs.IntVar(&f.WSBufferSize, "ws-buffer-size", http.DefaultWSBufferSize, "websocket read and write buffer size in bytes")
+	fs.StringVar(&f.APIToken, "api-token", "", "bearer token for the http REST API under /api/ (disabled if empty)")
+	fs.IntVar(&f.UDPMaxMessage, "udp-max-message", rudp.DefaultMaxMessageSize, "maximum udp message size in bytes")
+	fs.DurationVar(&f.ShutdownTimeout, "shutdown-timeout", app.DefaultDrainTimeout, "how long to deliver pending messages on SIGINT/SIGTERM")
+	fs.DurationVar(&f.HeartbeatInterval, "heartbeat-interval", app.DefaultHeartbeatInterval, "ping clients idle for this long (0 disables)")
+	fs.DurationVar(&f.HeartbeatTimeout, "heartbeat-timeout", app.DefaultHeartbeatTimeout, "disconnect clients idle for this long")
+	fs.DurationVar(&f.ResumeGrace, "resume-grace", app.DefaultResumeGrace, "how long a dropped session can be resumed (0 disables)")
+	fs.IntVar(&f.ResumeQueue, "resume-queue", app.DefaultResumeQueue, "frames kept for a dropped session")
+	fs.IntVar(&f.SendQueue, "send-queue", app.DefaultSendQueue, "frames queued per client before the policy applies (0 writes synchronously)")
+	fs.StringVar(&f.SendQueuePolicy, "send-queue-policy", "drop-oldest", "full send queue policy (drop-oldest, disconnect)")
+	fs.IntVar(&f.MaxText, "max-text", app.DefaultMaxTextSize, "maximum message text size in bytes")
+	fs.StringVar(&f.RateMessages, "rate-messages", "20/s:40", "messages per session: N/s, N/m or N/h with optional :burst (off disables)")
+	fs.StringVar(&f.RateMessagesPerIP, "rate-messages-ip", "off", "messages from all sessions of one ip")
+	fs.StringVar(&f.RateRegistrations, "rate-registrations", "1/s:5", "register and resume frames per session")
+	fs.StringVar(&f.RateRegistrationsPerIP, "rate-registrations-ip", "off", "register and resume frames from one ip")
+	fs.StringVar(&f.RateConnectionsPerIP, "rate-connections-ip", "off", "new connections from one ip")
+	fs.StringVar(&f.RatePenalty, "rate-penalty", "drop", "rate limit penalty (drop, warn, mute, disconnect)")
+	fs.DurationVar(&f.RateMute, "rate-mute", app.DefaultMuteDuration, "how long -rate-penalty mute lasts")
+	fs.StringVar(&f.Codecs, "codecs", strings.Join(protocol.Codecs(), ","), "codecs clients may choose in hello, comma separated (json, json-lp, msgpack)")
+	fs.StringVar(&f.LogLevel, "log-level", "info", "log level (debug, info, warn, error)")
+	fs.StringVar(&f.MOTD, "motd", "", "message of the day sent to users after registration")
+	fs.StringVar(&f.Rooms, "rooms", "", "rooms that always exist, comma separated")
+	fs.StringVar(&f.Bans, "bans", "", "usernames that may not join, comma separated")
+	fs.StringVar(&f.History, "history", "memory", "message history store (memory, file)")
+	fs.StringVar(&f.HistoryFile, "history-file", "history.jsonl", "history file for -history file")
+	fs.IntVar(&f.HistorySize, "history-size", app.DefaultHistorySize, "number of recent messages kept in memory")
+	fs.StringVar(&f.Auth, "auth", "none", "registration check (none, password, token)")
+	fs.StringVar(&f.AuthFile, "auth-file", "", "password file (name:bcrypt-hash) or token file (name token)")
+	fs.BoolVar(&f.AuthEnroll, "auth-enroll", false, "with -auth password: unknown users are enrolled with their first password")
+	fs.StringVar(&f.TLSCert, "tls-cert", "", "server certificate (PEM), enables TLS for tcp and WSS for http")
+	fs.StringVar(&f.TLSKey, "tls-key", "", "server private key (PEM)")
+	fs.StringVar(&f.TLSClientCA, "tls-client-ca", "", "CA for client certificates; certificate CommonName must match the username")
+	fs.BoolVar(&f.TLSClientAuth, "tls-client-auth", false, "with -tls-client-ca: reject clients without a certificate")
 
-	return f
+	printConfig, err := config.Load(fs, args, Keys, env)
+	if err != nil {
+		return nil, false, err
+	}
+	return f, printConfig, nil
 }
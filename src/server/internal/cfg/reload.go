@@ -0,0 +1,243 @@
+package cfg
+
+import (
+	"chat/config"
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/logging"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runtimeSettings - настройки, которые можно менять на работающем сервере.
+// Соответствуют ключам Keys с Reload.
+type runtimeSettings struct {
+	resumeGrace time.Duration
+	resumeQueue int
+	sendQueue   int
+	policy      app.QueuePolicy
+	codecs      []string
+	maxText     int
+	rateLimits  app.RateLimits
+	logLevel    logging.Level
+	motd        string
+	rooms       []string
+	bans        []string
+}
+
+// parseRuntime проверяет настройки, которые можно менять на ходу. Ошибка
+// не оставляет следов: ничего ещё не применено.
+func parseRuntime(flags *Flag) (*runtimeSettings, error) {
+	rt := &runtimeSettings{
+		resumeGrace: flags.ResumeGrace,
+		resumeQueue: flags.ResumeQueue,
+		sendQueue:   flags.SendQueue,
+		codecs:      protocol.Codecs(),
+		maxText:     flags.MaxText,
+		logLevel:    logging.LevelInfo,
+		motd:        flags.MOTD,
+		rooms:       splitList(flags.Rooms),
+		bans:        splitList(flags.Bans),
+	}
+	if flags.ResumeGrace < 0 || flags.ResumeQueue < 0 {
+		return nil, fmt.Errorf("-resume-grace and -resume-queue must not be negative")
+	}
+	if flags.SendQueue < 0 {
+		return nil, fmt.Errorf("-send-queue must not be negative")
+	}
+	if flags.MaxText < 0 || flags.MaxText > protocol.DefaultMaxFrameSize {
+		return nil, fmt.Errorf("-max-text must be between 0 (default) and %d", protocol.DefaultMaxFrameSize)
+	}
+	for _, room := range rt.rooms {
+		if err := app.ValidateRoom(room); err != nil {
+			return nil, fmt.Errorf("-rooms: %s: %w", room, err)
+		}
+	}
+	if flags.SendQueue > 0 {
+		policy, err := app.ParseQueuePolicy(flags.SendQueuePolicy)
+		if err != nil {
+			return nil, err
+		}
+		rt.policy = policy
+	}
+	if flags.Codecs != "" {
+		codecs, err := parseCodecs(flags.Codecs)
+		if err != nil {
+			return nil, err
+		}
+		rt.codecs = codecs
+	}
+	limits, err := parseRateLimits(flags)
+	if err != nil {
+		return nil, err
+	}
+	rt.rateLimits = limits
+	if flags.LogLevel != "" {
+		level, err := logging.ParseLevel(flags.LogLevel)
+		if err != nil {
+			return nil, err
+		}
+		rt.logLevel = level
+	}
+	return rt, nil
+}
+
+func (rt *runtimeSettings) apply(hub *app.Hub) {
+	logging.SetLevel(rt.logLevel)
+	hub.SetResume(rt.resumeGrace, rt.resumeQueue)
+	hub.SetSendQueue(rt.sendQueue, rt.policy)
+	hub.SetCodecs(rt.codecs)
+	hub.SetMaxTextSize(rt.maxText)
+	hub.SetRateLimits(rt.rateLimits)
+	hub.SetMOTD(rt.motd)
+	hub.SetRooms(rt.rooms)
+	hub.SetBans(rt.bans)
+}
+
+// parseRateLimits разбирает флаги -rate-*
+func parseRateLimits(flags *Flag) (app.RateLimits, error) {
+	var limits app.RateLimits
+	rates := []struct {
+		flag  string
+		value string
+		rate  *app.Rate
+	}{
+		{"rate-messages", flags.RateMessages, &limits.Messages},
+		{"rate-messages-ip", flags.RateMessagesPerIP, &limits.MessagesPerIP},
+		{"rate-registrations", flags.RateRegistrations, &limits.Registrations},
+		{"rate-registrations-ip", flags.RateRegistrationsPerIP, &limits.RegistrationsPerIP},
+		{"rate-connections-ip", flags.RateConnectionsPerIP, &limits.ConnectionsPerIP},
+	}
+	for _, r := range rates {
+		rate, err := app.ParseRate(r.value)
+		if err != nil {
+			return limits, fmt.Errorf("-%s: %w", r.flag, err)
+		}
+		*r.rate = rate
+	}
+	if flags.RatePenalty != "" {
+		penalty, err := app.ParsePenalty(flags.RatePenalty)
+		if err != nil {
+			return limits, err
+		}
+		limits.Penalty = penalty
+	}
+	if flags.RateMute < 0 {
+		return limits, fmt.Errorf("-rate-mute must not be negative")
+	}
+	limits.MuteFor = flags.RateMute
+	return limits, nil
+}
+
+// splitList разбирает список через запятую, пропуская пустые элементы
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ReloadReport - что изменилось при перечитывании настроек, ключами файла
+// настроек
+type ReloadReport struct {
+	Applied []string // Применено на работающем сервере
+	Restart []string // Вступит в силу только после перезапуска
+}
+
+func (r ReloadReport) String() string {
+	if len(r.Applied) == 0 && len(r.Restart) == 0 {
+		return "no changes"
+	}
+	var parts []string
+	if len(r.Applied) > 0 {
+		parts = append(parts, "applied "+strings.Join(r.Applied, ", "))
+	}
+	if len(r.Restart) > 0 {
+		parts = append(parts, "restart required for "+strings.Join(r.Restart, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Reloader перечитывает настройки работающего сервера: файл -config,
+// окружение и те же аргументы командной строки, что при запуске
+type Reloader struct {
+	hub  *app.Hub
+	args []string
+	env  func(string) (string, bool)
+
+	mu     sync.Mutex
+	values map[string]string // Действующие значения флагов сервера
+}
+
+// NewReloader запоминает действующие настройки сервера из fs, в который
+// они были прочитаны из args и env
+func NewReloader(server *app.ChatServer, fs *flag.FlagSet, args []string, env func(string) (string, bool)) *Reloader {
+	return &Reloader{
+		hub:    server.Hub(),
+		args:   args,
+		env:    env,
+		values: config.Values(fs, Keys),
+	}
+}
+
+// Reload читает настройки заново и применяет изменения, безопасные на
+// ходу: лимиты, кодеки, баны, MOTD, уровень журнала и комнаты. Сессии при
+// этом не разрываются. Остальные изменения только попадают в отчёт и
+// продолжают попадать в него до перезапуска. Если в новых настройках
+// ошибка, не применяется ничего.
+func (r *Reloader) Reload() (ReloadReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	flags, _, err := LoadFlags(fs, r.args, r.env)
+	if err != nil {
+		return ReloadReport{}, err
+	}
+	rt, err := parseRuntime(flags)
+	if err != nil {
+		return ReloadReport{}, err
+	}
+
+	var report ReloadReport
+	values := config.Values(fs, Keys)
+	for _, k := range Keys {
+		if values[k.Flag] == r.values[k.Flag] {
+			continue
+		}
+		if k.Reload {
+			report.Applied = append(report.Applied, k.Path)
+			r.values[k.Flag] = values[k.Flag]
+		} else {
+			report.Restart = append(report.Restart, k.Path)
+		}
+	}
+	rt.apply(r.hub)
+	return report, nil
+}
+
+// Watch перечитывает настройки на каждый сигнал из signals и пишет итог
+// в журнал. Возвращается, когда signals закрыт.
+func (r *Reloader) Watch(signals <-chan os.Signal) {
+	for range signals {
+		report, err := r.Reload()
+		if err != nil {
+			logging.Errorf("Configuration reload failed, keeping current settings: %s\n", err)
+			continue
+		}
+		if len(report.Restart) > 0 {
+			logging.Warnf("Configuration reloaded: %s\n", report)
+		} else {
+			logging.Infof("Configuration reloaded: %s\n", report)
+		}
+	}
+}
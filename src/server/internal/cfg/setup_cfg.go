@@ -1,47 +1,226 @@
 package cfg
 
 import (
+	"chat/protocol"
 	"chat/server/internal/app"
+	"chat/server/internal/auth"
+	"chat/server/internal/logging"
+	"chat/server/internal/store"
 	"chat/server/internal/transport/http"
 	"chat/server/internal/transport/tcp"
 	"chat/server/internal/transport/udp"
+	"flag"
 	"fmt"
 	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 )
 
-func Setup() (*app.ChatServer, error) {
-	flags := NewFlagsFromArgs()
+// Setup собирает сервер по настройкам программы. Reloader применяет
+// изменения настроек к работающему серверу.
+func Setup() (*app.ChatServer, *Reloader, error) {
+	flags, err := NewFlagsFromArgs()
+	if err != nil {
+		return nil, nil, err
+	}
+	server, err := NewServer(flags)
+	if err != nil {
+		return nil, nil, err
+	}
+	return server, NewReloader(server, flag.CommandLine, os.Args[1:], os.LookupEnv), nil
+}
+
+// NewServer собирает сервер со всеми перечисленными во флаге -p транспортами.
+// Все транспорты подключаются к общему хабу сервера.
+func NewServer(flags *Flag) (*app.ChatServer, error) {
+	if err := validatePort(flags.Port); err != nil {
+		return nil, err
+	}
 	address := net.JoinHostPort(flags.IP, flags.Port)
+	server := app.NewChatServer()
+	if flags.ShutdownTimeout > 0 {
+		server.SetDrainTimeout(flags.ShutdownTimeout)
+	}
 
-	switch flags.ProtoType {
-	case "tcp":
-		return setupTCP(address), nil
+	if flags.HeartbeatInterval < 0 || flags.HeartbeatTimeout < 0 {
+		return nil, fmt.Errorf("heartbeat interval and timeout must not be negative")
+	}
+	if flags.HeartbeatInterval > 0 && flags.HeartbeatTimeout < flags.HeartbeatInterval {
+		return nil, fmt.Errorf("-heartbeat-timeout must not be shorter than -heartbeat-interval")
+	}
+	server.SetHeartbeat(flags.HeartbeatInterval, flags.HeartbeatTimeout)
+	rt, err := parseRuntime(flags)
+	if err != nil {
+		return nil, err
+	}
+	rt.apply(server.Hub())
 
-	case "udp":
-		return setupUDP(address), nil
+	tlsConfig, err := newTLSConfig(flags)
+	if err != nil {
+		return nil, err
+	}
 
-	case "http":
-		return setupHTTP(address), nil
+	// TCP и HTTP не могут слушать один и тот же порт
+	streamAddrs := make(map[string]string)
+	seen := make(map[string]bool)
 
-	default:
-		return nil, fmt.Errorf("unsupported protocol type: %s (expected: tcp, udp, http)", flags.ProtoType)
+	for _, proto := range strings.Split(flags.ProtoType, ",") {
+		proto = strings.TrimSpace(proto)
+		if seen[proto] {
+			return nil, fmt.Errorf("protocol %s specified more than once", proto)
+		}
+		seen[proto] = true
+
+		switch proto {
+		case "tcp":
+			addr := addressOr(flags.TCPAddr, address)
+			if other, ok := streamAddrs[addr]; ok {
+				return nil, fmt.Errorf("tcp and %s cannot share address %s", other, addr)
+			}
+			streamAddrs[addr] = proto
+			tr := tcp.NewTCPTransport(server.Hub())
+			if tlsConfig != nil {
+				tr.SetTLSConfig(tlsConfig)
+			}
+			server.AddTransport(tr, addr)
+
+		case "udp":
+			// У UDP нет TLS: он работает без шифрования рядом с TLS-транспортами,
+			// но не там, где сертификат клиента обязателен
+			if tlsConfig != nil && flags.TLSClientAuth {
+				return nil, fmt.Errorf("udp cannot be used with -tls-client-auth: udp clients have no certificates")
+			}
+			if tlsConfig != nil {
+				logging.Warnf("TLS is not supported for udp, udp listener is unencrypted\n")
+			}
+			if flags.UDPMaxMessage < 0 {
+				return nil, fmt.Errorf("-udp-max-message must be positive")
+			}
+			tr := udp.NewUDPTransport(server.Hub())
+			tr.SetMaxMessageSize(flags.UDPMaxMessage)
+			server.AddTransport(tr, addressOr(flags.UDPAddr, address))
+
+		case "http":
+			addr := addressOr(flags.HTTPAddr, address)
+			if other, ok := streamAddrs[addr]; ok {
+				return nil, fmt.Errorf("http and %s cannot share address %s", other, addr)
+			}
+			streamAddrs[addr] = proto
+			tr := http.NewHTTPTransport(server.Hub())
+			if tlsConfig != nil {
+				tr.SetTLSConfig(tlsConfig)
+			}
+			tr.SetAPIToken(flags.APIToken)
+			if flags.WSPath != "" {
+				if err := http.ValidateWSPath(flags.WSPath); err != nil {
+					return nil, err
+				}
+				tr.SetWSPath(flags.WSPath)
+			}
+			tr.SetAllowedOrigins(splitList(flags.WSOrigins))
+			if flags.WSBufferSize < 0 {
+				return nil, fmt.Errorf("-ws-buffer-size must not be negative")
+			}
+			if flags.WSBufferSize > 0 {
+				tr.SetWSBufferSize(flags.WSBufferSize)
+			}
+			server.AddTransport(tr, addr)
+
+		default:
+			return nil, fmt.Errorf("unsupported protocol type: %s (expected: tcp, udp, http)", proto)
+		}
+	}
+
+	if flags.APIToken != "" && !seen["http"] {
+		return nil, fmt.Errorf("-api-token requires the http protocol")
+	}
+
+	authenticator, err := newAuthenticator(flags)
+	if err != nil {
+		return nil, err
 	}
+	if authenticator != nil {
+		server.Hub().SetAuthenticator(authenticator)
+	}
+
+	st, err := newStore(flags)
+	if err != nil {
+		return nil, err
+	}
+	server.Hub().SetStore(st)
+
+	return server, nil
+}
+
+// validatePort проверяет номер порта из -port. Пустой порт допустим, если
+// у каждого транспорта свой адрес.
+func validatePort(port string) error {
+	if port == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q: expected a number from 0 to 65535", port)
+	}
+	return nil
+}
+
+func addressOr(addr, fallback string) string {
+	if addr != "" {
+		return addr
+	}
+	return fallback
 }
 
-func setupTCP(address string) *app.ChatServer {
-	t := tcp.NewTCPTransport()
-	server := app.NewChatServer(t, address)
-	return server
+// parseCodecs разбирает список кодеков из флага -codecs. JSON разрешён
+// всегда: с него начинается любое соединение.
+func parseCodecs(list string) ([]string, error) {
+	codecs := []string{protocol.CodecJSON}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if _, err := protocol.CodecByName(name); err != nil {
+			return nil, err
+		}
+		if !slices.Contains(codecs, name) {
+			codecs = append(codecs, name)
+		}
+	}
+	return codecs, nil
 }
 
-func setupUDP(address string) *app.ChatServer {
-	u := udp.NewUDPTransport()
-	server := app.NewChatServer(u, address)
-	return server
+func newStore(flags *Flag) (app.MessageStore, error) {
+	size := flags.HistorySize
+	if size <= 0 {
+		size = app.DefaultHistorySize
+	}
+
+	switch flags.History {
+	case "", "memory":
+		return store.NewMemoryStore(size), nil
+	case "file":
+		return store.OpenFileStore(flags.HistoryFile, size)
+	default:
+		return nil, fmt.Errorf("unsupported history store: %s (expected: memory, file)", flags.History)
+	}
 }
 
-func setupHTTP(address string) *app.ChatServer {
-	h := http.NewHTTPTransport()
-	server := app.NewChatServer(h, address)
-	return server
+func newAuthenticator(flags *Flag) (app.Authenticator, error) {
+	switch flags.Auth {
+	case "", "none":
+		return nil, nil
+	case "password":
+		if flags.AuthFile == "" {
+			return nil, fmt.Errorf("-auth password requires -auth-file")
+		}
+		return auth.OpenPasswordStore(flags.AuthFile, flags.AuthEnroll)
+	case "token":
+		if flags.AuthFile == "" {
+			return nil, fmt.Errorf("-auth token requires -auth-file")
+		}
+		return auth.LoadTokenFile(flags.AuthFile)
+	default:
+		return nil, fmt.Errorf("unsupported auth mode: %s (expected: none, password, token)", flags.Auth)
+	}
 }
@@ -0,0 +1,57 @@
+package cfg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// newTLSConfig собирает настройки TLS из флагов; nil, если TLS не включён
+func newTLSConfig(flags *Flag) (*tls.Config, error) {
+	if flags.TLSCert == "" && flags.TLSKey == "" {
+		if flags.TLSClientCA != "" {
+			return nil, fmt.Errorf("-tls-client-ca requires -tls-cert and -tls-key")
+		}
+		return nil, nil
+	}
+	if flags.TLSCert == "" || flags.TLSKey == "" {
+		return nil, fmt.Errorf("-tls-cert and -tls-key must be set together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(flags.TLSCert, flags.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("load tls certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if flags.TLSClientCA != "" {
+		pool, err := loadCertPool(flags.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if flags.TLSClientAuth {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if flags.TLSClientAuth {
+		return nil, fmt.Errorf("-tls-client-auth requires -tls-client-ca")
+	}
+	return config, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read ca file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("ca file %s contains no certificates", path)
+	}
+	return pool, nil
+}
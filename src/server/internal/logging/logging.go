@@ -0,0 +1,66 @@
+// Package logging - журнал сервера с уровнями поверх стандартного log.
+// Уровень можно менять на работающем сервере.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// Level - наименьшая важность записей, которые попадают в журнал
+type Level int32
+
+const (
+	LevelDebug Level = iota // Каждый принятый кадр
+	LevelInfo               // Подключения, регистрация, комнаты
+	LevelWarn               // Отказы клиентам и ошибки отправки
+	LevelError              // Ошибки сервера: история, токены, страницы
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+var current atomic.Int32
+
+func init() {
+	current.Store(int32(LevelInfo))
+}
+
+// ParseLevel разбирает значение флага -log-level
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if s == name {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q (expected: debug, info, warn, error)", s)
+}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return fmt.Sprintf("Level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// SetLevel задаёт уровень журнала; безопасно на работающем сервере
+func SetLevel(l Level) {
+	current.Store(int32(l))
+}
+
+// CurrentLevel возвращает уровень журнала
+func CurrentLevel() Level {
+	return Level(current.Load())
+}
+
+func Debugf(format string, args ...any) { logf(LevelDebug, format, args...) }
+func Infof(format string, args ...any)  { logf(LevelInfo, format, args...) }
+func Warnf(format string, args ...any)  { logf(LevelWarn, format, args...) }
+func Errorf(format string, args ...any) { logf(LevelError, format, args...) }
+
+func logf(l Level, format string, args ...any) {
+	if l < CurrentLevel() {
+		return
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,35 @@
+package model
+
+import "time"
+
+// StoredMessage - сообщение в истории чата. ID и время назначает сервер.
+type StoredMessage struct {
+	ID   uint64
+	Time time.Time
+	Type string // TypeBroadcast или TypeWhisper
+	From string
+	To   string // Получатель приватного сообщения
+	Room string
+	Text string
+}
+
+// HistoryQuery - выборка из истории: сообщения комнаты, личная переписка
+// User и Peer или, если оба поля пусты, общий чат
+type HistoryQuery struct {
+	Room  string
+	User  string
+	Peer  string
+	Limit int
+}
+
+func (q HistoryQuery) Match(m StoredMessage) bool {
+	switch {
+	case q.Room != "":
+		return m.Type == TypeBroadcast && m.Room == q.Room
+	case q.Peer != "":
+		return m.Type == TypeWhisper &&
+			(m.From == q.User && m.To == q.Peer || m.From == q.Peer && m.To == q.User)
+	default:
+		return m.Type == TypeBroadcast && m.Room == ""
+	}
+}
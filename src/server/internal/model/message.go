@@ -1,15 +1,99 @@
 package model
 
+import "chat/protocol"
+
+// Типы сообщений протокола
+const (
+	TypeHello      = protocol.TypeHello
+	TypeRegister   = protocol.TypeRegister
+	TypeRegistered = protocol.TypeRegistered
+	TypeBroadcast  = protocol.TypeBroadcast
+	TypeWhisper    = protocol.TypeWhisper
+	TypeExit       = protocol.TypeExit
+	TypeError      = protocol.TypeError
+	TypeJoin       = protocol.TypeJoin
+	TypeLeave      = protocol.TypeLeave
+	TypeRooms      = protocol.TypeRooms
+	TypeHistory    = protocol.TypeHistory
+	TypeAuthFailed = protocol.TypeAuthFailed
+	TypeUserJoined = protocol.TypeUserJoined
+	TypeUserLeft   = protocol.TypeUserLeft
+	TypeWho        = protocol.TypeWho
+	TypePing       = protocol.TypePing
+	TypePong       = protocol.TypePong
+	TypeSession    = protocol.TypeSession
+	TypeResume     = protocol.TypeResume
+	TypeResumed    = protocol.TypeResumed
+	TypeMOTD       = protocol.TypeMOTD
+
+	TypeMessageTooLarge = protocol.TypeMessageTooLarge
+	TypeServerShutdown  = protocol.TypeServerShutdown
+	TypeRateLimited     = protocol.TypeRateLimited
+	TypeInvalidInput    = protocol.TypeInvalidInput
+)
+
+// Причины ухода пользователя в событии user_left
+const (
+	LeftExit       = "exit"          // Клиент отправил exit
+	LeftDisconnect = "disconnect"    // Соединение закрылось
+	LeftTimeout    = "timeout"       // Клиент перестал отвечать
+	LeftSlow       = "slow_consumer" // Клиент не успевал забирать сообщения
+	LeftBanned     = "banned"        // Имя попало в список запрещённых
+	LeftRateLimit  = "rate_limited"  // Клиент превысил лимит частоты
+)
+
+// TimeLayout - формат времени сообщений в протоколе
+const TimeLayout = protocol.TimeLayout
+
 // IncomingMessage - входящее сообщение от клиента
 type IncomingMessage struct {
-	From string
-	Text string
+	Type  string
+	Ref   string // Метка запроса, которую нужно повторить в ошибке
+	From  string // Имя, указанное клиентом в кадре
+	To    string // Получатель приватного сообщения
+	Room  string // Комната для broadcast, join, leave и history
+	Text  string
+	Time  string
+	Limit int // Количество сообщений в запросе history
+
+	Versions     []int    // Версии протокола, предложенные в hello
+	Capabilities []string // Возможности клиента из hello
+	Codecs       []string // Кодеки, которые клиент просит в hello, по предпочтению
+
+	Credentials Credentials // Пароль или токен в запросе register, токен сессии в resume
+}
+
+// Credentials - данные для проверки имени при регистрации
+type Credentials struct {
+	Password string
+	Token    string
 }
 
 // OutgoingMessage - исходящее сообщение для клиента (бизнес-модель)
 type OutgoingMessage struct {
+	ID      uint64 // Номер сообщения в истории, 0 для служебных сообщений
+	Type    string
 	Name    string
 	Text    string
 	Time    string
+	Dst     string
+	Room    string
+	Rooms   []string // Список комнат в ответе на rooms
+	Users   []string // Список пользователей в ответе на who
+	Reason  string   // Причина ухода в user_left
+	Code    string   // Код ошибки для кадров ошибок
+	Ref     string   // Метка запроса клиента, вызвавшего ошибку
+	Token   string   // Токен возобновления в session
 	Private bool
+	History bool // Сообщение из истории, а не новое
+
+	Version      int      // Версия протокола, выбранная в ответе на hello
+	Capabilities []string // Общие возможности в ответе на hello
+	Codec        string   // Кодек, на который соединение переходит после ответа на hello
+}
+
+// IsError сообщает, что сообщение описывает ошибку
+func (m OutgoingMessage) IsError() bool {
+	return m.Type == TypeError || m.Type == TypeAuthFailed || m.Type == TypeMessageTooLarge ||
+		m.Type == TypeRateLimited || m.Type == TypeInvalidInput
 }
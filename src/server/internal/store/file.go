@@ -0,0 +1,167 @@
+package store
+
+import (
+	"bufio"
+	"chat/protocol"
+	"chat/server/internal/logging"
+	"chat/server/internal/model"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// record - формат строки в файле истории
+type record struct {
+	ID   uint64    `json:"id"`
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	From string    `json:"from"`
+	To   string    `json:"to,omitempty"`
+	Room string    `json:"room,omitempty"`
+	Text string    `json:"text"`
+}
+
+// FileStore - история в файле: каждое сообщение дописывается строкой JSON,
+// последние capacity сообщений держатся в памяти для быстрых выборок
+type FileStore struct {
+	file   *os.File
+	recent *MemoryStore
+	mu     sync.Mutex
+}
+
+// OpenFileStore открывает или создаёт файл истории и загружает из него
+// последние capacity сообщений
+func OpenFileStore(path string, capacity int) (*FileStore, error) {
+	recent := NewMemoryStore(capacity)
+
+	if err := load(path, recent); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open history file: %w", err)
+	}
+
+	return &FileStore{
+		file:   file,
+		recent: recent,
+	}, nil
+}
+
+// maxRecordSize - наибольшая строка файла истории. Текст сообщения не
+// длиннее кадра (-max-text не больше protocol.DefaultMaxFrameSize), в JSON
+// байт текста занимает до 6 байт (\u00XX), остальные поля - не больше
+// recordOverhead.
+const (
+	recordOverhead = 4096
+	maxRecordSize  = 6*protocol.DefaultMaxFrameSize + recordOverhead
+)
+
+// load загружает историю. Повреждённые строки пропускаются с
+// предупреждением, а недописанная последняя строка (сервер упал посреди
+// записи) отрезается, чтобы следующая запись не склеилась с ней.
+func load(path string, recent *MemoryStore) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open history file: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	var offset int64 // Конец последней целой строки
+	for line := 1; ; line++ {
+		data, size, err := readLine(reader, maxRecordSize)
+		if err == io.EOF {
+			if size > 0 {
+				logging.Warnf("History file %s line %d is incomplete, dropped\n", path, line)
+				return truncate(path, offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read history file: %w", err)
+		}
+		offset += int64(size)
+		if data == nil {
+			logging.Warnf("History file %s line %d is longer than %d bytes, skipped\n", path, line, maxRecordSize)
+			continue
+		}
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			logging.Warnf("History file %s line %d skipped: %s\n", path, line, err)
+			continue
+		}
+		recent.push(model.StoredMessage(r))
+	}
+}
+
+// readLine читает строку до '\n' и возвращает её без перевода строки и
+// сколько байт файла она заняла. Строка длиннее limit дочитывается, но не
+// сохраняется: data равно nil. io.EOF с size > 0 - строка без '\n' в конце.
+func readLine(reader *bufio.Reader, limit int) (data []byte, size int, err error) {
+	data = []byte{}
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		size += len(chunk)
+		if data != nil && len(data)+len(chunk) <= limit+1 {
+			data = append(data, chunk...)
+		} else {
+			data = nil
+		}
+		switch err {
+		case bufio.ErrBufferFull:
+			continue
+		case nil:
+			if data != nil {
+				data = data[:len(data)-1]
+			}
+			return data, size, nil
+		default:
+			return data, size, err
+		}
+	}
+}
+
+// truncate отрезает файл истории до size байт
+func truncate(path string, size int64) error {
+	if err := os.Truncate(path, size); err != nil {
+		return fmt.Errorf("truncate history file: %w", err)
+	}
+	return nil
+}
+
+func (f *FileStore) Save(msg model.StoredMessage) (model.StoredMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	saved, err := f.recent.Save(msg)
+	if err != nil {
+		return saved, err
+	}
+
+	data, err := json.Marshal(record(saved))
+	if err != nil {
+		return saved, err
+	}
+	if _, err := f.file.Write(append(data, '\n')); err != nil {
+		return saved, fmt.Errorf("write history file: %w", err)
+	}
+	return saved, nil
+}
+
+func (f *FileStore) Last(q model.HistoryQuery) ([]model.StoredMessage, error) {
+	return f.recent.Last(q)
+}
+
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
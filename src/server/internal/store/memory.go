@@ -0,0 +1,77 @@
+package store
+
+import (
+	"chat/server/internal/model"
+	"sync"
+	"time"
+)
+
+// MemoryStore - история в кольцевом буфере: при переполнении
+// вытесняются самые старые сообщения
+type MemoryStore struct {
+	messages []model.StoredMessage
+	start    int // Индекс самого старого сообщения
+	count    int
+	lastID   uint64
+	mu       sync.RWMutex
+}
+
+func NewMemoryStore(capacity int) *MemoryStore {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &MemoryStore{
+		messages: make([]model.StoredMessage, capacity),
+	}
+}
+
+// Save назначает сообщению ID и время и сохраняет его
+func (m *MemoryStore) Save(msg model.StoredMessage) (model.StoredMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastID++
+	msg.ID = m.lastID
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	m.push(msg)
+	return msg, nil
+}
+
+// Last возвращает последние сообщения выборки от старых к новым
+func (m *MemoryStore) Last(q model.HistoryQuery) ([]model.StoredMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var res []model.StoredMessage
+	for i := m.count - 1; i >= 0 && len(res) < q.Limit; i-- {
+		msg := m.messages[(m.start+i)%len(m.messages)]
+		if q.Match(msg) {
+			res = append(res, msg)
+		}
+	}
+
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return res, nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// push добавляет уже пронумерованное сообщение, вызывается под m.mu
+func (m *MemoryStore) push(msg model.StoredMessage) {
+	if msg.ID > m.lastID {
+		m.lastID = msg.ID
+	}
+	if m.count < len(m.messages) {
+		m.messages[(m.start+m.count)%len(m.messages)] = msg
+		m.count++
+		return
+	}
+	m.messages[m.start] = msg
+	m.start = (m.start + 1) % len(m.messages)
+}
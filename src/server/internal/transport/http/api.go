@@ -0,0 +1,161 @@
+package http
+
+import (
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	"chat/server/internal/transport/wire"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxAPIBody - наибольшее тело запроса REST API
+const maxAPIBody = 64 << 10
+
+// apiMessage - тело POST /api/messages. С dst сообщение уходит лично
+// получателю, иначе всем или участникам room.
+type apiMessage struct {
+	Name string `json:"name"`
+	Text string `json:"text"`
+	Dst  string `json:"dst,omitempty"`
+	Room string `json:"room,omitempty"`
+}
+
+// apiError - ответ с ошибкой. Code - код протокола, если ошибка из его
+// каталога.
+type apiError struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// health - ответ GET /api/health со счётчиками очередей отправки
+type health struct {
+	Status          string `json:"status"`
+	DroppedFrames   uint64 `json:"dropped_frames"`   // Кадры, отброшенные из переполненных очередей
+	SlowDisconnects uint64 `json:"slow_disconnects"` // Клиенты, отключённые из-за переполнения очереди
+}
+
+// errorBody описывает ошибку хаба
+func errorBody(err error) apiError {
+	return apiError{Error: err.Error(), Code: app.ErrorCode(err)}
+}
+
+// registerAPI добавляет в mux REST API. Без токена работает только
+// /api/health: остальные запросы нечем проверить.
+func (h *Transport) registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/health", h.handleHealth)
+	if h.apiToken == "" {
+		return
+	}
+	mux.HandleFunc("POST /api/messages", h.authorized(h.handlePostMessage))
+	mux.HandleFunc("GET /api/users", h.authorized(h.handleUsers))
+	mux.HandleFunc("GET /api/history", h.authorized(h.handleHistory))
+}
+
+// authorized пропускает запросы с заголовком Authorization: Bearer <токен>
+func (h *Transport) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.apiToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="chat"`)
+			writeJSON(w, http.StatusUnauthorized, apiError{Error: "invalid or missing bearer token", Code: protocol.CodeAuthFailed})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (h *Transport) handleHealth(w http.ResponseWriter, r *http.Request) {
+	stats := h.hub.Stats()
+	body := health{Status: "ok", DroppedFrames: stats.DroppedFrames, SlowDisconnects: stats.SlowDisconnects}
+	if h.hub.Closing() {
+		body.Status = "shutting_down"
+		writeJSON(w, http.StatusServiceUnavailable, body)
+		return
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (h *Transport) handlePostMessage(w http.ResponseWriter, r *http.Request) {
+	var body apiMessage
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: app.ErrInvalidFrame.Error() + ": " + err.Error(), Code: protocol.CodeBadFrame})
+		return
+	}
+
+	msg := model.IncomingMessage{
+		Type: model.TypeBroadcast,
+		From: body.Name,
+		To:   body.Dst,
+		Room: body.Room,
+		Text: body.Text,
+	}
+	if body.Dst != "" {
+		msg.Type = model.TypeWhisper
+	}
+	out, err := h.hub.Post(r.RemoteAddr, msg)
+	if err != nil {
+		writeJSON(w, apiStatus(err), errorBody(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, wire.Outgoing(out))
+}
+
+func (h *Transport) handleUsers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string][]string{"users": h.hub.Users()})
+}
+
+// handleHistory отдаёт историю общего чата или комнаты из параметра room.
+// Личную переписку API не отдаёт: у запроса нет пользователя.
+func (h *Transport) handleHistory(w http.ResponseWriter, r *http.Request) {
+	q := model.HistoryQuery{Room: r.URL.Query().Get("room")}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "limit must be a positive number", Code: protocol.CodeBadFrame})
+			return
+		}
+		q.Limit = n
+	}
+
+	messages, err := h.hub.Messages(q)
+	if err != nil {
+		writeJSON(w, apiStatus(err), errorBody(err))
+		return
+	}
+	frames := make([]protocol.Envelope, 0, len(messages))
+	for _, msg := range messages {
+		frames = append(frames, wire.Outgoing(msg))
+	}
+	writeJSON(w, http.StatusOK, map[string][]protocol.Envelope{"messages": frames})
+}
+
+// apiStatus подбирает HTTP-статус для ошибки хаба
+func apiStatus(err error) int {
+	switch {
+	case errors.Is(err, app.ErrNameTaken):
+		return http.StatusConflict
+	case errors.Is(err, app.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, app.ErrMessageTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, app.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, app.ErrServerShutdown), errors.Is(err, app.ErrHistoryUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
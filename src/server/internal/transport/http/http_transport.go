@@ -1,210 +1,335 @@
 package http
 
 import (
-	"chat/server/internal/dto"
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/logging"
 	"chat/server/internal/model"
-	"chat/server/utils"
-	"encoding/json"
+	"chat/server/internal/transport/wire"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/fs"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
 	"sync"
 
 	"github.com/gorilla/websocket"
 )
 
-type RawMessage struct {
-	Conn *websocket.Conn
-	Data []byte
-}
 type Transport struct {
-	clients       map[*websocket.Conn]bool
-	clientsByName map[string]*websocket.Conn
-	quit          chan struct{}
-	mu            sync.RWMutex
-}
+	hub    *app.Hub
+	server *http.Server
+	tls    *tls.Config
+	quit   chan struct{}
+
+	apiToken string   // Bearer-токен REST API, пустой - API выключен
+	origins  []string // Разрешённые Origin кроме своего хоста, см. checkOrigin
+	wsPath   string
+	upgrader websocket.Upgrader
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+	ln       net.Listener
+	draining bool                   // Вызван Shutdown: слушатель закрыт намеренно
+	streams  map[string]*streamConn // Соединения SSE и long-poll по id
+	once     sync.Once
+	mu       sync.Mutex
 }
 
-func NewHTTPTransport() *Transport {
-	return &Transport{
-		clients:       make(map[*websocket.Conn]bool),
-		clientsByName: make(map[string]*websocket.Conn),
-		quit:          make(chan struct{}),
+const (
+	DefaultWSPath       = "/ws"
+	DefaultWSBufferSize = 1024 // Байт в буферах чтения и записи WebSocket
+
+	// maxWSMessage - сообщение WebSocket, после которого соединение
+	// закрывается с кодом 1009. Сообщения до этого размера, но больше
+	// кадра протокола пропускаются с ошибкой message_too_large, как в TCP.
+	maxWSMessage = 16 * protocol.DefaultMaxFrameSize
+)
+
+func NewHTTPTransport(hub *app.Hub) *Transport {
+	h := &Transport{
+		hub:     hub,
+		quit:    make(chan struct{}),
+		streams: make(map[string]*streamConn),
+		wsPath:  DefaultWSPath,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  DefaultWSBufferSize,
+			WriteBufferSize: DefaultWSBufferSize,
+		},
 	}
+	h.upgrader.CheckOrigin = h.checkOrigin
+	return h
 }
 
-func (h *Transport) Start(address string) error {
-	http.HandleFunc("/ws", h.handleConnections)
-	log.Println("http server started on ", address)
-	err := http.ListenAndServe(address, nil)
-	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+// ValidateWSPath проверяет путь для SetWSPath: он должен быть абсолютным
+// и не совпадать с другими путями транспорта
+func ValidateWSPath(path string) error {
+	if !strings.HasPrefix(path, "/") || path == "/" || strings.ContainsAny(path, " {}") {
+		return fmt.Errorf("invalid websocket path %q: expected an absolute path like %s", path, DefaultWSPath)
+	}
+	reserved := []string{"/events", "/send", "/poll"}
+	if entries, err := fs.ReadDir(webFiles, "web"); err == nil {
+		for _, e := range entries {
+			reserved = append(reserved, "/"+e.Name())
+		}
+	}
+	if slices.Contains(reserved, path) || strings.HasPrefix(path, "/api/") {
+		return fmt.Errorf("websocket path %s is already used by the http transport", path)
 	}
 	return nil
 }
 
-func (h *Transport) Stop() error {
-	close(h.quit)
-	return nil
+// SetWSPath задаёт путь WebSocket, по умолчанию DefaultWSPath. Вызывается
+// до Start.
+func (h *Transport) SetWSPath(path string) {
+	h.wsPath = path
 }
 
-func (h *Transport) BroadcastMessage(msg model.IncomingMessage) error {
+// SetWSBufferSize задаёт размер буферов чтения и записи WebSocket.
+// Вызывается до Start.
+func (h *Transport) SetWSBufferSize(size int) {
+	h.upgrader.ReadBufferSize = size
+	h.upgrader.WriteBufferSize = size
+}
 
-	var dtoMsg dto.HTTPMessageDTO
-	err := utils.JsonToStruct(msg.Text, &dtoMsg)
-	if err != nil {
-		return fmt.Errorf("send message error: %v", err)
-	}
+// SetTLSConfig включает HTTPS и WSS. Вызывается до Start.
+func (h *Transport) SetTLSConfig(cfg *tls.Config) {
+	h.tls = cfg
+}
 
-	outgoingMsg := model.HTTPMessage{
-		Name: dtoMsg.Name,
-		Text: dtoMsg.Text,
-		Time: dtoMsg.Time,
-	}
+// SetAllowedOrigins задаёт Origin вида https://chat.example.com, с
+// которых браузер может открыть WebSocket и SSE. Со списком проверяется
+// каждый запрос, без него - только при клиентских сертификатах.
+// Вызывается до Start.
+func (h *Transport) SetAllowedOrigins(origins []string) {
+	h.origins = origins
+}
 
-	responseDTO := dto.HTTPMessageDTO{
-		Name: outgoingMsg.Name,
-		Text: outgoingMsg.Text,
-		Time: outgoingMsg.Time,
-		Type: "broadcast",
+// checkOrigin защищает от межсайтового захвата соединения. Когда имя
+// берётся из клиентского сертификата, браузер предъявит его и для чужой
+// страницы, поэтому Origin должен совпасть с Host или быть в списке.
+// Запрос без Origin пришёл не из браузера.
+func (h *Transport) checkOrigin(r *http.Request) bool {
+	certIdentity := h.tls != nil && h.tls.ClientCAs != nil
+	origin := r.Header.Get("Origin")
+	if origin == "" || (!certIdentity && len(h.origins) == 0) {
+		return true
 	}
-
-	h.mu.RLock()
-	for _, client := range h.clientsByName {
-		err = client.WriteJSON(responseDTO)
-		if err != nil {
-			return fmt.Errorf("send message for client error: %s", err)
+	for _, allowed := range h.origins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
 		}
 	}
-	h.mu.RUnlock()
-	return nil
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
 }
 
-func (h *Transport) SendPrivateMessage(msg model.IncomingMessage) error {
-	var dtoMsg dto.HTTPMessageDTO
-	err := utils.JsonToStruct(msg.Text, &dtoMsg)
+// SetAPIToken включает REST API под /api/ с проверкой bearer-токена.
+// Вызывается до Start.
+func (h *Transport) SetAPIToken(token string) {
+	h.apiToken = token
+}
+
+func (h *Transport) Start(address string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+h.wsPath, h.handleConnections)
+	mux.HandleFunc("GET /events", h.handleEvents)
+	mux.HandleFunc("POST /send", h.handleSend)
+	mux.HandleFunc("POST /poll", h.handlePollOpen)
+	mux.HandleFunc("GET /poll", h.handlePoll)
+	mux.HandleFunc("DELETE /poll", h.handlePollClose)
+	h.registerAPI(mux)
+	registerWeb(mux, h.wsPath)
+
+	server := &http.Server{Addr: address, Handler: mux, TLSConfig: h.tls}
+	h.mu.Lock()
+	select {
+	case <-h.quit:
+		// Stop вызван раньше, чем транспорт успел запуститься
+		h.mu.Unlock()
+		return nil
+	default:
+	}
+	ln, err := net.Listen("tcp", address)
 	if err != nil {
-		return fmt.Errorf("send private message error: %v", err)
+		h.mu.Unlock()
+		return err
 	}
+	h.server = server
+	h.ln = ln
+	h.mu.Unlock()
 
-	privateMsg := model.HTTPMessage{
-		Name: dtoMsg.Name,
-		Text: dtoMsg.Text,
-		Time: dtoMsg.Time,
+	if h.tls != nil {
+		logging.Infof("https server started on %s\n", address)
+		err = server.ServeTLS(ln, "", "")
+	} else {
+		logging.Infof("http server started on %s\n", address)
+		err = server.Serve(ln)
 	}
-
-	responseDTO := dto.HTTPMessageDTO{
-		Name: privateMsg.Name,
-		Text: privateMsg.Text,
-		Time: privateMsg.Time,
-		Type: "whisper",
-		Dst:  dtoMsg.Dst,
+	h.mu.Lock()
+	draining := h.draining
+	h.mu.Unlock()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) && !draining {
+		return err
 	}
+	return nil
+}
 
-	h.mu.RLock()
-	if toConn, ok := h.clientsByName[dtoMsg.Dst]; ok {
-		toConn.WriteJSON(responseDTO)
-	} else {
-		if fromConn, ok := h.clientsByName[dtoMsg.Name]; ok {
-			errMsg := dto.HTTPMessageDTO{
-				Type: "error",
-				Text: fmt.Sprintf("%s is not existed", dtoMsg.Dst),
-			}
-			fromConn.WriteJSON(errMsg)
-			h.mu.RUnlock()
-			return nil
-		}
+// Shutdown перестаёт принимать соединения. WebSocket-соединения после
+// Upgrade сервер не отслеживает, их закрывает хаб. Потоки SSE и клиенты
+// long-poll должны успеть забрать server_shutdown, поэтому закрывается
+// только слушатель, а уже открытые соединения закроет Stop.
+func (h *Transport) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	ln := h.ln
+	h.draining = true
+	h.mu.Unlock()
+	if ln == nil {
+		return nil
+	}
+	err := ln.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
 	}
-	if fromConn, ok := h.clientsByName[dtoMsg.Name]; ok && dtoMsg.Dst != dtoMsg.Name {
-		fromConn.WriteJSON(responseDTO)
+	return err
+}
+
+func (h *Transport) Stop() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.once.Do(func() { close(h.quit) })
+	if h.server != nil {
+		return h.server.Close()
 	}
-	h.mu.RUnlock()
 	return nil
 }
 
 func (h *Transport) handleConnections(w http.ResponseWriter, r *http.Request) {
-	ws, err := upgrader.Upgrade(w, r, nil)
+	if !h.checkOrigin(r) {
+		http.Error(w, errForbiddenOrigin.Error(), http.StatusForbidden)
+		return
+	}
+	if err := h.hub.Admit(r.RemoteAddr); err != nil {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	ws, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Fatal(err)
+		logging.Warnf("Upgrade error: %s\n", err)
+		return
 	}
-	defer ws.Close()
 
-	h.mu.Lock()
-	h.clients[ws] = true
-	h.mu.Unlock()
+	client := &clientConn{ws: ws, w: newWSWriter(ws)}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		client.identity = r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
 
-	var username string
+	session := h.hub.Connect(client)
+	defer h.hub.Disconnect(session)
+	ws.SetReadLimit(maxWSMessage)
+	ws.SetPongHandler(func(string) error {
+		session.Touch()
+		return nil
+	})
 
+	codec := protocol.JSON
 	for {
-		var msg dto.HTTPMessageDTO
-		if err := ws.ReadJSON(&msg); err != nil {
-			log.Println("ReadJSON error:", err)
+		data, err := readMessage(ws, protocol.DefaultMaxFrameSize)
+		if errors.Is(err, protocol.ErrFrameTooLarge) {
+			session.SendError(fmt.Errorf("%w: limit %d bytes", app.ErrMessageTooLarge, protocol.DefaultMaxFrameSize))
+			continue
+		}
+		if err != nil {
+			logging.Debugf("WebSocket read error: %s\n", err)
 			break
 		}
 
-		msgJson, err := json.Marshal(msg)
+		msg, err := wire.Decode(codec, data)
 		if err != nil {
-			log.Println("Marshal error:", err)
+			session.SendError(err)
 			continue
 		}
-		incoming := model.IncomingMessage{
-			From: msg.Name,
-			Text: string(msgJson),
-		}
-
-		switch msg.Type {
-		case "register":
-			h.handleRegister(ws, &username, msg)
-		case "exit":
-			h.handleExit(ws, username)
-			return
-		case "whisper":
-			h.SendPrivateMessage(incoming)
-		case "broadcast":
-			h.BroadcastMessage(incoming)
-		}
+		h.hub.Handle(session, msg)
+		// После hello клиент пишет уже в выбранном кодеке
+		codec = wire.Codec(session)
 	}
+}
 
-	h.mu.Lock()
-	delete(h.clients, ws)
-	if username != "" {
-		if h.clientsByName[username] == ws {
-			delete(h.clientsByName, username)
+// readMessage читает следующее сообщение WebSocket не больше max байт.
+// Слишком большое сообщение дочитывается и отбрасывается, возвращается
+// protocol.ErrFrameTooLarge.
+func readMessage(ws *websocket.Conn, max int) ([]byte, error) {
+	_, r, err := ws.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > max {
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return nil, err
 		}
+		return nil, protocol.ErrFrameTooLarge
 	}
-	h.mu.Unlock()
+	return data, nil
 }
 
-func (h *Transport) handleRegister(ws *websocket.Conn, username *string, msg dto.HTTPMessageDTO) {
-	*username = msg.Name
-	h.mu.Lock()
-	if _, ok := h.clientsByName[*username]; ok {
-		err := dto.HTTPMessageDTO{
-			Type: "error",
-			Text: "username already taken",
-		}
-		ws.WriteJSON(err)
-		h.mu.Unlock()
-		ws.Close()
-		return
+// clientConn - WebSocket-соединение клиента. Читает из ws только
+// handleConnections, а пишут все через w. Двоичные кодеки отправляются
+// бинарными сообщениями, JSON - текстовыми.
+type clientConn struct {
+	ws       *websocket.Conn
+	w        *wsWriter
+	identity string // CommonName клиентского сертификата при mTLS
+
+	mu    sync.Mutex
+	codec protocol.Codec // nil - protocol.JSON
+}
+
+func (c *clientConn) Send(msg model.OutgoingMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	codec := c.codec
+	if codec == nil {
+		codec = protocol.JSON
 	}
-	h.clientsByName[*username] = ws
-	h.mu.Unlock()
-	fmt.Printf("User %s registered from %s\n", *username, ws.RemoteAddr())
+	data, err := wire.Encode(codec, msg)
+	if err != nil {
+		return err
+	}
+	messageType := websocket.TextMessage
+	if codec.Binary() {
+		messageType = websocket.BinaryMessage
+	}
+	if err := c.w.Write(messageType, data); err != nil {
+		return err
+	}
+	if next, ok := wire.Switch(msg); ok {
+		c.codec = next
+	}
+	return nil
 }
 
-func (h *Transport) handleExit(ws *websocket.Conn, username string) {
-	h.mu.Lock()
-	delete(h.clients, ws)
-	if username != "" {
+// Ping отправляет управляющий кадр ping: браузеры отвечают на него сами,
+// без кода на стороне страницы
+func (c *clientConn) Ping() error {
+	return c.w.Ping()
+}
 
-		delete(h.clientsByName, username)
+func (c *clientConn) Close() error {
+	return c.w.Close()
+}
 
-	}
-	h.mu.Unlock()
+func (c *clientConn) Identity() string {
+	return c.identity
+}
+
+func (c *clientConn) RemoteAddr() string {
+	return c.ws.RemoteAddr().String()
 }
@@ -0,0 +1,340 @@
+package http
+
+import (
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/logging"
+	"chat/server/internal/model"
+	"chat/server/internal/transport/wire"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	streamBuffer      = 64               // Кадров в буфере соединения, пока клиент их не забрал
+	sseKeepAlive      = 15 * time.Second // Комментарий в потоке SSE, чтобы прокси не рвали молчащее соединение
+	pollWait          = 25 * time.Second // Сколько long-poll ждёт кадров, если их нет
+	pollIdle          = 60 * time.Second // Сессия long-poll без запросов дольше этого отключается
+	streamFlushPeriod = 10 * time.Millisecond
+)
+
+var (
+	errStreamClosed  = errors.New("stream closed")
+	errStreamStalled = errors.New("stream client is not reading")
+
+	errForbiddenOrigin = errors.New("origin not allowed")
+)
+
+// streamConn - соединение SSE или long-poll. Кадры сервера копятся в out,
+// откуда их забирает открытый поток /events или очередной запрос /poll, а
+// кадры клиента приходят отдельными запросами POST /send с id соединения.
+// Кадры идут только в JSON: другой кодек в hello не согласуется.
+type streamConn struct {
+	id       string
+	remote   string
+	identity string // CommonName клиентского сертификата при mTLS
+	session  *app.Session
+	release  func() // Убирает соединение из реестра транспорта
+
+	out       chan json.RawMessage
+	unread    atomic.Int64 // Кадры в out и взятые из out, но ещё не записанные в ответ
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	handleMu sync.Mutex  // Кадры клиента обрабатываются по порядку
+	pollMu   sync.Mutex  // Одновременно ждёт только один /poll
+	idle     *time.Timer // Только long-poll: отключает клиента, который перестал опрашивать
+}
+
+func (c *streamConn) Send(msg model.OutgoingMessage) error {
+	data, err := wire.Encode(protocol.JSON, msg)
+	if err != nil {
+		return err
+	}
+	timer := time.NewTimer(writeWait)
+	defer timer.Stop()
+	c.unread.Add(1)
+	select {
+	case c.out <- data:
+		return nil
+	case <-c.closed:
+		err = errStreamClosed
+	case <-timer.C:
+		err = errStreamStalled
+	}
+	c.unread.Add(-1)
+	return err
+}
+
+// Flush ждёт, пока отправленные кадры будут записаны в ответ клиенту
+func (c *streamConn) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(streamFlushPeriod)
+	defer ticker.Stop()
+	for c.unread.Load() > 0 {
+		select {
+		case <-c.closed:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (c *streamConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.release()
+	})
+	return nil
+}
+
+func (c *streamConn) Identity() string {
+	return c.identity
+}
+
+func (c *streamConn) RemoteAddr() string {
+	return c.remote
+}
+
+// pending забирает кадры, которые уже есть в буфере, не дожидаясь новых
+func (c *streamConn) pending(frames []json.RawMessage) []json.RawMessage {
+	for {
+		select {
+		case data := <-c.out:
+			frames = append(frames, data)
+		default:
+			return frames
+		}
+	}
+}
+
+// openStream создаёт соединение и сессию хаба для запроса r. У соединения
+// long-poll (poll) есть таймер простоя.
+func (h *Transport) openStream(r *http.Request, poll bool) (*streamConn, error) {
+	if !h.checkOrigin(r) {
+		return nil, errForbiddenOrigin
+	}
+	if err := h.hub.Admit(r.RemoteAddr); err != nil {
+		return nil, err
+	}
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return nil, err
+	}
+	c := &streamConn{
+		id:     hex.EncodeToString(buf[:]),
+		remote: r.RemoteAddr,
+		out:    make(chan json.RawMessage, streamBuffer),
+		closed: make(chan struct{}),
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		c.identity = r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	c.release = func() {
+		h.mu.Lock()
+		delete(h.streams, c.id)
+		h.mu.Unlock()
+	}
+
+	c.session = h.hub.Connect(c)
+	if poll {
+		// Повторный Disconnect уже закрытой сессии ничего не делает
+		c.idle = time.AfterFunc(pollIdle, func() { h.hub.Disconnect(c.session) })
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-c.closed:
+		// Хаб успел закрыть сессию, например при остановке сервера
+		return nil, errStreamClosed
+	default:
+	}
+	h.streams[c.id] = c
+	return c, nil
+}
+
+// openStatus - код ответа, когда соединение не удалось открыть
+func openStatus(err error) int {
+	if errors.Is(err, app.ErrRateLimited) {
+		return http.StatusTooManyRequests
+	}
+	if errors.Is(err, errForbiddenOrigin) {
+		return http.StatusForbidden
+	}
+	return http.StatusServiceUnavailable
+}
+
+// stream возвращает соединение по id из параметра conn
+func (h *Transport) stream(r *http.Request) (*streamConn, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.streams[r.URL.Query().Get("conn")]
+	return c, ok
+}
+
+// handleEvents открывает поток Server-Sent Events. Первым приходит событие
+// open с id соединения для /send, затем кадры протокола в событиях message.
+func (h *Transport) handleEvents(w http.ResponseWriter, r *http.Request) {
+	c, err := h.openStream(r, false)
+	if err != nil {
+		http.Error(w, err.Error(), openStatus(err))
+		return
+	}
+	defer h.hub.Disconnect(c.session)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "event: open\ndata: {\"conn\":%q}\n\n", c.id)
+	if err := http.NewResponseController(w).Flush(); err != nil {
+		logging.Debugf("SSE write error: %s\n", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var frames []json.RawMessage
+		select {
+		case data := <-c.out:
+			frames = c.pending([]json.RawMessage{data})
+		case <-keepAlive.C:
+		case <-c.closed:
+			// Последние кадры, например server_shutdown, дописываются
+			writeEvents(w, c, c.pending(nil))
+			return
+		case <-r.Context().Done():
+			return
+		}
+		if err := writeEvents(w, c, frames); err != nil {
+			logging.Debugf("SSE write error: %s\n", err)
+			return
+		}
+	}
+}
+
+// writeEvents пишет кадры в поток SSE, а без кадров - комментарий keepalive
+func writeEvents(w http.ResponseWriter, c *streamConn, frames []json.RawMessage) error {
+	defer c.unread.Add(-int64(len(frames)))
+	var err error
+	if len(frames) == 0 {
+		_, err = io.WriteString(w, ": keepalive\n\n")
+	}
+	for _, data := range frames {
+		if err == nil {
+			_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
+
+// handleSend принимает кадр клиента SSE или long-poll. Ответ на кадр,
+// в том числе ошибка, приходит в поток соединения.
+func (h *Transport) handleSend(w http.ResponseWriter, r *http.Request) {
+	c, ok := h.stream(r)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "unknown connection"})
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, protocol.DefaultMaxFrameSize))
+	if err != nil {
+		c.session.SendError(fmt.Errorf("%w: limit %d bytes", app.ErrMessageTooLarge, protocol.DefaultMaxFrameSize))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	c.handleMu.Lock()
+	defer c.handleMu.Unlock()
+	msg, err := wire.Decode(protocol.JSON, data)
+	if err != nil {
+		c.session.SendError(err)
+	} else {
+		msg.Codecs = nil
+		h.hub.Handle(c.session, msg)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlePollOpen создаёт соединение long-poll и возвращает его id
+func (h *Transport) handlePollOpen(w http.ResponseWriter, r *http.Request) {
+	c, err := h.openStream(r, true)
+	if err != nil {
+		writeJSON(w, openStatus(err), errorBody(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"conn": c.id})
+}
+
+// handlePoll отдаёт накопившиеся кадры, а если их нет - ждёт первый не
+// дольше pollWait (или timeout секунд из запроса)
+func (h *Transport) handlePoll(w http.ResponseWriter, r *http.Request) {
+	c, ok := h.stream(r)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "unknown connection"})
+		return
+	}
+	wait := pollWait
+	if timeout := r.URL.Query().Get("timeout"); timeout != "" {
+		n, err := strconv.Atoi(timeout)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "timeout must be a number of seconds"})
+			return
+		}
+		wait = min(time.Duration(n)*time.Second, pollWait)
+	}
+	if !c.pollMu.TryLock() {
+		writeJSON(w, http.StatusConflict, apiError{Error: "poll already in progress"})
+		return
+	}
+	defer c.pollMu.Unlock()
+	c.idle.Stop()
+	defer c.idle.Reset(pollIdle)
+	c.session.Touch()
+
+	frames := c.pending(nil)
+	if len(frames) == 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case data := <-c.out:
+			frames = c.pending(append(frames, data))
+		case <-c.closed:
+			frames = c.pending(frames)
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if frames == nil {
+		frames = []json.RawMessage{}
+	}
+	writeJSON(w, http.StatusOK, map[string][]json.RawMessage{"frames": frames})
+	c.unread.Add(-int64(len(frames)))
+}
+
+// handlePollClose закрывает соединение long-poll, не дожидаясь таймера простоя
+func (h *Transport) handlePollClose(w http.ResponseWriter, r *http.Request) {
+	c, ok := h.stream(r)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "unknown connection"})
+		return
+	}
+	h.hub.Disconnect(c.session)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,44 @@
+package http
+
+import (
+	"chat/server/internal/logging"
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+)
+
+// webFiles - браузерный клиент: страница и скрипт, который говорит с /ws
+// тем же JSON-протоколом, что и консольный клиент
+//
+//go:embed web
+var webFiles embed.FS
+
+// registerWeb отдаёт браузерный клиент: страницу по корневому пути и
+// остальные файлы web по их именам. Другие пути mux не занимает, поэтому
+// неизвестные запросы по-прежнему получают 404 или 405. Путь WebSocket
+// страница узнаёт из meta-тега, который подставляется в index.html.
+func registerWeb(mux *http.ServeMux, wsPath string) {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err) // Каталог web встроен при сборке
+	}
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		panic(err)
+	}
+	index := template.Must(template.ParseFS(files, "index.html"))
+
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := index.Execute(w, struct{ WSPath string }{wsPath}); err != nil {
+			logging.Errorf("Web client page error: %s\n", err)
+		}
+	})
+	server := http.FileServerFS(files)
+	for _, e := range entries {
+		if !e.IsDir() && e.Name() != "index.html" {
+			mux.Handle("GET /"+e.Name(), server)
+		}
+	}
+}
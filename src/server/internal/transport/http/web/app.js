@@ -0,0 +1,351 @@
+// Браузерный клиент чата: те же JSON-кадры, что у консольного клиента
+// client/internal/app/http, через WebSocket того же сервера.
+"use strict";
+
+const VERSION = 1;
+
+const $ = (id) => document.getElementById(id);
+
+let ws = null;
+let me = "";
+let room = "";           // Текущая комната, пустая строка - общий чат
+let registered = false;  // Сервер подтвердил register кадром registered
+let exiting = false;     // Пользователь вышел сам, закрытие ожидаемо
+const users = new Set();
+
+// wsPath - путь WebSocket из настроек сервера (-ws-path)
+function wsPath() {
+  const meta = document.querySelector('meta[name="ws-path"]');
+  return (meta && meta.content) || "/ws";
+}
+
+// frame собирает кадр; ref сервер повторит в ошибке на этот кадр
+function frame(type, payload, ref) {
+  const f = { v: VERSION, type, payload: payload || {} };
+  if (ref) {
+    f.ref = ref;
+  }
+  return f;
+}
+
+function send(type, payload, ref) {
+  if (ws && ws.readyState === WebSocket.OPEN) {
+    ws.send(JSON.stringify(frame(type, payload, ref)));
+  }
+}
+
+// connect открывает WebSocket и регистрирует пользователя: hello, register,
+// затем список пользователей в сети для боковой панели и история общего чата
+function connect(name, password, token) {
+  const scheme = location.protocol === "https:" ? "wss:" : "ws:";
+  ws = new WebSocket(scheme + "//" + location.host + wsPath());
+  me = name;
+  registered = false;
+  exiting = false;
+  users.clear();
+  $("log").replaceChildren();
+
+  ws.onopen = () => {
+    send("hello", { versions: [VERSION], capabilities: ["presence"], codecs: ["json"] });
+    send("register", { name, password, token }, "register");
+    send("who", { name });
+    send("history", { name });
+  };
+  ws.onmessage = (event) => {
+    let msg;
+    try {
+      msg = JSON.parse(event.data);
+    } catch {
+      return;
+    }
+    handle(msg);
+  };
+  const socket = ws;
+  socket.onclose = () => {
+    if (ws !== socket) {
+      return; // Уже открыто новое соединение
+    }
+    ws = null;
+    if (exiting) {
+      return;
+    }
+    if (!registered) {
+      showLogin("Connection closed");
+      return;
+    }
+    info("Disconnected from server", "error");
+    $("text").disabled = true;
+  };
+}
+
+// errorText - текст ошибки с кодом протокола
+function errorText(p) {
+  return p.code ? p.text + " (" + p.code + ")" : p.text;
+}
+
+function handle(msg) {
+  const p = msg.payload || {};
+  switch (msg.type) {
+  case "hello":
+  case "session":
+    return;
+  case "ping":
+    send("pong", { name: me });
+    return;
+  case "broadcast":
+    line(msg, p.room ? "[" + p.room + "]" : "");
+    return;
+  case "whisper":
+    line(msg, p.dst === me ? "[whisper]" : "[whisper to " + p.dst + "]");
+    return;
+  case "join":
+    info(p.name + " joined " + p.room);
+    return;
+  case "leave":
+    info(p.name + " left " + p.room);
+    return;
+  case "rooms":
+    info(p.rooms && p.rooms.length ? "Rooms: " + p.rooms.join(", ") : "No rooms");
+    return;
+  case "registered":
+    if (!registered) {
+      registered = true;
+      showChat();
+    }
+    return;
+  case "who":
+    users.clear();
+    (p.users || []).forEach((u) => users.add(u));
+    renderUsers();
+    return;
+  case "motd":
+    info(p.text, "motd");
+    return;
+  case "user_joined":
+    users.add(p.name);
+    renderUsers();
+    info(p.name + " joined the chat");
+    return;
+  case "user_left":
+    users.delete(p.name);
+    renderUsers();
+    info(p.name + " left the chat" + (p.reason ? " (" + p.reason + ")" : ""));
+    return;
+  case "auth_failed":
+    exiting = true;
+    ws.close();
+    showLogin("Auth failed: " + errorText(p));
+    return;
+  case "server_shutdown":
+    exiting = true;
+    info("Server shutdown: " + p.text, "error");
+    $("text").disabled = true;
+    return;
+  case "error":
+  case "message_too_large":
+  case "rate_limited":
+  case "invalid_input":
+    if (!registered) {
+      // Отказ в register возвращает к входу, ошибки остальных кадров до
+      // входа - его следствие
+      if (msg.ref === "register") {
+        exiting = true;
+        ws.close();
+        showLogin(errorText(p));
+      }
+      return;
+    }
+    info(errorText(p), "error");
+    return;
+  default:
+    if (p.text) {
+      info(p.text);
+    }
+  }
+}
+
+// line выводит сообщение чата; текст всегда через textContent
+function line(msg, tag) {
+  const p = msg.payload || {};
+  const li = document.createElement("li");
+  if (p.history) {
+    li.className = "history";
+  }
+  if (msg.ts) {
+    append(li, "time", msg.ts);
+  }
+  if (tag) {
+    append(li, "tag", tag);
+  }
+  append(li, "name", p.name);
+  li.append(": " + (p.text || ""));
+  push(li);
+}
+
+function info(text, cls) {
+  const li = document.createElement("li");
+  li.className = cls || "info";
+  li.textContent = text;
+  push(li);
+}
+
+function append(parent, cls, text) {
+  const span = document.createElement("span");
+  span.className = cls;
+  span.textContent = text;
+  parent.append(span);
+}
+
+function push(li) {
+  const log = $("log");
+  const bottom = log.scrollHeight - log.scrollTop - log.clientHeight < 40;
+  log.append(li);
+  if (bottom) {
+    log.scrollTop = log.scrollHeight;
+  }
+}
+
+function renderUsers() {
+  const list = $("users");
+  list.replaceChildren();
+  [...users].sort().forEach((u) => {
+    const li = document.createElement("li");
+    li.textContent = u;
+    if (u === me) {
+      li.className = "me";
+    } else {
+      li.title = "Whisper to " + u;
+      li.onclick = () => {
+        $("text").value = "/w " + u + " ";
+        $("text").focus();
+      };
+    }
+    list.append(li);
+  });
+}
+
+// roomName приводит имя комнаты к виду #name, как это делает сервер
+function roomName(name) {
+  return !name || name.startsWith("#") ? name : "#" + name;
+}
+
+function setRoom(name) {
+  room = name;
+  $("room").textContent = room;
+}
+
+// command разбирает строку так же, как ParseCommand консольного клиента.
+// Неизвестные команды отправляются как обычный текст.
+function command(text) {
+  if (!text.startsWith("/")) {
+    send("broadcast", { name: me, text, room });
+    return;
+  }
+  const space = text.indexOf(" ");
+  const name = space < 0 ? text.slice(1) : text.slice(1, space);
+  const rest = space < 0 ? "" : text.slice(space + 1).trim();
+
+  switch (name) {
+  case "exit":
+    exit();
+    return;
+  case "w":
+  case "whisper": {
+    const i = rest.indexOf(" ");
+    if (i < 0 || !rest.slice(i + 1)) {
+      info("Usage: /w <username> <message>", "error");
+      return;
+    }
+    send("whisper", { name: me, dst: rest.slice(0, i), text: rest.slice(i + 1) });
+    return;
+  }
+  case "join":
+    if (!rest) {
+      info("Usage: /join #room", "error");
+      return;
+    }
+    setRoom(roomName(rest));
+    send("join", { name: me, room });
+    return;
+  case "leave": {
+    const left = roomName(rest) || room;
+    if (!left) {
+      info("You are not in a room", "error");
+      return;
+    }
+    if (left === room) {
+      setRoom("");
+    }
+    send("leave", { name: me, room: left });
+    return;
+  }
+  case "rooms":
+    send("rooms", { name: me });
+    return;
+  case "who":
+    send("who", { name: me });
+    return;
+  case "history": {
+    const payload = { name: me };
+    rest.split(/\s+/).filter(Boolean).forEach((field) => {
+      if (/^\d+$/.test(field)) {
+        payload.limit = Number(field);
+      } else if (field.startsWith("#")) {
+        payload.room = field;
+      } else {
+        payload.dst = field;
+      }
+    });
+    send("history", payload);
+    return;
+  }
+  default:
+    send("broadcast", { name: me, text, room });
+  }
+}
+
+function exit() {
+  exiting = true;
+  send("exit", { name: me });
+  if (ws) {
+    ws.close();
+  }
+  showLogin("");
+}
+
+function showLogin(error) {
+  $("chat").hidden = true;
+  $("login").hidden = false;
+  $("login-error").textContent = error || "";
+}
+
+function showChat() {
+  $("login").hidden = true;
+  $("chat").hidden = false;
+  $("me").textContent = me;
+  $("text").disabled = false;
+  setRoom("");
+  $("text").focus();
+}
+
+$("login").onsubmit = (event) => {
+  event.preventDefault();
+  const name = $("name").value.trim();
+  if (!name) {
+    return;
+  }
+  $("login-error").textContent = "";
+  connect(name, $("password").value, $("token").value.trim());
+};
+
+$("send").onsubmit = (event) => {
+  event.preventDefault();
+  const text = $("text").value;
+  if (!text.trim()) {
+    return;
+  }
+  $("text").value = "";
+  command(text);
+};
+
+$("exit").onclick = exit;
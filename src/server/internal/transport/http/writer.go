@@ -0,0 +1,70 @@
+package http
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait - сколько ждать записи кадра, прежде чем считать клиента пропавшим
+	writeWait = 10 * time.Second
+	// pingWriteWait - сколько ждать отправки управляющего кадра ping
+	pingWriteWait = 5 * time.Second
+)
+
+var errWriterClosed = errors.New("websocket writer closed")
+
+// wsWriter - единственный писатель в WebSocket-соединение. gorilla/websocket
+// не допускает параллельных вызовов методов записи, а кадры клиенту
+// отправляются из горутин разных сессий, heartbeat и остановки сервера.
+// Все записи проходят через wsWriter и выполняются по одной.
+type wsWriter struct {
+	ws     *websocket.Conn
+	mu     sync.Mutex
+	closed atomic.Bool
+}
+
+func newWSWriter(ws *websocket.Conn) *wsWriter {
+	return &wsWriter{ws: ws}
+}
+
+// Write отправляет сообщение с данными: websocket.TextMessage или
+// websocket.BinaryMessage. Зависший клиент держит писателя не дольше writeWait.
+func (w *wsWriter) Write(messageType int, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed.Load() {
+		return errWriterClosed
+	}
+	w.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return w.ws.WriteMessage(messageType, data)
+}
+
+// Ping отправляет управляющий кадр ping
+func (w *wsWriter) Ping() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed.Load() {
+		return errWriterClosed
+	}
+	return w.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingWriteWait))
+}
+
+// Close закрывает соединение, последующие записи возвращают ошибку. Кадр
+// закрытия отправляется, только если никто не пишет: зависшую запись
+// прерывает закрытие сокета, ждать её не нужно.
+func (w *wsWriter) Close() error {
+	if w.closed.Swap(true) {
+		return nil
+	}
+	if w.mu.TryLock() {
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		w.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(pingWriteWait))
+		w.mu.Unlock()
+	}
+	return w.ws.Close()
+}
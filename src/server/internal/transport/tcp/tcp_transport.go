@@ -2,233 +2,192 @@ package tcp
 
 import (
 	"bufio"
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/logging"
 	"chat/server/internal/model"
-	"encoding/json"
+	"chat/server/internal/transport/wire"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
-
-	"chat/server/internal/dto"
-	"chat/server/utils"
+	"time"
 )
 
+// handshakeTimeout ограничивает TLS-рукопожатие нового соединения
+const handshakeTimeout = 10 * time.Second
+
 type Transport struct {
-	clients       map[string]net.Conn
-	clientsByName map[string]net.Conn // Имя -> соединение
-	publicChan    chan model.IncomingMessage
-	privateChan   chan model.IncomingMessage
-	quit          chan struct{}
-	mu            sync.RWMutex
+	hub      *app.Hub
+	listener net.Listener
+	tls      *tls.Config
+	quit     chan struct{}
+	stopOnce sync.Once
+	mu       sync.Mutex
 }
 
-func NewTCPTransport() *Transport {
+func NewTCPTransport(hub *app.Hub) *Transport {
 	return &Transport{
-		clients:       make(map[string]net.Conn),
-		clientsByName: make(map[string]net.Conn),
-		publicChan:    make(chan model.IncomingMessage, 100),
-		privateChan:   make(chan model.IncomingMessage, 100),
-		quit:          make(chan struct{}),
+		hub:  hub,
+		quit: make(chan struct{}),
 	}
 }
 
+// SetTLSConfig включает TLS. Вызывается до Start.
+func (t *Transport) SetTLSConfig(cfg *tls.Config) {
+	t.tls = cfg
+}
+
 func (t *Transport) Start(address string) error {
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
 		return err
 	}
+	if t.tls != nil {
+		listener = tls.NewListener(listener, t.tls)
+	}
+	t.mu.Lock()
+	select {
+	case <-t.quit:
+		// Stop вызван раньше, чем транспорт успел запуститься
+		t.mu.Unlock()
+		listener.Close()
+		return nil
+	default:
+	}
+	t.listener = listener
+	t.mu.Unlock()
 	defer listener.Close()
 
-	go func() {
-		for {
-			select {
-			case msg := <-t.publicChan:
-				t.BroadcastMessage(msg)
-			case msg := <-t.privateChan:
-				t.SendPrivateMessage(msg)
-			case <-t.quit:
-				return
-			}
-		}
-	}()
-
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			continue
+			select {
+			case <-t.quit:
+				return nil
+			default:
+				continue
+			}
 		}
 		go t.handleRequest(conn)
 	}
 }
 
 func (t *Transport) handleRequest(conn net.Conn) {
-	defer conn.Close()
-	addr := conn.RemoteAddr().String()
-	t.mu.Lock()
-	t.clients[addr] = conn
-	t.mu.Unlock()
-
-	scanner := bufio.NewScanner(conn)
-	var username string
-
-	for scanner.Scan() {
-		clientMessage := scanner.Text()
-
-		var msgDTO dto.TCPMessageDTO
-		err := utils.JsonToStruct(clientMessage, &msgDTO)
-		if err != nil {
-			t.sendError(conn, "invalid json format")
-			continue
+	client := &clientConn{conn: conn}
+	// Лимит соединений проверяется до TLS, а рукопожатие и отказ
+	// ограничены по времени: молчащий клиент не держит горутину
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := t.hub.Admit(conn.RemoteAddr().String()); err != nil {
+		client.Send(app.ErrorMessage(err))
+		conn.Close()
+		return
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			logging.Warnf("TLS handshake with %s failed: %s\n", conn.RemoteAddr(), err)
+			conn.Close()
+			return
 		}
+		client.identity = peerName(tlsConn.ConnectionState())
+	}
+	conn.SetDeadline(time.Time{})
 
-		if msgDTO.Type == "register" {
-			if msgDTO.Name == "" {
-				t.sendError(conn, "username cannot be empty")
-				continue
-			}
-			t.mu.Lock()
-			if _, exists := t.clientsByName[msgDTO.Name]; exists {
-				t.mu.Unlock()
-				t.sendError(conn, "username already taken")
-				return
-			}
-			if username == "" {
-				username = msgDTO.Name
-				t.clientsByName[username] = conn
-				fmt.Printf("User %s registered from %s\n", username, addr)
-			}
-			t.mu.Unlock()
+	session := t.hub.Connect(client)
+	defer t.hub.Disconnect(session)
+
+	reader := bufio.NewReader(conn)
+	codec := protocol.JSON
+	for {
+		data, err := codec.ReadFrame(reader, protocol.DefaultMaxFrameSize)
+		if errors.Is(err, protocol.ErrFrameTooLarge) {
+			session.SendError(fmt.Errorf("%w: limit %d bytes", app.ErrMessageTooLarge, protocol.DefaultMaxFrameSize))
 			continue
 		}
-
-		if msgDTO.Type == "exit" {
-			t.mu.Lock()
-			delete(t.clients, addr)
-			if username != "" {
-				delete(t.clientsByName, username)
-			}
-			t.mu.Unlock()
+		if err != nil {
 			return
 		}
-
-		incomingMsg := model.IncomingMessage{From: msgDTO.Name, Text: clientMessage}
-		if msgDTO.Type == "whisper" {
-			t.privateChan <- incomingMsg
-		} else if msgDTO.Type == "broadcast" {
-			t.publicChan <- incomingMsg
+		msg, err := wire.Decode(codec, data)
+		if err != nil {
+			session.SendError(err)
+			continue
 		}
+		t.hub.Handle(session, msg)
+		// После hello клиент пишет уже в выбранном кодеке
+		codec = wire.Codec(session)
 	}
-
-	t.mu.Lock()
-	delete(t.clients, addr)
-	if username != "" {
-		delete(t.clientsByName, username)
-	}
-	t.mu.Unlock()
 }
 
-func (t *Transport) sendError(conn net.Conn, message string) {
-	errDTO := dto.ErrorDTO{
-		Type:    "error",
-		Message: message,
-	}
-	data, _ := json.Marshal(errDTO)
-	conn.Write(append(data, '\n'))
+// Shutdown закрывает слушающий сокет. Открытые соединения закрывает хаб.
+func (t *Transport) Shutdown(ctx context.Context) error {
+	return t.closeListener()
 }
 
-func (t *Transport) BroadcastMessage(msg model.IncomingMessage) error {
-	var msgDTO dto.TCPMessageDTO
-	err := utils.JsonToStruct(msg.Text, &msgDTO)
-	if err != nil {
-		if sender, ok := t.clientsByName[msg.From]; ok {
-			t.sendError(sender, "invalid json format in broadcast")
-		}
-		return fmt.Errorf("send message error: %v", err)
-	}
+func (t *Transport) Stop() error {
+	return t.closeListener()
+}
 
-	responseDTO := dto.TCPMessageDTO{
-		Type: "broadcast",
-		Name: msgDTO.Name,
-		Text: msgDTO.Text,
-		Time: msgDTO.Time,
-	}
-	responseJSON, err := json.Marshal(responseDTO)
-	if err != nil {
-		if sender, ok := t.clientsByName[msg.From]; ok {
-			t.sendError(sender, "marshal error in broadcast")
+func (t *Transport) closeListener() error {
+	var err error
+	t.stopOnce.Do(func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		close(t.quit)
+		if t.listener != nil {
+			err = t.listener.Close()
 		}
-		return fmt.Errorf("marshal response error: %v", err)
-	}
+	})
+	return err
+}
 
-	message := string(responseJSON) + "\n"
-	t.mu.RLock()
-	for _, client := range t.clientsByName {
-		_, err = client.Write([]byte(message))
-		if err != nil {
-			// Не отправляем ошибку всем, только логируем
-			fmt.Printf("send message for client error: %s\n", err)
-		}
+// peerName возвращает имя из проверенного клиентского сертификата
+func peerName(state tls.ConnectionState) string {
+	if len(state.VerifiedChains) == 0 {
+		return ""
 	}
-	t.mu.RUnlock()
-	return nil
+	return state.VerifiedChains[0][0].Subject.CommonName
 }
 
-func (t *Transport) SendPrivateMessage(msg model.IncomingMessage) error {
-	var msgDTO dto.TCPMessageDTO
-	err := utils.JsonToStruct(msg.Text, &msgDTO)
-	if err != nil {
-		if sender, ok := t.clientsByName[msg.From]; ok {
-			t.sendError(sender, "invalid json format in whisper")
-		}
-		return fmt.Errorf("send private message error: %v", err)
-	}
+// clientConn - TCP-соединение клиента. Кадры кодируются и разделяются
+// кодеком: до ответа на hello - JSON-строками, затем выбранным клиентом.
+type clientConn struct {
+	conn     net.Conn
+	identity string // CommonName клиентского сертификата при mTLS
 
-	responseDTO := dto.TCPMessageDTO{
-		Type: "whisper",
-		Name: msgDTO.Name,
-		Text: msgDTO.Text,
-		Time: msgDTO.Time,
-		Dst:  msgDTO.Dst,
+	mu    sync.Mutex
+	codec protocol.Codec // nil - protocol.JSON
+}
+
+func (c *clientConn) Send(msg model.OutgoingMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	codec := c.codec
+	if codec == nil {
+		codec = protocol.JSON
 	}
-	responseJSON, err := json.Marshal(responseDTO)
+	data, err := wire.Encode(codec, msg)
 	if err != nil {
-		if sender, ok := t.clientsByName[msg.From]; ok {
-			t.sendError(sender, "marshal error in whisper")
-		}
-		return fmt.Errorf("marshal response error: %v", err)
+		return err
 	}
-
-	message := string(responseJSON) + "\n"
-	t.mu.RLock()
-	if toConn, ok := t.clientsByName[msgDTO.Dst]; ok {
-		toConn.Write([]byte(message))
-	} else {
-		if fromConn, ok := t.clientsByName[msgDTO.Name]; ok && msgDTO.Dst != msgDTO.Name {
-			t.sendError(fromConn, fmt.Sprintf("%s not found", msgDTO.Dst))
-		}
-		t.mu.RUnlock()
-		return fmt.Errorf("send message error: %v", err)
+	if err := codec.WriteFrame(c.conn, data); err != nil {
+		return err
 	}
-
-	if fromConn, ok := t.clientsByName[msgDTO.Name]; ok && msgDTO.Dst != msgDTO.Name {
-		fromConn.Write([]byte(message))
+	if next, ok := wire.Switch(msg); ok {
+		c.codec = next
 	}
-	t.mu.RUnlock()
 	return nil
 }
 
-func (t *Transport) Stop() error {
-	close(t.quit)
-	t.mu.Lock()
-	for addr, conn := range t.clients {
-		conn.Close()
-		delete(t.clients, addr)
-	}
-	for name, conn := range t.clientsByName {
-		conn.Close()
-		delete(t.clientsByName, name)
-	}
-	t.mu.Unlock()
-	close(t.publicChan)
-	close(t.privateChan)
-	return nil
+func (c *clientConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *clientConn) Identity() string {
+	return c.identity
+}
+
+func (c *clientConn) RemoteAddr() string {
+	return c.conn.RemoteAddr().String()
 }
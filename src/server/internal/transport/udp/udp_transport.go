@@ -1,280 +1,285 @@
 package udp
 
 import (
-	"chat/server/internal/dto"
+	"chat/protocol"
+	"chat/rudp"
+	"chat/server/internal/app"
+	"chat/server/internal/logging"
 	"chat/server/internal/model"
-	"chat/server/utils"
-	"encoding/json"
+	"chat/server/internal/transport/wire"
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// inboxSize - сколько датаграмм клиента может ждать обработки
+const inboxSize = 64
+
+// ClientInfo - UDP-клиент, которого узнают по адресу отправителя.
+// Молчащих клиентов отключает heartbeat хаба.
 type ClientInfo struct {
-	Addr     *net.UDPAddr
-	Name     string
-	LastSeen time.Time
+	Addr    net.Addr
+	Session *app.Session
+	inbox   chan []byte    // Датаграммы клиента обрабатываются по порядку
+	codec   protocol.Codec // Кодек входящих кадров, меняется после hello
+	removed atomic.Bool    // Клиент отключён: датаграммы из inbox отбрасываются
 }
 
 type Transport struct {
-	clients       map[string]*ClientInfo  
-	clientsByName map[string]*net.UDPAddr 
-	publicChan    chan model.IncomingMessage
-	privateChan   chan model.IncomingMessage
-	quit          chan struct{}
-	conn          *net.UDPConn
-	mu            sync.RWMutex 
+	hub        *app.Hub
+	clients    map[string]*ClientInfo // Адрес -> клиент
+	quit       chan struct{}
+	conn       *rudp.Conn // Подтверждения, повторы, порядок и фрагментация датаграмм
+	maxMessage int        // Наибольшее сообщение в байтах, 0 - rudp.DefaultMaxMessageSize
+	closing    bool       // Shutdown: датаграммы с новых адресов отбрасываются
+	stopOnce   sync.Once
+	mu         sync.RWMutex
 }
 
-func NewUDPTransport() *Transport {
+func NewUDPTransport(hub *app.Hub) *Transport {
 	return &Transport{
-		clients:       make(map[string]*ClientInfo),
-		clientsByName: make(map[string]*net.UDPAddr),
-		publicChan:    make(chan model.IncomingMessage, 100),
-		privateChan:   make(chan model.IncomingMessage, 100),
-		quit:          make(chan struct{}),
+		hub:     hub,
+		clients: make(map[string]*ClientInfo),
+		quit:    make(chan struct{}),
 	}
 }
 
-// Очистка неактивных клиентов
-func (u *Transport) cleanupInactiveClients(timeout time.Duration) {
-	ticker := time.NewTicker(120 * time.Second)
-	for {
-		select {
-		case <-ticker.C:
-			now := time.Now()
-			u.mu.Lock()
-			for ip, client := range u.clients {
-				if now.Sub(client.LastSeen) > timeout {
-					fmt.Printf("Remove inactive client: %s (%s)\n", client.Name, ip)
-					delete(u.clients, ip)
-					if client.Name != "" {
-						delete(u.clientsByName, client.Name)
-					}
-				}
-			}
-			u.mu.Unlock()
-		case <-u.quit:
-			ticker.Stop()
-			return
-		}
-	}
+// SetMaxMessageSize задаёт наибольший размер сообщения в байтах.
+// Вызывается до Start.
+func (u *Transport) SetMaxMessageSize(n int) {
+	u.maxMessage = n
 }
 
 func (u *Transport) Start(address string) error {
-	addr, _ := net.ResolveUDPAddr("udp", address)
-	conn, _ := net.ListenUDP("udp", addr)
-	u.conn = conn
-	defer conn.Close()
-
-	go u.cleanupInactiveClients(120 * time.Second)
-
-	go func() {
-		for {
-			select {
-			case msg := <-u.publicChan:
-				u.BroadcastMessage(msg)
-			case msg := <-u.privateChan:
-				u.SendPrivateMessage(msg)
-			case <-u.quit:
-				return
-			}
-		}
-	}()
-
-	buf := make([]byte, 4096)
-	for {
-		n, addr, err := conn.ReadFromUDP(buf)
-		if err != nil {
-			fmt.Printf("%s", err)
-			continue
-		}
-		go u.handleRequest(buf[:n], addr)
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
 	}
+	return u.Serve(conn)
 }
 
-func (u *Transport) handleRequest(buf []byte, addr *net.UDPAddr) {
-	ip := fmt.Sprintf("%s:%d", addr.IP, addr.Port)
-	
+// Serve обслуживает клиентов на уже открытом сокете. Start вызывает его
+// для UDP, тесты - для сокета с потерями.
+func (u *Transport) Serve(pc net.PacketConn) error {
+	conn := rudp.NewConn(pc, rudp.Config{
+		MaxMessageSize: u.maxMessage,
+		OnLost:         u.clientLost,
+		OnTooLarge:     u.tooLarge,
+	})
 	u.mu.Lock()
-	if client, ok := u.clients[ip]; ok {
-		client.LastSeen = time.Now()
-	} else {
-		u.clients[ip] = &ClientInfo{Addr: addr, LastSeen: time.Now()}
+	select {
+	case <-u.quit:
+		// Stop вызван раньше, чем транспорт успел запуститься
+		u.mu.Unlock()
+		conn.Close()
+		return nil
+	default:
 	}
+	u.conn = conn
 	u.mu.Unlock()
-	strBuf := string(buf)
+	defer conn.Close()
 
-	var msgDTO dto.UDPMessageDTO
-	err := utils.JsonToStruct(strBuf, &msgDTO)
-	if err != nil {
-		u.sendError(addr, "invalid json format")
-		return
+	err := conn.Serve(u.dispatch)
+	select {
+	case <-u.quit:
+		return nil
+	default:
 	}
-
-	if msgDTO.Type == "register" {
-		if msgDTO.Name == "" {
-			u.sendError(addr, "username cannot be empty")
-			return
-		}
-		u.mu.Lock()
-		if existingAddr, ok := u.clientsByName[msgDTO.Name]; ok {
-			if existingAddr.String() != addr.String() {
-				u.mu.Unlock()
-				u.sendError(addr, "username already taken")
-				return
-			}
-		}
-		u.clientsByName[msgDTO.Name] = addr
-		u.clients[ip].Name = msgDTO.Name
-		u.mu.Unlock()
-		fmt.Printf("User %s registered from %s\n", msgDTO.Name, addr.String())
-		return
+	if errors.Is(err, rudp.ErrClosed) {
+		return nil
 	}
+	return err
+}
 
-	if msgDTO.Name == "" {
-		u.sendError(addr, "message from unregistered user")
+// tooLarge сообщает клиенту, что его сообщение отброшено из-за размера
+func (u *Transport) tooLarge(addr net.Addr) {
+	limit := u.maxMessage
+	if limit <= 0 {
+		limit = rudp.DefaultMaxMessageSize
+	}
+	err := fmt.Errorf("%w: limit %d bytes", app.ErrMessageTooLarge, limit)
+	u.mu.RLock()
+	client, ok := u.clients[addr.String()]
+	u.mu.RUnlock()
+	if !ok {
+		// Сессию заводит только dispatch: с проверкой Shutdown и Admit
+		(&clientConn{transport: u, addr: addr}).Send(app.ErrorMessage(err))
 		return
 	}
+	client.Session.SendError(err)
+}
 
+// clientLost отключает клиента, который перестал подтверждать сообщения
+func (u *Transport) clientLost(addr net.Addr) {
 	u.mu.RLock()
-	if _, ok := u.clientsByName[msgDTO.Name]; !ok {
-		u.mu.RUnlock()
-		u.sendError(addr, "user not registered")
+	client, ok := u.clients[addr.String()]
+	u.mu.RUnlock()
+	if ok {
+		logging.Warnf("Client %s (%s) stopped acknowledging messages\n", client.Session.Name(), addr)
+		u.hub.Evict(client.Session, model.LeftTimeout)
+	}
+}
+
+// dispatch ставит датаграмму в очередь клиента. Очередь закрывается
+// под тем же мьютексом, поэтому отправка в закрытый канал невозможна.
+func (u *Transport) dispatch(addr net.Addr, data []byte) {
+	u.mu.Lock()
+	_, known := u.clients[addr.String()]
+	if u.closing && !known {
+		u.mu.Unlock()
 		return
 	}
-	if existingAddr, ok := u.clientsByName[msgDTO.Name]; ok {
-		if existingAddr.String() != addr.String() {
-			u.mu.RUnlock()
-			u.sendError(addr, "message from wrong address for user")
+	if !known {
+		if err := u.hub.Admit(addr.String()); err != nil {
+			u.mu.Unlock()
+			// Сессии нет: отказ отправляется без неё, а состояние rudp
+			// адреса забывается, чтобы отвергнутые адреса не копились
+			(&clientConn{transport: u, addr: addr}).Send(app.ErrorMessage(err))
+			u.conn.Forget(addr)
 			return
 		}
 	}
-	u.mu.RUnlock()
-
-	incomingMsg := model.IncomingMessage{From: msgDTO.Name, Text: strBuf}
-	if msgDTO.Type == "whisper" {
-		u.privateChan <- incomingMsg
-	} else if msgDTO.Type == "exit" {
-		ip := fmt.Sprintf("%s:%d", addr.IP, addr.Port)
-		var username string
-		u.mu.Lock()
-		if client, ok := u.clients[ip]; ok {
-			username = client.Name
-		}
-		delete(u.clients, ip)
-		if username != "" {
-			delete(u.clientsByName, username)
-		}
-		u.mu.Unlock()
-		fmt.Printf("User %s (%s) disconnected\n", username, ip)
-		return
-	} else if msgDTO.Type == "broadcast" {
-		u.publicChan <- incomingMsg
+	defer u.mu.Unlock()
+	client := u.client(addr)
+	select {
+	case client.inbox <- data:
+	default:
+		logging.Warnf("Drop datagram from %s: inbox is full\n", addr)
 	}
 }
 
-func (u *Transport) sendError(addr *net.UDPAddr, message string) {
-	errDTO := dto.ErrorDTO{
-		Type:    "error",
-		Message: message,
+// serve обрабатывает датаграммы клиента в порядке поступления
+func (u *Transport) serve(client *ClientInfo) {
+	for data := range client.inbox {
+		if client.removed.Load() {
+			continue
+		}
+		u.handleRequest(client, data)
 	}
-	data, _ := json.Marshal(errDTO)
-	u.conn.WriteTo(data, addr)
 }
 
-func (u *Transport) BroadcastMessage(msg model.IncomingMessage) error {
-	var msgDTO dto.UDPMessageDTO
-	err := utils.JsonToStruct(msg.Text, &msgDTO)
+func (u *Transport) handleRequest(client *ClientInfo, buf []byte) {
+	msg, err := wire.Decode(client.codec, buf)
 	if err != nil {
-		return fmt.Errorf("send message error: %v", err)
+		client.Session.SendError(err)
+		return
 	}
+	u.hub.Handle(client.Session, msg)
+	client.codec = wire.Codec(client.Session)
+}
 
-	responseDTO := dto.UDPMessageDTO{
-		Type: "broadcast",
-		Name: msgDTO.Name,
-		Text: msgDTO.Text,
-		Time: msgDTO.Time,
+// client возвращает клиента по адресу, создавая сессию для нового адреса.
+// Вызывается под u.mu.
+func (u *Transport) client(addr net.Addr) *ClientInfo {
+	key := addr.String()
+	if client, ok := u.clients[key]; ok {
+		return client
 	}
-	responseJSON, err := json.Marshal(responseDTO)
-	if err != nil {
-		return fmt.Errorf("marshal response error: %v", err)
+
+	client := &ClientInfo{
+		Addr:  addr,
+		inbox: make(chan []byte, inboxSize),
+		codec: protocol.JSON,
 	}
+	client.Session = u.hub.Connect(&clientConn{transport: u, addr: addr})
+	u.clients[key] = client
+	go u.serve(client)
+	return client
+}
 
-	u.mu.RLock()
-	for _, client := range u.clients {
-		if _, err = u.conn.WriteTo(responseJSON, client.Addr); err != nil {
-			fmt.Errorf("send message for clients error: %s", err)
-			continue
-		}
+func (u *Transport) removeClient(addr net.Addr) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if client, ok := u.clients[addr.String()]; ok {
+		delete(u.clients, addr.String())
+		client.removed.Store(true)
+		close(client.inbox)
 	}
-	u.mu.RUnlock()
+	if u.conn != nil {
+		u.conn.Forget(addr)
+	}
+}
+
+// Shutdown перестаёт заводить сессии для новых адресов. Сокет остаётся
+// открытым, чтобы известные клиенты получили и подтвердили последние кадры.
+func (u *Transport) Shutdown(ctx context.Context) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.closing = true
 	return nil
 }
 
 func (u *Transport) Stop() error {
-	close(u.quit)
 	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stopOnce.Do(func() { close(u.quit) })
 	for ip, client := range u.clients {
-		fmt.Printf("Disconnecting client: %s (%s)\n", client.Name, ip)
+		logging.Infof("Disconnecting client: %s (%s)\n", client.Session.Name(), ip)
 	}
-	u.clients = make(map[string]*ClientInfo)
-	u.clientsByName = make(map[string]*net.UDPAddr)
-	u.mu.Unlock()
-	close(u.publicChan)
-	close(u.privateChan)
 	if u.conn != nil {
-		u.conn.Close()
+		return u.conn.Close()
 	}
 	return nil
 }
 
-func (u *Transport) SendPrivateMessage(msg model.IncomingMessage) error {
-	var msgDTO dto.UDPMessageDTO
-	err := utils.JsonToStruct(msg.Text, &msgDTO)
+// clientConn - адрес UDP-клиента: у UDP нет соединения, закрытие лишь
+// забывает адрес, чтобы следующая датаграмма создала новую сессию.
+// Границы кадров задают датаграммы, кодек нужен только для кодирования.
+type clientConn struct {
+	transport *Transport
+	addr      net.Addr
+
+	mu    sync.Mutex
+	codec protocol.Codec // nil - protocol.JSON
+}
+
+func (c *clientConn) Send(msg model.OutgoingMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	codec := c.codec
+	if codec == nil {
+		codec = protocol.JSON
+	}
+	data, err := wire.Encode(codec, msg)
 	if err != nil {
-		if fromAddr, ok := u.clientsByName[msgDTO.Name]; ok {
-			u.sendError(fromAddr, "invalid json format in whisper")
-		}
-		return fmt.Errorf("не удалось распарсить JSON приватного сообщения: %v", err)
+		return err
 	}
-
-	if msgDTO.Dst == "" {
-		if fromAddr, ok := u.clientsByName[msgDTO.Name]; ok {
-			u.sendError(fromAddr, "destination user not specified")
-		}
-		return fmt.Errorf("destination user not specified")
+	if err := c.transport.conn.Send(c.addr, data); err != nil {
+		return err
 	}
-
-	u.mu.RLock()
-	toAddr, ok := u.clientsByName[msgDTO.Dst]
-	u.mu.RUnlock()
-	if !ok {
-		if fromAddr, ok := u.clientsByName[msgDTO.Name]; ok {
-			u.sendError(fromAddr, fmt.Sprintf("user %s not found", msgDTO.Dst))
-		}
-		return fmt.Errorf("user %s not found", msgDTO.Dst)
+	if next, ok := wire.Switch(msg); ok {
+		c.codec = next
 	}
+	return nil
+}
 
-	responseDTO := dto.UDPMessageDTO{
-		Type: "whisper",
-		Name: msgDTO.Name,
-		Text: msgDTO.Text,
-		Time: msgDTO.Time,
-		Dst:  msgDTO.Dst,
-	}
-	responseJSON, err := json.Marshal(responseDTO)
-	if err != nil {
-		if fromAddr, ok := u.clientsByName[msgDTO.Name]; ok {
-			u.sendError(fromAddr, "marshal error in whisper")
+// Flush ждёт, пока клиент подтвердит все отправленные ему кадры
+func (c *clientConn) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+	for c.transport.conn.PendingTo(c.addr) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
 		}
-		return fmt.Errorf("marshal private message error: %v", err)
 	}
+	return nil
+}
 
-	u.conn.WriteTo(responseJSON, toAddr)
-	if fromAddr, ok := u.clientsByName[msgDTO.Name]; ok && msgDTO.Dst != msgDTO.Name {
-		u.conn.WriteTo(responseJSON, fromAddr)
-	}
+func (c *clientConn) Close() error {
+	c.transport.removeClient(c.addr)
 	return nil
 }
+
+func (c *clientConn) RemoteAddr() string {
+	return c.addr.String()
+}
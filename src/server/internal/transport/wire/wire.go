@@ -0,0 +1,96 @@
+// Package wire переводит кадры протокола в модель хаба и обратно.
+// Транспорты отличаются только способом доставки кадров, формат у всех общий.
+package wire
+
+import (
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	"errors"
+)
+
+// Decode разбирает кадр клиента. Ошибка уже подходит для отправки клиенту:
+// кадр неизвестной версии или испорченный кадр.
+func Decode(c protocol.Codec, data []byte) (model.IncomingMessage, error) {
+	e, err := c.Unmarshal(data)
+	if errors.Is(err, protocol.ErrUnsupportedVersion) {
+		return model.IncomingMessage{}, err
+	}
+	if err != nil {
+		return model.IncomingMessage{}, app.ErrInvalidFrame
+	}
+	return Incoming(e), nil
+}
+
+// Encode кодирует сообщение хаба в кадр протокола
+func Encode(c protocol.Codec, msg model.OutgoingMessage) ([]byte, error) {
+	return c.Marshal(Outgoing(msg))
+}
+
+// Codec возвращает кодек, о котором сессия договорилась в hello
+func Codec(s *app.Session) protocol.Codec {
+	c, err := protocol.CodecByName(s.Codec())
+	if err != nil {
+		return protocol.JSON
+	}
+	return c
+}
+
+// Switch сообщает, что после отправки msg соединение переходит на другой
+// кодек: это ответ на hello с выбранным кодеком
+func Switch(msg model.OutgoingMessage) (protocol.Codec, bool) {
+	if msg.Type != model.TypeHello || msg.Codec == "" {
+		return nil, false
+	}
+	c, err := protocol.CodecByName(msg.Codec)
+	return c, err == nil
+}
+
+// Incoming переводит кадр клиента в сообщение хаба
+func Incoming(e protocol.Envelope) model.IncomingMessage {
+	p := e.Payload
+	return model.IncomingMessage{
+		Type:         e.Type,
+		Ref:          e.Ref,
+		From:         p.Name,
+		To:           p.Dst,
+		Room:         p.Room,
+		Text:         p.Text,
+		Time:         e.Time,
+		Limit:        p.Limit,
+		Versions:     p.Versions,
+		Capabilities: p.Capabilities,
+		Codecs:       p.Codecs,
+		Credentials: model.Credentials{
+			Password: p.Password,
+			Token:    p.Token,
+		},
+	}
+}
+
+// Outgoing переводит сообщение хаба в кадр для клиента
+func Outgoing(msg model.OutgoingMessage) protocol.Envelope {
+	e := protocol.New(msg.Type, protocol.Payload{
+		Name:         msg.Name,
+		Text:         msg.Text,
+		Code:         msg.Code,
+		Dst:          msg.Dst,
+		Room:         msg.Room,
+		Rooms:        msg.Rooms,
+		Users:        msg.Users,
+		Reason:       msg.Reason,
+		Token:        msg.Token,
+		History:      msg.History,
+		Capabilities: msg.Capabilities,
+	})
+	e.ID = msg.ID
+	e.Time = msg.Time
+	e.Ref = msg.Ref
+	if msg.Version != 0 {
+		e.Payload.Versions = []int{msg.Version}
+	}
+	if msg.Codec != "" {
+		e.Payload.Codecs = []string{msg.Codec}
+	}
+	return e
+}
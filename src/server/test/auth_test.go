@@ -0,0 +1,155 @@
+package test
+
+import (
+	"chat/server/internal/app"
+	"chat/server/internal/auth"
+	"chat/server/internal/model"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return path
+}
+
+func TestPasswordStore_Authenticate(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeFile(t, "passwd", "# users\nalice:"+string(hash)+"\n")
+
+	store, err := auth.OpenPasswordStore(path, false)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	cases := []struct {
+		name     string
+		user     string
+		password string
+		wantErr  error
+	}{
+		{"valid password", "alice", "secret", nil},
+		{"wrong password", "alice", "guess", auth.ErrInvalidCredentials},
+		{"empty password", "alice", "", auth.ErrInvalidCredentials},
+		{"unknown user", "bob", "secret", auth.ErrInvalidCredentials},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := store.Authenticate(tc.user, model.Credentials{Password: tc.password})
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("got %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestPasswordStore_Enroll(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwd")
+	store, err := auth.OpenPasswordStore(path, true)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	if err := store.Authenticate("alice", model.Credentials{Password: "secret"}); err != nil {
+		t.Fatalf("enroll: %v", err)
+	}
+	if err := store.Authenticate("alice", model.Credentials{Password: "other"}); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("second password: got %v, want %v", err, auth.ErrInvalidCredentials)
+	}
+
+	// Учётная запись сохраняется в файле и переживает перезапуск
+	reopened, err := auth.OpenPasswordStore(path, false)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if err := reopened.Authenticate("alice", model.Credentials{Password: "secret"}); err != nil {
+		t.Errorf("after reopen: %v", err)
+	}
+}
+
+func TestOpenPasswordStore_Errors(t *testing.T) {
+	if _, err := auth.OpenPasswordStore(filepath.Join(t.TempDir(), "missing"), false); err == nil {
+		t.Error("expected error for missing file without enroll")
+	}
+	if _, err := auth.OpenPasswordStore(writeFile(t, "passwd", "alice\n"), false); err == nil {
+		t.Error("expected error for malformed line")
+	}
+}
+
+func TestTokenStore_Authenticate(t *testing.T) {
+	store, err := auth.LoadTokenFile(writeFile(t, "tokens", "alice abc123\n\nbob xyz\n"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		user    string
+		token   string
+		wantErr error
+	}{
+		{"valid token", "alice", "abc123", nil},
+		{"token of another user", "alice", "xyz", auth.ErrInvalidCredentials},
+		{"empty token", "bob", "", auth.ErrInvalidCredentials},
+		{"unknown user", "carol", "abc123", auth.ErrInvalidCredentials},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := store.Authenticate(tc.user, model.Credentials{Token: tc.token})
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("got %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestHub_RegisterWithAuthenticator(t *testing.T) {
+	store, err := auth.LoadTokenFile(writeFile(t, "tokens", "alice abc123\n"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	hub := app.NewHub()
+	hub.SetAuthenticator(store)
+
+	conn := &MockConn{Addr: "alice:1"}
+	session := hub.Connect(conn)
+
+	err = hub.Handle(session, model.IncomingMessage{
+		Type:        model.TypeRegister,
+		From:        "alice",
+		Credentials: model.Credentials{Token: "wrong"},
+	})
+	if !errors.Is(err, app.ErrAuthFailed) {
+		t.Fatalf("got %v, want %v", err, app.ErrAuthFailed)
+	}
+	if session.Name() != "" {
+		t.Errorf("session registered as %q after failed auth", session.Name())
+	}
+	sent := conn.Sent()
+	if len(sent) != 1 || sent[0].Type != model.TypeAuthFailed {
+		t.Fatalf("want one auth_failed frame, got %+v", sent)
+	}
+
+	err = hub.Handle(session, model.IncomingMessage{
+		Type:        model.TypeRegister,
+		From:        "alice",
+		Credentials: model.Credentials{Token: "abc123"},
+	})
+	if err != nil {
+		t.Fatalf("register with valid token: %v", err)
+	}
+	if session.Name() != "alice" {
+		t.Errorf("got name %q, want alice", session.Name())
+	}
+}
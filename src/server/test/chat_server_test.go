@@ -3,41 +3,61 @@ package test
 import (
 	"chat/server/internal/app"
 	"chat/server/internal/model"
+	"errors"
 	"testing"
 )
 
-func TestChatServer_BroadcastMessage(t *testing.T) {
-	mock := &MockTransport{}
-	server := app.NewChatServer(mock, "localhost:1234")
+func TestChatServer_StartAllTransports(t *testing.T) {
+	tcpMock := &MockTransport{}
+	wsMock := &MockTransport{}
+	server := app.NewChatServer()
+	server.AddTransport(tcpMock, "localhost:1234")
+	server.AddTransport(wsMock, "localhost:1235")
 
-	msg := model.IncomingMessage{From: "alice", Text: "hello"}
-	err := server.BroadcastMessage(msg)
-	if err != nil {
+	if err := server.Start(); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if len(mock.BroadcastCalls) != 1 {
-		t.Fatalf("expected 1 broadcast call, got %d", len(mock.BroadcastCalls))
+	if len(tcpMock.StartCalls) != 1 || tcpMock.StartCalls[0] != "localhost:1234" {
+		t.Errorf("tcp start calls mismatch: %v", tcpMock.StartCalls)
+	}
+	if len(wsMock.StartCalls) != 1 || wsMock.StartCalls[0] != "localhost:1235" {
+		t.Errorf("ws start calls mismatch: %v", wsMock.StartCalls)
+	}
+}
+
+func TestChatServer_StartError(t *testing.T) {
+	startErr := errors.New("address in use")
+	server := app.NewChatServer()
+	server.AddTransport(&MockTransport{StartFunc: func(string) error { return startErr }}, "localhost:1234")
+
+	if err := server.Start(); !errors.Is(err, startErr) {
+		t.Fatalf("expected start error, got %v", err)
 	}
-	if mock.BroadcastCalls[0] != msg {
-		t.Errorf("broadcast call mismatch: want %+v, got %+v", msg, mock.BroadcastCalls[0])
+}
+
+func TestChatServer_StartWithoutTransports(t *testing.T) {
+	if err := app.NewChatServer().Start(); err == nil {
+		t.Fatal("expected error for server without transports")
 	}
 }
 
-func TestChatServer_SendPrivateMessage(t *testing.T) {
+func TestChatServer_StopDisconnectsSessions(t *testing.T) {
 	mock := &MockTransport{}
-	server := app.NewChatServer(mock, "localhost:1234")
+	server := app.NewChatServer()
+	server.AddTransport(mock, "localhost:1234")
 
-	msg := model.IncomingMessage{From: "bob", Text: "secret"}
-	err := server.SendPrivateMessage(msg)
-	if err != nil {
+	conn := &MockConn{Addr: "1.1.1.1:1"}
+	session := server.Hub().Connect(conn)
+	server.Hub().Register(session, "alice", model.Credentials{})
+
+	if err := server.Stop(); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-
-	if len(mock.PrivateCalls) != 1 {
-		t.Fatalf("expected 1 private call, got %d", len(mock.PrivateCalls))
+	if mock.StopCalls != 1 {
+		t.Errorf("expected 1 stop call, got %d", mock.StopCalls)
 	}
-	if mock.PrivateCalls[0] != msg {
-		t.Errorf("private call mismatch: want %+v, got %+v", msg, mock.PrivateCalls[0])
+	if !conn.Closed() {
+		t.Error("expected session connection to be closed")
 	}
 }
@@ -0,0 +1,260 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	"errors"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func allCodecs(t testing.TB) []protocol.Codec {
+	t.Helper()
+	var codecs []protocol.Codec
+	for _, name := range protocol.Codecs() {
+		c, err := protocol.CodecByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		codecs = append(codecs, c)
+	}
+	return codecs
+}
+
+func FuzzCodecs_RoundTrip(f *testing.F) {
+	f.Add("broadcast", "alice", "hello", "#general", uint64(7), 0, false)
+	f.Add("history", "bob", "line\nbreak \"quoted\"", "", uint64(0), 50, true)
+	f.Add("whisper", "", "", "", uint64(1<<40), -1, false)
+	f.Add("error", "ünïcödé", "\x00\xff", "#r", uint64(0), 1<<31, true)
+
+	codecs := allCodecs(f)
+	f.Fuzz(func(t *testing.T, typ, name, text, room string, id uint64, limit int, history bool) {
+		msg := protocol.New(typ, protocol.Payload{
+			Name:    name,
+			Text:    text,
+			Room:    room,
+			Rooms:   []string{room},
+			Limit:   limit,
+			History: history,
+		})
+		msg.ID = id
+		// Пустые списки с omitempty не передаются и возвращаются как nil
+		if room == "" {
+			msg.Payload.Rooms = nil
+		}
+
+		for _, c := range codecs {
+			// encoding/json заменяет неверный UTF-8 на U+FFFD
+			if !c.Binary() && !(utf8.ValidString(typ) && utf8.ValidString(name) && utf8.ValidString(text) && utf8.ValidString(room)) {
+				continue
+			}
+
+			data, err := c.Marshal(msg)
+			if err != nil {
+				t.Fatalf("%s: marshal %+v: %v", c.Name(), msg, err)
+			}
+			got, err := c.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("%s: unmarshal %q: %v", c.Name(), data, err)
+			}
+			if !reflect.DeepEqual(msg, got) {
+				t.Fatalf("%s: round-trip mismatch:\nwant: %+v\ngot:  %+v", c.Name(), msg, got)
+			}
+
+			// Два кадра подряд в потоке читаются по отдельности
+			var stream bytes.Buffer
+			for range 2 {
+				if err := c.WriteFrame(&stream, data); err != nil {
+					t.Fatalf("%s: write frame: %v", c.Name(), err)
+				}
+			}
+			reader := bufio.NewReader(&stream)
+			for i := range 2 {
+				frame, err := c.ReadFrame(reader, protocol.DefaultMaxFrameSize)
+				if err != nil {
+					t.Fatalf("%s: read frame %d: %v", c.Name(), i, err)
+				}
+				if !bytes.Equal(frame, data) {
+					t.Fatalf("%s: frame %d:\nwant: %q\ngot:  %q", c.Name(), i, data, frame)
+				}
+			}
+			if _, err := c.ReadFrame(reader, protocol.DefaultMaxFrameSize); !errors.Is(err, io.EOF) {
+				t.Fatalf("%s: want EOF after the last frame, got %v", c.Name(), err)
+			}
+		}
+	})
+}
+
+// Произвольные байты от клиента не должны ронять разбор кадров
+func FuzzCodecs_ReadArbitrary(f *testing.F) {
+	f.Add([]byte(`{"v":1,"type":"who","payload":{}}` + "\n"))
+	f.Add([]byte{0, 0, 0, 3, 0x81, 0xa1, 'v'})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte("\n\n{\n"))
+
+	codecs := allCodecs(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, c := range codecs {
+			c.Unmarshal(data)
+			reader := bufio.NewReader(bytes.NewReader(data))
+			for {
+				frame, err := c.ReadFrame(reader, 64)
+				if errors.Is(err, protocol.ErrFrameTooLarge) {
+					continue
+				}
+				if err != nil {
+					break
+				}
+				if len(frame) > 64 {
+					t.Fatalf("%s: frame of %d bytes exceeds the limit", c.Name(), len(frame))
+				}
+				c.Unmarshal(frame)
+			}
+		}
+	})
+}
+
+func TestCodecs_ReadFrameTooLarge(t *testing.T) {
+	for _, c := range allCodecs(t) {
+		big, _ := c.Marshal(protocol.New(model.TypeBroadcast, protocol.Payload{Text: strings.Repeat("x", 100)}))
+		small, _ := c.Marshal(protocol.New(model.TypeWho, protocol.Payload{}))
+
+		var stream bytes.Buffer
+		c.WriteFrame(&stream, big)
+		c.WriteFrame(&stream, small)
+		reader := bufio.NewReaderSize(&stream, 16)
+
+		if _, err := c.ReadFrame(reader, 64); !errors.Is(err, protocol.ErrFrameTooLarge) {
+			t.Errorf("%s: want %v, got %v", c.Name(), protocol.ErrFrameTooLarge, err)
+		}
+		// Слишком большой кадр пропущен целиком, следующий читается
+		frame, err := c.ReadFrame(reader, 64)
+		if err != nil || !bytes.Equal(frame, small) {
+			t.Errorf("%s: want %q after the large frame, got %q, %v", c.Name(), small, frame, err)
+		}
+	}
+}
+
+func TestCodecs_MsgpackIsCompact(t *testing.T) {
+	msg := protocol.New(model.TypeBroadcast, protocol.Payload{Name: "alice", Text: "hello", Room: "#general"})
+	msg.ID = 42
+	jsonData, _ := protocol.JSON.Marshal(msg)
+	msgpack, _ := protocol.CodecByName(protocol.CodecMsgpack)
+	packed, _ := msgpack.Marshal(msg)
+	if len(packed) >= len(jsonData) {
+		t.Errorf("msgpack frame is %d bytes, json %d", len(packed), len(jsonData))
+	}
+}
+
+// negotiateCodec договаривается о кодеке в hello и переключает соединение
+func negotiateCodec(t *testing.T, conn frameConn, name string) {
+	t.Helper()
+	conn.send(t, protocol.New(protocol.TypeHello, protocol.Payload{
+		Versions:     protocol.SupportedVersions,
+		Capabilities: []string{protocol.CapPresence},
+		Codecs:       []string{name},
+	}))
+	reply := conn.receive(t)
+	if reply.Type != model.TypeHello || !slices.Equal(reply.Payload.Codecs, []string{name}) {
+		t.Fatalf("want hello with codec %s, got %+v", name, reply)
+	}
+	c, _ := protocol.CodecByName(name)
+	conn.use(c)
+}
+
+func TestTransports_NegotiateCodec(t *testing.T) {
+	for _, proto := range []string{"tcp", "udp", "http"} {
+		for _, name := range []string{protocol.CodecJSONLP, protocol.CodecMsgpack} {
+			t.Run(proto+"/"+name, func(t *testing.T) {
+				hub := app.NewHub()
+				// Текст упирается в размер кадра, а не в лимит сообщения
+				hub.SetMaxTextSize(protocol.DefaultMaxFrameSize)
+				dial, _ := startHubTransport(t, hub, proto)
+				alice := dial(t)
+				defer alice.close()
+				bob := dial(t)
+				defer bob.close()
+
+				// bob остаётся в JSON: кодек у каждого соединения свой
+				negotiateCodec(t, alice, name)
+				alice.send(t, protocol.New(model.TypeRegister, protocol.Payload{Name: "alice"}))
+				alice.send(t, protocol.New(model.TypeWho, protocol.Payload{Name: "alice"}))
+				receiveType(t, alice, model.TypeWho)
+				bob.send(t, protocol.New(model.TypeRegister, protocol.Payload{Name: "bob"}))
+				if got := receiveType(t, alice, model.TypeUserJoined); got.Payload.Name != "bob" {
+					t.Fatalf("want bob joined, got %+v", got)
+				}
+
+				// Кадр больше 64 КБ: раньше TCP упирался в предел bufio.Scanner
+				text := strings.Repeat("x", 100<<10)
+				if proto == "udp" {
+					text = "hello"
+				}
+				bob.send(t, protocol.New(model.TypeBroadcast, protocol.Payload{Name: "bob", Text: text}))
+				if got := receiveType(t, alice, model.TypeBroadcast); got.Payload.Text != text {
+					t.Fatalf("broadcast text of %d bytes arrived as %d bytes", len(text), len(got.Payload.Text))
+				}
+				alice.send(t, protocol.New(model.TypeWhisper, protocol.Payload{Name: "alice", Dst: "bob", Text: "hi"}))
+				if got := receiveType(t, bob, model.TypeWhisper); got.Payload.Text != "hi" {
+					t.Fatalf("want whisper, got %+v", got)
+				}
+			})
+		}
+	}
+}
+
+func TestTCP_FrameTooLargeKeepsConnection(t *testing.T) {
+	conn := startTransport(t, "tcp")(t)
+	defer conn.close()
+	negotiateCodec(t, conn, protocol.CodecJSONLP)
+
+	c := conn.(*tcpFrameConn)
+	huge := bytes.Repeat([]byte("x"), protocol.DefaultMaxFrameSize+1)
+	go c.codec.WriteFrame(c.conn, huge)
+	if got := conn.receive(t); got.Type != model.TypeMessageTooLarge {
+		t.Fatalf("want %s, got %+v", model.TypeMessageTooLarge, got)
+	}
+
+	conn.send(t, protocol.New(model.TypeRegister, protocol.Payload{Name: "alice"}))
+	conn.send(t, protocol.New(model.TypeWho, protocol.Payload{Name: "alice"}))
+	if got := receiveType(t, conn, model.TypeWho); !slices.Equal(got.Payload.Users, []string{"alice"}) {
+		t.Fatalf("want alice online, got %+v", got)
+	}
+}
+
+func TestHub_HelloRejectsDisabledCodec(t *testing.T) {
+	conn := startTransport(t, "tcp")(t)
+	defer conn.close()
+
+	conn.send(t, protocol.New(protocol.TypeHello, protocol.Payload{
+		Versions: protocol.SupportedVersions,
+		Codecs:   []string{"xml"},
+	}))
+	if got := conn.receive(t); got.Type != model.TypeHello || len(got.Payload.Codecs) != 0 {
+		t.Fatalf("want hello without codec, got %+v", got)
+	}
+	// Соединение осталось в JSON
+	conn.send(t, protocol.New(model.TypeWho, protocol.Payload{}))
+	if got := conn.receive(t); got.Type != model.TypeError {
+		t.Fatalf("want error for who before register, got %+v", got)
+	}
+}
+
+// receiveType пропускает кадры других типов
+func receiveType(t *testing.T, conn frameConn, typ string) protocol.Envelope {
+	t.Helper()
+	for range 10 {
+		if got := conn.receive(t); got.Type == typ {
+			return got
+		}
+	}
+	t.Fatalf("no %s frame", typ)
+	return protocol.Envelope{}
+}
@@ -0,0 +1,184 @@
+package test
+
+import (
+	"bytes"
+	"chat/config"
+	"chat/server/internal/cfg"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadServerFlags разбирает args как командную строку сервера с окружением env
+func loadServerFlags(t *testing.T, args []string, env map[string]string) (*cfg.Flag, *flag.FlagSet, bool, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	f, printConfig, err := cfg.LoadFlags(fs, args, lookup)
+	return f, fs, printConfig, err
+}
+
+func writeConfig(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFlags_Precedence(t *testing.T) {
+	files := map[string]string{
+		"chat.yaml": `
+listeners:
+  protocols: [tcp, udp]
+  port: 5000
+  http: 127.0.0.1:8080
+timeouts:
+  heartbeat-interval: 10s
+  shutdown: 3s
+limits:
+  send-queue: 32
+tls:
+  client-auth: false
+`,
+		"chat.toml": `
+[listeners]
+protocols = ["tcp", "udp"]
+port = 5000
+http = "127.0.0.1:8080"
+
+[timeouts]
+heartbeat-interval = "10s"
+shutdown = "3s"
+
+[limits]
+send-queue = 32
+
+[tls]
+client-auth = false
+`,
+	}
+	for name, data := range files {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, name, data)
+			env := map[string]string{
+				"CHAT_HEARTBEAT_INTERVAL": "20s",
+				"CHAT_PORT":               "6000",
+				"CHAT_SEND_QUEUE_POLICY":  "disconnect",
+			}
+			f, _, _, err := loadServerFlags(t, []string{"-config", path, "-port", "7000"}, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Флаг важнее окружения, окружение - файла, файл - значения по умолчанию
+			if f.Port != "7000" {
+				t.Errorf("port: want flag value 7000, got %s", f.Port)
+			}
+			if f.HeartbeatInterval != 20*time.Second {
+				t.Errorf("heartbeat interval: want env value 20s, got %s", f.HeartbeatInterval)
+			}
+			if f.ProtoType != "tcp,udp" || f.HTTPAddr != "127.0.0.1:8080" || f.ShutdownTimeout != 3*time.Second || f.SendQueue != 32 {
+				t.Errorf("file values not applied: %+v", f)
+			}
+			if f.SendQueuePolicy != "disconnect" {
+				t.Errorf("send queue policy: want env value disconnect, got %s", f.SendQueuePolicy)
+			}
+			if f.HistorySize != 1000 || f.WSPath != "/ws" {
+				t.Errorf("defaults changed: history size %d, ws path %s", f.HistorySize, f.WSPath)
+			}
+		})
+	}
+}
+
+func TestLoadFlags_ConfigFromEnvironment(t *testing.T) {
+	path := writeConfig(t, "chat.yml", "storage:\n  history: file\n  history-size: 50\n")
+	f, _, _, err := loadServerFlags(t, nil, map[string]string{config.EnvConfig: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.History != "file" || f.HistorySize != 50 {
+		t.Errorf("want history from %s, got %s %d", path, f.History, f.HistorySize)
+	}
+}
+
+func TestLoadFlags_Errors(t *testing.T) {
+	cases := []struct {
+		name string
+		file string // Имя и содержимое файла через перевод строки
+		env  map[string]string
+		want []string // Части текста ошибки
+	}{
+		{"unknown key", "chat.yaml\nlimits:\n  send-queu: 10\n", nil, []string{`unknown key "limits.send-queu"`}},
+		{"key outside section", "chat.yaml\nport: 4545\n", nil, []string{`unknown key "port"`}},
+		{"bad duration", "chat.yaml\ntimeouts:\n  shutdown: soon\n", nil, []string{"timeouts.shutdown", `"soon"`, "expected a duration"}},
+		{"bad integer", "chat.toml\n[limits]\nsend-queue = \"many\"\n", nil, []string{"limits.send-queue", "expected an integer"}},
+		{"bad bool", "chat.yaml\ntls:\n  client-auth: maybe\n", nil, []string{"tls.client-auth", "expected true or false"}},
+		{"nested list", "chat.yaml\nlisteners:\n  protocols: [[tcp]]\n", nil, []string{"listeners.protocols", "nested lists"}},
+		{"syntax", "chat.toml\n[limits\n", nil, []string{"chat.toml"}},
+		{"unsupported format", "chat.json\n{}\n", nil, []string{`unsupported format ".json"`}},
+		{"bad env", "", map[string]string{"CHAT_RESUME_GRACE": "forever"}, []string{"CHAT_RESUME_GRACE", "expected a duration"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var args []string
+			if c.file != "" {
+				name, data, _ := strings.Cut(c.file, "\n")
+				args = []string{"-config", writeConfig(t, name, data)}
+			}
+			_, _, _, err := loadServerFlags(t, args, c.env)
+			if err == nil {
+				t.Fatal("want error")
+			}
+			for _, part := range c.want {
+				if !strings.Contains(err.Error(), part) {
+					t.Errorf("error %q does not contain %q", err, part)
+				}
+			}
+		})
+	}
+
+	if _, _, _, err := loadServerFlags(t, []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, nil); err == nil {
+		t.Error("missing file: want error")
+	}
+}
+
+func TestPrintConfig_RoundTrip(t *testing.T) {
+	args := []string{"-p", "tcp,http", "-heartbeat-interval", "5s", "-api-token", "s3cret", "-tls-client-auth", "-print-config"}
+	f, fs, printConfig, err := loadServerFlags(t, args, nil)
+	if err != nil || !printConfig {
+		t.Fatalf("want -print-config, got %v, %v", printConfig, err)
+	}
+	var out bytes.Buffer
+	if err := config.Print(&out, fs, cfg.Keys); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "s3cret") {
+		t.Errorf("secret printed:\n%s", out.String())
+	}
+	for _, part := range []string{"listeners:\n", "  protocols: tcp,http\n", "  heartbeat-interval: 5s\n", "  client-auth: true\n"} {
+		if !strings.Contains(out.String(), part) {
+			t.Errorf("output does not contain %q:\n%s", part, out.String())
+		}
+	}
+
+	// Вывод - рабочий файл настроек с теми же значениями
+	path := writeConfig(t, "printed.yaml", out.String())
+	got, _, _, err := loadServerFlags(t, []string{"-config", path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.APIToken, f.APIToken = "", ""
+	if *got != *f {
+		t.Errorf("round trip:\n got %+v\nwant %+v", *got, *f)
+	}
+}
@@ -0,0 +1,142 @@
+package test
+
+import (
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestErrorMessage_Codes(t *testing.T) {
+	cases := []struct {
+		err      error
+		code     string
+		wantType string
+	}{
+		{app.ErrInvalidFrame, protocol.CodeBadFrame, model.TypeError},
+		{fmt.Errorf("%w: 2", app.ErrUnsupportedVersion), protocol.CodeUnsupportedVersion, model.TypeError},
+		{fmt.Errorf("%w: %q", app.ErrUnknownType, "dance"), protocol.CodeUnknownType, model.TypeError},
+		{fmt.Errorf("%w: limit 10 bytes", app.ErrMessageTooLarge), protocol.CodeMessageTooLarge, model.TypeMessageTooLarge},
+		{app.ErrRateLimited, protocol.CodeRateLimited, model.TypeRateLimited},
+		{app.ErrTextEmpty, protocol.CodeTextEmpty, model.TypeInvalidInput},
+		{app.ErrNameReserved, protocol.CodeNameReserved, model.TypeInvalidInput},
+		{app.ErrNameTaken, protocol.CodeNameTaken, model.TypeError},
+		{fmt.Errorf("%w: bad password", app.ErrAuthFailed), protocol.CodeAuthFailed, model.TypeAuthFailed},
+		{app.ErrNotRegistered, protocol.CodeNotRegistered, model.TypeError},
+		{fmt.Errorf("%w: bob", app.ErrUserNotFound), protocol.CodeUserNotFound, model.TypeError},
+		{app.ErrResumeFailed, protocol.CodeResumeFailed, model.TypeError},
+		{errors.New("disk on fire"), protocol.CodeInternal, model.TypeError},
+	}
+	for _, c := range cases {
+		msg := app.ErrorMessage(c.err)
+		if msg.Code != c.code || msg.Type != c.wantType || msg.Text != c.err.Error() {
+			t.Errorf("%v: got %s %s %q, want %s %s", c.err, msg.Type, msg.Code, msg.Text, c.wantType, c.code)
+		}
+		if got := app.ErrorCode(c.err); got != c.code {
+			t.Errorf("ErrorCode(%v) = %s, want %s", c.err, got, c.code)
+		}
+	}
+}
+
+func TestProtocol_ErrorFrameEncoding(t *testing.T) {
+	msg := protocol.New(protocol.TypeError, protocol.Payload{Text: "username already taken", Code: protocol.CodeNameTaken})
+	msg.Ref = "r1"
+	data, err := protocol.Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"v":1,"type":"error","ref":"r1","payload":{"text":"username already taken","code":"NAME_TAKEN"}}`
+	if string(data) != want {
+		t.Errorf("encoded frame:\nwant: %s\ngot:  %s", want, data)
+	}
+}
+
+func TestHub_ErrorEchoesRef(t *testing.T) {
+	hub := app.NewHub()
+	alice, aliceConn := connectAs(t, hub, "alice")
+
+	cases := []struct {
+		msg  model.IncomingMessage
+		code string
+	}{
+		{model.IncomingMessage{Type: model.TypeWhisper, Ref: "w1", To: "bob", Text: "hi"}, protocol.CodeUserNotFound},
+		{model.IncomingMessage{Type: model.TypeBroadcast, Ref: "b1", Room: "#dev", Text: "hi"}, protocol.CodeNotInRoom},
+		{model.IncomingMessage{Type: model.TypeBroadcast, Ref: "b2", From: "mallory", Text: "hi"}, protocol.CodeNameMismatch},
+		{model.IncomingMessage{Type: "dance", Ref: "d1"}, protocol.CodeUnknownType},
+		{model.IncomingMessage{Type: model.TypeWhisper, To: "alice"}, protocol.CodeTextEmpty},
+	}
+	for _, c := range cases {
+		aliceConn.Reset()
+		hub.Handle(alice, c.msg)
+		sent := aliceConn.Sent()
+		if len(sent) != 1 || sent[0].Code != c.code || sent[0].Ref != c.msg.Ref {
+			t.Errorf("%s: want %s with ref %q, got %+v", c.msg.Type, c.code, c.msg.Ref, sent)
+		}
+	}
+
+	// Ответ без ошибки ref не несёт
+	aliceConn.Reset()
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeWho, Ref: "q1"})
+	if got := messagesOfType(aliceConn, model.TypeWho); len(got) != 1 || got[0].Ref != "" {
+		t.Errorf("who reply: got %+v", got)
+	}
+}
+
+func TestTransports_ErrorCodes(t *testing.T) {
+	for _, proto := range []string{"tcp", "udp", "http", "sse", "poll"} {
+		t.Run(proto, func(t *testing.T) {
+			dial := startTransport(t, proto)
+			alice := dial(t)
+			defer alice.close()
+			bob := dial(t)
+			defer bob.close()
+
+			who := protocol.New(protocol.TypeWho, protocol.Payload{})
+			who.Ref = "q1"
+			alice.send(t, who)
+			if got := alice.receive(t); got.Payload.Code != protocol.CodeNotRegistered || got.Ref != "q1" {
+				t.Fatalf("want %s with ref q1, got %+v", protocol.CodeNotRegistered, got)
+			}
+
+			alice.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "alice"}))
+			alice.send(t, protocol.New(protocol.TypeWho, protocol.Payload{}))
+			receiveType(t, alice, model.TypeWho) // Имя уже занято
+			register := protocol.New(protocol.TypeRegister, protocol.Payload{Name: "alice"})
+			register.Ref = "reg-7"
+			bob.send(t, register)
+			got := bob.receive(t)
+			if !got.IsError() || got.Payload.Code != protocol.CodeNameTaken || got.Ref != "reg-7" {
+				t.Fatalf("want %s with ref reg-7, got %+v", protocol.CodeNameTaken, got)
+			}
+		})
+	}
+}
+
+func TestAPI_ErrorCodes(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	base := startAPI(t, hub, testAPIToken)
+	connectAs(t, hub, "alice")
+
+	cases := []struct {
+		token string
+		body  string
+		code  string
+	}{
+		{"wrong", `{"name":"ci","text":"hi"}`, protocol.CodeAuthFailed},
+		{testAPIToken, `{"name":`, protocol.CodeBadFrame},
+		{testAPIToken, `{"name":"al ice","text":"hi"}`, protocol.CodeNameInvalid},
+		{testAPIToken, `{"name":"ci","dst":"bob","text":"hi"}`, protocol.CodeUserNotFound},
+		{testAPIToken, `{"name":"ci","text":""}`, protocol.CodeTextEmpty},
+	}
+	for _, c := range cases {
+		var reply struct{ Error, Code string }
+		status := apiRequest(t, "POST", base+"/api/messages", c.token, c.body, &reply)
+		if status < http.StatusBadRequest || reply.Code != c.code {
+			t.Errorf("%s: want %s, got %d %+v", c.body, c.code, status, reply)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package test
+
+import (
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	httptransport "chat/server/internal/transport/http"
+	"chat/server/internal/transport/tcp"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pingerConn - соединение со своим механизмом ping, как у WebSocket
+type pingerConn struct {
+	MockConn
+	pings atomic.Int32
+}
+
+func (c *pingerConn) Ping() error {
+	c.pings.Add(1)
+	return nil
+}
+
+func TestHub_PingGetsPong(t *testing.T) {
+	hub := app.NewHub()
+	conn := &MockConn{}
+	session := hub.Connect(conn)
+
+	// Проверка связи не требует регистрации
+	if err := hub.Handle(session, model.IncomingMessage{Type: model.TypePing}); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+	if err := hub.Handle(session, model.IncomingMessage{Type: model.TypePong}); err != nil {
+		t.Fatalf("pong: %v", err)
+	}
+	sent := conn.Sent()
+	if len(sent) != 1 || sent[0].Type != model.TypePong {
+		t.Fatalf("want a single pong, got %+v", sent)
+	}
+}
+
+func TestHub_HeartbeatEvictsSilentSession(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	_, aliceConn := connectAs(t, hub, "alice")
+	bob, bobConn := connectAs(t, hub, "bob")
+	hub.StartHeartbeat(20*time.Millisecond, 80*time.Millisecond)
+
+	// bob отвечает на ping, alice молчит
+	deadline := time.Now().Add(2 * time.Second)
+	for !aliceConn.Closed() && time.Now().Before(deadline) {
+		hub.Handle(bob, model.IncomingMessage{Type: model.TypePong})
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if !aliceConn.Closed() {
+		t.Fatal("silent session was not evicted")
+	}
+	if len(messagesOfType(aliceConn, model.TypePing)) == 0 {
+		t.Error("alice was evicted without being pinged")
+	}
+	if bobConn.Closed() {
+		t.Error("bob answered pings but was evicted")
+	}
+	want := model.OutgoingMessage{Type: model.TypeUserLeft, Name: "alice", Reason: model.LeftTimeout}
+	if got := messagesOfType(bobConn, model.TypeUserLeft); len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+		t.Errorf("bob: want %+v, got %+v", want, got)
+	}
+}
+
+func TestHub_HeartbeatUsesTransportPing(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	conn := &pingerConn{}
+	hub.Connect(conn)
+	hub.StartHeartbeat(10*time.Millisecond, 50*time.Millisecond)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !conn.Closed() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !conn.Closed() {
+		t.Fatal("silent unregistered session was not evicted")
+	}
+	if conn.pings.Load() == 0 {
+		t.Error("transport ping was not used")
+	}
+	if got := messagesOfType(&conn.MockConn, model.TypePing); len(got) != 0 {
+		t.Errorf("ping frames sent to a connection with its own ping: %+v", got)
+	}
+}
+
+func TestHeartbeat_TCPClientAnswersPing(t *testing.T) {
+	hub := app.NewHub()
+	hub.StartHeartbeat(30*time.Millisecond, 120*time.Millisecond)
+	addr := freeAddr(t, "tcp")
+	tr := tcp.NewTCPTransport(hub)
+	go tr.Start(addr)
+	t.Cleanup(func() {
+		tr.Stop()
+		hub.Close()
+	})
+	dial := func() (frameConn, error) { return dialFrameConn("tcp", addr) }
+
+	alice := dialReady(t, dial)
+	defer alice.close()
+	alice.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "alice"}))
+	bob := dialReady(t, dial)
+	defer bob.close()
+	bob.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "bob"}))
+
+	pinged := false
+	for {
+		frame := alice.receive(t)
+		switch frame.Type {
+		case model.TypePing:
+			pinged = true
+			alice.send(t, protocol.New(protocol.TypePong, protocol.Payload{}))
+		case model.TypeUserLeft:
+			if frame.Payload.Name != "bob" || frame.Payload.Reason != model.LeftTimeout {
+				t.Fatalf("got %v, want bob left by timeout", frame)
+			}
+			if !pinged {
+				t.Error("alice was never pinged")
+			}
+			return
+		}
+	}
+}
+
+func TestHeartbeat_WebSocketControlPing(t *testing.T) {
+	hub := app.NewHub()
+	hub.StartHeartbeat(30*time.Millisecond, 120*time.Millisecond)
+	addr := freeAddr(t, "tcp")
+	tr := httptransport.NewHTTPTransport(hub)
+	go tr.Start(addr)
+	t.Cleanup(func() {
+		tr.Stop()
+		hub.Close()
+	})
+	dial := func() (frameConn, error) { return dialFrameConn("http", addr) }
+
+	// alice читает соединение, и gorilla/websocket сама отвечает на ping
+	alice := dialReady(t, dial).(*wsFrameConn)
+	defer alice.close()
+	alice.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "alice"}))
+
+	// bob получает ping, но не отвечает
+	bob := dialReady(t, dial).(*wsFrameConn)
+	defer bob.close()
+	var bobPings atomic.Int32
+	bob.ws.SetPingHandler(func(string) error {
+		bobPings.Add(1)
+		return nil
+	})
+	bob.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "bob"}))
+	bobClosed := make(chan struct{})
+	go func() {
+		defer close(bobClosed)
+		for {
+			if _, _, err := bob.ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		frame := alice.receive(t)
+		if frame.Type == model.TypePing {
+			t.Fatalf("WebSocket clients should get control pings, got %v", frame)
+		}
+		if frame.Type == model.TypeUserLeft {
+			if frame.Payload.Name != "bob" || frame.Payload.Reason != model.LeftTimeout {
+				t.Fatalf("got %v, want bob left by timeout", frame)
+			}
+			break
+		}
+	}
+	select {
+	case <-bobClosed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("bob's connection was not closed")
+	}
+	if bobPings.Load() == 0 {
+		t.Error("bob received no control pings")
+	}
+}
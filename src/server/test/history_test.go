@@ -0,0 +1,88 @@
+package test
+
+import (
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func historyTexts(conn *MockConn) []string {
+	var res []string
+	for _, msg := range conn.Sent() {
+		if msg.History {
+			res = append(res, msg.Text)
+		}
+	}
+	return res
+}
+
+func TestHub_HistoryGlobal(t *testing.T) {
+	hub := app.NewHub()
+	alice, _ := connectAs(t, hub, "alice")
+	for _, text := range []string{"one", "two", "three"} {
+		hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: text})
+	}
+
+	late, lateConn := connectAs(t, hub, "late")
+	if err := hub.Handle(late, model.IncomingMessage{Type: model.TypeHistory, Limit: 2}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := historyTexts(lateConn); !reflect.DeepEqual(got, []string{"two", "three"}) {
+		t.Errorf("got %v, want [two three]", got)
+	}
+	for _, msg := range lateConn.Sent() {
+		if msg.ID == 0 || msg.Time == "" {
+			t.Errorf("history message without server ID or time: %+v", msg)
+		}
+	}
+}
+
+func TestHub_HistoryConversation(t *testing.T) {
+	hub := app.NewHub()
+	alice, _ := connectAs(t, hub, "alice")
+	bob, _ := connectAs(t, hub, "bob")
+	connectAs(t, hub, "carol")
+
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeWhisper, To: "bob", Text: "hi bob"})
+	hub.Handle(bob, model.IncomingMessage{Type: model.TypeWhisper, To: "alice", Text: "hi alice"})
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeWhisper, To: "carol", Text: "hi carol"})
+
+	hub.Disconnect(bob)
+	bob, bobConn := connectAs(t, hub, "bob")
+	hub.Handle(bob, model.IncomingMessage{Type: model.TypeHistory, To: "alice"})
+
+	if got := historyTexts(bobConn); !reflect.DeepEqual(got, []string{"hi bob", "hi alice"}) {
+		t.Errorf("got %v, want [hi bob hi alice]", got)
+	}
+}
+
+func TestHub_HistoryReplayedOnJoin(t *testing.T) {
+	hub := app.NewHub()
+	alice, _ := connectAs(t, hub, "alice")
+	join(t, hub, alice, "#dev")
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Room: "#dev", Text: "build is red"})
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: "global"})
+
+	bob, bobConn := connectAs(t, hub, "bob")
+	join(t, hub, bob, "#dev")
+
+	if got := historyTexts(bobConn); !reflect.DeepEqual(got, []string{"build is red"}) {
+		t.Errorf("got %v, want [build is red]", got)
+	}
+}
+
+func TestHub_HistoryRoomRequiresMembership(t *testing.T) {
+	hub := app.NewHub()
+	alice, _ := connectAs(t, hub, "alice")
+	anon := hub.Connect(&MockConn{})
+
+	if err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeHistory, Room: "#dev"}); !errors.Is(err, app.ErrNotInRoom) {
+		t.Errorf("expected ErrNotInRoom, got %v", err)
+	}
+	if err := hub.Handle(anon, model.IncomingMessage{Type: model.TypeHistory}); !errors.Is(err, app.ErrNotRegistered) {
+		t.Errorf("expected ErrNotRegistered, got %v", err)
+	}
+}
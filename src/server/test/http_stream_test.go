@@ -0,0 +1,314 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseFrameConn - клиент SSE: кадры сервера приходят в поток /events,
+// свои кадры он отправляет запросами POST /send
+type sseFrameConn struct {
+	base string
+	id   string
+	resp *http.Response
+	in   chan []byte
+}
+
+func dialSSE(addr string) (*sseFrameConn, error) {
+	resp, err := http.Get("http://" + addr + "/events")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	c := &sseFrameConn{base: "http://" + addr, resp: resp, in: make(chan []byte, 1024)}
+	reader := bufio.NewReader(resp.Body)
+	event, data, err := readEvent(reader)
+	if err != nil || event != "open" {
+		resp.Body.Close()
+		return nil, fmt.Errorf("want open event, got %q %q: %v", event, data, err)
+	}
+	var open struct{ Conn string }
+	if err := json.Unmarshal(data, &open); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	c.id = open.Conn
+
+	go func() {
+		defer close(c.in)
+		for {
+			event, data, err := readEvent(reader)
+			if err != nil {
+				return
+			}
+			if event == "" {
+				c.in <- data
+			}
+		}
+	}()
+	return c, nil
+}
+
+// readEvent читает одно событие SSE, пропуская комментарии
+func readEvent(r *bufio.Reader) (event string, data []byte, err error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			if data != nil || event != "" {
+				return event, data, nil
+			}
+		case strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = append(data, strings.TrimPrefix(line, "data: ")...)
+		}
+	}
+}
+
+// postFrame отправляет кадр соединения SSE или long-poll
+func postFrame(base, id string, data []byte) error {
+	resp, err := http.Post(base+"/send?conn="+id, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("send: status %s", resp.Status)
+	}
+	return nil
+}
+
+func (c *sseFrameConn) send(t *testing.T, frame protocol.Envelope) {
+	t.Helper()
+	data, _ := protocol.Encode(frame)
+	if err := postFrame(c.base, c.id, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *sseFrameConn) receive(t *testing.T) protocol.Envelope {
+	t.Helper()
+	data, err := c.next(2 * time.Second)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return decodeFrame(t, protocol.JSON, data)
+}
+
+func (c *sseFrameConn) next(timeout time.Duration) ([]byte, error) {
+	select {
+	case data, ok := <-c.in:
+		if !ok {
+			return nil, errors.New("stream closed")
+		}
+		return data, nil
+	case <-time.After(timeout):
+		return nil, errors.New("timeout")
+	}
+}
+
+// use ничего не делает: SSE и long-poll работают только в JSON
+func (c *sseFrameConn) use(protocol.Codec) {}
+
+func (c *sseFrameConn) close() { c.resp.Body.Close() }
+
+// pollFrameConn - клиент long-poll: кадры сервера забирает запросами GET /poll
+type pollFrameConn struct {
+	base  string
+	id    string
+	queue []json.RawMessage
+}
+
+func dialPoll(addr string) (*pollFrameConn, error) {
+	c := &pollFrameConn{base: "http://" + addr}
+	resp, err := http.Post(c.base+"/poll", "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var open struct{ Conn string }
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&open); err != nil {
+		return nil, err
+	}
+	c.id = open.Conn
+	return c, nil
+}
+
+func (c *pollFrameConn) send(t *testing.T, frame protocol.Envelope) {
+	t.Helper()
+	data, _ := protocol.Encode(frame)
+	if err := postFrame(c.base, c.id, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *pollFrameConn) receive(t *testing.T) protocol.Envelope {
+	t.Helper()
+	data, err := c.next(2 * time.Second)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return decodeFrame(t, protocol.JSON, data)
+}
+
+func (c *pollFrameConn) next(timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	for len(c.queue) == 0 {
+		if time.Now().After(deadline) {
+			return nil, errors.New("timeout")
+		}
+		frames, err := c.poll(1)
+		if err != nil {
+			return nil, err
+		}
+		c.queue = frames
+	}
+	data := c.queue[0]
+	c.queue = c.queue[1:]
+	return data, nil
+}
+
+func (c *pollFrameConn) poll(timeout int) ([]json.RawMessage, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/poll?conn=%s&timeout=%d", c.base, c.id, timeout))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("poll: status %s", resp.Status)
+	}
+	var body struct{ Frames []json.RawMessage }
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	return body.Frames, err
+}
+
+func (c *pollFrameConn) use(protocol.Codec) {}
+
+func (c *pollFrameConn) close() {
+	req, _ := http.NewRequest(http.MethodDelete, c.base+"/poll?conn="+c.id, nil)
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+func TestHTTPStreams_ShareRoutingWithWebSocket(t *testing.T) {
+	dial := startTransport(t, "http")
+	ws := dial(t)
+	defer ws.close()
+	sse := dialReady(t, func() (frameConn, error) { return dialFrameConn("sse", wsAddr(t, ws)) })
+	defer sse.close()
+	poll := dialReady(t, func() (frameConn, error) { return dialFrameConn("poll", wsAddr(t, ws)) })
+	defer poll.close()
+
+	conns := []struct {
+		name string
+		conn frameConn
+	}{{"ws", ws}, {"sse", sse}, {"poll", poll}}
+	for _, c := range conns {
+		c.conn.send(t, protocol.New(model.TypeRegister, protocol.Payload{Name: c.name}))
+		c.conn.send(t, protocol.New(model.TypeWho, protocol.Payload{}))
+		receiveType(t, c.conn, model.TypeWho)
+	}
+
+	sse.send(t, protocol.New(model.TypeBroadcast, protocol.Payload{Text: "from sse"}))
+	for _, c := range conns {
+		if got := receiveType(t, c.conn, model.TypeBroadcast); got.Payload.Name != "sse" || got.Payload.Text != "from sse" {
+			t.Errorf("%s: got %+v", c.name, got)
+		}
+	}
+	ws.send(t, protocol.New(model.TypeWhisper, protocol.Payload{Dst: "poll", Text: "psst"}))
+	if got := receiveType(t, poll, model.TypeWhisper); got.Payload.Name != "ws" || got.Payload.Text != "psst" {
+		t.Errorf("poll: got %+v", got)
+	}
+
+	// Ошибка разбора кадра приходит в поток соединения
+	if err := postFrame("http://"+wsAddr(t, ws), sse.(*sseFrameConn).id, []byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+	if got := receiveType(t, sse, model.TypeError); got.Payload.Text != app.ErrInvalidFrame.Error() {
+		t.Errorf("sse: want invalid frame error, got %+v", got)
+	}
+
+	// Закрытый поток SSE отключает пользователя
+	sse.close()
+	if got := receiveType(t, ws, model.TypeUserLeft); got.Payload.Name != "sse" {
+		t.Errorf("ws: want sse left, got %+v", got)
+	}
+	poll.close()
+	if got := receiveType(t, ws, model.TypeUserLeft); got.Payload.Name != "poll" {
+		t.Errorf("ws: want poll left, got %+v", got)
+	}
+}
+
+func TestHTTPStreams_UnknownConnection(t *testing.T) {
+	conn := startTransport(t, "poll")(t)
+	defer conn.close()
+	base := conn.(*pollFrameConn).base
+
+	if err := postFrame(base, "missing", []byte(`{}`)); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("send: want 404, got %v", err)
+	}
+	resp, err := http.Get(base + "/poll?conn=missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("poll: want 404, got %s", resp.Status)
+	}
+}
+
+func TestHTTPStreams_EmptyPollReturnsAfterTimeout(t *testing.T) {
+	conn := startTransport(t, "poll")(t).(*pollFrameConn)
+	defer conn.close()
+
+	start := time.Now()
+	frames, err := conn.poll(0)
+	if err != nil || len(frames) != 0 {
+		t.Fatalf("want no frames, got %q, %v", frames, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("poll with timeout=0 took %s", elapsed)
+	}
+}
+
+func TestHTTPStreams_HelloKeepsJSON(t *testing.T) {
+	conn := startTransport(t, "sse")(t)
+	defer conn.close()
+
+	conn.send(t, protocol.New(protocol.TypeHello, protocol.Payload{
+		Versions: protocol.SupportedVersions,
+		Codecs:   []string{protocol.CodecMsgpack},
+	}))
+	if got := conn.receive(t); got.Type != model.TypeHello || len(got.Payload.Codecs) != 0 {
+		t.Fatalf("want hello without codec, got %+v", got)
+	}
+}
+
+// wsAddr возвращает адрес сервера, к которому подключено WebSocket-соединение
+func wsAddr(t *testing.T, conn frameConn) string {
+	t.Helper()
+	return conn.(*wsFrameConn).ws.RemoteAddr().String()
+}
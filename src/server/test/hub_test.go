@@ -0,0 +1,255 @@
+package test
+
+import (
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func connectAs(t *testing.T, hub *app.Hub, name string) (*app.Session, *MockConn) {
+	t.Helper()
+	conn := &MockConn{Addr: name + ":1"}
+	session := hub.Connect(conn)
+	if err := hub.Handle(session, model.IncomingMessage{Type: model.TypeRegister, From: name}); err != nil {
+		t.Fatalf("register %s: %v", name, err)
+	}
+	return session, conn
+}
+
+// stripTime убирает назначенное сервером время, чтобы сообщения можно было сравнить
+func stripTime(t *testing.T, msgs []model.OutgoingMessage) []model.OutgoingMessage {
+	t.Helper()
+	res := make([]model.OutgoingMessage, len(msgs))
+	for i, msg := range msgs {
+		if (msg.Type == model.TypeBroadcast || msg.Type == model.TypeWhisper) && msg.Time == "" {
+			t.Errorf("message %+v has no server time", msg)
+		}
+		msg.Time = ""
+		res[i] = msg
+	}
+	return res
+}
+
+func TestHub_Register(t *testing.T) {
+	cases := []struct {
+		name    string
+		first   string
+		second  string
+		wantErr error
+	}{
+		{"empty name", "", "", app.ErrNameEmpty},
+		{"taken by other session", "alice", "alice", app.ErrNameTaken},
+		{"free name", "alice", "bob", nil},
+	}
+
+	for _, c := range cases {
+		hub := app.NewHub()
+		if c.first != "" {
+			connectAs(t, hub, c.first)
+		}
+
+		conn := &MockConn{}
+		session := hub.Connect(conn)
+		err := hub.Handle(session, model.IncomingMessage{Type: model.TypeRegister, From: c.second})
+		if !errors.Is(err, c.wantErr) {
+			t.Errorf("%s: got error %v, want %v", c.name, err, c.wantErr)
+		}
+
+		sent := conn.Sent()
+		if c.wantErr != nil && (len(sent) != 1 || !sent[0].IsError()) {
+			t.Errorf("%s: expected error frame, got %+v", c.name, sent)
+		}
+	}
+}
+
+func TestHub_RegisterTwice(t *testing.T) {
+	hub := app.NewHub()
+	session, _ := connectAs(t, hub, "alice")
+
+	if err := hub.Register(session, "alice", model.Credentials{}); err != nil {
+		t.Errorf("re-register with same name: unexpected error %v", err)
+	}
+	if err := hub.Register(session, "bob", model.Credentials{}); !errors.Is(err, app.ErrAlreadyRegistered) {
+		t.Errorf("expected ErrAlreadyRegistered, got %v", err)
+	}
+}
+
+func TestHub_UnregisteredCannotSend(t *testing.T) {
+	hub := app.NewHub()
+	connectAs(t, hub, "alice")
+	session := hub.Connect(&MockConn{})
+
+	for _, typ := range []string{model.TypeBroadcast, model.TypeWhisper} {
+		err := hub.Handle(session, model.IncomingMessage{Type: typ, To: "alice", Text: "hi"})
+		if !errors.Is(err, app.ErrNotRegistered) {
+			t.Errorf("%s: expected ErrNotRegistered, got %v", typ, err)
+		}
+	}
+}
+
+func TestHub_Broadcast(t *testing.T) {
+	hub := app.NewHub()
+	alice, aliceConn := connectAs(t, hub, "alice")
+	_, bobConn := connectAs(t, hub, "bob")
+	anonConn := &MockConn{}
+	hub.Connect(anonConn)
+	aliceConn.Reset()
+
+	err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: "hello", Time: "12:00"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := model.OutgoingMessage{ID: 1, Type: model.TypeBroadcast, Name: "alice", Text: "hello"}
+	for name, conn := range map[string]*MockConn{"alice": aliceConn, "bob": bobConn} {
+		sent := stripTime(t, conn.Sent())
+		if len(sent) != 1 || !reflect.DeepEqual(sent[0], want) {
+			t.Errorf("%s: want %+v, got %+v", name, want, sent)
+		}
+	}
+	if len(anonConn.Sent()) != 0 {
+		t.Errorf("unregistered session should not receive broadcasts, got %+v", anonConn.Sent())
+	}
+}
+
+func TestHub_Whisper(t *testing.T) {
+	hub := app.NewHub()
+	alice, aliceConn := connectAs(t, hub, "alice")
+	_, bobConn := connectAs(t, hub, "bob")
+	_, carolConn := connectAs(t, hub, "carol")
+	aliceConn.Reset()
+	bobConn.Reset()
+
+	err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeWhisper, To: "bob", Text: "secret"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := model.OutgoingMessage{ID: 1, Type: model.TypeWhisper, Name: "alice", Dst: "bob", Text: "secret", Private: true}
+	for name, conn := range map[string]*MockConn{"bob": bobConn, "alice (echo)": aliceConn} {
+		sent := stripTime(t, conn.Sent())
+		if len(sent) != 1 || !reflect.DeepEqual(sent[0], want) {
+			t.Errorf("%s: want %+v, got %+v", name, want, sent)
+		}
+	}
+	if len(carolConn.Sent()) != 0 {
+		t.Errorf("carol should not receive the whisper, got %+v", carolConn.Sent())
+	}
+}
+
+func TestHub_WhisperSelf(t *testing.T) {
+	hub := app.NewHub()
+	alice, aliceConn := connectAs(t, hub, "alice")
+
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeWhisper, To: "alice", Text: "note"})
+	if len(aliceConn.Sent()) != 1 {
+		t.Errorf("expected exactly one copy of a whisper to self, got %+v", aliceConn.Sent())
+	}
+}
+
+func TestHub_WhisperErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		to      string
+		wantErr error
+	}{
+		{"no destination", "", app.ErrNoDestination},
+		{"unknown user", "carol", app.ErrUserNotFound},
+	}
+
+	for _, c := range cases {
+		hub := app.NewHub()
+		alice, aliceConn := connectAs(t, hub, "alice")
+
+		err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeWhisper, To: c.to, Text: "?"})
+		if !errors.Is(err, c.wantErr) {
+			t.Errorf("%s: got error %v, want %v", c.name, err, c.wantErr)
+		}
+		sent := aliceConn.Sent()
+		if len(sent) != 1 || sent[0].Type != model.TypeError {
+			t.Errorf("%s: expected error frame, got %+v", c.name, sent)
+		}
+	}
+}
+
+func TestHub_UnknownType(t *testing.T) {
+	hub := app.NewHub()
+	alice, _ := connectAs(t, hub, "alice")
+
+	if err := hub.Handle(alice, model.IncomingMessage{Type: "dance"}); !errors.Is(err, app.ErrUnknownType) {
+		t.Errorf("expected ErrUnknownType, got %v", err)
+	}
+}
+
+func TestHub_ExitReleasesName(t *testing.T) {
+	hub := app.NewHub()
+	alice, aliceConn := connectAs(t, hub, "alice")
+
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeExit})
+	if !aliceConn.Closed() {
+		t.Error("expected connection to be closed on exit")
+	}
+	if err := hub.Register(alice, "alice", model.Credentials{}); !errors.Is(err, app.ErrSessionClosed) {
+		t.Errorf("closed session should not register again, got %v", err)
+	}
+
+	connectAs(t, hub, "alice")
+}
+
+func TestHub_ClosedSessionCannotSend(t *testing.T) {
+	hub := app.NewHub()
+	_, bobConn := connectAs(t, hub, "bob")
+	mallory, _ := connectAs(t, hub, "mallory")
+	alice, _ := connectAs(t, hub, "alice")
+	bobConn.Reset()
+
+	hub.SetBans([]string{"mallory"})
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeExit})
+
+	frames := []model.IncomingMessage{
+		{Type: model.TypeBroadcast, Text: "still here"},
+		{Type: model.TypeWhisper, To: "bob", Text: "psst"},
+		{Type: model.TypeWho},
+	}
+	for _, s := range []*app.Session{mallory, alice} {
+		for _, msg := range frames {
+			if err := hub.Handle(s, msg); !errors.Is(err, app.ErrSessionClosed) {
+				t.Errorf("%s after disconnect: got %v, want %v", msg.Type, err, app.ErrSessionClosed)
+			}
+		}
+	}
+	for _, typ := range []string{model.TypeBroadcast, model.TypeWhisper} {
+		if got := messagesOfType(bobConn, typ); len(got) != 0 {
+			t.Errorf("bob should not receive %s from closed sessions, got %+v", typ, got)
+		}
+	}
+}
+
+func TestHub_RegisterAcknowledgedByRef(t *testing.T) {
+	hub := app.NewHub()
+	conn := &MockConn{Addr: "alice:1"}
+	alice := hub.Connect(conn)
+	if err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeRegister, Ref: "7", From: "alice"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	got := messagesOfType(conn, model.TypeRegistered)
+	if len(got) != 1 || got[0].Ref != "7" || got[0].Name != "alice" {
+		t.Errorf("want registered with ref 7, got %+v", conn.Sent())
+	}
+
+	// Отказ подтверждения не получает, только ошибку с тем же ref
+	conn = &MockConn{Addr: "alice:2"}
+	other := hub.Connect(conn)
+	hub.Handle(other, model.IncomingMessage{Type: model.TypeRegister, Ref: "1", From: "alice"})
+	if got := messagesOfType(conn, model.TypeRegistered); len(got) != 0 {
+		t.Errorf("rejected register acknowledged: %+v", got)
+	}
+
+	// Без ref подтверждение не отправляется
+	_, bobConn := connectAs(t, hub, "bob")
+	if got := messagesOfType(bobConn, model.TypeRegistered); len(got) != 0 {
+		t.Errorf("register without ref acknowledged: %+v", got)
+	}
+}
@@ -0,0 +1,414 @@
+package test
+
+import (
+	"bufio"
+	"chat/protocol"
+	"chat/rudp"
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	httptransport "chat/server/internal/transport/http"
+	"chat/server/internal/transport/tcp"
+	"chat/server/internal/transport/udp"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHub_RejectsForeignName(t *testing.T) {
+	cases := []struct {
+		name string
+		msg  model.IncomingMessage
+	}{
+		{"broadcast", model.IncomingMessage{Type: model.TypeBroadcast, From: "bob", Text: "hi"}},
+		{"whisper", model.IncomingMessage{Type: model.TypeWhisper, From: "bob", To: "bob", Text: "hi"}},
+		{"join", model.IncomingMessage{Type: model.TypeJoin, From: "bob", Room: "#go"}},
+		{"history", model.IncomingMessage{Type: model.TypeHistory, From: "bob"}},
+		{"exit", model.IncomingMessage{Type: model.TypeExit, From: "bob"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			hub := app.NewHub()
+			alice, aliceConn := connectAs(t, hub, "alice")
+			_, bobConn := connectAs(t, hub, "bob")
+			aliceConn.Reset()
+
+			err := hub.Handle(alice, tc.msg)
+			if !errors.Is(err, app.ErrNameMismatch) {
+				t.Fatalf("got %v, want %v", err, app.ErrNameMismatch)
+			}
+			if sent := aliceConn.Sent(); len(sent) != 1 || sent[0].Type != model.TypeError {
+				t.Errorf("alice: want one error frame, got %+v", sent)
+			}
+			if sent := bobConn.Sent(); len(sent) != 0 {
+				t.Errorf("bob received %+v", sent)
+			}
+			if aliceConn.Closed() {
+				t.Error("alice disconnected by a frame with a foreign name")
+			}
+		})
+	}
+}
+
+func TestHub_StampsSessionName(t *testing.T) {
+	hub := app.NewHub()
+	alice, _ := connectAs(t, hub, "alice")
+	_, bobConn := connectAs(t, hub, "bob")
+
+	// Кадр без имени принимается, отправителем становится имя сессии
+	if err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: "hi"}); err != nil {
+		t.Fatalf("broadcast: %v", err)
+	}
+	sent := bobConn.Sent()
+	if len(sent) != 1 || sent[0].Name != "alice" {
+		t.Fatalf("want broadcast from alice, got %+v", sent)
+	}
+}
+
+// frameConn - клиент одного из транспортов, обменивающийся кадрами протокола
+type frameConn interface {
+	send(t *testing.T, frame protocol.Envelope)
+	receive(t *testing.T) protocol.Envelope
+	// use переключает соединение на кодек, о котором договорились в hello
+	use(codec protocol.Codec)
+	close()
+}
+
+type tcpFrameConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	codec  protocol.Codec
+}
+
+func newTCPFrameConn(conn net.Conn) *tcpFrameConn {
+	return &tcpFrameConn{conn: conn, reader: bufio.NewReader(conn), codec: protocol.JSON}
+}
+
+func (c *tcpFrameConn) send(t *testing.T, frame protocol.Envelope) {
+	t.Helper()
+	data := encodeFrame(t, c.codec, frame)
+	if err := c.codec.WriteFrame(c.conn, data); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func (c *tcpFrameConn) receive(t *testing.T) protocol.Envelope {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	data, err := c.codec.ReadFrame(c.reader, protocol.DefaultMaxFrameSize)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return decodeFrame(t, c.codec, data)
+}
+
+func (c *tcpFrameConn) use(codec protocol.Codec) { c.codec = codec }
+
+func (c *tcpFrameConn) close() { c.conn.Close() }
+
+// udpFrameConn - UDP-клиент поверх надёжного канала, как у настоящего клиента
+type udpFrameConn struct {
+	conn   *rudp.Conn
+	server net.Addr
+	in     chan []byte
+	codec  protocol.Codec
+}
+
+func newUDPFrameConn(pc net.PacketConn, server net.Addr) *udpFrameConn {
+	c := &udpFrameConn{
+		conn:   rudp.NewConn(pc, rudp.Config{RTO: 20 * time.Millisecond}),
+		server: server,
+		in:     make(chan []byte, 1024),
+		codec:  protocol.JSON,
+	}
+	go c.conn.Serve(func(_ net.Addr, payload []byte) { c.in <- payload })
+	return c
+}
+
+func (c *udpFrameConn) send(t *testing.T, frame protocol.Envelope) {
+	t.Helper()
+	data := encodeFrame(t, c.codec, frame)
+	if err := c.conn.Send(c.server, data); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func (c *udpFrameConn) receive(t *testing.T) protocol.Envelope {
+	t.Helper()
+	return c.receiveWithin(t, 2*time.Second)
+}
+
+func (c *udpFrameConn) receiveWithin(t *testing.T, timeout time.Duration) protocol.Envelope {
+	t.Helper()
+	data, err := c.next(timeout)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return decodeFrame(t, c.codec, data)
+}
+
+func (c *udpFrameConn) use(codec protocol.Codec) { c.codec = codec }
+
+func (c *udpFrameConn) next(timeout time.Duration) ([]byte, error) {
+	select {
+	case data := <-c.in:
+		return data, nil
+	case <-time.After(timeout):
+		return nil, errors.New("timeout")
+	}
+}
+
+func (c *udpFrameConn) close() { c.conn.Close() }
+
+type wsFrameConn struct {
+	ws    *websocket.Conn
+	codec protocol.Codec
+}
+
+func newWSFrameConn(ws *websocket.Conn) *wsFrameConn {
+	return &wsFrameConn{ws: ws, codec: protocol.JSON}
+}
+
+func (c *wsFrameConn) send(t *testing.T, frame protocol.Envelope) {
+	t.Helper()
+	messageType := websocket.TextMessage
+	if c.codec.Binary() {
+		messageType = websocket.BinaryMessage
+	}
+	if err := c.ws.WriteMessage(messageType, encodeFrame(t, c.codec, frame)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func (c *wsFrameConn) receive(t *testing.T) protocol.Envelope {
+	t.Helper()
+	c.ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	messageType, data, err := c.ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if binary := messageType == websocket.BinaryMessage; binary != c.codec.Binary() {
+		t.Fatalf("%s frame arrived as binary=%v", c.codec.Name(), binary)
+	}
+	return decodeFrame(t, c.codec, data)
+}
+
+func (c *wsFrameConn) use(codec protocol.Codec) { c.codec = codec }
+
+func (c *wsFrameConn) close() { c.ws.Close() }
+
+func encodeFrame(t *testing.T, codec protocol.Codec, frame protocol.Envelope) []byte {
+	t.Helper()
+	data, err := codec.Marshal(frame)
+	if err != nil {
+		t.Fatalf("encode %+v: %v", frame, err)
+	}
+	return data
+}
+
+func decodeFrame(t *testing.T, codec protocol.Codec, data []byte) protocol.Envelope {
+	t.Helper()
+	frame, err := codec.Unmarshal(data)
+	if err != nil {
+		t.Fatalf("decode %q: %v", data, err)
+	}
+	return frame
+}
+
+// freeAddr возвращает свободный локальный адрес для транспорта
+func freeAddr(t *testing.T, network string) string {
+	t.Helper()
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.LocalAddr().String()
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startTransport запускает транспорт на свободном адресе и возвращает функцию
+// подключения клиента
+func startTransport(t *testing.T, proto string) func(t *testing.T) frameConn {
+	t.Helper()
+	dial, _ := startHubTransport(t, app.NewHub(), proto)
+	return dial
+}
+
+// startHubTransport запускает транспорт хаба hub и возвращает функцию
+// подключения клиента и адрес транспорта
+func startHubTransport(t *testing.T, hub *app.Hub, proto string) (func(t *testing.T) frameConn, string) {
+	t.Helper()
+	var tr app.Transport
+	var addr string
+	switch proto {
+	case "tcp":
+		tr, addr = tcp.NewTCPTransport(hub), freeAddr(t, "tcp")
+	case "udp":
+		tr, addr = udp.NewUDPTransport(hub), freeAddr(t, "udp")
+	case "http", "sse", "poll":
+		tr, addr = httptransport.NewHTTPTransport(hub), freeAddr(t, "tcp")
+	}
+
+	go tr.Start(addr)
+	t.Cleanup(func() {
+		tr.Stop()
+		hub.Close()
+	})
+
+	return func(t *testing.T) frameConn {
+		t.Helper()
+		return dialReady(t, func() (frameConn, error) { return dialFrameConn(proto, addr) })
+	}, addr
+}
+
+// dialReady подключается, пока только что запущенный сервер не ответит
+func dialReady(t *testing.T, dial func() (frameConn, error)) frameConn {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := dial()
+		if err == nil {
+			if err = probe(conn); err == nil {
+				return conn
+			}
+			conn.close()
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dial: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// probe убеждается, что сервер отвечает: запрос rooms до регистрации
+// возвращает ошибку. UDP-датаграммы до запуска сервера теряются.
+func probe(conn frameConn) error {
+	data, _ := protocol.Encode(protocol.New(model.TypeRooms, protocol.Payload{}))
+	switch c := conn.(type) {
+	case *tcpFrameConn:
+		if _, err := c.conn.Write(append(data, '\n')); err != nil {
+			return err
+		}
+		c.conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if _, err := c.reader.ReadBytes('\n'); err != nil {
+			return err
+		}
+	case *udpFrameConn:
+		if err := c.conn.Send(c.server, data); err != nil {
+			return err
+		}
+		if _, err := c.next(200 * time.Millisecond); err != nil {
+			return err
+		}
+	case *wsFrameConn:
+		if err := c.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+			return err
+		}
+		c.ws.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if _, _, err := c.ws.ReadMessage(); err != nil {
+			return err
+		}
+	case *sseFrameConn:
+		if err := postFrame(c.base, c.id, data); err != nil {
+			return err
+		}
+		if _, err := c.next(200 * time.Millisecond); err != nil {
+			return err
+		}
+	case *pollFrameConn:
+		if err := postFrame(c.base, c.id, data); err != nil {
+			return err
+		}
+		if _, err := c.next(time.Second); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dialFrameConn(proto, addr string) (frameConn, error) {
+	switch proto {
+	case "tcp":
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return newTCPFrameConn(conn), nil
+	case "udp":
+		raddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return nil, err
+		}
+		return newUDPFrameConn(conn, raddr), nil
+	case "sse":
+		return dialSSE(addr)
+	case "poll":
+		return dialPoll(addr)
+	default:
+		return dialWSPath(addr, "/ws")
+	}
+}
+
+// dialWSPath подключается к WebSocket сервера addr по пути path
+func dialWSPath(addr, path string) (frameConn, error) {
+	ws, _, err := websocket.DefaultDialer.Dial("ws://"+addr+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return newWSFrameConn(ws), nil
+}
+
+func TestTransports_RejectImpersonation(t *testing.T) {
+	for _, proto := range []string{"tcp", "udp", "http", "sse", "poll"} {
+		t.Run(proto, func(t *testing.T) {
+			dial := startTransport(t, proto)
+
+			bob := dial(t)
+			defer bob.close()
+			bob.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "bob"}))
+			// Ответ на rooms подтверждает, что регистрация обработана
+			bob.send(t, protocol.New(protocol.TypeRooms, protocol.Payload{Name: "bob"}))
+			if frame := bob.receive(t); frame.Type != model.TypeRooms {
+				t.Fatalf("bob: want rooms, got %v", frame)
+			}
+
+			alice := dial(t)
+			defer alice.close()
+			alice.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "alice"}))
+			alice.send(t, protocol.New(protocol.TypeBroadcast, protocol.Payload{Name: "bob", Text: "I am bob"}))
+			if frame := bob.receive(t); frame.Type != model.TypeUserJoined || frame.Payload.Name != "alice" {
+				t.Fatalf("bob: want user_joined alice, got %v", frame)
+			}
+
+			frame := alice.receive(t)
+			if frame.Type != model.TypeError {
+				t.Fatalf("want error frame, got %v", frame)
+			}
+			if text := frame.Payload.Text; !strings.HasPrefix(text, app.ErrNameMismatch.Error()) {
+				t.Fatalf("got error %q, want %q", text, app.ErrNameMismatch)
+			}
+
+			// Сообщение под своим именем проходит, и bob видит настоящего отправителя
+			alice.send(t, protocol.New(protocol.TypeBroadcast, protocol.Payload{Name: "alice", Text: "hello"}))
+			frame = bob.receive(t)
+			if frame.Type != model.TypeBroadcast || frame.Payload.Name != "alice" || frame.Payload.Text != "hello" {
+				t.Fatalf("bob got %v, want broadcast from alice", frame)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package test
+
+import (
+	"chat/server/internal/model"
+	"sync"
+)
+
+type MockConn struct {
+	Addr  string
+	Block chan struct{} // Если задан, Send ждёт его закрытия
+
+	mu     sync.Mutex
+	sent   []model.OutgoingMessage
+	closed bool
+}
+
+func (m *MockConn) Send(msg model.OutgoingMessage) error {
+	if m.Block != nil {
+		<-m.Block
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *MockConn) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+func (m *MockConn) RemoteAddr() string {
+	return m.Addr
+}
+
+func (m *MockConn) Sent() []model.OutgoingMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]model.OutgoingMessage(nil), m.sent...)
+}
+
+// Reset забывает отправленные кадры, например события о входе участников теста
+func (m *MockConn) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = nil
+}
+
+func (m *MockConn) Closed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed
+}
@@ -0,0 +1,164 @@
+package test
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// LossyNetwork - сеть в памяти процесса, которая теряет, дублирует и
+// переставляет датаграммы
+type LossyNetwork struct {
+	DropRate float64       // Доля потерянных датаграмм
+	DupRate  float64       // Доля датаграмм, доставленных дважды
+	MaxDelay time.Duration // Случайная задержка меняет порядок доставки
+
+	mu    sync.Mutex
+	rnd   *rand.Rand
+	conns map[string]*LossyPacketConn
+}
+
+func NewLossyNetwork(seed int64) *LossyNetwork {
+	return &LossyNetwork{
+		rnd:   rand.New(rand.NewSource(seed)),
+		conns: make(map[string]*LossyPacketConn),
+	}
+}
+
+// Listen создаёт точку сети с указанным адресом
+func (n *LossyNetwork) Listen(name string) *LossyPacketConn {
+	c := &LossyPacketConn{
+		network: n,
+		addr:    memAddr(name),
+		in:      make(chan memPacket, 4096),
+		done:    make(chan struct{}),
+	}
+	n.mu.Lock()
+	n.conns[name] = c
+	n.mu.Unlock()
+	return c
+}
+
+// fate решает судьбу датаграммы: сколько копий доставить и с какой задержкой
+func (n *LossyNetwork) fate() (copies int, delays []time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.rnd.Float64() < n.DropRate {
+		return 0, nil
+	}
+	copies = 1
+	if n.rnd.Float64() < n.DupRate {
+		copies = 2
+	}
+	for i := 0; i < copies; i++ {
+		var d time.Duration
+		if n.MaxDelay > 0 {
+			d = time.Duration(n.rnd.Int63n(int64(n.MaxDelay)))
+		}
+		delays = append(delays, d)
+	}
+	return copies, delays
+}
+
+func (n *LossyNetwork) deliver(to string, pkt memPacket) {
+	n.mu.Lock()
+	dst, ok := n.conns[to]
+	n.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case dst.in <- pkt:
+	case <-dst.done:
+	default:
+		// Переполненный буфер приёма - тоже потеря
+	}
+}
+
+type memAddr string
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string  { return string(a) }
+
+type memPacket struct {
+	from memAddr
+	data []byte
+}
+
+// LossyPacketConn - net.PacketConn поверх LossyNetwork
+type LossyPacketConn struct {
+	network *LossyNetwork
+	addr    memAddr
+	in      chan memPacket
+	done    chan struct{}
+	once    sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (c *LossyPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case pkt := <-c.in:
+		return copy(b, pkt.data), pkt.from, nil
+	case <-c.done:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *LossyPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	copies, delays := c.network.fate()
+	for i := 0; i < copies; i++ {
+		pkt := memPacket{from: c.addr, data: append([]byte(nil), b...)}
+		if delays[i] == 0 {
+			c.network.deliver(addr.String(), pkt)
+			continue
+		}
+		time.AfterFunc(delays[i], func() { c.network.deliver(addr.String(), pkt) })
+	}
+	return len(b), nil
+}
+
+func (c *LossyPacketConn) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+		c.network.mu.Lock()
+		delete(c.network.conns, string(c.addr))
+		c.network.mu.Unlock()
+	})
+	return nil
+}
+
+func (c *LossyPacketConn) LocalAddr() net.Addr { return c.addr }
+
+func (c *LossyPacketConn) SetDeadline(t time.Time) error { return c.SetReadDeadline(t) }
+
+func (c *LossyPacketConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *LossyPacketConn) SetWriteDeadline(time.Time) error { return nil }
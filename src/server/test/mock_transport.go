@@ -1,42 +1,37 @@
 package test
 
-import (
-	"chat/server/internal/model"
-)
+import "context"
 
 type MockTransport struct {
-	StartFunc              func(address string) error
-	StopFunc               func() error
-	BroadcastMessageFunc   func(msg model.IncomingMessage) error
-	SendPrivateMessageFunc func(msg model.IncomingMessage) error
+	StartFunc    func(address string) error
+	ShutdownFunc func(ctx context.Context) error
+	StopFunc     func() error
 
-	BroadcastCalls []model.IncomingMessage
-	PrivateCalls   []model.IncomingMessage
+	StartCalls    []string
+	ShutdownCalls int
+	StopCalls     int
 }
 
 func (m *MockTransport) Start(address string) error {
+	m.StartCalls = append(m.StartCalls, address)
 	if m.StartFunc != nil {
 		return m.StartFunc(address)
 	}
 	return nil
 }
-func (m *MockTransport) Stop() error {
-	if m.StopFunc != nil {
-		return m.StopFunc()
-	}
-	return nil
-}
-func (m *MockTransport) BroadcastMessage(msg model.IncomingMessage) error {
-	m.BroadcastCalls = append(m.BroadcastCalls, msg)
-	if m.BroadcastMessageFunc != nil {
-		return m.BroadcastMessageFunc(msg)
+
+func (m *MockTransport) Shutdown(ctx context.Context) error {
+	m.ShutdownCalls++
+	if m.ShutdownFunc != nil {
+		return m.ShutdownFunc(ctx)
 	}
 	return nil
 }
-func (m *MockTransport) SendPrivateMessage(msg model.IncomingMessage) error {
-	m.PrivateCalls = append(m.PrivateCalls, msg)
-	if m.SendPrivateMessageFunc != nil {
-		return m.SendPrivateMessageFunc(msg)
+
+func (m *MockTransport) Stop() error {
+	m.StopCalls++
+	if m.StopFunc != nil {
+		return m.StopFunc()
 	}
 	return nil
 }
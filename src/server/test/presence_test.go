@@ -0,0 +1,136 @@
+package test
+
+import (
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestHub_UserJoinedAnnouncedToOthers(t *testing.T) {
+	hub := app.NewHub()
+	_, aliceConn := connectAs(t, hub, "alice")
+	_, bobConn := connectAs(t, hub, "bob")
+	anonConn := &MockConn{}
+	hub.Connect(anonConn)
+	connectAs(t, hub, "carol")
+
+	want := []model.OutgoingMessage{
+		{Type: model.TypeUserJoined, Name: "bob"},
+		{Type: model.TypeUserJoined, Name: "carol"},
+	}
+	if got := messagesOfType(aliceConn, model.TypeUserJoined); !reflect.DeepEqual(got, want) {
+		t.Errorf("alice: want %+v, got %+v", want, got)
+	}
+	if got := messagesOfType(bobConn, model.TypeUserJoined); len(got) != 1 || got[0].Name != "carol" {
+		t.Errorf("bob should see only carol joining, got %+v", got)
+	}
+	if got := anonConn.Sent(); len(got) != 0 {
+		t.Errorf("unregistered session should not receive presence events, got %+v", got)
+	}
+}
+
+func TestHub_RegisterSameNameAgainIsSilent(t *testing.T) {
+	hub := app.NewHub()
+	alice, _ := connectAs(t, hub, "alice")
+	_, bobConn := connectAs(t, hub, "bob")
+
+	if err := hub.Register(alice, "alice", model.Credentials{}); err != nil {
+		t.Fatalf("re-register: %v", err)
+	}
+	if got := messagesOfType(bobConn, model.TypeUserJoined); len(got) != 0 {
+		t.Errorf("repeated register should not be announced, got %+v", got)
+	}
+}
+
+func TestHub_UserLeftReasons(t *testing.T) {
+	cases := []struct {
+		name   string
+		leave  func(hub *app.Hub, s *app.Session)
+		reason string
+	}{
+		{"exit", func(hub *app.Hub, s *app.Session) {
+			hub.Handle(s, model.IncomingMessage{Type: model.TypeExit})
+		}, model.LeftExit},
+		{"disconnect", func(hub *app.Hub, s *app.Session) { hub.Disconnect(s) }, model.LeftDisconnect},
+		{"timeout", func(hub *app.Hub, s *app.Session) { hub.Evict(s, model.LeftTimeout) }, model.LeftTimeout},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			hub := app.NewHub()
+			_, aliceConn := connectAs(t, hub, "alice")
+			bob, bobConn := connectAs(t, hub, "bob")
+
+			tc.leave(hub, bob)
+			tc.leave(hub, bob) // Повторное отключение не дублирует событие
+
+			want := []model.OutgoingMessage{{Type: model.TypeUserLeft, Name: "bob", Reason: tc.reason}}
+			if got := messagesOfType(aliceConn, model.TypeUserLeft); !reflect.DeepEqual(got, want) {
+				t.Errorf("alice: want %+v, got %+v", want, got)
+			}
+			if got := messagesOfType(bobConn, model.TypeUserLeft); len(got) != 0 {
+				t.Errorf("bob should not receive own user_left, got %+v", got)
+			}
+		})
+	}
+}
+
+func TestHub_AnonymousDisconnectIsSilent(t *testing.T) {
+	hub := app.NewHub()
+	_, aliceConn := connectAs(t, hub, "alice")
+	hub.Disconnect(hub.Connect(&MockConn{}))
+
+	if got := messagesOfType(aliceConn, model.TypeUserLeft); len(got) != 0 {
+		t.Errorf("unregistered session left silently, got %+v", got)
+	}
+}
+
+func TestHub_NoUserLeftOnShutdown(t *testing.T) {
+	hub := app.NewHub()
+	_, aliceConn := connectAs(t, hub, "alice")
+	_, bobConn := connectAs(t, hub, "bob")
+
+	if err := hub.Shutdown(context.Background(), "bye"); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	for name, conn := range map[string]*MockConn{"alice": aliceConn, "bob": bobConn} {
+		if got := messagesOfType(conn, model.TypeUserLeft); len(got) != 0 {
+			t.Errorf("%s: server_shutdown replaces user_left, got %+v", name, got)
+		}
+	}
+}
+
+func TestHub_Who(t *testing.T) {
+	hub := app.NewHub()
+	connectAs(t, hub, "carol")
+	alice, aliceConn := connectAs(t, hub, "alice")
+	bob, _ := connectAs(t, hub, "bob")
+	hub.Connect(&MockConn{})
+	hub.Disconnect(bob)
+
+	if err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeWho}); err != nil {
+		t.Fatalf("who: %v", err)
+	}
+	got := messagesOfType(aliceConn, model.TypeWho)
+	want := []string{"alice", "carol"}
+	if len(got) != 1 || !reflect.DeepEqual(got[0].Users, want) {
+		t.Errorf("want roster %v, got %+v", want, got)
+	}
+}
+
+func TestHub_WhoRequiresRegistration(t *testing.T) {
+	hub := app.NewHub()
+	connectAs(t, hub, "alice")
+	conn := &MockConn{}
+	session := hub.Connect(conn)
+
+	err := hub.Handle(session, model.IncomingMessage{Type: model.TypeWho})
+	if !errors.Is(err, app.ErrNotRegistered) {
+		t.Fatalf("got %v, want %v", err, app.ErrNotRegistered)
+	}
+	if got := messagesOfType(conn, model.TypeWho); len(got) != 0 {
+		t.Errorf("roster leaked to unregistered session: %+v", got)
+	}
+}
@@ -0,0 +1,142 @@
+package test
+
+import (
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProtocol_EncodeDecode(t *testing.T) {
+	msg := protocol.New(protocol.TypeWhisper, protocol.Payload{
+		Name: "alice",
+		Text: "hello",
+		Dst:  "bob",
+	})
+	msg.ID = 7
+	msg.Time = "2024/05/01 12:00:00"
+
+	data, err := protocol.Encode(msg)
+	if err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	want := `{"v":1,"type":"whisper","id":7,"ts":"2024/05/01 12:00:00","payload":{"name":"alice","text":"hello","dst":"bob"}}`
+	if string(data) != want {
+		t.Errorf("encoded frame:\nwant: %s\ngot:  %s", want, data)
+	}
+
+	got, err := protocol.Decode(data)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if !reflect.DeepEqual(msg, got) {
+		t.Errorf("encode/decode mismatch:\nwant: %+v\ngot: %+v", msg, got)
+	}
+}
+
+func TestProtocol_DecodeRejectsUnknownVersion(t *testing.T) {
+	for _, data := range []string{
+		`{"type":"broadcast","payload":{"text":"no version"}}`,
+		`{"v":99,"type":"broadcast","payload":{"text":"from the future"}}`,
+	} {
+		if _, err := protocol.Decode([]byte(data)); !errors.Is(err, protocol.ErrUnsupportedVersion) {
+			t.Errorf("Decode(%s) = %v, want %v", data, err, protocol.ErrUnsupportedVersion)
+		}
+	}
+	if _, err := protocol.Decode([]byte(`{"v":1,`)); err == nil || errors.Is(err, protocol.ErrUnsupportedVersion) {
+		t.Errorf("broken JSON: got %v, want a syntax error", err)
+	}
+}
+
+func TestProtocol_Negotiate(t *testing.T) {
+	tests := []struct {
+		offered []int
+		want    int
+		ok      bool
+	}{
+		{[]int{1}, 1, true},
+		{[]int{3, 1, 2}, 1, true},
+		{[]int{2, 3}, 0, false},
+		{nil, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := protocol.Negotiate(tt.offered)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Negotiate(%v) = %d, %v; want %d, %v", tt.offered, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestHub_HelloNegotiatesCapabilities(t *testing.T) {
+	hub := app.NewHub()
+	hub.SetResume(time.Minute, 0)
+	defer hub.Close()
+
+	conn := &MockConn{Addr: "quiet:1"}
+	quiet := hub.Connect(conn)
+	err := hub.Handle(quiet, model.IncomingMessage{
+		Type:         model.TypeHello,
+		Versions:     []int{1, 2},
+		Capabilities: []string{"unknown", protocol.CapResume},
+	})
+	if err != nil {
+		t.Fatalf("hello: %v", err)
+	}
+	want := model.OutgoingMessage{Type: model.TypeHello, Version: 1, Capabilities: []string{protocol.CapResume}}
+	if sent := conn.Sent(); len(sent) != 1 || !reflect.DeepEqual(sent[0], want) {
+		t.Fatalf("want %+v, got %+v", want, sent)
+	}
+
+	// Без presence события о других пользователях не приходят, resume
+	// согласован - токен выдаётся
+	conn.Reset()
+	hub.Handle(quiet, model.IncomingMessage{Type: model.TypeRegister, From: "quiet"})
+	connectAs(t, hub, "alice")
+	if got := messagesOfType(conn, model.TypeUserJoined); len(got) != 0 {
+		t.Errorf("client without presence got %+v", got)
+	}
+	if got := messagesOfType(conn, model.TypeSession); len(got) != 1 {
+		t.Errorf("want session token, got %+v", conn.Sent())
+	}
+}
+
+func TestHub_HelloWithoutCommonVersion(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+
+	conn := &MockConn{Addr: "old:1"}
+	s := hub.Connect(conn)
+	err := hub.Handle(s, model.IncomingMessage{Type: model.TypeHello, Versions: []int{0}})
+	if !errors.Is(err, app.ErrUnsupportedVersion) {
+		t.Fatalf("want %v, got %v", app.ErrUnsupportedVersion, err)
+	}
+	if sent := conn.Sent(); len(sent) != 1 || sent[0].Type != model.TypeError {
+		t.Fatalf("want error frame, got %+v", sent)
+	}
+}
+
+func TestTransports_RejectUnknownVersion(t *testing.T) {
+	for _, proto := range []string{"tcp", "udp", "http", "sse", "poll"} {
+		t.Run(proto, func(t *testing.T) {
+			conn := startTransport(t, proto)(t)
+			defer conn.close()
+
+			frame := protocol.New(protocol.TypeRegister, protocol.Payload{Name: "alice"})
+			frame.Version = 99
+			conn.send(t, frame)
+			got := conn.receive(t)
+			if got.Type != model.TypeError || !strings.HasPrefix(got.Payload.Text, protocol.ErrUnsupportedVersion.Error()) {
+				t.Fatalf("want unsupported version error, got %+v", got)
+			}
+
+			conn.send(t, protocol.New(protocol.TypeHello, protocol.Payload{Versions: []int{1}}))
+			if got := conn.receive(t); got.Type != model.TypeHello || !reflect.DeepEqual(got.Payload.Versions, []int{1}) {
+				t.Fatalf("want hello with version 1, got %+v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package test
+
+import (
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestParseRate(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    app.Rate
+		wantErr bool
+	}{
+		{"", app.Rate{}, false},
+		{"off", app.Rate{}, false},
+		{"10/s", app.Rate{PerSecond: 10, Burst: 10}, false},
+		{"10/s:20", app.Rate{PerSecond: 10, Burst: 20}, false},
+		{"120/m", app.Rate{PerSecond: 2, Burst: 120}, false},
+		{"3600/h:5", app.Rate{PerSecond: 1, Burst: 5}, false},
+		{"10", app.Rate{}, true},
+		{"10/d", app.Rate{}, true},
+		{"-1/s", app.Rate{}, true},
+		{"10/s:0", app.Rate{}, true},
+	}
+	for _, c := range cases {
+		got, err := app.ParseRate(c.in)
+		if (err != nil) != c.wantErr || got != c.want {
+			t.Errorf("ParseRate(%q) = %+v, %v; want %+v, error %v", c.in, got, err, c.want, c.wantErr)
+		}
+	}
+}
+
+// twoPerHour - два события подряд, дальше лимит исчерпан на время теста
+var twoPerHour = app.Rate{PerSecond: 1.0 / 3600, Burst: 2}
+
+func TestHub_RateLimitPenalties(t *testing.T) {
+	cases := []struct {
+		penalty       app.Penalty
+		delivered     int  // Сколько broadcast получит bob
+		wantErr       bool // Третье сообщение отклонено
+		disconnected  bool
+		mutedAfterAll bool // Следующее сообщение отклоняется как muted
+	}{
+		{app.PenaltyDrop, 2, true, false, false},
+		{app.PenaltyWarn, 3, false, false, false},
+		{app.PenaltyMute, 2, true, false, true},
+		{app.PenaltyDisconnect, 2, true, true, false},
+	}
+	for _, c := range cases {
+		t.Run(c.penalty.String(), func(t *testing.T) {
+			hub := app.NewHub()
+			hub.SetRateLimits(app.RateLimits{Messages: twoPerHour, Penalty: c.penalty, MuteFor: time.Hour})
+			alice, aliceConn := connectAs(t, hub, "alice")
+			_, bobConn := connectAs(t, hub, "bob")
+
+			var err error
+			for i := 0; i < 3; i++ {
+				err = hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: "spam"})
+			}
+			if gotErr := errors.Is(err, app.ErrRateLimited); gotErr != c.wantErr {
+				t.Errorf("third message: got %v, want rate limited %v", err, c.wantErr)
+			}
+			if got := len(messagesOfType(bobConn, model.TypeBroadcast)); got != c.delivered {
+				t.Errorf("bob got %d broadcasts, want %d", got, c.delivered)
+			}
+			limited := messagesOfType(aliceConn, model.TypeRateLimited)
+			if len(limited) != 1 || !strings.Contains(limited[0].Text, "messages per session") {
+				t.Errorf("alice should get one rate_limited frame, got %+v", aliceConn.Sent())
+			}
+			if aliceConn.Closed() != c.disconnected {
+				t.Errorf("alice closed %v, want %v", aliceConn.Closed(), c.disconnected)
+			}
+			if c.disconnected {
+				left := messagesOfType(bobConn, model.TypeUserLeft)
+				if len(left) != 1 || left[0].Reason != model.LeftRateLimit {
+					t.Errorf("bob should see alice leave with reason rate_limited, got %+v", left)
+				}
+			}
+			if c.mutedAfterAll {
+				err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeWhisper, To: "bob", Text: "psst"})
+				if !errors.Is(err, app.ErrRateLimited) || !strings.Contains(err.Error(), "muted") {
+					t.Errorf("muted alice sent a whisper: %v", err)
+				}
+				// Остальные кадры молчание не затрагивает
+				if err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeWho}); err != nil {
+					t.Errorf("who while muted: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestHub_RateLimitDisconnectIsNotResumable(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	hub.SetResume(time.Minute, 10)
+	hub.SetRateLimits(app.RateLimits{Messages: twoPerHour, Penalty: app.PenaltyDisconnect})
+	alice, _, token := registerWithToken(t, hub, "alice")
+	_, bobConn, _ := registerWithToken(t, hub, "bob")
+
+	for i := 0; i < 3; i++ {
+		hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: "spam"})
+	}
+	// Имя освобождается сразу, а не после -resume-grace
+	if got := messagesOfType(bobConn, model.TypeUserLeft); len(got) != 1 || got[0].Reason != model.LeftRateLimit {
+		t.Fatalf("bob should see alice leave at once, got %+v", got)
+	}
+	if err := hub.Resume(hub.Connect(&MockConn{}), "alice", token); !errors.Is(err, app.ErrResumeFailed) {
+		t.Errorf("resume after a rate limit disconnect: got %v, want %v", err, app.ErrResumeFailed)
+	}
+}
+
+func TestHub_RateLimitPerAddress(t *testing.T) {
+	hub := app.NewHub()
+	hub.SetRateLimits(app.RateLimits{MessagesPerIP: twoPerHour})
+	register := func(name, addr string) *app.Session {
+		s := hub.Connect(&MockConn{Addr: addr})
+		if err := hub.Handle(s, model.IncomingMessage{Type: model.TypeRegister, From: name}); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	alice := register("alice", "10.0.0.1:1000")
+	alice2 := register("alice2", "10.0.0.1:1001")
+	carol := register("carol", "10.0.0.2:1000")
+
+	send := func(s *app.Session) error {
+		return hub.Handle(s, model.IncomingMessage{Type: model.TypeBroadcast, Text: "hi"})
+	}
+	if err := send(alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := send(alice2); err != nil {
+		t.Fatal(err)
+	}
+	if err := send(alice2); !errors.Is(err, app.ErrRateLimited) || !strings.Contains(err.Error(), "per address") {
+		t.Errorf("third message from 10.0.0.1: got %v, want address limit", err)
+	}
+	if err := send(carol); err != nil {
+		t.Errorf("another address should not be limited: %v", err)
+	}
+}
+
+func TestHub_RateLimitRegistrations(t *testing.T) {
+	hub := app.NewHub()
+	hub.SetRateLimits(app.RateLimits{Registrations: twoPerHour, Penalty: app.PenaltyMute})
+	connectAs(t, hub, "alice")
+
+	conn := &MockConn{}
+	s := hub.Connect(conn)
+	for i := 0; i < 2; i++ {
+		err := hub.Handle(s, model.IncomingMessage{Type: model.TypeRegister, From: "alice"})
+		if !errors.Is(err, app.ErrNameTaken) {
+			t.Fatalf("attempt %d: got %v, want %v", i, err, app.ErrNameTaken)
+		}
+	}
+	err := hub.Handle(s, model.IncomingMessage{Type: model.TypeRegister, From: "bob"})
+	if !errors.Is(err, app.ErrRateLimited) {
+		t.Fatalf("third attempt: got %v, want %v", err, app.ErrRateLimited)
+	}
+	if s.Name() != "" {
+		t.Error("rate limited registration should not take the name")
+	}
+}
+
+func TestHub_RateLimitsReload(t *testing.T) {
+	hub := app.NewHub()
+	hub.SetRateLimits(app.RateLimits{Messages: twoPerHour})
+	alice, _ := connectAs(t, hub, "alice")
+	connectAs(t, hub, "bob")
+	for i := 0; i < 2; i++ {
+		hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: "hi"})
+	}
+	if err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: "hi"}); !errors.Is(err, app.ErrRateLimited) {
+		t.Fatalf("got %v, want %v", err, app.ErrRateLimited)
+	}
+
+	// Новый лимит начинает счёт заново, отключённый - не ограничивает
+	hub.SetRateLimits(app.RateLimits{})
+	for i := 0; i < 10; i++ {
+		if err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: "hi"}); err != nil {
+			t.Fatalf("message %d after lifting the limit: %v", i, err)
+		}
+	}
+}
+
+func TestTransports_RateLimitedFrame(t *testing.T) {
+	for _, proto := range []string{"tcp", "udp", "http", "sse", "poll"} {
+		t.Run(proto, func(t *testing.T) {
+			hub := app.NewHub()
+			hub.SetRateLimits(app.RateLimits{Messages: twoPerHour})
+			dial, _ := startHubTransport(t, hub, proto)
+			alice := dial(t)
+			defer alice.close()
+
+			alice.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "alice"}))
+			for i := 0; i < 3; i++ {
+				alice.send(t, protocol.New(protocol.TypeBroadcast, protocol.Payload{Text: "spam"}))
+			}
+			for i := 0; i < 2; i++ {
+				if frame := alice.receive(t); frame.Type != model.TypeBroadcast {
+					t.Fatalf("want broadcast echo, got %v", frame)
+				}
+			}
+			frame := alice.receive(t)
+			if frame.Type != model.TypeRateLimited || !frame.IsError() {
+				t.Fatalf("want rate_limited, got %v", frame)
+			}
+		})
+	}
+}
+
+func TestTransports_RateLimitConnections(t *testing.T) {
+	limits := app.RateLimits{ConnectionsPerIP: twoPerHour}
+	for _, proto := range []string{"tcp", "udp"} {
+		t.Run(proto, func(t *testing.T) {
+			hub := app.NewHub()
+			dial, addr := startHubTransport(t, hub, proto)
+			// Лимит после запуска: dial ждёт сервер, переподключаясь
+			dial(t).close()
+			hub.SetRateLimits(limits)
+
+			for i := 0; ; i++ {
+				conn, err := dialFrameConn(proto, addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				conn.send(t, protocol.New(protocol.TypeRooms, protocol.Payload{}))
+				frame := conn.receive(t)
+				conn.close()
+				if frame.Type == model.TypeRateLimited {
+					if i != 2 {
+						t.Errorf("connection %d rejected, want the third", i+1)
+					}
+					return
+				}
+				if i == 2 {
+					t.Fatalf("third connection accepted: %v", frame)
+				}
+			}
+		})
+	}
+
+	t.Run("http", func(t *testing.T) {
+		hub := app.NewHub()
+		dial, addr := startHubTransport(t, hub, "http")
+		dial(t).close()
+		hub.SetRateLimits(limits)
+
+		for i := 0; i < 2; i++ {
+			ws, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+			if err != nil {
+				t.Fatalf("connection %d: %v", i+1, err)
+			}
+			ws.Close()
+		}
+		_, resp, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("third websocket: got %v, want 429", err)
+		}
+		resp, err = http.Get("http://" + addr + "/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("sse: got %d, want 429", resp.StatusCode)
+		}
+	})
+}
+
+func TestAPI_RateLimited(t *testing.T) {
+	cases := []struct {
+		name   string
+		limits app.RateLimits
+		socket bool // Сообщение сокета с того же IP тратит общий лимит
+		want   string
+	}{
+		{"per session", app.RateLimits{Messages: twoPerHour}, false, "api messages per address"},
+		{"per address", app.RateLimits{MessagesPerIP: twoPerHour}, true, "messages per address"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			hub := app.NewHub()
+			defer hub.Close()
+			base := startAPI(t, hub, testAPIToken)
+			hub.SetRateLimits(c.limits)
+
+			posts := 2
+			if c.socket {
+				alice := hub.Connect(&MockConn{Addr: "127.0.0.1:5000"})
+				hub.Handle(alice, model.IncomingMessage{Type: model.TypeRegister, From: "alice"})
+				if err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: "hi"}); err != nil {
+					t.Fatal(err)
+				}
+				posts = 1
+			}
+			for i := 0; i < posts; i++ {
+				if status := apiRequest(t, "POST", base+"/api/messages", testAPIToken, `{"name":"ci","text":"hi"}`, nil); status != http.StatusCreated {
+					t.Fatalf("post %d: status %d", i+1, status)
+				}
+			}
+			var reply struct{ Error, Code string }
+			status := apiRequest(t, "POST", base+"/api/messages", testAPIToken, `{"name":"ci","text":"hi"}`, &reply)
+			if status != http.StatusTooManyRequests || reply.Code != protocol.CodeRateLimited || !strings.Contains(reply.Error, c.want) {
+				t.Errorf("want 429 %s, got %d %+v", c.want, status, reply)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package test
+
+import (
+	"chat/server/internal/app"
+	"chat/server/internal/cfg"
+	"chat/server/internal/logging"
+	"chat/server/internal/model"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestHub_Bans(t *testing.T) {
+	hub := app.NewHub()
+	hub.SetResume(app.DefaultResumeGrace, 0)
+	_, aliceConn := connectAs(t, hub, "alice")
+	_, bobConn := connectAs(t, hub, "bob")
+	aliceConn.Reset()
+
+	hub.SetBans([]string{"mallory", "bob"})
+
+	if !bobConn.Closed() {
+		t.Fatal("banned bob should be disconnected")
+	}
+	if got := messagesOfType(bobConn, model.TypeError); len(got) != 1 {
+		t.Errorf("bob should be told about the ban, got %+v", bobConn.Sent())
+	}
+	left := messagesOfType(aliceConn, model.TypeUserLeft)
+	if len(left) != 1 || left[0].Name != "bob" || left[0].Reason != model.LeftBanned {
+		t.Errorf("alice should see bob leave with reason banned, got %+v", aliceConn.Sent())
+	}
+	if aliceConn.Closed() {
+		t.Error("alice is not banned and should stay connected")
+	}
+	if got := hub.Users(); !reflect.DeepEqual(got, []string{"alice"}) {
+		t.Errorf("online users: got %v, want [alice]", got)
+	}
+
+	s := hub.Connect(&MockConn{})
+	err := hub.Handle(s, model.IncomingMessage{Type: model.TypeRegister, From: "mallory"})
+	if !errors.Is(err, app.ErrBanned) {
+		t.Errorf("register banned name: got %v, want %v", err, app.ErrBanned)
+	}
+
+	hub.SetBans(nil)
+	connectAs(t, hub, "bob")
+}
+
+func TestHub_MOTD(t *testing.T) {
+	hub := app.NewHub()
+	_, aliceConn := connectAs(t, hub, "alice")
+	if got := messagesOfType(aliceConn, model.TypeMOTD); len(got) != 0 {
+		t.Errorf("no motd configured, got %+v", got)
+	}
+
+	hub.SetMOTD("maintenance at 22:00")
+	_, bobConn := connectAs(t, hub, "bob")
+	got := messagesOfType(bobConn, model.TypeMOTD)
+	if len(got) != 1 || got[0].Text != "maintenance at 22:00" {
+		t.Errorf("bob should get the motd after registration, got %+v", bobConn.Sent())
+	}
+	if len(messagesOfType(aliceConn, model.TypeMOTD)) != 0 {
+		t.Error("users already online should not get the new motd")
+	}
+}
+
+func TestHub_DefinedRooms(t *testing.T) {
+	hub := app.NewHub()
+	alice, aliceConn := connectAs(t, hub, "alice")
+	hub.SetRooms([]string{"lobby", "#help"})
+	join(t, hub, alice, "#dev")
+	join(t, hub, alice, "#help")
+	if err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeLeave, Room: "#help"}); err != nil {
+		t.Fatal(err)
+	}
+
+	aliceConn.Reset()
+	if err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeRooms}); err != nil {
+		t.Fatal(err)
+	}
+	got := messagesOfType(aliceConn, model.TypeRooms)
+	want := []string{"#dev", "#help", "#lobby"}
+	if len(got) != 1 || !reflect.DeepEqual(got[0].Rooms, want) {
+		t.Errorf("rooms: got %+v, want %v", got, want)
+	}
+}
+
+func TestReloader_AppliesRuntimeSettings(t *testing.T) {
+	t.Cleanup(func() { logging.SetLevel(logging.LevelInfo) })
+	path := writeConfig(t, "chat.yaml", `
+listeners:
+  protocols: tcp
+  port: 5000
+limits:
+  send-queue: 0
+chat:
+  motd: hello
+`)
+	args := []string{"-config", path}
+	noEnv := func(string) (string, bool) { return "", false }
+	flags, fs, _, err := loadServerFlags(t, args, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := cfg.NewServer(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader := cfg.NewReloader(server, fs, args, noEnv)
+	hub := server.Hub()
+	_, aliceConn := connectAs(t, hub, "alice")
+	_, bobConn := connectAs(t, hub, "bob")
+
+	rewrite := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rewrite(`
+listeners:
+  protocols: tcp
+  port: 6000
+chat:
+  motd: welcome back
+  rooms: [lobby]
+moderation:
+  bans: [bob]
+logging:
+  level: warn
+limits:
+  send-queue: 0
+  resume-queue: 50
+`)
+	report, err := reloader.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantApplied := []string{"limits.resume-queue", "chat.motd", "chat.rooms", "moderation.bans", "logging.level"}
+	if !reflect.DeepEqual(report.Applied, wantApplied) {
+		t.Errorf("applied: got %v, want %v", report.Applied, wantApplied)
+	}
+	if want := []string{"listeners.port"}; !reflect.DeepEqual(report.Restart, want) {
+		t.Errorf("restart: got %v, want %v", report.Restart, want)
+	}
+
+	if !bobConn.Closed() {
+		t.Error("bob was banned by the reload and should be disconnected")
+	}
+	if aliceConn.Closed() {
+		t.Error("reload should not drop other sessions")
+	}
+	if got := logging.CurrentLevel(); got != logging.LevelWarn {
+		t.Errorf("log level: got %s, want warn", got)
+	}
+	_, carolConn := connectAs(t, hub, "carol")
+	if got := messagesOfType(carolConn, model.TypeMOTD); len(got) != 1 || got[0].Text != "welcome back" {
+		t.Errorf("carol should get the new motd, got %+v", carolConn.Sent())
+	}
+
+	// Перезапуск всё ещё нужен, хотя файл больше не менялся
+	report, err = reloader.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Applied) != 0 || !reflect.DeepEqual(report.Restart, []string{"listeners.port"}) {
+		t.Errorf("second reload: got %s", report)
+	}
+
+	// Ошибка в файле не меняет ничего
+	rewrite(`
+limits:
+  send-queue: 0
+chat:
+  motd: broken
+logging:
+  level: loud
+`)
+	if _, err := reloader.Reload(); err == nil {
+		t.Fatal("invalid log level should fail the reload")
+	}
+	_, daveConn := connectAs(t, hub, "dave")
+	if got := messagesOfType(daveConn, model.TypeMOTD); len(got) != 1 || got[0].Text != "welcome back" {
+		t.Errorf("failed reload should keep the motd, got %+v", daveConn.Sent())
+	}
+}
@@ -0,0 +1,259 @@
+package test
+
+import (
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/auth"
+	"chat/server/internal/model"
+	httptransport "chat/server/internal/transport/http"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAPIToken = "s3cret"
+
+// startAPI запускает HTTP-транспорт с REST API и возвращает его адрес
+func startAPI(t *testing.T, hub *app.Hub, token string) string {
+	t.Helper()
+	tr := httptransport.NewHTTPTransport(hub)
+	tr.SetAPIToken(token)
+	addr := freeAddr(t, "tcp")
+	go tr.Start(addr)
+	t.Cleanup(func() { tr.Stop() })
+
+	base := "http://" + addr
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp, err := http.Get(base + "/api/health")
+		if err == nil {
+			resp.Body.Close()
+			return base
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("api did not start: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// apiRequest выполняет запрос и разбирает JSON-ответ в out, если он не nil
+func apiRequest(t *testing.T, method, url, token, body string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("%s %s: decode %q: %v", method, url, data, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAPI_RequiresBearerToken(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	base := startAPI(t, hub, testAPIToken)
+
+	for _, token := range []string{"", "wrong"} {
+		if status := apiRequest(t, "GET", base+"/api/users", token, "", nil); status != http.StatusUnauthorized {
+			t.Errorf("token %q: want %d, got %d", token, http.StatusUnauthorized, status)
+		}
+	}
+	if status := apiRequest(t, "GET", base+"/api/users", testAPIToken, "", nil); status != http.StatusOK {
+		t.Errorf("valid token: want %d, got %d", http.StatusOK, status)
+	}
+
+	var health struct{ Status string }
+	if status := apiRequest(t, "GET", base+"/api/health", "", "", &health); status != http.StatusOK || health.Status != "ok" {
+		t.Errorf("health: got %d %+v", status, health)
+	}
+	if status := apiRequest(t, "GET", base+"/api/messages", testAPIToken, "", nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET /api/messages: want %d, got %d", http.StatusMethodNotAllowed, status)
+	}
+}
+
+func TestAPI_DisabledWithoutToken(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	base := startAPI(t, hub, "")
+
+	if status := apiRequest(t, "GET", base+"/api/users", "", "", nil); status != http.StatusNotFound {
+		t.Errorf("want %d, got %d", http.StatusNotFound, status)
+	}
+}
+
+func TestAPI_PostMessages(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	base := startAPI(t, hub, testAPIToken)
+	_, aliceConn := connectAs(t, hub, "alice")
+	aliceConn.Reset()
+
+	var frame protocol.Envelope
+	status := apiRequest(t, "POST", base+"/api/messages", testAPIToken, `{"name":"ci","text":"build passed"}`, &frame)
+	if status != http.StatusCreated || frame.Type != model.TypeBroadcast || frame.ID == 0 || frame.Payload.Text != "build passed" {
+		t.Fatalf("broadcast: got %d %+v", status, frame)
+	}
+	status = apiRequest(t, "POST", base+"/api/messages", testAPIToken, `{"name":"ci","text":"your build","dst":"alice"}`, &frame)
+	if status != http.StatusCreated || frame.Type != model.TypeWhisper {
+		t.Fatalf("whisper: got %d %+v", status, frame)
+	}
+
+	got := stripTime(t, aliceConn.Sent())
+	want := []model.OutgoingMessage{
+		{ID: 1, Type: model.TypeBroadcast, Name: "ci" + app.APISenderSuffix, Text: "build passed"},
+		{ID: 2, Type: model.TypeWhisper, Name: "ci" + app.APISenderSuffix, Text: "your build", Dst: "alice", Private: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("alice got:\n%+v\nwant:\n%+v", got, want)
+	}
+
+	errorCases := []struct {
+		body   string
+		status int
+	}{
+		{`{"name":"ci","text":"hi","dst":"nobody"}`, http.StatusNotFound},
+		{`{"text":"anonymous"}`, http.StatusBadRequest},
+		{`{"name":"ci","text":"hi","unknown":1}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	}
+	for _, c := range errorCases {
+		var apiErr struct{ Error string }
+		if status := apiRequest(t, "POST", base+"/api/messages", testAPIToken, c.body, &apiErr); status != c.status || apiErr.Error == "" {
+			t.Errorf("%s: want %d with error, got %d %+v", c.body, c.status, status, apiErr)
+		}
+	}
+
+	// Имя пользователя в сети не мешает: отправитель API отличается суффиксом
+	status = apiRequest(t, "POST", base+"/api/messages", testAPIToken, `{"name":"alice","text":"from ci"}`, &frame)
+	if status != http.StatusCreated || frame.Payload.Name != "alice"+app.APISenderSuffix {
+		t.Errorf("post as online user: got %d %+v", status, frame)
+	}
+}
+
+func TestHub_PostSenderIsStamped(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	_, bobConn := connectAs(t, hub, "bob")
+	bobConn.Reset()
+	// alice защищена токеном и не в сети: API не может писать от её имени
+	store, err := auth.LoadTokenFile(writeFile(t, "tokens", "alice abc123\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.SetAuthenticator(store)
+
+	out, err := hub.Post("127.0.0.1:1", model.IncomingMessage{Type: model.TypeBroadcast, From: "alice", Text: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "alice"+app.APISenderSuffix {
+		t.Fatalf("post from alice delivered as %q", out.Name)
+	}
+	if got := messagesOfType(bobConn, model.TypeBroadcast); len(got) != 1 || got[0].Name != out.Name {
+		t.Fatalf("bob got %+v", got)
+	}
+
+	// И пользователь не может занять имя отправителя API
+	s := hub.Connect(&MockConn{})
+	if err := hub.Register(s, out.Name, model.Credentials{}); !errors.Is(err, app.ErrNameInvalid) {
+		t.Fatalf("register as %s: got %v, want %v", out.Name, err, app.ErrNameInvalid)
+	}
+}
+
+func TestAPI_UsersAndHistory(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	base := startAPI(t, hub, testAPIToken)
+	alice, _ := connectAs(t, hub, "alice")
+	connectAs(t, hub, "bob")
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeJoin, Room: "#ops"})
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: "one"})
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: "two"})
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Room: "#ops", Text: "deploy"})
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeWhisper, To: "bob", Text: "secret"})
+
+	var users struct{ Users []string }
+	if status := apiRequest(t, "GET", base+"/api/users", testAPIToken, "", &users); status != http.StatusOK || !reflect.DeepEqual(users.Users, []string{"alice", "bob"}) {
+		t.Fatalf("users: got %d %+v", status, users)
+	}
+
+	texts := func(query string) []string {
+		t.Helper()
+		var history struct{ Messages []protocol.Envelope }
+		if status := apiRequest(t, "GET", base+"/api/history"+query, testAPIToken, "", &history); status != http.StatusOK {
+			t.Fatalf("history%s: status %d", query, status)
+		}
+		var res []string
+		for _, m := range history.Messages {
+			if !m.Payload.History {
+				t.Errorf("history frame without history flag: %+v", m)
+			}
+			res = append(res, m.Payload.Text)
+		}
+		return res
+	}
+	if got := texts(""); !reflect.DeepEqual(got, []string{"one", "two"}) {
+		t.Errorf("general history: %v", got)
+	}
+	if got := texts("?limit=1"); !reflect.DeepEqual(got, []string{"two"}) {
+		t.Errorf("limited history: %v", got)
+	}
+	if got := texts("?room=ops"); !reflect.DeepEqual(got, []string{"deploy"}) {
+		t.Errorf("room history: %v", got)
+	}
+	if status := apiRequest(t, "GET", base+"/api/history?limit=x", testAPIToken, "", nil); status != http.StatusBadRequest {
+		t.Errorf("bad limit: want %d, got %d", http.StatusBadRequest, status)
+	}
+}
+
+func TestHub_PostRejectedDuringShutdown(t *testing.T) {
+	hub := app.NewHub()
+	hub.Close()
+	if _, err := hub.Post("127.0.0.1:1", model.IncomingMessage{Type: model.TypeBroadcast, From: "ci", Text: "late"}); !errors.Is(err, app.ErrServerShutdown) {
+		t.Fatalf("want %v, got %v", app.ErrServerShutdown, err)
+	}
+}
+
+func TestAPI_HealthReportsSendQueues(t *testing.T) {
+	hub := app.NewHub()
+	hub.SetSendQueue(1, app.DropOldest)
+	defer hub.Close()
+	base := startAPI(t, hub, "")
+	stuck := &MockConn{Block: make(chan struct{})}
+	defer close(stuck.Block)
+	register(t, hub, stuck, "carol")
+	alice, _ := connectAs(t, hub, "alice")
+	for i := 0; i < 5; i++ {
+		hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: "hi"})
+	}
+
+	var health struct {
+		Status          string
+		DroppedFrames   uint64 `json:"dropped_frames"`
+		SlowDisconnects uint64 `json:"slow_disconnects"`
+	}
+	if status := apiRequest(t, "GET", base+"/api/health", "", "", &health); status != http.StatusOK {
+		t.Fatalf("health: got %d", status)
+	}
+	if health.DroppedFrames != hub.Stats().DroppedFrames || health.DroppedFrames == 0 || health.SlowDisconnects != 0 {
+		t.Errorf("health %+v, hub stats %+v", health, hub.Stats())
+	}
+}
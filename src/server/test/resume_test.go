@@ -0,0 +1,236 @@
+package test
+
+import (
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	"chat/server/internal/transport/tcp"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// registerWithToken регистрирует пользователя и возвращает его токен возобновления
+func registerWithToken(t *testing.T, hub *app.Hub, name string) (*app.Session, *MockConn, string) {
+	t.Helper()
+	session, conn := connectAs(t, hub, name)
+	frames := messagesOfType(conn, model.TypeSession)
+	if len(frames) != 1 || frames[0].Token == "" || frames[0].Name != name {
+		t.Fatalf("%s: want one session frame with a token, got %+v", name, conn.Sent())
+	}
+	return session, conn, frames[0].Token
+}
+
+func TestHub_ResumeRestoresSessionAndMissedFrames(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	hub.SetResume(time.Minute, 10)
+
+	alice, _, token := registerWithToken(t, hub, "alice")
+	bob, bobConn, _ := registerWithToken(t, hub, "bob")
+	join(t, hub, alice, "#dev")
+	hub.Disconnect(alice)
+
+	if got := messagesOfType(bobConn, model.TypeUserLeft); len(got) != 0 {
+		t.Errorf("dropped session is held, but bob got %+v", got)
+	}
+	other := hub.Connect(&MockConn{})
+	if err := hub.Register(other, "alice", model.Credentials{}); !errors.Is(err, app.ErrNameTaken) {
+		t.Errorf("held name: got %v, want %v", err, app.ErrNameTaken)
+	}
+
+	hub.Handle(bob, model.IncomingMessage{Type: model.TypeBroadcast, Text: "while you were away"})
+	hub.Handle(bob, model.IncomingMessage{Type: model.TypeWhisper, To: "alice", Text: "psst"})
+
+	conn := &MockConn{}
+	resumed := hub.Connect(conn)
+	if err := hub.Handle(resumed, model.IncomingMessage{Type: model.TypeResume, From: "alice", Credentials: model.Credentials{Token: token}}); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+
+	sent := conn.Sent()
+	if len(sent) != 3 || sent[0].Type != model.TypeResumed {
+		t.Fatalf("want resumed and two missed frames, got %+v", sent)
+	}
+	if sent[1].Text != "while you were away" || sent[2].Text != "psst" {
+		t.Errorf("missed frames out of order: %+v", sent[1:])
+	}
+	if resumed.Name() != "alice" || !resumed.InRoom("#dev") {
+		t.Errorf("resumed session lost its name or rooms: %q", resumed.Name())
+	}
+
+	// Новые кадры идут в новое соединение
+	hub.Handle(bob, model.IncomingMessage{Type: model.TypeWhisper, To: "alice", Text: "welcome back"})
+	if got := messagesOfType(conn, model.TypeWhisper); len(got) != 2 || got[1].Text != "welcome back" {
+		t.Errorf("whisper after resume: got %+v", got)
+	}
+	if got := messagesOfType(bobConn, model.TypeUserJoined); len(got) != 0 {
+		t.Errorf("resume should be invisible to others, bob got %+v", got)
+	}
+}
+
+func TestHub_ResumeQueueDropsOldest(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	hub.SetResume(time.Minute, 3)
+
+	alice, _, token := registerWithToken(t, hub, "alice")
+	bob, _, _ := registerWithToken(t, hub, "bob")
+	hub.Disconnect(alice)
+	for i := 0; i < 5; i++ {
+		hub.Handle(bob, model.IncomingMessage{Type: model.TypeBroadcast, Text: fmt.Sprint(i)})
+	}
+
+	conn := &MockConn{}
+	if err := hub.Resume(hub.Connect(conn), "alice", token); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	sent := conn.Sent()
+	if len(sent) != 4 || sent[0].Text != "3 missed, 2 dropped" {
+		t.Fatalf("want resumed and the last 3 frames, got %+v", sent)
+	}
+	for i, msg := range sent[1:] {
+		if want := fmt.Sprint(i + 2); msg.Text != want {
+			t.Errorf("frame %d: got %q, want %q", i, msg.Text, want)
+		}
+	}
+}
+
+func TestHub_ResumeRejected(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	hub.SetResume(time.Minute, 10)
+	alice, _, token := registerWithToken(t, hub, "alice")
+
+	cases := []struct {
+		name, user, token string
+	}{
+		{"session still connected", "alice", token},
+		{"unknown user", "mallory", token},
+	}
+	for _, tc := range cases {
+		if err := hub.Resume(hub.Connect(&MockConn{}), tc.user, tc.token); !errors.Is(err, app.ErrResumeFailed) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, app.ErrResumeFailed)
+		}
+	}
+
+	hub.Disconnect(alice)
+	if err := hub.Resume(hub.Connect(&MockConn{}), "alice", "wrong"); !errors.Is(err, app.ErrResumeFailed) {
+		t.Errorf("wrong token: got %v, want %v", err, app.ErrResumeFailed)
+	}
+	if err := hub.Resume(hub.Connect(&MockConn{}), "alice", token); err != nil {
+		t.Errorf("right token after a wrong one: %v", err)
+	}
+}
+
+func TestHub_HeldSessionExpires(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	hub.SetResume(30*time.Millisecond, 10)
+
+	alice, _, token := registerWithToken(t, hub, "alice")
+	_, bobConn, _ := registerWithToken(t, hub, "bob")
+	hub.Evict(alice, model.LeftTimeout)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(messagesOfType(bobConn, model.TypeUserLeft)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	got := messagesOfType(bobConn, model.TypeUserLeft)
+	if len(got) != 1 || got[0].Name != "alice" || got[0].Reason != model.LeftTimeout {
+		t.Fatalf("want user_left alice by timeout after grace, got %+v", got)
+	}
+	if err := hub.Resume(hub.Connect(&MockConn{}), "alice", token); !errors.Is(err, app.ErrResumeFailed) {
+		t.Errorf("resume after grace: got %v, want %v", err, app.ErrResumeFailed)
+	}
+	connectAs(t, hub, "alice")
+}
+
+func TestHub_ExitIsNotHeld(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	hub.SetResume(time.Minute, 10)
+
+	alice, _, _ := registerWithToken(t, hub, "alice")
+	_, bobConn, _ := registerWithToken(t, hub, "bob")
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeExit})
+
+	if got := messagesOfType(bobConn, model.TypeUserLeft); len(got) != 1 || got[0].Reason != model.LeftExit {
+		t.Errorf("exit should release the name at once, bob got %+v", got)
+	}
+}
+
+func TestHub_SlowConsumerIsNotHeld(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	hub.SetResume(time.Minute, 10)
+
+	alice, _, token := registerWithToken(t, hub, "alice")
+	_, bobConn, _ := registerWithToken(t, hub, "bob")
+	hub.Evict(alice, model.LeftSlow)
+
+	if got := messagesOfType(bobConn, model.TypeUserLeft); len(got) != 1 || got[0].Reason != model.LeftSlow {
+		t.Errorf("slow client should release the name at once, bob got %+v", got)
+	}
+	if err := hub.Resume(hub.Connect(&MockConn{}), "alice", token); !errors.Is(err, app.ErrResumeFailed) {
+		t.Errorf("resume after a slow disconnect: got %v, want %v", err, app.ErrResumeFailed)
+	}
+}
+
+func TestHub_NoTokenWithoutResume(t *testing.T) {
+	hub := app.NewHub()
+	_, conn := connectAs(t, hub, "alice")
+	if got := messagesOfType(conn, model.TypeSession); len(got) != 0 {
+		t.Errorf("resume is disabled, got %+v", got)
+	}
+}
+
+func TestTCP_ResumeAfterReconnect(t *testing.T) {
+	hub := app.NewHub()
+	hub.SetResume(time.Minute, 10)
+	addr := freeAddr(t, "tcp")
+	tr := tcp.NewTCPTransport(hub)
+	go tr.Start(addr)
+	t.Cleanup(func() {
+		tr.Stop()
+		hub.Close()
+	})
+	dial := func() (frameConn, error) { return dialFrameConn("tcp", addr) }
+
+	alice := dialReady(t, dial)
+	alice.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "alice"}))
+	session := alice.receive(t)
+	token := session.Payload.Token
+	if session.Type != model.TypeSession || token == "" {
+		t.Fatalf("want session frame with token, got %v", session)
+	}
+
+	bob := dialReady(t, dial)
+	defer bob.close()
+	bob.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "bob"}))
+	if frame := bob.receive(t); frame.Type != model.TypeSession {
+		t.Fatalf("bob: want session, got %v", frame)
+	}
+	if frame := alice.receive(t); frame.Type != model.TypeUserJoined {
+		t.Fatalf("alice: want user_joined, got %v", frame)
+	}
+	alice.close()
+	time.Sleep(50 * time.Millisecond) // Сервер замечает обрыв
+
+	// Сообщение отправлено, пока alice переподключается
+	bob.send(t, protocol.New(protocol.TypeWhisper, protocol.Payload{Dst: "alice", Text: "missed"}))
+	if frame := bob.receive(t); frame.Type != model.TypeWhisper {
+		t.Fatalf("bob: want whisper echo, got %v", frame)
+	}
+
+	alice = dialReady(t, dial)
+	defer alice.close()
+	alice.send(t, protocol.New(protocol.TypeResume, protocol.Payload{Name: "alice", Token: token}))
+	if frame := alice.receive(t); frame.Type != model.TypeResumed {
+		t.Fatalf("want resumed, got %v", frame)
+	}
+	if frame := alice.receive(t); frame.Type != model.TypeWhisper || frame.Payload.Text != "missed" {
+		t.Fatalf("want missed whisper, got %v", frame)
+	}
+}
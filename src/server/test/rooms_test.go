@@ -0,0 +1,143 @@
+package test
+
+import (
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func join(t *testing.T, hub *app.Hub, s *app.Session, room string) {
+	t.Helper()
+	if err := hub.Handle(s, model.IncomingMessage{Type: model.TypeJoin, Room: room}); err != nil {
+		t.Fatalf("join %s: %v", room, err)
+	}
+}
+
+func messagesOfType(conn *MockConn, typ string) []model.OutgoingMessage {
+	var res []model.OutgoingMessage
+	for _, msg := range conn.Sent() {
+		if msg.Type == typ {
+			res = append(res, msg)
+		}
+	}
+	return res
+}
+
+func TestHub_RoomBroadcastReachesOnlyMembers(t *testing.T) {
+	hub := app.NewHub()
+	alice, aliceConn := connectAs(t, hub, "alice")
+	bob, bobConn := connectAs(t, hub, "bob")
+	_, carolConn := connectAs(t, hub, "carol")
+
+	join(t, hub, alice, "#dev")
+	join(t, hub, bob, "dev")
+
+	err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Room: "#dev", Text: "deploy?"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := model.OutgoingMessage{ID: 1, Type: model.TypeBroadcast, Name: "alice", Room: "#dev", Text: "deploy?"}
+	for name, conn := range map[string]*MockConn{"alice": aliceConn, "bob": bobConn} {
+		got := stripTime(t, messagesOfType(conn, model.TypeBroadcast))
+		if len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+			t.Errorf("%s: want %+v, got %+v", name, want, got)
+		}
+	}
+	if got := messagesOfType(carolConn, model.TypeBroadcast); len(got) != 0 {
+		t.Errorf("carol is not in #dev and should not receive room messages, got %+v", got)
+	}
+}
+
+func TestHub_RoomBroadcastRequiresMembership(t *testing.T) {
+	hub := app.NewHub()
+	alice, _ := connectAs(t, hub, "alice")
+	bob, _ := connectAs(t, hub, "bob")
+	join(t, hub, bob, "#dev")
+
+	err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Room: "#dev", Text: "hi"})
+	if !errors.Is(err, app.ErrNotInRoom) {
+		t.Fatalf("expected ErrNotInRoom, got %v", err)
+	}
+}
+
+func TestHub_JoinLeaveAnnouncements(t *testing.T) {
+	hub := app.NewHub()
+	alice, aliceConn := connectAs(t, hub, "alice")
+	bob, bobConn := connectAs(t, hub, "bob")
+
+	join(t, hub, alice, "#dev")
+	join(t, hub, bob, "#dev")
+
+	if got := messagesOfType(aliceConn, model.TypeJoin); len(got) != 2 || got[1].Name != "bob" {
+		t.Errorf("alice should see her own and bob's join, got %+v", got)
+	}
+
+	if err := hub.Handle(bob, model.IncomingMessage{Type: model.TypeLeave, Room: "#dev"}); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	want := model.OutgoingMessage{Type: model.TypeLeave, Name: "bob", Room: "#dev"}
+	for name, conn := range map[string]*MockConn{"alice": aliceConn, "bob": bobConn} {
+		got := messagesOfType(conn, model.TypeLeave)
+		if len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+			t.Errorf("%s: want %+v, got %+v", name, want, got)
+		}
+	}
+
+	if err := hub.Handle(bob, model.IncomingMessage{Type: model.TypeLeave, Room: "#dev"}); !errors.Is(err, app.ErrNotInRoom) {
+		t.Errorf("second leave: expected ErrNotInRoom, got %v", err)
+	}
+}
+
+func TestHub_DisconnectLeavesRooms(t *testing.T) {
+	hub := app.NewHub()
+	alice, aliceConn := connectAs(t, hub, "alice")
+	bob, _ := connectAs(t, hub, "bob")
+	join(t, hub, alice, "#dev")
+	join(t, hub, bob, "#dev")
+
+	hub.Disconnect(bob)
+
+	got := messagesOfType(aliceConn, model.TypeLeave)
+	if len(got) != 1 || got[0].Name != "bob" || got[0].Room != "#dev" {
+		t.Errorf("expected leave announcement for bob, got %+v", got)
+	}
+}
+
+func TestHub_RoomsList(t *testing.T) {
+	hub := app.NewHub()
+	alice, aliceConn := connectAs(t, hub, "alice")
+	bob, _ := connectAs(t, hub, "bob")
+	join(t, hub, alice, "#ops")
+	join(t, hub, bob, "#dev")
+	join(t, hub, alice, "#dev")
+
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeRooms})
+	got := messagesOfType(aliceConn, model.TypeRooms)
+	if len(got) != 1 || !reflect.DeepEqual(got[0].Rooms, []string{"#dev", "#ops"}) {
+		t.Fatalf("unexpected rooms list: %+v", got)
+	}
+
+	// Пустая комната удаляется
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeLeave, Room: "#ops"})
+	hub.Handle(alice, model.IncomingMessage{Type: model.TypeRooms})
+	got = messagesOfType(aliceConn, model.TypeRooms)
+	if len(got) != 2 || !reflect.DeepEqual(got[1].Rooms, []string{"#dev"}) {
+		t.Errorf("expected empty room to be removed, got %+v", got)
+	}
+}
+
+func TestHub_JoinErrors(t *testing.T) {
+	hub := app.NewHub()
+	alice, _ := connectAs(t, hub, "alice")
+	anon := hub.Connect(&MockConn{})
+
+	if err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeJoin, Room: " "}); !errors.Is(err, app.ErrRoomNameEmpty) {
+		t.Errorf("expected ErrRoomNameEmpty, got %v", err)
+	}
+	if err := hub.Handle(anon, model.IncomingMessage{Type: model.TypeJoin, Room: "#dev"}); !errors.Is(err, app.ErrNotRegistered) {
+		t.Errorf("expected ErrNotRegistered, got %v", err)
+	}
+}
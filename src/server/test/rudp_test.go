@@ -0,0 +1,363 @@
+package test
+
+import (
+	"chat/protocol"
+	"chat/rudp"
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	"chat/server/internal/transport/udp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector собирает сообщения, выданные rudp.Conn
+type collector struct {
+	mu   sync.Mutex
+	msgs []string
+	got  chan struct{}
+}
+
+func newCollector() *collector {
+	return &collector{got: make(chan struct{}, 1)}
+}
+
+func (c *collector) handle(_ net.Addr, payload []byte) {
+	c.mu.Lock()
+	c.msgs = append(c.msgs, string(payload))
+	c.mu.Unlock()
+	select {
+	case c.got <- struct{}{}:
+	default:
+	}
+}
+
+// wait ждёт n сообщений и возвращает всё полученное
+func (c *collector) wait(t *testing.T, n int, timeout time.Duration) []string {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		c.mu.Lock()
+		msgs := append([]string(nil), c.msgs...)
+		c.mu.Unlock()
+		if len(msgs) >= n {
+			return msgs
+		}
+		select {
+		case <-c.got:
+		case <-deadline:
+			t.Fatalf("got %d of %d messages", len(msgs), n)
+		}
+	}
+}
+
+func checkSequence(t *testing.T, got []string, prefix string, n int) {
+	t.Helper()
+	if len(got) != n {
+		t.Fatalf("got %d messages, want %d", len(got), n)
+	}
+	for i, msg := range got {
+		if want := fmt.Sprintf("%s %d", prefix, i); msg != want {
+			t.Fatalf("message %d: got %q, want %q", i, msg, want)
+		}
+	}
+}
+
+func TestRUDP_InOrderExactlyOnceUnderLoss(t *testing.T) {
+	network := NewLossyNetwork(1)
+	network.DropRate = 0.2
+	network.DupRate = 0.1
+	network.MaxDelay = 5 * time.Millisecond
+
+	cfg := rudp.Config{RTO: 10 * time.Millisecond, MaxRTO: 100 * time.Millisecond}
+	a := rudp.NewConn(network.Listen("a"), cfg)
+	defer a.Close()
+	b := rudp.NewConn(network.Listen("b"), cfg)
+	defer b.Close()
+
+	atA, atB := newCollector(), newCollector()
+	go a.Serve(atA.handle)
+	go b.Serve(atB.handle)
+
+	const n = 200
+	for i := 0; i < n; i++ {
+		a.Send(b.LocalAddr(), []byte(fmt.Sprintf("a %d", i)))
+		b.Send(a.LocalAddr(), []byte(fmt.Sprintf("b %d", i)))
+	}
+
+	checkSequence(t, atB.wait(t, n, 10*time.Second), "a", n)
+	checkSequence(t, atA.wait(t, n, 10*time.Second), "b", n)
+
+	// Лишних копий не приходит и после повторов
+	time.Sleep(50 * time.Millisecond)
+	checkSequence(t, atB.wait(t, n, time.Second), "a", n)
+}
+
+func TestRUDP_ReportsLostPeer(t *testing.T) {
+	network := NewLossyNetwork(1)
+	lost := make(chan net.Addr, 1)
+	a := rudp.NewConn(network.Listen("a"), rudp.Config{
+		RTO:        time.Millisecond,
+		MaxRTO:     5 * time.Millisecond,
+		MaxRetries: 3,
+		OnLost:     func(addr net.Addr) { lost <- addr },
+	})
+	defer a.Close()
+
+	// Адрес никто не слушает, подтверждений не будет
+	nobody := network.Listen("nobody")
+	nobody.Close()
+	a.Send(nobody.LocalAddr(), []byte("hello"))
+
+	select {
+	case addr := <-lost:
+		if addr.String() != "nobody" {
+			t.Errorf("lost %s, want nobody", addr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnLost was not called")
+	}
+	if a.Pending() != 0 {
+		t.Errorf("pending = %d after peer was lost", a.Pending())
+	}
+}
+
+func TestRUDP_ResyncAfterForget(t *testing.T) {
+	network := NewLossyNetwork(1)
+	cfg := rudp.Config{RTO: 10 * time.Millisecond}
+	a := rudp.NewConn(network.Listen("a"), cfg)
+	defer a.Close()
+	b := rudp.NewConn(network.Listen("b"), cfg)
+	defer b.Close()
+
+	atB := newCollector()
+	go a.Serve(func(net.Addr, []byte) {})
+	go b.Serve(atB.handle)
+
+	for i := 0; i < 3; i++ {
+		a.Send(b.LocalAddr(), []byte(fmt.Sprintf("m %d", i)))
+	}
+	atB.wait(t, 3, time.Second)
+	for a.Pending() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Получатель забыл отправителя, а тот продолжает свою нумерацию
+	b.Forget(a.LocalAddr())
+	for i := 3; i < 6; i++ {
+		a.Send(b.LocalAddr(), []byte(fmt.Sprintf("m %d", i)))
+	}
+	checkSequence(t, atB.wait(t, 6, time.Second), "m", 6)
+}
+
+func TestRUDP_ResyncAfterSenderForget(t *testing.T) {
+	network := NewLossyNetwork(1)
+	cfg := rudp.Config{RTO: 10 * time.Millisecond}
+	a := rudp.NewConn(network.Listen("a"), cfg)
+	defer a.Close()
+	b := rudp.NewConn(network.Listen("b"), cfg)
+	defer b.Close()
+
+	atB := newCollector()
+	go a.Serve(func(net.Addr, []byte) {})
+	go b.Serve(atB.handle)
+
+	for i := 0; i < 3; i++ {
+		a.Send(b.LocalAddr(), []byte(fmt.Sprintf("m %d", i)))
+	}
+	atB.wait(t, 3, time.Second)
+
+	// Отправитель забыл получателя и начинает нумерацию с 1, а получатель
+	// ждёт пакет 4
+	a.Forget(b.LocalAddr())
+	for i := 3; i < 6; i++ {
+		a.Send(b.LocalAddr(), []byte(fmt.Sprintf("m %d", i)))
+	}
+	checkSequence(t, atB.wait(t, 6, time.Second), "m", 6)
+	for a.Pending() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUDPTransport_LossyNetwork(t *testing.T) {
+	network := NewLossyNetwork(2)
+	network.DropRate = 0.2
+	network.MaxDelay = 2 * time.Millisecond
+
+	hub := app.NewHub()
+	tr := udp.NewUDPTransport(hub)
+	serverConn := network.Listen("server")
+	go tr.Serve(serverConn)
+	defer func() {
+		tr.Stop()
+		hub.Close()
+	}()
+
+	bob := newUDPFrameConn(network.Listen("bob"), serverConn.LocalAddr())
+	defer bob.close()
+	bob.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "bob"}))
+	bob.send(t, protocol.New(protocol.TypeRooms, protocol.Payload{}))
+	if frame := bob.receiveWithin(t, 10*time.Second); frame.Type != model.TypeRooms {
+		t.Fatalf("bob: want rooms, got %v", frame)
+	}
+
+	alice := newUDPFrameConn(network.Listen("alice"), serverConn.LocalAddr())
+	defer alice.close()
+	alice.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "alice"}))
+	if frame := bob.receiveWithin(t, 10*time.Second); frame.Type != model.TypeUserJoined {
+		t.Fatalf("bob: want user_joined, got %v", frame)
+	}
+
+	const n = 50
+	for i := 0; i < n; i++ {
+		alice.send(t, protocol.New(protocol.TypeBroadcast, protocol.Payload{Text: fmt.Sprintf("msg %d", i)}))
+	}
+
+	for i := 0; i < n; i++ {
+		frame := bob.receiveWithin(t, 10*time.Second)
+		want := fmt.Sprintf("msg %d", i)
+		if frame.Type != model.TypeBroadcast || frame.Payload.Name != "alice" || frame.Payload.Text != want {
+			t.Fatalf("message %d: got %v, want broadcast %q from alice", i, frame, want)
+		}
+	}
+}
+
+func TestRUDP_FragmentsLargeMessages(t *testing.T) {
+	network := NewLossyNetwork(3)
+	network.DropRate = 0.2
+	network.DupRate = 0.1
+	network.MaxDelay = 5 * time.Millisecond
+
+	cfg := rudp.Config{RTO: 10 * time.Millisecond, MaxRTO: 100 * time.Millisecond, FragmentSize: 100}
+	a := rudp.NewConn(network.Listen("a"), cfg)
+	defer a.Close()
+	b := rudp.NewConn(network.Listen("b"), cfg)
+	defer b.Close()
+
+	atB := newCollector()
+	go a.Serve(func(net.Addr, []byte) {})
+	go b.Serve(atB.handle)
+
+	var want []string
+	for i, size := range []int{10, 5000, 100, 101, 0, 20000, 1} {
+		msg := make([]byte, size)
+		for j := range msg {
+			msg[j] = byte('a' + (i+j)%26)
+		}
+		want = append(want, string(msg))
+		if err := a.Send(b.LocalAddr(), msg); err != nil {
+			t.Fatalf("send %d bytes: %v", size, err)
+		}
+	}
+
+	got := atB.wait(t, len(want), 10*time.Second)
+	if len(got) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("message %d: got %d bytes, want %d", i, len(got[i]), len(want[i]))
+		}
+	}
+}
+
+func TestRUDP_MessageTooLarge(t *testing.T) {
+	network := NewLossyNetwork(1)
+	tooLarge := make(chan net.Addr, 1)
+	a := rudp.NewConn(network.Listen("a"), rudp.Config{RTO: 10 * time.Millisecond, FragmentSize: 100, MaxMessageSize: 1000})
+	defer a.Close()
+	b := rudp.NewConn(network.Listen("b"), rudp.Config{
+		RTO:            10 * time.Millisecond,
+		MaxMessageSize: 500,
+		OnTooLarge:     func(addr net.Addr) { tooLarge <- addr },
+	})
+	defer b.Close()
+
+	atB := newCollector()
+	go a.Serve(func(net.Addr, []byte) {})
+	go b.Serve(atB.handle)
+
+	if err := a.Send(b.LocalAddr(), make([]byte, 1001)); !errors.Is(err, rudp.ErrMessageTooLarge) {
+		t.Fatalf("send over own limit: got %v, want %v", err, rudp.ErrMessageTooLarge)
+	}
+
+	// Отправитель пропускает 800 байт, а получатель принимает не больше 500
+	a.Send(b.LocalAddr(), make([]byte, 800))
+	a.Send(b.LocalAddr(), []byte("after"))
+
+	select {
+	case addr := <-tooLarge:
+		if addr.String() != "a" {
+			t.Errorf("too large from %s, want a", addr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnTooLarge was not called")
+	}
+	if got := atB.wait(t, 1, 2*time.Second); len(got) != 1 || got[0] != "after" {
+		t.Fatalf("got %q, want only the message after the oversized one", got)
+	}
+}
+
+func TestUDPTransport_MessageSizeLimit(t *testing.T) {
+	network := NewLossyNetwork(4)
+	hub := app.NewHub()
+	tr := udp.NewUDPTransport(hub)
+	tr.SetMaxMessageSize(2000)
+	serverConn := network.Listen("server")
+	go tr.Serve(serverConn)
+	defer func() {
+		tr.Stop()
+		hub.Close()
+	}()
+
+	alice := newUDPFrameConn(network.Listen("alice"), serverConn.LocalAddr())
+	defer alice.close()
+	alice.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "alice"}))
+
+	alice.send(t, protocol.New(protocol.TypeBroadcast, protocol.Payload{Text: strings.Repeat("x", 5000)}))
+	frame := alice.receive(t)
+	if frame.Type != model.TypeMessageTooLarge {
+		t.Fatalf("got %v, want %s", frame, model.TypeMessageTooLarge)
+	}
+
+	// Сообщение больше одной датаграммы, но в пределах лимита, доходит целиком
+	text := strings.Repeat("y", 1500)
+	alice.send(t, protocol.New(protocol.TypeBroadcast, protocol.Payload{Text: text}))
+	frame = alice.receive(t)
+	if frame.Type != model.TypeBroadcast || frame.Payload.Text != text {
+		t.Fatalf("got %v, want broadcast of %d bytes", frame.Type, len(text))
+	}
+}
+
+func TestUDPTransport_TooLargeFromUnknownAddress(t *testing.T) {
+	network := NewLossyNetwork(5)
+	hub := app.NewHub()
+	tr := udp.NewUDPTransport(hub)
+	tr.SetMaxMessageSize(2000)
+	serverConn := network.Listen("server")
+	go tr.Serve(serverConn)
+	defer func() {
+		tr.Stop()
+		hub.Close()
+	}()
+
+	stranger := newUDPFrameConn(network.Listen("stranger"), serverConn.LocalAddr())
+	defer stranger.close()
+	// Сервер останавливается: новые адреса сессий не получают
+	tr.Shutdown(context.Background())
+
+	stranger.send(t, protocol.New(protocol.TypeBroadcast, protocol.Payload{Text: strings.Repeat("x", 5000)}))
+	if frame := stranger.receive(t); frame.Type != model.TypeMessageTooLarge {
+		t.Fatalf("got %v, want %s", frame, model.TypeMessageTooLarge)
+	}
+	// Отказ не завёл сессию: ответа not_registered нет
+	stranger.send(t, protocol.New(protocol.TypeWho, protocol.Payload{}))
+	if data, err := stranger.next(200 * time.Millisecond); err == nil {
+		t.Fatalf("unknown address got a session: %s", data)
+	}
+}
@@ -0,0 +1,167 @@
+package test
+
+import (
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// discardConn - быстрый клиент, который ничего не запоминает
+type discardConn struct {
+	MockConn
+}
+
+func (c *discardConn) Send(model.OutgoingMessage) error { return nil }
+
+// slowConn - клиент, который долго принимает каждый кадр
+type slowConn struct {
+	MockConn
+	delay time.Duration
+}
+
+func (c *slowConn) Send(model.OutgoingMessage) error {
+	time.Sleep(c.delay)
+	return nil
+}
+
+// register подключает соединение к хабу под именем name
+func register(b testing.TB, hub *app.Hub, conn app.Conn, name string) *app.Session {
+	b.Helper()
+	s := hub.Connect(conn)
+	if err := hub.Register(s, name, model.Credentials{}); err != nil {
+		b.Fatalf("register %s: %v", name, err)
+	}
+	return s
+}
+
+// waitFor ждёт выполнения условия не дольше двух секунд
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// broadcastPaced рассылает n сообщений, дожидаясь, пока каждое дойдёт до
+// быстрых получателей: переполняться должна только очередь зависшего
+func broadcastPaced(t *testing.T, hub *app.Hub, from *app.Session, n int, fast ...*MockConn) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		hub.Handle(from, model.IncomingMessage{Type: model.TypeBroadcast, Text: fmt.Sprint(i)})
+		for _, conn := range fast {
+			waitFor(t, "broadcast delivery", func() bool {
+				return len(messagesOfType(conn, model.TypeBroadcast)) == i+1
+			})
+		}
+	}
+}
+
+func TestHub_StuckPeerDoesNotBlockBroadcast(t *testing.T) {
+	hub := app.NewHub()
+	hub.SetSendQueue(4, app.DropOldest)
+	defer hub.Close()
+	alice, aliceConn := connectAs(t, hub, "alice")
+	_, bobConn := connectAs(t, hub, "bob")
+	stuck := &MockConn{Block: make(chan struct{})}
+	defer close(stuck.Block)
+	register(t, hub, stuck, "carol")
+
+	const n = 20
+	broadcastPaced(t, hub, alice, n, aliceConn, bobConn)
+	for i, msg := range messagesOfType(bobConn, model.TypeBroadcast) {
+		if msg.Text != fmt.Sprint(i) {
+			t.Fatalf("bob: broadcast %d out of order: %+v", i, msg)
+		}
+	}
+	// Один кадр висит в записи, четыре ждут в очереди, остальные отброшены
+	if dropped := hub.Stats().DroppedFrames; dropped < n-5 {
+		t.Errorf("dropped frames = %d, want at least %d", dropped, n-5)
+	}
+	if stuck.Closed() {
+		t.Error("drop-oldest policy should keep the slow client connected")
+	}
+}
+
+func TestHub_SlowConsumerDisconnected(t *testing.T) {
+	hub := app.NewHub()
+	hub.SetSendQueue(4, app.DisconnectSlow)
+	defer hub.Close()
+	alice, aliceConn := connectAs(t, hub, "alice")
+	_, bobConn := connectAs(t, hub, "bob")
+	stuck := &MockConn{Block: make(chan struct{})}
+	defer close(stuck.Block)
+	register(t, hub, stuck, "carol")
+	waitFor(t, "alice to see carol join", func() bool {
+		return len(messagesOfType(aliceConn, model.TypeUserJoined)) == 2
+	})
+
+	broadcastPaced(t, hub, alice, 10, aliceConn, bobConn)
+
+	waitFor(t, "carol to be disconnected", stuck.Closed)
+	waitFor(t, "bob to see carol leave", func() bool {
+		return len(messagesOfType(bobConn, model.TypeUserLeft)) == 1
+	})
+	if got := messagesOfType(bobConn, model.TypeUserLeft)[0]; got.Name != "carol" || got.Reason != model.LeftSlow {
+		t.Errorf("want carol left as %s, got %+v", model.LeftSlow, got)
+	}
+	if stats := hub.Stats(); stats.SlowDisconnects != 1 || stats.DroppedFrames == 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestHub_ShutdownDrainsSendQueue(t *testing.T) {
+	hub := app.NewHub()
+	hub.SetSendQueue(16, app.DropOldest)
+	alice, aliceConn := connectAs(t, hub, "alice")
+	for i := 0; i < 10; i++ {
+		hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: fmt.Sprint(i)})
+	}
+
+	if err := hub.Shutdown(context.Background(), "bye"); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	sent := aliceConn.Sent()
+	if len(sent) != 11 || sent[10].Type != model.TypeServerShutdown {
+		t.Fatalf("want 10 broadcasts and server_shutdown before close, got %+v", sent)
+	}
+}
+
+// BenchmarkBroadcastWithStuckPeer показывает задержку рассылки, когда один
+// из получателей не читает соединение
+func BenchmarkBroadcastWithStuckPeer(b *testing.B) {
+	const receivers = 50
+	run := func(b *testing.B, hub *app.Hub, peer app.Conn) {
+		defer hub.Close()
+		sender := register(b, hub, &discardConn{}, "sender")
+		for i := 0; i < receivers; i++ {
+			register(b, hub, &discardConn{}, fmt.Sprint("user", i))
+		}
+		register(b, hub, peer, "stuck")
+
+		msg := model.IncomingMessage{Type: model.TypeBroadcast, Text: "hello"}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			hub.Handle(sender, msg)
+		}
+	}
+
+	b.Run("no-queue-slow-peer", func(b *testing.B) {
+		// Без очереди зависший клиент заблокировал бы рассылку навсегда,
+		// поэтому здесь он лишь тратит миллисекунду на каждый кадр
+		run(b, app.NewHub(), &slowConn{delay: time.Millisecond})
+	})
+	b.Run("queue-stuck-peer", func(b *testing.B) {
+		hub := app.NewHub()
+		hub.SetSendQueue(app.DefaultSendQueue, app.DropOldest)
+		stuck := &MockConn{Block: make(chan struct{})}
+		defer close(stuck.Block)
+		run(b, hub, stuck)
+	})
+}
@@ -0,0 +1,47 @@
+package test
+
+import (
+	"chat/server/internal/cfg"
+	"testing"
+	"time"
+)
+
+func TestNewServer_MultipleProtocols(t *testing.T) {
+	cases := []struct {
+		name    string
+		flags   cfg.Flag
+		wantErr bool
+	}{
+		{"single", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "4545"}, false},
+		{"tcp and udp share port", cfg.Flag{ProtoType: "tcp,udp", IP: "127.0.0.1", Port: "4545"}, false},
+		{"all with http addr", cfg.Flag{ProtoType: "tcp, udp, http", IP: "127.0.0.1", Port: "4545", HTTPAddr: "127.0.0.1:8080"}, false},
+		{"tcp and http collide", cfg.Flag{ProtoType: "tcp,http", IP: "127.0.0.1", Port: "4545"}, true},
+		{"duplicate protocol", cfg.Flag{ProtoType: "tcp,tcp", IP: "127.0.0.1", Port: "4545"}, true},
+		{"unknown protocol", cfg.Flag{ProtoType: "sctp", IP: "127.0.0.1", Port: "4545"}, true},
+		{"empty", cfg.Flag{IP: "127.0.0.1", Port: "4545"}, true},
+		{"negative udp message limit", cfg.Flag{ProtoType: "udp", IP: "127.0.0.1", Port: "4545", UDPMaxMessage: -1}, true},
+		{"heartbeat", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "4545", HeartbeatInterval: time.Second, HeartbeatTimeout: 3 * time.Second}, false},
+		{"heartbeat timeout shorter than interval", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "4545", HeartbeatInterval: time.Second, HeartbeatTimeout: time.Millisecond}, true},
+		{"send queue", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "4545", SendQueue: 16, SendQueuePolicy: "disconnect"}, false},
+		{"unknown send queue policy", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "4545", SendQueue: 16, SendQueuePolicy: "block"}, true},
+		{"codecs", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "4545", Codecs: "msgpack, json-lp"}, false},
+		{"unknown codec", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "4545", Codecs: "json,xml"}, true},
+		{"api token", cfg.Flag{ProtoType: "http", IP: "127.0.0.1", Port: "4545", APIToken: "secret"}, false},
+		{"api token without http", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "4545", APIToken: "secret"}, true},
+		{"ws path", cfg.Flag{ProtoType: "http", IP: "127.0.0.1", Port: "4545", WSPath: "/chat/ws", WSBufferSize: 4096}, false},
+		{"ws path used by sse", cfg.Flag{ProtoType: "http", IP: "127.0.0.1", Port: "4545", WSPath: "/events"}, true},
+		{"relative ws path", cfg.Flag{ProtoType: "http", IP: "127.0.0.1", Port: "4545", WSPath: "ws"}, true},
+		{"rooms", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "4545", Rooms: "#general, dev"}, false},
+		{"invalid room", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "4545", Rooms: "#general,dev ops"}, true},
+		{"bad port", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "http"}, true},
+		{"negative heartbeat", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "4545", HeartbeatInterval: -time.Second}, true},
+	}
+
+	for _, c := range cases {
+		flags := c.flags
+		_, err := cfg.NewServer(&flags)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: NewServer error = %v, wantErr %v", c.name, err, c.wantErr)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package test
+
+import (
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	httptransport "chat/server/internal/transport/http"
+	"chat/server/internal/transport/tcp"
+	"chat/server/internal/transport/udp"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHub_ShutdownNotifiesAndDisconnects(t *testing.T) {
+	hub := app.NewHub()
+	alice, aliceConn := connectAs(t, hub, "alice")
+	guestConn := &MockConn{Addr: "guest:1"}
+	hub.Connect(guestConn)
+
+	if err := hub.Shutdown(context.Background(), "maintenance"); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	for name, conn := range map[string]*MockConn{"alice": aliceConn, "guest": guestConn} {
+		sent := conn.Sent()
+		if len(sent) == 0 || sent[len(sent)-1].Type != model.TypeServerShutdown || sent[len(sent)-1].Text != "maintenance" {
+			t.Errorf("%s: want server_shutdown as the last frame, got %+v", name, sent)
+		}
+		if !conn.Closed() {
+			t.Errorf("%s: connection not closed", name)
+		}
+	}
+
+	if err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: "late"}); !errors.Is(err, app.ErrServerShutdown) {
+		t.Errorf("handle after shutdown: got %v, want %v", err, app.ErrServerShutdown)
+	}
+}
+
+func TestHub_ShutdownDrainsInflight(t *testing.T) {
+	hub := app.NewHub()
+	alice, _ := connectAs(t, hub, "alice")
+	bobConn := &MockConn{Addr: "bob:1"}
+	bob := hub.Connect(bobConn)
+	if err := hub.Handle(bob, model.IncomingMessage{Type: model.TypeRegister, From: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Рассылка застревает на отправке bob, пока тест не откроет Block
+	bobConn.Block = make(chan struct{})
+	go hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: "in flight"})
+	time.Sleep(20 * time.Millisecond)
+	time.AfterFunc(50*time.Millisecond, func() { close(bobConn.Block) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := hub.Shutdown(ctx, "bye"); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	sent := bobConn.Sent()
+	if len(sent) != 2 || sent[0].Text != "in flight" || sent[1].Type != model.TypeServerShutdown {
+		t.Fatalf("bob: want the broadcast and then server_shutdown, got %+v", sent)
+	}
+}
+
+func TestHub_ShutdownDeadline(t *testing.T) {
+	hub := app.NewHub()
+	_, aliceConn := connectAs(t, hub, "alice")
+	stuck := &MockConn{Addr: "stuck:1", Block: make(chan struct{})}
+	defer close(stuck.Block)
+	s := hub.Connect(stuck)
+	go hub.Handle(s, model.IncomingMessage{Type: model.TypeRooms})
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := hub.Shutdown(ctx, "bye"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown took %s", elapsed)
+	}
+	if !aliceConn.Closed() {
+		t.Error("sessions must be closed after the deadline")
+	}
+}
+
+func TestChatServer_ShutdownOrder(t *testing.T) {
+	var calls []string
+	mock := &MockTransport{
+		ShutdownFunc: func(context.Context) error { calls = append(calls, "shutdown"); return nil },
+		StopFunc:     func() error { calls = append(calls, "stop"); return nil },
+	}
+	server := app.NewChatServer()
+	server.AddTransport(mock, "localhost:1234")
+	conn := &MockConn{Addr: "alice:1"}
+	server.Hub().Connect(conn)
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if len(calls) != 2 || calls[0] != "shutdown" || calls[1] != "stop" {
+		t.Errorf("calls = %v, want [shutdown stop]", calls)
+	}
+	if sent := conn.Sent(); len(sent) != 1 || sent[0].Type != model.TypeServerShutdown {
+		t.Errorf("client got %+v, want server_shutdown", sent)
+	}
+}
+
+func TestChatServer_RunSlowShutdown(t *testing.T) {
+	stopped := make(chan struct{})
+	mock := &MockTransport{
+		StartFunc: func(string) error { <-stopped; return nil },
+		StopFunc:  func() error { close(stopped); return nil },
+	}
+	server := app.NewChatServer()
+	server.AddTransport(mock, "localhost:1234")
+	server.SetDrainTimeout(50 * time.Millisecond)
+	stuck := &MockConn{Addr: "stuck:1", Block: make(chan struct{})}
+	defer close(stuck.Block)
+	s := server.Hub().Connect(stuck)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Run(ctx) }()
+	go server.Hub().Handle(s, model.IncomingMessage{Type: model.TypeRooms})
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("slow shutdown should not fail Run, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after the drain timeout")
+	}
+	if mock.StopCalls != 1 {
+		t.Errorf("transport stopped %d times, want 1", mock.StopCalls)
+	}
+}
+
+func TestChatServer_RunShutsDownTransports(t *testing.T) {
+	for _, proto := range []string{"tcp", "udp", "http", "sse", "poll"} {
+		t.Run(proto, func(t *testing.T) {
+			server := app.NewChatServer()
+			var addr string
+			switch proto {
+			case "tcp":
+				addr = freeAddr(t, "tcp")
+				server.AddTransport(tcp.NewTCPTransport(server.Hub()), addr)
+			case "udp":
+				addr = freeAddr(t, "udp")
+				server.AddTransport(udp.NewUDPTransport(server.Hub()), addr)
+			case "http", "sse", "poll":
+				addr = freeAddr(t, "tcp")
+				server.AddTransport(httptransport.NewHTTPTransport(server.Hub()), addr)
+			}
+			server.SetDrainTimeout(2 * time.Second)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- server.Run(ctx) }()
+
+			dial := func() (frameConn, error) { return dialFrameConn(proto, addr) }
+			alice := dialReady(t, dial)
+			defer alice.close()
+			bob := dialReady(t, dial)
+			defer bob.close()
+			// Порядок важен: alice получит user_joined о bob
+			for _, c := range []struct {
+				name string
+				conn frameConn
+			}{{"alice", alice}, {"bob", bob}} {
+				name, conn := c.name, c.conn
+				conn.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: name}))
+				conn.send(t, protocol.New(protocol.TypeRooms, protocol.Payload{}))
+				if frame := conn.receive(t); frame.Type != model.TypeRooms {
+					t.Fatalf("%s: want rooms, got %v", name, frame)
+				}
+			}
+			if frame := alice.receive(t); frame.Type != model.TypeUserJoined {
+				t.Fatalf("alice: want user_joined, got %v", frame)
+			}
+
+			cancel()
+			for name, conn := range map[string]frameConn{"alice": alice, "bob": bob} {
+				if frame := conn.receive(t); frame.Type != model.TypeServerShutdown {
+					t.Fatalf("%s: want server_shutdown, got %v", name, frame)
+				}
+			}
+
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("run: %v", err)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatal("Run did not return after shutdown")
+			}
+
+			// Сервер больше не принимает соединения
+			if conn, err := dialFrameConn(proto, addr); err == nil {
+				defer conn.close()
+				if probe(conn) == nil {
+					t.Fatal("server still answers after shutdown")
+				}
+			}
+		})
+	}
+}
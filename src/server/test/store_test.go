@@ -0,0 +1,184 @@
+package test
+
+import (
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	"chat/server/internal/store"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func texts(msgs []model.StoredMessage) []string {
+	res := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		res = append(res, msg.Text)
+	}
+	return res
+}
+
+func fillStore(t *testing.T, st app.MessageStore) {
+	t.Helper()
+	messages := []model.StoredMessage{
+		{Type: model.TypeBroadcast, From: "alice", Text: "g1"},
+		{Type: model.TypeBroadcast, From: "bob", Room: "#dev", Text: "d1"},
+		{Type: model.TypeWhisper, From: "alice", To: "bob", Text: "w1"},
+		{Type: model.TypeBroadcast, From: "bob", Text: "g2"},
+		{Type: model.TypeWhisper, From: "bob", To: "alice", Text: "w2"},
+		{Type: model.TypeWhisper, From: "carol", To: "alice", Text: "w3"},
+		{Type: model.TypeBroadcast, From: "alice", Room: "#dev", Text: "d2"},
+	}
+	for _, msg := range messages {
+		if _, err := st.Save(msg); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+}
+
+func checkQueries(t *testing.T, st app.MessageStore) {
+	t.Helper()
+	cases := []struct {
+		name  string
+		query model.HistoryQuery
+		want  []string
+	}{
+		{"global", model.HistoryQuery{Limit: 10}, []string{"g1", "g2"}},
+		{"room", model.HistoryQuery{Room: "#dev", Limit: 10}, []string{"d1", "d2"}},
+		{"conversation", model.HistoryQuery{User: "alice", Peer: "bob", Limit: 10}, []string{"w1", "w2"}},
+		{"conversation other side", model.HistoryQuery{User: "bob", Peer: "alice", Limit: 10}, []string{"w1", "w2"}},
+		{"limit keeps newest", model.HistoryQuery{User: "alice", Peer: "bob", Limit: 1}, []string{"w2"}},
+	}
+
+	for _, c := range cases {
+		got, err := st.Last(c.query)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(texts(got), c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, texts(got), c.want)
+		}
+	}
+}
+
+func TestMemoryStore_Queries(t *testing.T) {
+	st := store.NewMemoryStore(100)
+	fillStore(t, st)
+	checkQueries(t, st)
+}
+
+func TestMemoryStore_AssignsIDAndTime(t *testing.T) {
+	st := store.NewMemoryStore(10)
+	first, _ := st.Save(model.StoredMessage{Type: model.TypeBroadcast, Text: "a"})
+	second, _ := st.Save(model.StoredMessage{Type: model.TypeBroadcast, Text: "b"})
+
+	if first.ID == 0 || second.ID <= first.ID {
+		t.Errorf("expected increasing IDs, got %d and %d", first.ID, second.ID)
+	}
+	if first.Time.IsZero() {
+		t.Error("expected server time to be assigned")
+	}
+}
+
+func TestMemoryStore_RingBufferEvictsOldest(t *testing.T) {
+	st := store.NewMemoryStore(3)
+	for _, text := range []string{"1", "2", "3", "4", "5"} {
+		st.Save(model.StoredMessage{Type: model.TypeBroadcast, Text: text})
+	}
+
+	got, _ := st.Last(model.HistoryQuery{Limit: 10})
+	if !reflect.DeepEqual(texts(got), []string{"3", "4", "5"}) {
+		t.Errorf("got %v, want [3 4 5]", texts(got))
+	}
+}
+
+func TestFileStore_PersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	st, err := store.OpenFileStore(path, 100)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	fillStore(t, st)
+	checkQueries(t, st)
+	if err := st.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	reopened, err := store.OpenFileStore(path, 100)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	checkQueries(t, reopened)
+
+	// Нумерация продолжается после перезапуска
+	saved, err := reopened.Save(model.StoredMessage{Type: model.TypeBroadcast, Text: "g3", Time: time.Now()})
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if saved.ID != 8 {
+		t.Errorf("expected ID 8 after reopen, got %d", saved.ID)
+	}
+}
+
+func TestFileStore_SkipsDamagedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	data := `{"id":1,"type":"broadcast","from":"alice","text":"first"}
+not json
+{"id":2,"type":"broadcast","from":"bob","text":"second"}
+{"id":3,"type":"broadcast","from":"bo`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := store.OpenFileStore(path, 100)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	got, _ := st.Last(model.HistoryQuery{Limit: 10})
+	if !reflect.DeepEqual(texts(got), []string{"first", "second"}) {
+		t.Errorf("got %v, want [first second]", texts(got))
+	}
+	// Недописанная строка отрезана: новая запись не склеивается с ней
+	if _, err := st.Save(model.StoredMessage{Type: model.TypeBroadcast, Text: "third", Time: time.Now()}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	st.Close()
+
+	reopened, err := store.OpenFileStore(path, 100)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	got, _ = reopened.Last(model.HistoryQuery{Limit: 10})
+	if !reflect.DeepEqual(texts(got), []string{"first", "second", "third"}) {
+		t.Errorf("after reopen got %v, want [first second third]", texts(got))
+	}
+}
+
+func TestFileStore_LoadsLongEscapedText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	st, err := store.OpenFileStore(path, 10)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	// Каждый управляющий символ в JSON занимает 6 байт: строка больше 1 МБ
+	text := strings.Repeat("\x01", 200*1024)
+	if _, err := st.Save(model.StoredMessage{Type: model.TypeBroadcast, Text: text, Time: time.Now()}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	st.Close()
+
+	reopened, err := store.OpenFileStore(path, 10)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	got, _ := reopened.Last(model.HistoryQuery{Limit: 1})
+	if len(got) != 1 || got[0].Text != text {
+		t.Errorf("long message was not restored, got %d messages", len(got))
+	}
+}
@@ -0,0 +1,336 @@
+package test
+
+import (
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/cfg"
+	"chat/server/internal/model"
+	httptransport "chat/server/internal/transport/http"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testPKI - самоподписанный CA и выпущенные им сертификаты, созданные в тесте
+type testPKI struct {
+	dir    string
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caFile string
+	pool   *x509.CertPool
+	serial int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "chat test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &testPKI{dir: t.TempDir(), ca: ca, caKey: key, pool: x509.NewCertPool(), serial: 1}
+	p.pool.AddCert(ca)
+	p.caFile = p.writePEM(t, "ca.pem", "CERTIFICATE", der)
+	return p
+}
+
+func (p *testPKI) writePEM(t *testing.T, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(p.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// issue выпускает сертификат и возвращает пути к сертификату и ключу
+func (p *testPKI) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(p.serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	if usage == x509.ExtKeyUsageServerAuth {
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.ca, &key.PublicKey, p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.writePEM(t, name+".pem", "CERTIFICATE", der), p.writePEM(t, name+"-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+// clientConfig - настройки клиента, доверяющего тестовому CA
+func (p *testPKI) clientConfig(t *testing.T, user string) *tls.Config {
+	t.Helper()
+	config := &tls.Config{RootCAs: p.pool}
+	if user != "" {
+		certFile, keyFile := p.issue(t, user, x509.ExtKeyUsageClientAuth)
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config
+}
+
+// startTLSServer запускает tcp и http по TLS через те же флаги, что и cmd/server
+func startTLSServer(t *testing.T, pki *testPKI, clientCA bool) (tcpAddr, httpAddr string) {
+	t.Helper()
+	certFile, keyFile := pki.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	flags := cfg.Flag{
+		ProtoType: "tcp,http",
+		TCPAddr:   freeAddr(t, "tcp"),
+		HTTPAddr:  freeAddr(t, "tcp"),
+		TLSCert:   certFile,
+		TLSKey:    keyFile,
+	}
+	if clientCA {
+		flags.TLSClientCA = pki.caFile
+	}
+
+	server, err := cfg.NewServer(&flags)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go server.Start()
+	t.Cleanup(func() { server.Stop() })
+	return flags.TCPAddr, flags.HTTPAddr
+}
+
+func dialTLS(t *testing.T, proto, addr string, config *tls.Config) frameConn {
+	t.Helper()
+	return dialReady(t, func() (frameConn, error) {
+		if proto == "tcp" {
+			conn, err := tls.Dial("tcp", addr, config)
+			if err != nil {
+				return nil, err
+			}
+			return newTCPFrameConn(conn), nil
+		}
+		dialer := websocket.Dialer{TLSClientConfig: config}
+		ws, _, err := dialer.Dial("wss://"+addr+"/ws", nil)
+		if err != nil {
+			return nil, err
+		}
+		return newWSFrameConn(ws), nil
+	})
+}
+
+func TestTLS_TCPAndWSS(t *testing.T) {
+	pki := newTestPKI(t)
+	tcpAddr, httpAddr := startTLSServer(t, pki, false)
+
+	alice := dialTLS(t, "tcp", tcpAddr, pki.clientConfig(t, ""))
+	defer alice.close()
+	bob := dialTLS(t, "http", httpAddr, pki.clientConfig(t, ""))
+	defer bob.close()
+
+	bob.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "bob"}))
+	bob.send(t, protocol.New(protocol.TypeRooms, protocol.Payload{}))
+	if frame := bob.receive(t); frame.Type != model.TypeRooms {
+		t.Fatalf("bob: want rooms, got %v", frame)
+	}
+
+	alice.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "alice"}))
+	alice.send(t, protocol.New(protocol.TypeWhisper, protocol.Payload{Dst: "bob", Text: "over tls"}))
+	if frame := bob.receive(t); frame.Type != model.TypeUserJoined || frame.Payload.Name != "alice" {
+		t.Fatalf("bob: want user_joined alice, got %v", frame)
+	}
+	frame := bob.receive(t)
+	if frame.Type != model.TypeWhisper || frame.Payload.Name != "alice" || frame.Payload.Text != "over tls" {
+		t.Fatalf("bob got %v, want whisper from alice", frame)
+	}
+}
+
+func TestTLS_RejectsUntrustedServer(t *testing.T) {
+	pki := newTestPKI(t)
+	tcpAddr, _ := startTLSServer(t, pki, false)
+	// Ждём запуска сервера доверяющим клиентом
+	dialTLS(t, "tcp", tcpAddr, pki.clientConfig(t, "")).close()
+
+	other := newTestPKI(t)
+	if conn, err := tls.Dial("tcp", tcpAddr, other.clientConfig(t, "")); err == nil {
+		conn.Close()
+		t.Fatal("handshake with a server signed by an unknown CA succeeded")
+	}
+}
+
+func TestTLS_ClientCertificateBindsName(t *testing.T) {
+	pki := newTestPKI(t)
+	tcpAddr, httpAddr := startTLSServer(t, pki, true)
+
+	for _, proto := range []string{"tcp", "http"} {
+		addr := tcpAddr
+		if proto == "http" {
+			addr = httpAddr
+		}
+		t.Run(proto, func(t *testing.T) {
+			user := "alice-" + proto
+			conn := dialTLS(t, proto, addr, pki.clientConfig(t, user))
+			defer conn.close()
+
+			conn.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "mallory"}))
+			if frame := conn.receive(t); frame.Type != model.TypeAuthFailed {
+				t.Fatalf("register with foreign name: got %v, want auth_failed", frame)
+			}
+
+			conn.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: user}))
+			conn.send(t, protocol.New(protocol.TypeRooms, protocol.Payload{}))
+			if frame := conn.receive(t); frame.Type != model.TypeRooms {
+				t.Fatalf("register as certificate name: got %v, want rooms", frame)
+			}
+		})
+	}
+
+	t.Run("without certificate", func(t *testing.T) {
+		conn := dialTLS(t, "tcp", tcpAddr, pki.clientConfig(t, ""))
+		defer conn.close()
+		conn.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "guest"}))
+		conn.send(t, protocol.New(protocol.TypeRooms, protocol.Payload{}))
+		if frame := conn.receive(t); frame.Type != model.TypeRooms {
+			t.Fatalf("got %v, want rooms", frame)
+		}
+	})
+}
+
+func TestTLS_ClientCertificateChecksOrigin(t *testing.T) {
+	pki := newTestPKI(t)
+	_, httpAddr := startTLSServer(t, pki, true)
+	config := pki.clientConfig(t, "alice")
+	// Без Origin подключается не браузер, такой запрос пропускается
+	dialTLS(t, "http", httpAddr, config).close()
+
+	cases := []struct {
+		origin string
+		status int
+	}{
+		{"https://evil.example", http.StatusForbidden},
+		{"https://" + httpAddr, http.StatusOK},
+	}
+	for _, c := range cases {
+		dialer := websocket.Dialer{TLSClientConfig: config}
+		ws, resp, err := dialer.Dial("wss://"+httpAddr+"/ws", http.Header{"Origin": {c.origin}})
+		if c.status == http.StatusOK {
+			if err != nil {
+				t.Errorf("websocket from %s: %v", c.origin, err)
+				continue
+			}
+			ws.Close()
+		} else if err == nil || resp == nil || resp.StatusCode != c.status {
+			t.Errorf("websocket from %s: want %d, got %v", c.origin, c.status, err)
+		}
+
+		req, _ := http.NewRequest("GET", "https://"+httpAddr+"/events", nil)
+		req.Header.Set("Origin", c.origin)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		resp, err = client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("events from %s: want %d, got %d", c.origin, c.status, resp.StatusCode)
+		}
+	}
+}
+
+func TestHTTP_AllowedOrigins(t *testing.T) {
+	hub := app.NewHub()
+	tr := httptransport.NewHTTPTransport(hub)
+	tr.SetAllowedOrigins([]string{"https://chat.example"})
+	addr := freeAddr(t, "tcp")
+	go tr.Start(addr)
+	t.Cleanup(func() {
+		tr.Stop()
+		hub.Close()
+	})
+	dialReady(t, func() (frameConn, error) { return dialFrameConn("http", addr) }).close()
+
+	for origin, allowed := range map[string]bool{
+		"https://chat.example": true,
+		"https://CHAT.example": true,
+		"http://" + addr:       true,
+		"https://evil.example": false,
+	} {
+		ws, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", http.Header{"Origin": {origin}})
+		if err == nil {
+			ws.Close()
+		}
+		if (err == nil) != allowed {
+			t.Errorf("origin %s: allowed %v, got error %v", origin, allowed, err)
+		}
+	}
+}
+
+func TestNewServer_TLSFlags(t *testing.T) {
+	pki := newTestPKI(t)
+	certFile, keyFile := pki.issue(t, "server", x509.ExtKeyUsageServerAuth)
+
+	cases := []struct {
+		name    string
+		flags   cfg.Flag
+		wantErr bool
+	}{
+		{"tls tcp and http", cfg.Flag{ProtoType: "tcp,http", IP: "127.0.0.1", Port: "4545", HTTPAddr: "127.0.0.1:8443", TLSCert: certFile, TLSKey: keyFile}, false},
+		{"mutual tls", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "4545", TLSCert: certFile, TLSKey: keyFile, TLSClientCA: pki.caFile, TLSClientAuth: true}, false},
+		{"cert without key", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "4545", TLSCert: certFile}, true},
+		{"client ca without cert", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "4545", TLSClientCA: pki.caFile}, true},
+		{"client auth without ca", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "4545", TLSCert: certFile, TLSKey: keyFile, TLSClientAuth: true}, true},
+		{"tls tcp with plain udp", cfg.Flag{ProtoType: "tcp,udp", IP: "127.0.0.1", Port: "4545", TLSCert: certFile, TLSKey: keyFile}, false},
+		{"udp with required client certificates", cfg.Flag{ProtoType: "tcp,udp", IP: "127.0.0.1", Port: "4545", TLSCert: certFile, TLSKey: keyFile, TLSClientCA: pki.caFile, TLSClientAuth: true}, true},
+		{"missing file", cfg.Flag{ProtoType: "tcp", IP: "127.0.0.1", Port: "4545", TLSCert: certFile + ".missing", TLSKey: keyFile}, true},
+	}
+
+	for _, c := range cases {
+		flags := c.flags
+		_, err := cfg.NewServer(&flags)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: NewServer error = %v, wantErr %v", c.name, err, c.wantErr)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package test
+
+import (
+	"chat/protocol"
+	"chat/server/internal/app"
+	"chat/server/internal/model"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestValidateName(t *testing.T) {
+	cases := []struct {
+		name string
+		want error
+	}{
+		{"alice", nil},
+		{"bob_2", nil},
+		{"j.doe-1", nil},
+		{"Иван", nil},
+		{"7up", nil},
+		{strings.Repeat("a", app.MaxNameLength), nil},
+		{"", app.ErrNameEmpty},
+		{strings.Repeat("a", app.MaxNameLength+1), app.ErrNameTooLong},
+		{strings.Repeat("я", app.MaxNameLength+1), app.ErrNameTooLong},
+		{"al ice", app.ErrNameInvalid},
+		{"_alice", app.ErrNameInvalid},
+		{"#general", app.ErrNameInvalid},
+		{"bob\n", app.ErrNameInvalid},
+		{"a\xffb", app.ErrNameInvalid},
+		{"server", app.ErrNameReserved},
+		{"Server", app.ErrNameReserved},
+		{"ADMIN", app.ErrNameReserved},
+	}
+	for _, c := range cases {
+		if err := app.ValidateName(c.name); !errors.Is(err, c.want) || (c.want == nil) != (err == nil) {
+			t.Errorf("ValidateName(%q) = %v, want %v", c.name, err, c.want)
+		}
+	}
+}
+
+func TestValidateRoom(t *testing.T) {
+	cases := []struct {
+		room string
+		want error
+	}{
+		{"#dev", nil},
+		{"dev", nil},
+		{"#комната_1", nil},
+		{"#" + strings.Repeat("r", app.MaxNameLength), nil},
+		{"", app.ErrRoomNameEmpty},
+		{"#", app.ErrRoomNameEmpty},
+		{"#" + strings.Repeat("r", app.MaxNameLength+1), app.ErrRoomNameInvalid},
+		{"#dev ops", app.ErrRoomNameInvalid},
+		{"#dev\x1b[2J", app.ErrRoomNameInvalid},
+		{"#a\xffb", app.ErrRoomNameInvalid},
+		{"##dev", app.ErrRoomNameInvalid},
+	}
+	for _, c := range cases {
+		if err := app.ValidateRoom(c.room); !errors.Is(err, c.want) || (c.want == nil) != (err == nil) {
+			t.Errorf("ValidateRoom(%q) = %v, want %v", c.room, err, c.want)
+		}
+	}
+}
+
+func TestHub_RejectsInvalidRoom(t *testing.T) {
+	hub := app.NewHub()
+	alice, aliceConn := connectAs(t, hub, "alice")
+	bad := "#dev\x07" + strings.Repeat("x", 40)
+
+	for _, msg := range []model.IncomingMessage{
+		{Type: model.TypeJoin, Room: bad},
+		{Type: model.TypeLeave, Room: bad},
+		{Type: model.TypeHistory, Room: bad},
+	} {
+		if err := hub.Handle(alice, msg); !errors.Is(err, app.ErrRoomNameInvalid) {
+			t.Errorf("%s: got %v, want %v", msg.Type, err, app.ErrRoomNameInvalid)
+		}
+	}
+	got := messagesOfType(aliceConn, model.TypeInvalidInput)
+	if len(got) != 3 || got[0].Code != protocol.CodeRoomNameInvalid {
+		t.Errorf("want invalid_input frames with %s, got %+v", protocol.CodeRoomNameInvalid, got)
+	}
+
+	_, err := hub.Post("127.0.0.1:1", model.IncomingMessage{Type: model.TypeBroadcast, From: "ci", Room: bad, Text: "hi"})
+	if !errors.Is(err, app.ErrRoomNameInvalid) {
+		t.Errorf("post to invalid room: got %v, want %v", err, app.ErrRoomNameInvalid)
+	}
+	if _, err := hub.Messages(model.HistoryQuery{Room: bad}); !errors.Is(err, app.ErrRoomNameInvalid) {
+		t.Errorf("history of invalid room: got %v, want %v", err, app.ErrRoomNameInvalid)
+	}
+}
+
+func TestValidateText(t *testing.T) {
+	cases := []struct {
+		text string
+		want error
+	}{
+		{"hello", nil},
+		{"line 1\nline 2\tend", nil},
+		{"привет 👋", nil},
+		{strings.Repeat("x", 32), nil},
+		{"", app.ErrTextEmpty},
+		{" \n\t", app.ErrTextEmpty},
+		{strings.Repeat("x", 33), app.ErrMessageTooLarge},
+		{strings.Repeat("я", 17), app.ErrMessageTooLarge},
+		{"bell\a", app.ErrTextInvalid},
+		{"nul\x00", app.ErrTextInvalid},
+		{"esc\x1b[2J", app.ErrTextInvalid},
+		{"c1\u0085", app.ErrTextInvalid},
+		{"bad \xff utf-8", app.ErrTextInvalid},
+	}
+	for _, c := range cases {
+		if err := app.ValidateText(c.text, 32); !errors.Is(err, c.want) || (c.want == nil) != (err == nil) {
+			t.Errorf("ValidateText(%q) = %v, want %v", c.text, err, c.want)
+		}
+	}
+}
+
+func TestHub_InvalidInput(t *testing.T) {
+	hub := app.NewHub()
+	hub.SetMaxTextSize(16)
+	alice, aliceConn := connectAs(t, hub, "alice")
+	_, bobConn := connectAs(t, hub, "bob")
+
+	cases := []struct {
+		name     string
+		msg      model.IncomingMessage
+		want     error
+		wantType string
+	}{
+		{"reserved name", model.IncomingMessage{Type: model.TypeRegister, From: "server"}, app.ErrNameReserved, model.TypeInvalidInput},
+		{"control character", model.IncomingMessage{Type: model.TypeBroadcast, Text: "\x1b[2J"}, app.ErrTextInvalid, model.TypeInvalidInput},
+		{"empty whisper", model.IncomingMessage{Type: model.TypeWhisper, To: "bob"}, app.ErrTextEmpty, model.TypeInvalidInput},
+		{"long broadcast", model.IncomingMessage{Type: model.TypeBroadcast, Text: strings.Repeat("x", 17)}, app.ErrMessageTooLarge, model.TypeMessageTooLarge},
+	}
+	for _, c := range cases {
+		s := alice
+		conn := aliceConn
+		if c.msg.Type == model.TypeRegister {
+			conn = &MockConn{}
+			s = hub.Connect(conn)
+		}
+		conn.Reset()
+		if err := hub.Handle(s, c.msg); !errors.Is(err, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+		if sent := conn.Sent(); len(sent) != 1 || sent[0].Type != c.wantType {
+			t.Errorf("%s: want one %s frame, got %+v", c.name, c.wantType, sent)
+		}
+	}
+	if got := messagesOfType(bobConn, model.TypeBroadcast); len(got) != 0 {
+		t.Errorf("invalid messages were delivered: %+v", got)
+	}
+
+	hub.SetMaxTextSize(32)
+	if err := hub.Handle(alice, model.IncomingMessage{Type: model.TypeBroadcast, Text: strings.Repeat("x", 17)}); err != nil {
+		t.Errorf("raised limit: %v", err)
+	}
+}
+
+func TestTransports_InvalidInput(t *testing.T) {
+	for _, proto := range []string{"tcp", "udp", "http", "sse", "poll"} {
+		t.Run(proto, func(t *testing.T) {
+			conn := startTransport(t, proto)(t)
+			defer conn.close()
+
+			conn.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "system"}))
+			if got := conn.receive(t); got.Type != model.TypeInvalidInput || !got.IsError() {
+				t.Fatalf("want %s for a reserved name, got %+v", model.TypeInvalidInput, got)
+			}
+			conn.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: "alice"}))
+			conn.send(t, protocol.New(protocol.TypeBroadcast, protocol.Payload{Text: "beep\a"}))
+			got := receiveType(t, conn, model.TypeInvalidInput)
+			if !strings.Contains(got.Payload.Text, app.ErrTextInvalid.Error()) {
+				t.Errorf("want %q, got %q", app.ErrTextInvalid, got.Payload.Text)
+			}
+		})
+	}
+}
+
+func TestWS_FrameTooLargeKeepsConnection(t *testing.T) {
+	conn := startTransport(t, "http")(t)
+	defer conn.close()
+
+	c := conn.(*wsFrameConn)
+	huge := strings.Repeat("x", protocol.DefaultMaxFrameSize+1)
+	if err := c.ws.WriteMessage(websocket.TextMessage, []byte(huge)); err != nil {
+		t.Fatal(err)
+	}
+	if got := conn.receive(t); got.Type != model.TypeMessageTooLarge {
+		t.Fatalf("want %s, got %+v", model.TypeMessageTooLarge, got)
+	}
+
+	conn.send(t, protocol.New(model.TypeRegister, protocol.Payload{Name: "alice"}))
+	conn.send(t, protocol.New(model.TypeWho, protocol.Payload{}))
+	if got := receiveType(t, conn, model.TypeWho); len(got.Payload.Users) != 1 {
+		t.Fatalf("want alice online, got %+v", got)
+	}
+}
+
+func TestAPI_InvalidInput(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	hub.SetMaxTextSize(16)
+	base := startAPI(t, hub, testAPIToken)
+
+	cases := []struct {
+		body   string
+		status int
+	}{
+		{`{"name":"root","text":"hi"}`, http.StatusBadRequest},
+		{`{"name":"ci bot","text":"hi"}`, http.StatusBadRequest},
+		{`{"name":"ci","text":"\u0007"}`, http.StatusBadRequest},
+		{fmt.Sprintf(`{"name":"ci","text":%q}`, strings.Repeat("x", 17)), http.StatusRequestEntityTooLarge},
+		{`{"name":"ci","text":"build passed"}`, http.StatusCreated},
+	}
+	for _, c := range cases {
+		var reply struct{ Error string }
+		if status := apiRequest(t, "POST", base+"/api/messages", testAPIToken, c.body, &reply); status != c.status {
+			t.Errorf("%s: want %d, got %d (%s)", c.body, c.status, status, reply.Error)
+		}
+	}
+}
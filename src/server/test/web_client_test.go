@@ -0,0 +1,79 @@
+package test
+
+import (
+	"chat/server/internal/app"
+	httptransport "chat/server/internal/transport/http"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWebClient_Served(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	base := startAPI(t, hub, "")
+
+	cases := []struct {
+		path        string
+		contentType string
+		contains    string
+	}{
+		{"/", "text/html", `<meta name="ws-path" content="/ws">`},
+		{"/app.js", "javascript", `meta[name="ws-path"]`},
+		{"/style.css", "text/css", "#log"},
+	}
+	for _, c := range cases {
+		resp, err := http.Get(base + c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: want %d, got %s", c.path, http.StatusOK, resp.Status)
+			continue
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.Contains(ct, c.contentType) {
+			t.Errorf("%s: want content type %s, got %q", c.path, c.contentType, ct)
+		}
+		if !strings.Contains(string(body), c.contains) {
+			t.Errorf("%s: body does not contain %s", c.path, c.contains)
+		}
+	}
+
+	resp, err := http.Get(base + "/missing.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing file: want %d, got %s", http.StatusNotFound, resp.Status)
+	}
+}
+
+func TestWebClient_CustomWSPath(t *testing.T) {
+	hub := app.NewHub()
+	defer hub.Close()
+	tr := httptransport.NewHTTPTransport(hub)
+	tr.SetWSPath("/chat/ws")
+	addr := freeAddr(t, "tcp")
+	go tr.Start(addr)
+	t.Cleanup(func() { tr.Stop() })
+
+	ws := dialReady(t, func() (frameConn, error) { return dialWSPath(addr, "/chat/ws") })
+	defer ws.close()
+
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `<meta name="ws-path" content="/chat/ws">`) {
+		t.Errorf("page does not point to /chat/ws:\n%s", body)
+	}
+	if _, err := dialWSPath(addr, "/ws"); err == nil {
+		t.Error("default /ws path still accepts connections")
+	}
+}
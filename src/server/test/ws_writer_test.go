@@ -0,0 +1,124 @@
+package test
+
+import (
+	"chat/protocol"
+	"chat/server/internal/app"
+	httptransport "chat/server/internal/transport/http"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestWS_ConcurrentWritersStress: много клиентов одновременно рассылают
+// broadcast и whisper, а сервер ещё и шлёт ping. Кадры одному клиенту пишут
+// горутины всех отправителей и heartbeat; без единственного писателя
+// gorilla/websocket паникует, а -race видит гонку.
+func TestWS_ConcurrentWritersStress(t *testing.T) {
+	const (
+		clients   = 8
+		perClient = 100
+	)
+	conns := make([]*websocket.Conn, clients)
+	// Клиенты закрываются после сервера, чтобы heartbeat не пинговал
+	// уже закрытые соединения
+	t.Cleanup(func() {
+		for _, ws := range conns {
+			if ws != nil {
+				ws.Close()
+			}
+		}
+	})
+	hub := app.NewHub()
+	hub.StartHeartbeat(time.Millisecond, time.Minute)
+	tr := httptransport.NewHTTPTransport(hub)
+	addr := freeAddr(t, "tcp")
+	go tr.Start(addr)
+	t.Cleanup(func() {
+		tr.Stop()
+		hub.Close()
+	})
+
+	for i := range conns {
+		conn := dialReady(t, func() (frameConn, error) { return dialFrameConn("http", addr) })
+		conn.send(t, protocol.New(protocol.TypeRegister, protocol.Payload{Name: fmt.Sprint("user", i)}))
+		// who отвечает только зарегистрированным: после ответа рассылка дойдёт
+		conn.send(t, protocol.New(protocol.TypeWho, protocol.Payload{Name: fmt.Sprint("user", i)}))
+		for frame := conn.receive(t); frame.Type != protocol.TypeWho; frame = conn.receive(t) {
+		}
+		conns[i] = conn.(*wsFrameConn).ws
+	}
+
+	wantBroadcasts := clients * perClient
+	wantWhispers := 2 * perClient // Полученные и копии отправленных
+	errs := make(chan error, 2*clients)
+	var readers sync.WaitGroup
+	for i, ws := range conns {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			errs <- readAll(ws, fmt.Sprint("user", i), wantBroadcasts, wantWhispers)
+		}()
+	}
+
+	var senders sync.WaitGroup
+	for i, ws := range conns {
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			name, next := fmt.Sprint("user", i), fmt.Sprint("user", (i+1)%clients)
+			for n := 0; n < perClient; n++ {
+				frames := []protocol.Envelope{
+					protocol.New(protocol.TypeBroadcast, protocol.Payload{Name: name, Text: fmt.Sprint(n)}),
+					protocol.New(protocol.TypeWhisper, protocol.Payload{Name: name, Dst: next, Text: fmt.Sprint(n)}),
+				}
+				for _, frame := range frames {
+					if err := ws.WriteJSON(frame); err != nil {
+						errs <- fmt.Errorf("%s write: %w", name, err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	senders.Wait()
+	readers.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// readAll читает кадры, пока не придут все broadcast и whisper, и проверяет,
+// что кадры целые, а сообщения каждого отправителя идут по порядку
+func readAll(ws *websocket.Conn, name string, broadcasts, whispers int) error {
+	next := make(map[string]int) // Ожидаемый номер broadcast от каждого отправителя
+	gotBroadcasts, gotWhispers := 0, 0
+	for gotBroadcasts < broadcasts || gotWhispers < whispers {
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("%s: read after %d broadcasts, %d whispers: %w", name, gotBroadcasts, gotWhispers, err)
+		}
+		frame, err := protocol.Decode(data)
+		if err != nil {
+			return fmt.Errorf("%s: corrupted frame %q: %w", name, data, err)
+		}
+		p := frame.Payload
+		switch frame.Type {
+		case protocol.TypeBroadcast:
+			if want := fmt.Sprint(next[p.Name]); p.Text != want {
+				return fmt.Errorf("%s: broadcast from %s: got %s, want %s", name, p.Name, p.Text, want)
+			}
+			next[p.Name]++
+			gotBroadcasts++
+		case protocol.TypeWhisper:
+			gotWhispers++
+		}
+	}
+	return nil
+}